@@ -3,8 +3,12 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/SteliosSpanos/mygit/internal/commands"
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/grep"
 )
 
 func main() {
@@ -15,8 +19,32 @@ func main() {
 		fmt.Println("   init          Initialize a new repository")
 		fmt.Println("   hash-object   Hash and store a file")
 		fmt.Println("   cat-file      Display an object's content")
-		fmt.Println("   add           Add file to staging area")
+		fmt.Println("   add           Add file contents to the staging area")
+		fmt.Println("   rm            Remove files from the work tree and the index")
+		fmt.Println("   mv            Move or rename a file or directory")
 		fmt.Println("   commit        Create a commit from staged files")
+		fmt.Println("   reset         Move HEAD and reset the index or work tree")
+		fmt.Println("   restore       Restore work tree or staged files")
+		fmt.Println("   stash         Shelve local changes and bring them back later")
+		fmt.Println("   cherry-pick   Apply the changes of existing commits")
+		fmt.Println("   revert        Undo the changes of existing commits")
+		fmt.Println("   rebase        Reapply commits on top of another base")
+		fmt.Println("   blame         Show what revision last modified each line of a file")
+		fmt.Println("   grep          Print lines of tracked files matching a pattern")
+		fmt.Println("   bisect        Use binary search to find the commit that introduced a bug")
+		fmt.Println("   describe      Name a commit after the nearest tag reachable from it")
+		fmt.Println("   name-rev      Find symbolic names for commits")
+		fmt.Println("   shortlog      Summarize commit history by author")
+		fmt.Println("   archive       Create a tar or zip archive of a tree")
+		fmt.Println("   format-patch  Prepare commits as patches for email")
+		fmt.Println("   am            Apply patches from a mailbox as commits")
+		fmt.Println("   pack-objects  Write objects listed on stdin into a pack")
+		fmt.Println("   repack        Pack loose objects into packfiles")
+		fmt.Println("   prune         Remove unreachable loose objects")
+		fmt.Println("   gc            Repack and prune the object database")
+		fmt.Println("   fsck          Verify the integrity of the object database")
+		fmt.Println("   config        Get and set repository or global options")
+		fmt.Println("   check-ignore  Debug .gitignore and exclude rules")
 		os.Exit(1)
 	}
 
@@ -24,7 +52,23 @@ func main() {
 
 	switch command {
 	case "init":
-		err := commands.Init()
+		objectFormat := ""
+		args := os.Args[2:]
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case strings.HasPrefix(arg, "--object-format="):
+				objectFormat = strings.TrimPrefix(arg, "--object-format=")
+			case arg == "--object-format" && i+1 < len(args):
+				i++
+				objectFormat = args[i]
+			default:
+				fmt.Fprintf(os.Stderr, "Usage: mygit init [--object-format=sha1|sha256]\n")
+				os.Exit(1)
+			}
+		}
+
+		err := commands.Init(objectFormat)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
@@ -42,7 +86,7 @@ func main() {
 		}
 	case "cat-file":
 		if len(os.Args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: mygit cat-file <hash>\n")
+			fmt.Fprintf(os.Stderr, "Usage: mygit cat-file <object>\n")
 			os.Exit(1)
 		}
 
@@ -52,24 +96,1036 @@ func main() {
 			os.Exit(1)
 		}
 	case "add":
-		if len(os.Args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: mygit add <file>\n")
+		var opts commands.AddOptions
+		all := false
+		args := os.Args[2:]
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; arg {
+			case "--":
+				opts.Paths = append(opts.Paths, args[i+1:]...)
+				i = len(args)
+			case "-A", "--all":
+				all = true
+			case "-u", "--update":
+				opts.Update = true
+			case "-n", "--dry-run":
+				opts.DryRun = true
+			case "-f", "--force":
+				opts.Force = true
+			case "-p", "--patch":
+				opts.Patch = true
+			default:
+				opts.Paths = append(opts.Paths, arg)
+			}
+		}
+
+		if len(opts.Paths) == 0 && !all && !opts.Update && !opts.Patch {
+			fmt.Fprintf(os.Stderr, "Usage: mygit add [-A | -u | -p] [-n] [-f] <pathspec>...\n")
 			os.Exit(1)
 		}
 
-		filePath := os.Args[2]
-		if err := commands.Add(filePath); err != nil {
+		if err := commands.Add(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "rm":
+		var opts commands.RmOptions
+		args := os.Args[2:]
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; arg {
+			case "--":
+				opts.Paths = append(opts.Paths, args[i+1:]...)
+				i = len(args)
+			case "--cached":
+				opts.Cached = true
+			case "-r":
+				opts.Recursive = true
+			case "-f", "--force":
+				opts.Force = true
+			case "-n", "--dry-run":
+				opts.DryRun = true
+			default:
+				opts.Paths = append(opts.Paths, arg)
+			}
+		}
+
+		if len(opts.Paths) == 0 {
+			fmt.Fprintf(os.Stderr, "Usage: mygit rm [--cached] [-r] [-f] [-n] <pathspec>...\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Rm(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "mv":
+		var opts commands.MvOptions
+		var paths []string
+
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "-f", "--force":
+				opts.Force = true
+			case "-k":
+				opts.SkipErrors = true
+			case "-n", "--dry-run":
+				opts.DryRun = true
+			default:
+				paths = append(paths, arg)
+			}
+		}
+
+		if len(paths) < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: mygit mv [-f] [-k] [-n] <source>... <destination>\n")
+			os.Exit(1)
+		}
+
+		opts.Sources = paths[:len(paths)-1]
+		opts.Destination = paths[len(paths)-1]
+		if err := commands.Mv(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "reset":
+		var opts commands.ResetOptions
+		var positional []string
+		separator := false
+		valid := true
+
+		for _, arg := range os.Args[2:] {
+			switch {
+			case separator:
+				opts.Paths = append(opts.Paths, arg)
+			case arg == "--":
+				separator = true
+				if len(positional) > 1 {
+					valid = false
+				}
+			case arg == "--soft" || arg == "--mixed" || arg == "--hard" || arg == "--keep":
+				opts.Mode = strings.TrimPrefix(arg, "--")
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				positional = append(positional, arg)
+			}
+		}
+
+		if len(positional) > 0 {
+			opts.Rev = positional[0]
+			opts.Paths = append(positional[1:], opts.Paths...)
+			opts.RevMayBePath = !separator
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit reset [--soft | --mixed | --hard | --keep] [<commit>]\n")
+			fmt.Fprintf(os.Stderr, "       mygit reset [<commit>] [--] <pathspec>...\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Reset(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "restore":
+		var opts commands.RestoreOptions
+		args := os.Args[2:]
+		separator := false
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case separator:
+				opts.Paths = append(opts.Paths, arg)
+			case arg == "--":
+				separator = true
+			case arg == "-S" || arg == "--staged":
+				opts.Staged = true
+			case arg == "-W" || arg == "--worktree":
+				opts.Worktree = true
+			case arg == "--ours":
+				opts.Ours = true
+			case arg == "--theirs":
+				opts.Theirs = true
+			case (arg == "-s" || arg == "--source") && i+1 < len(args):
+				i++
+				opts.Source = args[i]
+			case strings.HasPrefix(arg, "--source="):
+				opts.Source = strings.TrimPrefix(arg, "--source=")
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				opts.Paths = append(opts.Paths, arg)
+			}
+		}
+
+		if !valid || len(opts.Paths) == 0 {
+			fmt.Fprintf(os.Stderr, "Usage: mygit restore [--source=<rev>] [--staged] [--worktree] [--ours | --theirs] [--] <pathspec>...\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Restore(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "stash":
+		var opts commands.StashOptions
+		args := os.Args[2:]
+		valid := true
+
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			opts.Command = args[0]
+			args = args[1:]
+		}
+
+		var positional []string
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case (arg == "-m" || arg == "--message") && i+1 < len(args):
+				i++
+				opts.Message = args[i]
+			case arg == "-u" || arg == "--include-untracked":
+				opts.IncludeUntracked = true
+			case arg == "-k" || arg == "--keep-index":
+				opts.KeepIndex = true
+			case arg == "--index":
+				opts.Index = true
+			case arg == "-p" || arg == "--patch":
+				opts.Patch = true
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				positional = append(positional, arg)
+			}
+		}
+
+		if opts.Command == "branch" && len(positional) > 0 {
+			opts.Branch = positional[0]
+			positional = positional[1:]
+		}
+		if len(positional) > 1 || (len(positional) > 0 && (opts.Command == "" || opts.Command == "push" || opts.Command == "list" || opts.Command == "clear")) {
+			valid = false
+		}
+		if len(positional) > 0 {
+			opts.Stash = positional[0]
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit stash [push [-m <message>] [-u] [-k]]\n")
+			fmt.Fprintf(os.Stderr, "       mygit stash list | clear\n")
+			fmt.Fprintf(os.Stderr, "       mygit stash show [-p] [<stash>]\n")
+			fmt.Fprintf(os.Stderr, "       mygit stash (apply | pop) [--index] [<stash>]\n")
+			fmt.Fprintf(os.Stderr, "       mygit stash drop [<stash>]\n")
+			fmt.Fprintf(os.Stderr, "       mygit stash branch <branch> [<stash>]\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Stash(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "cherry-pick", "revert":
+		var opts commands.PickOptions
+		args := os.Args[2:]
+		command := os.Args[1]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case (arg == "-m" || arg == "--mainline") && i+1 < len(args):
+				i++
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 1 {
+					valid = false
+				}
+				opts.Mainline = n
+			case arg == "-n" || arg == "--no-commit":
+				opts.NoCommit = true
+			case arg == "-x" && command == "cherry-pick":
+				opts.RecordOrigin = true
+			case arg == "--continue":
+				opts.Continue = true
+			case arg == "--abort":
+				opts.Abort = true
+			case arg == "--skip":
+				opts.Skip = true
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				opts.Revs = append(opts.Revs, arg)
+			}
+		}
+
+		actions := 0
+		for _, set := range []bool{opts.Continue, opts.Abort, opts.Skip} {
+			if set {
+				actions++
+			}
+		}
+		if actions > 1 || (actions == 1 && len(opts.Revs) > 0) || (actions == 0 && len(opts.Revs) == 0) {
+			valid = false
+		}
+
+		if !valid {
+			flags := "[-n] [-m <parent>]"
+			if command == "cherry-pick" {
+				flags = "[-n] [-x] [-m <parent>]"
+			}
+			fmt.Fprintf(os.Stderr, "Usage: mygit %s %s <commit>...\n", command, flags)
+			fmt.Fprintf(os.Stderr, "       mygit %s (--continue | --skip | --abort)\n", command)
+			os.Exit(1)
+		}
+
+		run := commands.CherryPick
+		if command == "revert" {
+			run = commands.Revert
+		}
+
+		if err := run(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "rebase":
+		var opts commands.RebaseOptions
+		var positional []string
+		args := os.Args[2:]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-i" || arg == "--interactive":
+				opts.Interactive = true
+			case arg == "--autosquash":
+				opts.Autosquash = true
+			case arg == "--onto" && i+1 < len(args):
+				i++
+				opts.Onto = args[i]
+			case strings.HasPrefix(arg, "--onto="):
+				opts.Onto = strings.TrimPrefix(arg, "--onto=")
+			case arg == "--continue":
+				opts.Continue = true
+			case arg == "--abort":
+				opts.Abort = true
+			case arg == "--skip":
+				opts.Skip = true
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				positional = append(positional, arg)
+			}
+		}
+
+		actions := 0
+		for _, set := range []bool{opts.Continue, opts.Abort, opts.Skip} {
+			if set {
+				actions++
+			}
+		}
+		switch {
+		case actions > 1 || (actions == 1 && len(positional) > 0):
+			valid = false
+		case actions == 0 && (len(positional) == 0 || len(positional) > 2):
+			valid = false
+		case actions == 0:
+			opts.Upstream = positional[0]
+			if len(positional) == 2 {
+				opts.Branch = positional[1]
+			}
+		}
+
+		if !valid {
+			fmt.Fprintln(os.Stderr, "Usage: mygit rebase [-i] [--autosquash] [--onto <newbase>] <upstream> [<branch>]")
+			fmt.Fprintln(os.Stderr, "       mygit rebase (--continue | --skip | --abort)")
+			os.Exit(1)
+		}
+
+		if err := commands.Rebase(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "blame":
+		var opts commands.BlameOptions
+		var positional []string
+		args := os.Args[2:]
+		valid := true
+		dashdash := -1
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case dashdash >= 0:
+				positional = append(positional, arg)
+			case arg == "--":
+				dashdash = len(positional)
+			case arg == "-L" && i+1 < len(args):
+				i++
+				opts.Ranges = append(opts.Ranges, args[i])
+			case strings.HasPrefix(arg, "-L") && len(arg) > 2:
+				opts.Ranges = append(opts.Ranges, arg[2:])
+			case arg == "-w":
+				opts.IgnoreWhitespace = true
+			case arg == "-M":
+				opts.Moves = true
+			case arg == "-C":
+				opts.Copies++
+			case arg == "--porcelain" || arg == "-p":
+				opts.Porcelain = true
+			case arg == "--ignore-rev" && i+1 < len(args):
+				i++
+				opts.IgnoreRevs = append(opts.IgnoreRevs, args[i])
+			case strings.HasPrefix(arg, "--ignore-rev="):
+				opts.IgnoreRevs = append(opts.IgnoreRevs, strings.TrimPrefix(arg, "--ignore-rev="))
+			case arg == "--ignore-revs-file" && i+1 < len(args):
+				i++
+				opts.IgnoreRevsFiles = append(opts.IgnoreRevsFiles, args[i])
+			case strings.HasPrefix(arg, "--ignore-revs-file="):
+				opts.IgnoreRevsFiles = append(opts.IgnoreRevsFiles, strings.TrimPrefix(arg, "--ignore-revs-file="))
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				positional = append(positional, arg)
+			}
+		}
+
+		switch {
+		case len(positional) == 1 && dashdash <= 0:
+			opts.Path = positional[0]
+		case len(positional) == 2 && (dashdash < 0 || dashdash == 1):
+			opts.Rev, opts.Path = positional[0], positional[1]
+		default:
+			valid = false
+		}
+
+		if !valid {
+			fmt.Fprintln(os.Stderr, "Usage: mygit blame [-w] [-M] [-C] [--porcelain] [-L <start>,<end>] [--ignore-rev <rev>] [--ignore-revs-file <file>] [<rev>] [--] <file>")
+			os.Exit(1)
+		}
+
+		if err := commands.Blame(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "grep":
+		var opts commands.GrepOptions
+		var positional []string
+		args := os.Args[2:]
+		valid := true
+		dashdash := false
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case dashdash:
+				opts.Paths = append(opts.Paths, arg)
+			case arg == "--":
+				dashdash = true
+			case arg == "-e" && i+1 < len(args):
+				i++
+				opts.Patterns = append(opts.Patterns, args[i])
+			case arg == "-n" || arg == "--line-number":
+				opts.LineNumber = true
+			case arg == "-i" || arg == "--ignore-case":
+				opts.IgnoreCase = true
+			case arg == "-w" || arg == "--word-regexp":
+				opts.WordRegexp = true
+			case arg == "-G" || arg == "--basic-regexp":
+				opts.Mode = grep.Basic
+			case arg == "-E" || arg == "--extended-regexp":
+				opts.Mode = grep.Extended
+			case arg == "-F" || arg == "--fixed-strings":
+				opts.Mode = grep.Fixed
+			case arg == "-P" || arg == "--perl-regexp":
+				opts.Mode = grep.Perl
+			case arg == "-l" || arg == "--files-with-matches" || arg == "--name-only":
+				opts.FilesWithMatches = true
+			case arg == "-c" || arg == "--count":
+				opts.Count = true
+			case arg == "--cached":
+				opts.Cached = true
+			case arg == "--threads" && i+1 < len(args):
+				i++
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 0 {
+					valid = false
+				}
+				opts.Threads = n
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				positional = append(positional, arg)
+			}
+		}
+
+		if len(opts.Patterns) == 0 && len(positional) > 0 {
+			opts.Patterns = positional[:1]
+			positional = positional[1:]
+		}
+		if len(opts.Patterns) == 0 {
+			valid = false
+		}
+		opts.Revs = positional
+		opts.RevsMayBePaths = !dashdash
+
+		if !valid {
+			fmt.Fprintln(os.Stderr, "Usage: mygit grep [-n] [-i] [-w] [-E | -F | -P] [-l | -c] [--cached] [--threads <n>] (<pattern> | -e <pattern>...) [<rev>...] [--] [<path>...]")
+			os.Exit(1)
+		}
+
+		found, err := commands.Grep(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !found {
+			os.Exit(1)
+		}
+	case "bisect":
+		var opts commands.BisectOptions
+		args := os.Args[2:]
+		valid := len(args) > 0
+
+		if valid {
+			opts.Command = args[0]
+			opts.Args = args[1:]
+		}
+
+		switch opts.Command {
+		case "start", "bad", "good", "skip":
+			for _, arg := range opts.Args {
+				if strings.HasPrefix(arg, "-") {
+					valid = false
+				}
+			}
+		case "reset":
+			valid = len(opts.Args) <= 1
+		case "log":
+			valid = len(opts.Args) == 0
+		case "replay":
+			valid = len(opts.Args) == 1
+		case "run":
+			valid = len(opts.Args) > 0
+		default:
+			valid = false
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit bisect start [<bad> [<good>...]]\n")
+			fmt.Fprintf(os.Stderr, "       mygit bisect (bad | good | skip) [<rev>...]\n")
+			fmt.Fprintf(os.Stderr, "       mygit bisect reset [<commit>]\n")
+			fmt.Fprintf(os.Stderr, "       mygit bisect log | replay <logfile>\n")
+			fmt.Fprintf(os.Stderr, "       mygit bisect run <cmd> [<arg>...]\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Bisect(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "describe":
+		opts := commands.DescribeOptions{Abbrev: -1}
+		args := os.Args[2:]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "--tags":
+				opts.Tags = true
+			case arg == "--long":
+				opts.Long = true
+			case arg == "--always":
+				opts.Always = true
+			case arg == "--dirty":
+				opts.Dirty = "-dirty"
+			case strings.HasPrefix(arg, "--dirty="):
+				opts.Dirty = strings.TrimPrefix(arg, "--dirty=")
+			case arg == "--match" && i+1 < len(args):
+				i++
+				opts.Match = append(opts.Match, args[i])
+			case strings.HasPrefix(arg, "--match="):
+				opts.Match = append(opts.Match, strings.TrimPrefix(arg, "--match="))
+			case arg == "--exclude" && i+1 < len(args):
+				i++
+				opts.Exclude = append(opts.Exclude, args[i])
+			case strings.HasPrefix(arg, "--exclude="):
+				opts.Exclude = append(opts.Exclude, strings.TrimPrefix(arg, "--exclude="))
+			case strings.HasPrefix(arg, "--abbrev="):
+				n, err := strconv.Atoi(strings.TrimPrefix(arg, "--abbrev="))
+				if err != nil || n < 0 {
+					valid = false
+				}
+				opts.Abbrev = n
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				opts.Commits = append(opts.Commits, arg)
+			}
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit describe [--tags] [--long] [--always] [--abbrev=<n>] [--match <pattern>]... [--exclude <pattern>]... [--dirty[=<mark>] | <commit>...]\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Describe(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "name-rev":
+		var opts commands.NameRevOptions
+		args := os.Args[2:]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "--all":
+				opts.All = true
+			case arg == "--tags":
+				opts.Tags = true
+			case arg == "--name-only":
+				opts.NameOnly = true
+			case arg == "--no-undefined":
+				opts.NoUndefined = true
+			case arg == "--always":
+				opts.Always = true
+			case arg == "--refs" && i+1 < len(args):
+				i++
+				opts.Refs = append(opts.Refs, args[i])
+			case strings.HasPrefix(arg, "--refs="):
+				opts.Refs = append(opts.Refs, strings.TrimPrefix(arg, "--refs="))
+			case arg == "--exclude" && i+1 < len(args):
+				i++
+				opts.Exclude = append(opts.Exclude, args[i])
+			case strings.HasPrefix(arg, "--exclude="):
+				opts.Exclude = append(opts.Exclude, strings.TrimPrefix(arg, "--exclude="))
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				opts.Commits = append(opts.Commits, arg)
+			}
+		}
+
+		if opts.All == (len(opts.Commits) > 0) {
+			valid = false
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit name-rev [--tags] [--refs=<pattern>]... [--exclude=<pattern>]... [--name-only] [--no-undefined] [--always] (--all | <commit>...)\n")
+			os.Exit(1)
+		}
+
+		if err := commands.NameRev(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "shortlog":
+		var opts commands.ShortlogOptions
+		args := os.Args[2:]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "--summary":
+				opts.Summary = true
+			case arg == "--numbered":
+				opts.Numbered = true
+			case arg == "--email":
+				opts.Email = true
+			case arg == "--committer":
+				opts.Groups = append(opts.Groups, "committer")
+			case arg == "--group" && i+1 < len(args):
+				i++
+				opts.Groups = append(opts.Groups, args[i])
+			case strings.HasPrefix(arg, "--group="):
+				opts.Groups = append(opts.Groups, strings.TrimPrefix(arg, "--group="))
+			case len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.Trim(arg[1:], "snec") == "":
+				// Short flags may be bundled, as in -sne
+				for _, flag := range arg[1:] {
+					switch flag {
+					case 's':
+						opts.Summary = true
+					case 'n':
+						opts.Numbered = true
+					case 'e':
+						opts.Email = true
+					case 'c':
+						opts.Groups = append(opts.Groups, "committer")
+					}
+				}
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				opts.Revs = append(opts.Revs, arg)
+			}
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit shortlog [-s] [-n] [-e] [-c] [--group=(author | committer | trailer:<key>)]... [<revision-range>...]\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Shortlog(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "archive":
+		var opts commands.ArchiveOptions
+		args := os.Args[2:]
+		valid := true
+		var positional []string
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "--format" && i+1 < len(args):
+				i++
+				opts.Format = args[i]
+			case strings.HasPrefix(arg, "--format="):
+				opts.Format = strings.TrimPrefix(arg, "--format=")
+			case arg == "--prefix" && i+1 < len(args):
+				i++
+				opts.Prefix = args[i]
+			case strings.HasPrefix(arg, "--prefix="):
+				opts.Prefix = strings.TrimPrefix(arg, "--prefix=")
+			case (arg == "-o" || arg == "--output") && i+1 < len(args):
+				i++
+				opts.Output = args[i]
+			case strings.HasPrefix(arg, "--output="):
+				opts.Output = strings.TrimPrefix(arg, "--output=")
+			case arg == "--":
+				positional = append(positional, args[i+1:]...)
+				i = len(args)
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				positional = append(positional, arg)
+			}
+		}
+
+		if !valid || len(positional) == 0 {
+			fmt.Fprintf(os.Stderr, "Usage: mygit archive [--format=(tar | tgz | tar.gz | zip)] [--prefix=<prefix>/] [-o <file>] <tree-ish> [<path>...]\n")
+			os.Exit(1)
+		}
+		opts.TreeIsh = positional[0]
+		opts.Paths = positional[1:]
+
+		if err := commands.Archive(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "format-patch":
+		var opts commands.FormatPatchOptions
+		args := os.Args[2:]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "--stdout":
+				opts.Stdout = true
+			case (arg == "-o" || arg == "--output-directory") && i+1 < len(args):
+				i++
+				opts.OutputDir = args[i]
+			case strings.HasPrefix(arg, "--output-directory="):
+				opts.OutputDir = strings.TrimPrefix(arg, "--output-directory=")
+			case arg == "-n" || arg == "--numbered":
+				opts.Numbered = true
+			case arg == "-N" || arg == "--no-numbered":
+				opts.NoNumbered = true
+			case strings.HasPrefix(arg, "--subject-prefix="):
+				opts.SubjectPrefix = strings.TrimPrefix(arg, "--subject-prefix=")
+			case strings.HasPrefix(arg, "--signature="):
+				opts.Signature = strings.TrimPrefix(arg, "--signature=")
+			case arg == "--no-signature":
+				opts.NoSignature = true
+			case len(arg) > 1 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+				n, err := strconv.Atoi(arg[1:])
+				if err != nil || n < 1 {
+					valid = false
+				}
+				opts.Count = n
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				opts.Revs = append(opts.Revs, arg)
+			}
+		}
+
+		if !valid || (len(opts.Revs) == 0 && opts.Count == 0) {
+			fmt.Fprintf(os.Stderr, "Usage: mygit format-patch [--stdout | -o <dir>] [-n | -N] [--subject-prefix=<prefix>] [--signature=<text> | --no-signature] [-<n>] (<since> | <revision-range>)\n")
+			os.Exit(1)
+		}
+
+		if err := commands.FormatPatch(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "am":
+		var opts commands.AmOptions
+		args := os.Args[2:]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-3" || arg == "--3way":
+				opts.ThreeWay = true
+			case arg == "--continue" || arg == "-r" || arg == "--resolved":
+				opts.Continue = true
+			case arg == "--skip":
+				opts.Skip = true
+			case arg == "--abort":
+				opts.Abort = true
+			case strings.HasPrefix(arg, "-"):
+				valid = false
+			default:
+				opts.Mailboxes = append(opts.Mailboxes, arg)
+			}
+		}
+
+		actions := 0
+		for _, set := range []bool{opts.Continue, opts.Skip, opts.Abort} {
+			if set {
+				actions++
+			}
+		}
+		if actions > 1 || (actions == 1 && (len(opts.Mailboxes) > 0 || opts.ThreeWay)) {
+			valid = false
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit am [-3 | --3way] [<mbox>...]\n       mygit am (--continue | --skip | --abort)\n")
+			os.Exit(1)
+		}
+
+		if err := commands.Am(opts); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 	case "commit":
-		if len(os.Args) < 4 || os.Args[2] != "-m" {
-			fmt.Fprintf(os.Stderr, "Usage: mygit commit -m \"message\"\n")
+		var opts commands.CommitOptions
+		args := os.Args[2:]
+		valid := true
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-m" && i+1 < len(args):
+				i++
+				opts.Messages = append(opts.Messages, args[i])
+			case arg == "-F" && i+1 < len(args):
+				i++
+				opts.File = args[i]
+			case arg == "-a" || arg == "--all":
+				opts.All = true
+			case arg == "--amend":
+				opts.Amend = true
+			case arg == "--allow-empty":
+				opts.AllowEmpty = true
+			case strings.HasPrefix(arg, "--author="):
+				opts.Author = strings.TrimPrefix(arg, "--author=")
+			case strings.HasPrefix(arg, "--date="):
+				opts.Date = strings.TrimPrefix(arg, "--date=")
+			default:
+				valid = false
+			}
+		}
+
+		if !valid {
+			fmt.Fprintf(os.Stderr, "Usage: mygit commit [-a] [--amend] [--allow-empty] [-m \"message\"]... [-F <file>] [--author=\"Name <email>\"] [--date=<date>]\n")
 			os.Exit(1)
 		}
 
-		message := os.Args[3]
-		if err := commands.Commit(message); err != nil {
+		if err := commands.Commit(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "pack-objects":
+		window, depth := 0, 0
+		baseName := ""
+
+		for _, arg := range os.Args[2:] {
+			switch {
+			case strings.HasPrefix(arg, "--window="):
+				window = intFlag(arg)
+			case strings.HasPrefix(arg, "--depth="):
+				depth = intFlag(arg)
+			default:
+				baseName = arg
+			}
+		}
+
+		if baseName == "" {
+			fmt.Fprintf(os.Stderr, "Usage: mygit pack-objects [--window=<n>] [--depth=<n>] <base-name>\n")
+			os.Exit(1)
+		}
+
+		if err := commands.PackObjects(baseName, window, depth); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "repack":
+		var opts commands.RepackOptions
+
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-a":
+				opts.All = true
+			case arg == "-d":
+				opts.Delete = true
+			case arg == "-ad" || arg == "-da":
+				opts.All = true
+				opts.Delete = true
+			case strings.HasPrefix(arg, "--window="):
+				opts.Window = intFlag(arg)
+			case strings.HasPrefix(arg, "--depth="):
+				opts.Depth = intFlag(arg)
+			default:
+				fmt.Fprintf(os.Stderr, "Usage: mygit repack [-a] [-d] [--window=<n>] [--depth=<n>]\n")
+				os.Exit(1)
+			}
+		}
+
+		if err := commands.Repack(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "prune":
+		var opts commands.PruneOptions
+		args := os.Args[2:]
+
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-n" || arg == "--dry-run":
+				opts.DryRun = true
+			case arg == "-v" || arg == "--verbose":
+				opts.Verbose = true
+			case strings.HasPrefix(arg, "--expire="):
+				opts.Expire = strings.TrimPrefix(arg, "--expire=")
+			case arg == "--expire" && i+1 < len(args):
+				i++
+				opts.Expire = args[i]
+			default:
+				fmt.Fprintf(os.Stderr, "Usage: mygit prune [-n] [-v] [--expire=<time>]\n")
+				os.Exit(1)
+			}
+		}
+
+		if err := commands.Prune(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "gc":
+		var opts commands.GCOptions
+
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-n" || arg == "--dry-run":
+				opts.DryRun = true
+			case arg == "--no-repack":
+				opts.NoRepack = true
+			case arg == "--no-prune":
+				opts.Prune = "never"
+			case strings.HasPrefix(arg, "--prune="):
+				opts.Prune = strings.TrimPrefix(arg, "--prune=")
+			default:
+				fmt.Fprintf(os.Stderr, "Usage: mygit gc [--dry-run] [--no-repack] [--prune=<date> | --no-prune]\n")
+				os.Exit(1)
+			}
+		}
+
+		if err := commands.GC(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "fsck":
+		showDangling := true
+
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--no-dangling":
+				showDangling = false
+			case "--dangling":
+				showDangling = true
+			default:
+				fmt.Fprintf(os.Stderr, "Usage: mygit fsck [--[no-]dangling]\n")
+				os.Exit(1)
+			}
+		}
+
+		if err := commands.Fsck(showDangling); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "check-ignore":
+		var opts commands.CheckIgnoreOptions
+
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "-v", "--verbose":
+				opts.Verbose = true
+			case "-n", "--non-matching":
+				opts.NonMatching = true
+			case "--stdin":
+				opts.Stdin = true
+			case "--no-index":
+				opts.NoIndex = true
+			default:
+				opts.Paths = append(opts.Paths, arg)
+			}
+		}
+
+		if len(opts.Paths) == 0 && !opts.Stdin {
+			fmt.Fprintf(os.Stderr, "Usage: mygit check-ignore [-v] [-n] [--no-index] [--stdin] <pathname>...\n")
+			os.Exit(1)
+		}
+
+		found, err := commands.CheckIgnore(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !found {
+			os.Exit(1)
+		}
+	case "config":
+		var opts commands.ConfigOptions
+		var rest []string
+
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--global", "--system", "--local":
+				opts.Scope = config.Scope(strings.TrimPrefix(arg, "--"))
+			case "--get", "--get-all", "--add", "--unset", "--unset-all", "--list":
+				opts.Action = strings.TrimPrefix(arg, "--")
+			case "-l":
+				opts.Action = "list"
+			case "--bool", "--int":
+				opts.Type = strings.TrimPrefix(arg, "--")
+			case "--type=bool", "--type=int":
+				opts.Type = strings.TrimPrefix(arg, "--type=")
+			default:
+				rest = append(rest, arg)
+			}
+		}
+
+		if opts.Action == "" {
+			opts.Action = "get"
+			if len(rest) == 2 {
+				opts.Action = "set"
+			}
+		}
+
+		want := map[string]int{"get": 1, "get-all": 1, "unset": 1, "unset-all": 1, "set": 2, "add": 2, "list": 0}
+		if len(rest) != want[opts.Action] {
+			fmt.Fprintf(os.Stderr, "Usage: mygit config [--global | --system | --local] [--bool | --int]\n")
+			fmt.Fprintf(os.Stderr, "                    [--get | --get-all | --unset | --unset-all | --add] <name> [<value>]\n")
+			fmt.Fprintf(os.Stderr, "       mygit config [--global | --system | --local] --list\n")
+			os.Exit(1)
+		}
+
+		if len(rest) > 0 {
+			opts.Name = rest[0]
+		}
+		if len(rest) > 1 {
+			opts.Value = rest[1]
+		}
+
+		if err := commands.Config(opts); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -78,3 +1134,16 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// intFlag parses the value of a --name=<n> option
+func intFlag(arg string) int {
+	value := arg[strings.Index(arg, "=")+1:]
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid number in %s\n", arg)
+		os.Exit(1)
+	}
+
+	return n
+}
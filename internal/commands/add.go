@@ -4,62 +4,225 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/ignore"
 	"github.com/SteliosSpanos/mygit/pkg/index"
-	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
 	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
 )
 
-func Add(filePath string) error {
-	gitDir, err := FindGitDir()
+type AddOptions struct {
+	// Paths limits what is staged; empty means the whole work tree
+	Paths []string
+	// Update only touches files already in the index
+	Update bool
+	DryRun bool
+	// Force adds ignored files too
+	Force bool
+	// Patch picks hunks of tracked files to stage interactively
+	Patch bool
+
+	// quiet suppresses the per-path report, for commit -a
+	quiet  bool
+	ignore *ignore.Matcher
+}
+
+func Add(opts AddOptions) error {
+	gitDir, store, err := openStore()
 	if err != nil {
 		return err
 	}
 
 	repoRoot := filepath.Dir(gitDir)
 
-	absPath, err := filepath.Abs(filePath)
+	prefix, err := worktree.Prefix(repoRoot)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return err
 	}
 
-	relPath, err := filepath.Rel(repoRoot, absPath)
+	ps, err := pathspec.New(prefix, opts.Paths)
 	if err != nil {
-		return fmt.Errorf("file is outside repository: %w", err)
+		return err
 	}
 
-	data, err := os.ReadFile(absPath)
+	idx, err := index.ReadIndex(gitDir)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return fmt.Errorf("failed to read index: %w", err)
 	}
 
-	blob := objects.NewBlob(data)
-	hash, err := storage.WriteObject(gitDir, blob)
+	cfg, err := config.Load(gitDir)
 	if err != nil {
-		return fmt.Errorf("failed to write blob: %w", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	info, err := os.Stat(absPath)
+	if opts.ignore, err = ignore.New(repoRoot, gitDir, cfg); err != nil {
+		return err
+	}
+
+	var changed bool
+	if opts.Patch {
+		changed, err = addPatch(gitDir, store, cfg, idx, ps)
+	} else {
+		changed, err = stagePaths(repoRoot, store, idx, ps, opts)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+		return err
 	}
 
-	mode := "100644"
-	if info.Mode()&0111 != 0 {
-		mode = "100755"
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		return unmatchedError(repoRoot, prefix, unmatched, opts.ignore)
 	}
 
-	idx, err := index.ReadIndex(gitDir)
+	if opts.DryRun || !changed {
+		return nil
+	}
+
+	return writeSortedIndex(gitDir, idx)
+}
+
+// stagePaths brings the index entries matching ps in line with the work
+// tree: new and modified files are added and deleted ones removed. It
+// reports whether the index changed.
+func stagePaths(repoRoot string, store storage.ObjectStore, idx *index.Index, ps *pathspec.Pathspec, opts AddOptions) (bool, error) {
+	changed := false
+
+	for _, path := range idx.Paths() {
+		if !ps.Match(path) {
+			continue
+		}
+
+		info, err := os.Lstat(worktreePath(repoRoot, path))
+		if err == nil && worktree.Tracked(info) {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		if !opts.quiet {
+			reportStaged("remove", path, opts.DryRun)
+		}
+		if !opts.DryRun {
+			idx.Remove(path)
+		}
+		changed = true
+	}
+
+	// Tracked files are always considered, even inside ignored directories
+	paths := idx.Paths()
+
+	if !opts.Update {
+		var matcher *ignore.Matcher
+		if !opts.Force {
+			matcher = opts.ignore
+		}
+
+		untracked, err := worktree.Untracked(repoRoot, idx, matcher)
+		if err != nil {
+			return false, err
+		}
+		paths = append(paths, untracked...)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if !ps.Match(path) {
+			continue
+		}
+
+		staged, err := stageFile(repoRoot, store, idx, path, opts)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || staged
+	}
+
+	return changed, nil
+}
+
+// stageFile adds one work tree file to the index if it differs from its
+// entry, writing the blob only when it is new
+func stageFile(repoRoot string, store storage.ObjectStore, idx *index.Index, path string, opts AddOptions) (bool, error) {
+	full := filepath.Join(repoRoot, filepath.FromSlash(path))
+
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read index: %w", err)
+		return false, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if !worktree.Tracked(info) {
+		return false, nil
+	}
+
+	hash, err := worktree.HashFile(store.Algorithm(), full, info)
+	if err != nil {
+		return false, err
+	}
+
+	mode := worktree.Mode(info)
+	if entry, ok := idx.Get(path); ok && entry.Hash == hash && entry.Mode == mode {
+		return false, nil
 	}
 
-	idx.Add(mode, hash, relPath)
+	if !opts.quiet {
+		reportStaged("add", path, opts.DryRun)
+	}
+	if opts.DryRun {
+		return true, nil
+	}
 
-	if err := index.WriteIndex(gitDir, idx); err != nil {
-		return fmt.Errorf("failed to write index: %w", err)
+	if !store.Has(hash) {
+		if hash, err = worktree.WriteFile(store, full, info); err != nil {
+			return false, fmt.Errorf("failed to write blob: %w", err)
+		}
 	}
 
-	fmt.Printf("Added '%s' to staging area\n", relPath)
-	return nil
+	idx.Add(mode, hash, path)
+	return true, nil
+}
+
+// unmatchedError explains a pathspec that matched nothing, pointing out
+// when it names an ignored path
+func unmatchedError(repoRoot, prefix string, unmatched []string, matcher *ignore.Matcher) error {
+	var ignored []string
+
+	for _, arg := range unmatched {
+		path, err := pathspec.Resolve(prefix, arg)
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Lstat(filepath.Join(repoRoot, filepath.FromSlash(path)))
+		if err != nil {
+			return fmt.Errorf("pathspec '%s' did not match any files", arg)
+		}
+
+		isIgnored, _, err := matcher.Ignored(path, info.IsDir())
+		if err != nil {
+			return err
+		}
+		if !isIgnored {
+			return fmt.Errorf("pathspec '%s' did not match any files", arg)
+		}
+		ignored = append(ignored, arg)
+	}
+
+	return fmt.Errorf("the following paths are ignored by one of your .gitignore files:\n%s\nuse -f if you really want to add them", strings.Join(ignored, "\n"))
+}
+
+func reportStaged(action, path string, dryRun bool) {
+	switch {
+	case dryRun:
+		fmt.Printf("%s '%s'\n", action, path)
+	case action == "add":
+		fmt.Printf("Added '%s' to staging area\n", path)
+	default:
+		fmt.Printf("Removed '%s' from staging area\n", path)
+	}
 }
@@ -0,0 +1,411 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/diff"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+const patchHelp = `y - stage this hunk
+n - do not stage this hunk
+q - quit; do not stage this hunk or any of the remaining ones
+a - stage this hunk and all later hunks in the file
+d - do not stage this hunk or any of the later hunks in the file
+s - split the current hunk into smaller hunks
+e - manually edit the current hunk
+? - print help
+`
+
+const editHelp = `# ---
+# To remove '-' lines, make them ' ' lines (context).
+# To remove '+' lines, delete them.
+# Lines starting with # will be removed.
+# If the patch does not apply cleanly, the hunk is left unchanged.
+# If all lines of the hunk are removed, the edit is aborted.
+`
+
+// errQuit stops hunk selection, keeping what was already chosen
+var errQuit = fmt.Errorf("quit")
+
+type hunkChoice int
+
+const (
+	undecided hunkChoice = iota
+	stage
+	skip
+)
+
+// patchSession walks tracked files and asks, hunk by hunk, what to stage
+type patchSession struct {
+	gitDir string
+	store  storage.ObjectStore
+	cfg    *config.Config
+	idx    *index.Index
+	in     *bufio.Reader
+	out    io.Writer
+}
+
+// addPatch interactively stages parts of the changes in tracked files
+// matching ps, writing new blobs built from the old content and the chosen
+// hunks. The work tree is left alone.
+func addPatch(gitDir string, store storage.ObjectStore, cfg *config.Config, idx *index.Index, ps *pathspec.Pathspec) (bool, error) {
+	s := &patchSession{
+		gitDir: gitDir,
+		store:  store,
+		cfg:    cfg,
+		idx:    idx,
+		in:     bufio.NewReader(os.Stdin),
+		out:    os.Stdout,
+	}
+
+	var entries []index.Entry
+	for _, entry := range idx.Entries {
+		if entry.Stage == 0 && ps.Match(entry.Path) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	changed, shown := false, false
+	for _, entry := range entries {
+		staged, had, err := s.patchFile(entry)
+		changed = changed || staged
+		shown = shown || had
+
+		if err == errQuit {
+			break
+		}
+		if err != nil {
+			return changed, err
+		}
+	}
+
+	if !shown {
+		fmt.Fprintln(s.out, "No changes.")
+	}
+
+	return changed, nil
+}
+
+// patchFile offers the changes of one file, reporting whether anything was
+// staged and whether it had changes at all
+func (s *patchSession) patchFile(entry index.Entry) (bool, bool, error) {
+	full := filepath.Join(filepath.Dir(s.gitDir), filepath.FromSlash(entry.Path))
+
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) || (err == nil && !worktree.Tracked(info)) {
+		return s.patchDeletion(entry)
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to stat %s: %w", entry.Path, err)
+	}
+
+	hash, err := worktree.HashFile(s.store.Algorithm(), full, info)
+	if err != nil {
+		return false, false, err
+	}
+
+	mode := worktree.Mode(info)
+	if hash == entry.Hash && mode == entry.Mode {
+		return false, false, nil
+	}
+
+	oldData, err := s.readBlob(entry.Hash)
+	if err != nil {
+		return false, false, err
+	}
+	newData, err := readWorktreeFile(full, info)
+	if err != nil {
+		return false, false, err
+	}
+
+	fmt.Fprintf(s.out, "diff --git a/%s b/%s\n", entry.Path, entry.Path)
+	if mode != entry.Mode {
+		fmt.Fprintf(s.out, "old mode %s\nnew mode %s\n", entry.Mode, mode)
+	}
+
+	if isBinary(oldData) || isBinary(newData) {
+		fmt.Fprintln(s.out, "Binary files differ, skipping")
+		return false, true, nil
+	}
+
+	oldLines := diff.SplitLines(string(oldData))
+	hunks := diff.Hunks(diff.Lines(oldLines, diff.SplitLines(string(newData))), diff.DefaultContext)
+
+	if hash != entry.Hash {
+		fmt.Fprintf(s.out, "index %s..%s\n--- a/%s\n+++ b/%s\n", entry.Hash[:7], hash[:7], entry.Path, entry.Path)
+	}
+
+	newMode := entry.Mode
+	if mode != entry.Mode {
+		answer, err := s.askChoice("Stage mode change [y,n,q,a,d,?]? ", "ynqad")
+		if answer == "y" || answer == "a" {
+			newMode = mode
+		}
+		if err != nil || answer == "q" {
+			return s.stageHunks(entry, newMode, oldLines, nil, errQuit)
+		}
+		if answer == "d" {
+			return false, true, nil
+		}
+	}
+
+	selected, err := s.chooseHunks(hunks)
+	return s.stageHunks(entry, newMode, oldLines, selected, err)
+}
+
+// stageHunks writes the chosen hunks into a new blob for the entry
+func (s *patchSession) stageHunks(entry index.Entry, mode string, oldLines []string, selected []diff.Hunk, chooseErr error) (bool, bool, error) {
+	if chooseErr != nil && chooseErr != errQuit {
+		return false, true, chooseErr
+	}
+	if len(selected) == 0 && mode == entry.Mode {
+		return false, true, chooseErr
+	}
+
+	hash := entry.Hash
+	if len(selected) > 0 {
+		lines, err := diff.Apply(oldLines, selected)
+		if err != nil {
+			return false, true, err
+		}
+
+		if hash, err = storage.WriteObject(s.store, objects.NewBlob([]byte(strings.Join(lines, "")))); err != nil {
+			return false, true, fmt.Errorf("failed to write blob: %w", err)
+		}
+	}
+
+	s.idx.Add(mode, hash, entry.Path)
+	return true, true, chooseErr
+}
+
+func (s *patchSession) patchDeletion(entry index.Entry) (bool, bool, error) {
+	fmt.Fprintf(s.out, "diff --git a/%s b/%s\ndeleted file mode %s\n", entry.Path, entry.Path, entry.Mode)
+
+	answer, err := s.askChoice("Stage deletion [y,n,q,a,d,?]? ", "ynqad")
+	if answer == "y" || answer == "a" {
+		s.idx.Remove(entry.Path)
+		return true, true, err
+	}
+	if err == nil && answer == "q" {
+		err = errQuit
+	}
+
+	return false, true, err
+}
+
+// chooseHunks asks about each hunk until every one is decided and returns
+// those to stage, in order
+func (s *patchSession) chooseHunks(hunks []diff.Hunk) ([]diff.Hunk, error) {
+	choices := make([]hunkChoice, len(hunks))
+	var quit error
+
+	for i := 0; i < len(hunks); {
+		if choices[i] != undecided {
+			i++
+			continue
+		}
+
+		hunks[i].Write(s.out)
+
+		options := "y,n,q,a,d"
+		if len(hunks[i].Split()) > 1 {
+			options += ",s"
+		}
+		answer, err := s.ask(fmt.Sprintf("(%d/%d) Stage this hunk [%s,e,?]? ", i+1, len(hunks), options))
+		if err != nil {
+			answer = "q"
+		}
+
+		switch answer {
+		case "y":
+			choices[i] = stage
+		case "n":
+			choices[i] = skip
+		case "a", "d", "q":
+			choice := stage
+			if answer != "a" {
+				choice = skip
+			}
+			for j := i; j < len(hunks); j++ {
+				if choices[j] == undecided {
+					choices[j] = choice
+				}
+			}
+			if answer == "q" {
+				quit = errQuit
+			}
+		case "s":
+			pieces := hunks[i].Split()
+			if len(pieces) < 2 {
+				fmt.Fprintln(s.out, "Sorry, cannot split this hunk")
+				continue
+			}
+			fmt.Fprintf(s.out, "Split into %d hunks.\n", len(pieces))
+
+			hunks = append(hunks[:i], append(pieces, hunks[i+1:]...)...)
+			choices = append(choices[:i], append(make([]hunkChoice, len(pieces)), choices[i+1:]...)...)
+		case "e":
+			edited, err := s.editHunk(hunks[i])
+			if err != nil {
+				fmt.Fprintln(s.out, err)
+				continue
+			}
+			if edited != nil {
+				hunks[i] = *edited
+				choices[i] = stage
+			}
+		default:
+			fmt.Fprint(s.out, patchHelp)
+		}
+	}
+
+	var selected []diff.Hunk
+	for i, h := range hunks {
+		if choices[i] == stage {
+			selected = append(selected, h)
+		}
+	}
+
+	return selected, quit
+}
+
+// editHunk lets the user rewrite a hunk in the editor. A nil hunk means
+// the edit was abandoned.
+func (s *patchSession) editHunk(h diff.Hunk) (*diff.Hunk, error) {
+	var buf bytes.Buffer
+	buf.WriteString("# Manual hunk edit mode -- see bottom for a quick guide.\n")
+	h.Write(&buf)
+	buf.WriteString(editHelp)
+
+	path := filepath.Join(s.gitDir, "addp-hunk-edit.diff")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(path)
+
+	if err := launchEditor(s.cfg, path); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	edited := diff.Hunk{OldPos: h.OldPos, NewPos: h.NewPos}
+	for _, line := range diff.SplitLines(string(data)) {
+		switch {
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, "@@"):
+		case strings.HasPrefix(line, `\`):
+			if n := len(edited.Lines); n > 0 {
+				edited.Lines[n-1].Text = strings.TrimSuffix(edited.Lines[n-1].Text, "\n")
+			}
+		case strings.HasPrefix(line, "+"):
+			edited.Lines = append(edited.Lines, diff.Line{Op: diff.Insert, Text: line[1:]})
+		case strings.HasPrefix(line, "-"):
+			edited.Lines = append(edited.Lines, diff.Line{Op: diff.Delete, Text: line[1:]})
+		case strings.HasPrefix(line, " "):
+			edited.Lines = append(edited.Lines, diff.Line{Op: diff.Equal, Text: line[1:]})
+		case line == "\n":
+			edited.Lines = append(edited.Lines, diff.Line{Op: diff.Equal, Text: "\n"})
+		default:
+			return nil, fmt.Errorf("your edited hunk does not apply: unexpected line %q", strings.TrimSuffix(line, "\n"))
+		}
+	}
+
+	if len(edited.Lines) == 0 {
+		return nil, nil
+	}
+
+	// The old side must be unchanged for the hunk to apply
+	var before, after []string
+	for _, line := range h.Lines {
+		if line.Op != diff.Insert {
+			before = append(before, line.Text)
+		}
+	}
+	for _, line := range edited.Lines {
+		if line.Op != diff.Insert {
+			after = append(after, line.Text)
+		}
+	}
+	if strings.Join(before, "") != strings.Join(after, "") {
+		return nil, fmt.Errorf("your edited hunk does not apply")
+	}
+
+	return &edited, nil
+}
+
+// ask prompts and returns the first letter of the answer
+func (s *patchSession) ask(prompt string) (string, error) {
+	fmt.Fprint(s.out, prompt)
+
+	line, err := s.in.ReadString('\n')
+	answer := strings.TrimSpace(line)
+	if answer == "" && err != nil {
+		fmt.Fprintln(s.out)
+		return "q", errQuit
+	}
+	if answer == "" {
+		return "?", nil
+	}
+
+	return strings.ToLower(answer[:1]), nil
+}
+
+// askChoice prompts until one of the valid letters is given
+func (s *patchSession) askChoice(prompt, valid string) (string, error) {
+	for {
+		answer, err := s.ask(prompt)
+		if err != nil || strings.Contains(valid, answer) {
+			return answer, err
+		}
+		fmt.Fprint(s.out, patchHelp)
+	}
+}
+
+func (s *patchSession) readBlob(hash string) ([]byte, error) {
+	return readBlob(s.store, hash)
+}
+
+func readWorktreeFile(path string, info os.FileInfo) ([]byte, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read link %s: %w", path, err)
+		}
+		return []byte(target), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+
+	return data, nil
+}
+
+// isBinary uses Git's heuristic of a NUL byte in the first 8000 bytes
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+
+	return bytes.IndexByte(data, 0) != -1
+}
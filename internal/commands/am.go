@@ -0,0 +1,629 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/mailbox"
+	"github.com/SteliosSpanos/mygit/pkg/merge"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/patch"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+)
+
+// errNoBlobs stops a three-way fallback when the preimages named by the
+// patch's index lines are not in the store
+var errNoBlobs = errors.New("repository lacks necessary blobs to fall back on 3-way merge")
+
+type AmOptions struct {
+	// Mailboxes are mbox files or single patches, standard input when empty
+	Mailboxes []string
+	// ThreeWay merges a patch that does not apply against the blobs it was
+	// made from, when the repository has them
+	ThreeWay bool
+	Continue bool
+	Skip     bool
+	Abort    bool
+}
+
+// am applies patches from mail as commits, keeping its state in
+// .git/rebase-apply like Git: the split mails numbered from 0001, the one
+// to apply next and the last, and HEAD before it started
+type am struct {
+	gitDir   string
+	store    storage.ObjectStore
+	cfg      *config.Config
+	res      *revision.Resolver
+	threeWay bool
+	next     int
+	last     int
+}
+
+func Am(opts AmOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	a := &am{gitDir: gitDir, store: store, cfg: cfg, res: revision.New(gitDir, store), threeWay: opts.ThreeWay}
+
+	switch {
+	case opts.Continue:
+		return a.resume(a.commitResolved)
+	case opts.Skip:
+		return a.resume(a.skip)
+	case opts.Abort:
+		return a.abort()
+	}
+
+	return a.start(opts.Mailboxes)
+}
+
+func (a *am) dir() string {
+	return filepath.Join(a.gitDir, "rebase-apply")
+}
+
+func (a *am) path(name string) string {
+	return filepath.Join(a.dir(), name)
+}
+
+func (a *am) mailPath(n int) string {
+	return a.path(fmt.Sprintf("%04d", n))
+}
+
+func (a *am) start(mailboxes []string) error {
+	if exists(a.dir()) {
+		return fmt.Errorf("an am session is already in progress\n(try \"mygit am --continue\", \"--skip\" or \"--abort\")")
+	}
+
+	var mails []string
+	if len(mailboxes) == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read standard input: %w", err)
+		}
+		mails = mailbox.Split(string(data))
+	}
+	for _, name := range mailboxes {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		mails = append(mails, mailbox.Split(string(data))...)
+	}
+	if len(mails) == 0 {
+		return fmt.Errorf("no patches found")
+	}
+
+	if err := a.checkCleanIndex(); err != nil {
+		return err
+	}
+
+	head, err := refs.ReadRef(a.gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	if err := os.MkdirAll(a.dir(), 0755); err != nil {
+		return fmt.Errorf("failed to create am state: %w", err)
+	}
+	for i, mail := range mails {
+		if err := os.WriteFile(a.mailPath(i+1), []byte(mail), 0644); err != nil {
+			return fmt.Errorf("failed to write am state: %w", err)
+		}
+	}
+
+	a.next, a.last = 1, len(mails)
+	if err := a.writeState("orig-head", head); err != nil {
+		return err
+	}
+	if a.threeWay {
+		if err := a.writeState("threeway", "true"); err != nil {
+			return err
+		}
+	}
+	if err := a.saveProgress(); err != nil {
+		return err
+	}
+
+	if head != "" {
+		if err := refs.WriteRef(a.gitDir, "ORIG_HEAD", head); err != nil {
+			return err
+		}
+	}
+
+	return a.run()
+}
+
+// checkCleanIndex refuses to start while the index has changes of its own,
+// which would end up in the first commit
+func (a *am) checkCleanIndex() error {
+	idx, err := index.ReadIndex(a.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(idx.Unmerged()) > 0 {
+		return fmt.Errorf("cannot apply patches: you have unmerged files")
+	}
+
+	staged, _, err := localChanges(a.gitDir, a.store, idx)
+	if err != nil {
+		return err
+	}
+	if staged {
+		return fmt.Errorf("dirty index: cannot apply patches\n(commit or stash your changes)")
+	}
+
+	return nil
+}
+
+func (a *am) run() error {
+	for a.next <= a.last {
+		if err := a.apply(a.next); err != nil {
+			return err
+		}
+
+		a.next++
+		if err := a.saveProgress(); err != nil {
+			return err
+		}
+	}
+
+	return a.cleanup()
+}
+
+// apply commits one mail's patch, falling back to a three-way merge if it
+// does not apply and that was asked for. On failure the session stops
+// here.
+func (a *am) apply(n int) error {
+	mail, files, err := a.readMail(n)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Applying: %s\n", mail.Subject)
+
+	idx, err := index.ReadIndex(a.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	entries, applyErr := a.applyFiles(entryMap(idx.Entries), files)
+	if applyErr != nil {
+		if !a.threeWay {
+			return a.stop(n, mail, applyErr)
+		}
+		if err := a.fallBackThreeWay(n, mail, idx, files); err != nil {
+			return err
+		}
+	} else {
+		result := &merge.Result{Entries: entries, Worktree: entries}
+		if err := checkoutMerge(filepath.Dir(a.gitDir), a.store, idx, result); err != nil {
+			return a.stop(n, mail, err)
+		}
+
+		idx.Entries = entries
+		if err := writeSortedIndex(a.gitDir, idx); err != nil {
+			return err
+		}
+	}
+
+	return a.commit(idx, mail)
+}
+
+// readMail parses the nth mail and the patch it carries
+func (a *am) readMail(n int) (*mailbox.Mail, []*patch.File, error) {
+	data, err := os.ReadFile(a.mailPath(n))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read am state: %w", err)
+	}
+
+	mail, err := mailbox.Parse(string(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("patch %04d: %w", n, err)
+	}
+	if mail.Author.When.IsZero() {
+		mail.Author.When = time.Now()
+	}
+
+	files, err := patch.Parse(mail.Patch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("patch %04d: %w", n, err)
+	}
+	if len(files) == 0 {
+		return nil, nil, a.stop(n, mail, fmt.Errorf("patch is empty"))
+	}
+
+	return mail, files, nil
+}
+
+// applyFiles applies each file's change to the entries of an index,
+// writing the new blobs, and returns the entries that result
+func (a *am) applyFiles(entries map[string]index.Entry, files []*patch.File) ([]index.Entry, error) {
+	for _, f := range files {
+		old, ok := entries[f.OldPath]
+		switch {
+		case f.New && entries[f.NewPath].Hash != "":
+			return nil, fmt.Errorf("%s: already exists in index", f.NewPath)
+		case !f.New && !ok:
+			return nil, fmt.Errorf("%s: does not exist in index", f.OldPath)
+		case !f.New && old.Stage != 0:
+			return nil, fmt.Errorf("%s: needs merge", f.OldPath)
+		}
+
+		if f.Renamed || f.Deleted {
+			delete(entries, f.OldPath)
+		}
+		if f.Deleted {
+			if f.Binary || len(f.Hunks) == 0 {
+				continue
+			}
+			if data, err := a.applyFile(old, f); err != nil {
+				return nil, err
+			} else if len(data) > 0 {
+				return nil, fmt.Errorf("%s: removal patch leaves file contents", f.OldPath)
+			}
+			continue
+		}
+
+		mode := f.NewMode
+		if mode == "" {
+			mode = old.Mode
+		}
+		if mode == "" {
+			mode = "100644"
+		}
+
+		hash := old.Hash
+		switch {
+		case f.Binary:
+			var err error
+			if hash, err = a.binaryResult(old, f); err != nil {
+				return nil, err
+			}
+		case len(f.Hunks) > 0 || f.New:
+			data, err := a.applyFile(old, f)
+			if err != nil {
+				return nil, err
+			}
+			if hash, err = storage.WriteObject(a.store, objects.NewBlob(data)); err != nil {
+				return nil, fmt.Errorf("failed to write blob: %w", err)
+			}
+		}
+
+		entries[f.NewPath] = index.Entry{Path: f.NewPath, Mode: mode, Hash: hash}
+	}
+
+	result := make([]index.Entry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+
+	return result, nil
+}
+
+func (a *am) applyFile(old index.Entry, f *patch.File) ([]byte, error) {
+	var data []byte
+	if old.Hash != "" {
+		var err error
+		if data, err = readBlob(a.store, old.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := f.Apply(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s: patch does not apply", err, f.OldPath)
+	}
+
+	return result, nil
+}
+
+// binaryResult finds the blob a binary change produces, which a patch
+// without the binary data itself can only name by its hash
+func (a *am) binaryResult(old index.Entry, f *patch.File) (string, error) {
+	if old.Hash != "" && !strings.HasPrefix(old.Hash, f.OldHash) {
+		return "", fmt.Errorf("binary patch does not apply to '%s'", f.OldPath)
+	}
+
+	hash, err := a.findBlob(f.NewHash)
+	if err != nil {
+		return "", fmt.Errorf("cannot apply binary patch to '%s' without full index line", f.NewPath)
+	}
+
+	return hash, nil
+}
+
+// findBlob expands the abbreviated name of a blob in the store
+func (a *am) findBlob(abbrev string) (string, error) {
+	if strings.Trim(abbrev, "0") == "" {
+		return "", fmt.Errorf("no blob named")
+	}
+
+	hash, err := a.res.Resolve(abbrev)
+	if err != nil {
+		return "", err
+	}
+
+	return a.res.Peel(hash, objects.BlobObject)
+}
+
+// fallBackThreeWay rebuilds the files the patch was made against from the
+// blobs its index lines name, applies the patch to them, and merges that
+// change into the index. Conflicts stop the session.
+func (a *am) fallBackThreeWay(n int, mail *mailbox.Mail, idx *index.Index, files []*patch.File) error {
+	fmt.Println("Using index info to reconstruct a base tree...")
+
+	base := make(map[string]index.Entry)
+	for _, f := range files {
+		if f.New {
+			continue
+		}
+		hash, err := a.findBlob(f.OldHash)
+		if err != nil {
+			return a.stop(n, mail, errNoBlobs)
+		}
+		mode := f.OldMode
+		if mode == "" {
+			mode = "100644"
+		}
+		base[f.OldPath] = index.Entry{Path: f.OldPath, Mode: mode, Hash: hash}
+	}
+
+	baseEntries := make([]index.Entry, 0, len(base))
+	for _, entry := range base {
+		baseEntries = append(baseEntries, entry)
+	}
+
+	theirs, err := a.applyFiles(entryMap(baseEntries), files)
+	if err != nil {
+		return a.stop(n, mail, errNoBlobs)
+	}
+
+	ours := entryMap(idx.Entries)
+	for _, entry := range baseEntries {
+		if current, ok := ours[entry.Path]; !ok {
+			fmt.Printf("A\t%s\n", entry.Path)
+		} else if current.Hash != entry.Hash || current.Mode != entry.Mode {
+			fmt.Printf("M\t%s\n", entry.Path)
+		}
+	}
+	fmt.Println("Falling back to patching base and 3-way merge...")
+
+	result, err := merge.Trees(a.store, baseEntries, idx.Entries, theirs, merge.Labels{Ours: "HEAD", Theirs: mail.Subject})
+	if err != nil {
+		return err
+	}
+
+	if err := checkoutMerge(filepath.Dir(a.gitDir), a.store, idx, result); err != nil {
+		return a.stop(n, mail, err)
+	}
+
+	idx.Entries = result.Entries
+	if err := writeSortedIndex(a.gitDir, idx); err != nil {
+		return err
+	}
+
+	if !result.Clean() {
+		printConflicts(result)
+		return a.stop(n, mail, fmt.Errorf("failed to merge in the changes"))
+	}
+
+	return nil
+}
+
+// commit records the index as the mail's commit, by its author at the date
+// it was sent. A patch that changes nothing is passed over.
+func (a *am) commit(idx *index.Index, mail *mailbox.Mail) error {
+	head, err := refs.ReadRef(a.gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	treeHash, err := tree.BuildTreeFromIndex(a.store, idx)
+	if err != nil {
+		return fmt.Errorf("failed to build tree: %w", err)
+	}
+
+	var parents []string
+	if head != "" {
+		headCommit, err := loadCommit(a.store, head)
+		if err != nil {
+			return err
+		}
+		if treeHash == headCommit.Tree {
+			fmt.Println("No changes -- Patch already applied.")
+			return nil
+		}
+		parents = []string{head}
+	}
+
+	message := cleanupMessage(mail.Message(), false)
+	if _, err := commitTree(a.gitDir, a.store, a.cfg, treeHash, parents, message, mail.Author); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// stop leaves the session at patch n for the user to sort out
+func (a *am) stop(n int, mail *mailbox.Mail, err error) error {
+	return fmt.Errorf("%w\npatch failed at %04d %s\n(fix it and run \"mygit am --continue\", or use \"--skip\" or \"--abort\")", err, n, mail.Subject)
+}
+
+// resume loads a stopped session, settles the current patch with settle,
+// and carries on with the rest
+func (a *am) resume(settle func() error) error {
+	if err := a.load(); err != nil {
+		return err
+	}
+
+	if a.next <= a.last {
+		if err := settle(); err != nil {
+			return err
+		}
+		a.next++
+		if err := a.saveProgress(); err != nil {
+			return err
+		}
+	}
+
+	return a.run()
+}
+
+// commitResolved commits the stopped patch once the user has applied it
+// to the index by hand
+func (a *am) commitResolved() error {
+	data, err := os.ReadFile(a.mailPath(a.next))
+	if err != nil {
+		return fmt.Errorf("failed to read am state: %w", err)
+	}
+	mail, err := mailbox.Parse(string(data))
+	if err != nil {
+		return err
+	}
+	if mail.Author.When.IsZero() {
+		mail.Author.When = time.Now()
+	}
+
+	idx, err := index.ReadIndex(a.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(idx.Unmerged()) > 0 {
+		return fmt.Errorf("you still have unmerged paths in your index\n(fix the conflicts, mark them with \"mygit add <paths>\" and run \"mygit am --continue\")")
+	}
+
+	staged, _, err := localChanges(a.gitDir, a.store, idx)
+	if err != nil {
+		return err
+	}
+	if !staged {
+		return fmt.Errorf("no changes - did you forget to use \"mygit add\"?\nIf there is nothing left to stage, something else already made the same changes; you might want to skip this patch")
+	}
+
+	fmt.Printf("Applying: %s\n", mail.Subject)
+	return a.commit(idx, mail)
+}
+
+// skip throws away whatever the stopped patch left behind
+func (a *am) skip() error {
+	return discardChanges(a.gitDir, a.store)
+}
+
+// abort returns HEAD, the index and the files the session changed to
+// where it started
+func (a *am) abort() error {
+	if !exists(a.dir()) {
+		return fmt.Errorf("no am session in progress")
+	}
+
+	orig, err := a.readState("orig-head")
+	if err != nil {
+		return err
+	}
+
+	var target []index.Entry
+	if orig != "" {
+		if target, err = commitEntries(a.store, orig); err != nil {
+			return err
+		}
+	}
+
+	idx, err := index.ReadIndex(a.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if err := checkoutResetMerge(filepath.Dir(a.gitDir), a.store, idx, target); err != nil {
+		return err
+	}
+	if err := writeSortedIndex(a.gitDir, idx); err != nil {
+		return err
+	}
+
+	if orig != "" {
+		if err := refs.UpdateHead(a.gitDir, orig); err != nil {
+			return fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	} else if branch, err := refs.GetCurrentBranch(a.gitDir); err == nil {
+		// The session started on an unborn branch, which it is again
+		if err := refs.DeleteRef(a.gitDir, branch); err != nil {
+			return err
+		}
+	}
+
+	return a.cleanup()
+}
+
+func (a *am) load() error {
+	if !exists(a.dir()) {
+		return fmt.Errorf("no am session in progress")
+	}
+
+	for name, value := range map[string]*int{"next": &a.next, "last": &a.last} {
+		text, err := a.readState(name)
+		if err != nil {
+			return err
+		}
+		if *value, err = strconv.Atoi(text); err != nil {
+			return fmt.Errorf("invalid am state in %s", name)
+		}
+	}
+	a.threeWay = exists(a.path("threeway"))
+
+	return nil
+}
+
+func (a *am) saveProgress() error {
+	if err := a.writeState("next", strconv.Itoa(a.next)); err != nil {
+		return err
+	}
+
+	return a.writeState("last", strconv.Itoa(a.last))
+}
+
+func (a *am) writeState(name, value string) error {
+	if err := os.WriteFile(a.path(name), []byte(value+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write am state: %w", err)
+	}
+
+	return nil
+}
+
+func (a *am) readState(name string) (string, error) {
+	data, err := os.ReadFile(a.path(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read am state: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (a *am) cleanup() error {
+	if err := os.RemoveAll(a.dir()); err != nil {
+		return fmt.Errorf("failed to remove am state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/archive"
+	"github.com/SteliosSpanos/mygit/pkg/attributes"
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+type ArchiveOptions struct {
+	TreeIsh string
+	// Paths limit the archive to what they match
+	Paths []string
+	// Format is tar, tgz, tar.gz or zip. When empty it is guessed from the
+	// name of Output, and is otherwise tar.
+	Format string
+	// Prefix goes in front of every path; end it with '/' for a directory
+	Prefix string
+	// Output is the file to write, standard output when empty
+	Output string
+}
+
+// archiver walks a tree into an archive, leaving out what the pathspec
+// does not match and what has the export-ignore attribute
+type archiver struct {
+	store  storage.ObjectStore
+	w      archive.Writer
+	ps     *pathspec.Pathspec
+	attrs  *attributes.Matcher
+	prefix string
+	// lazy holds back each directory until something inside it is
+	// written, as Git does when the pathspec has wildcards
+	lazy   bool
+	queued []archive.Entry
+}
+
+func Archive(opts ArchiveOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = archiveFormat(opts.Output)
+	}
+	if !slices.Contains(archive.Formats, format) {
+		return fmt.Errorf("unknown archive format '%s'", format)
+	}
+
+	res := revision.New(gitDir, store)
+	hash, err := res.Resolve(opts.TreeIsh)
+	if err != nil {
+		return err
+	}
+
+	// A commit gives the archive its id and date; a bare tree has neither
+	commitHash, mtime := "", time.Now()
+	if peeled, err := res.Peel(hash, objects.CommitObject); err == nil {
+		commit, err := loadCommit(store, peeled)
+		if err != nil {
+			return err
+		}
+		commitHash, mtime = peeled, commit.CommitTime
+	}
+
+	treeHash, err := res.Peel(hash, objects.TreeObject)
+	if err != nil {
+		return fmt.Errorf("not a tree object: %s", opts.TreeIsh)
+	}
+
+	// Run from a subdirectory, only that part of the tree is archived
+	prefix, err := worktree.Prefix(filepath.Dir(gitDir))
+	if err != nil {
+		return err
+	}
+	if prefix != "" {
+		entry, err := tree.Lookup(store, treeHash, prefix)
+		if err != nil {
+			return err
+		}
+		if entry == nil || !entry.IsDir() {
+			return fmt.Errorf("current working directory is untracked")
+		}
+		treeHash = entry.Hash
+	}
+
+	entries, err := tree.Flatten(store, treeHash)
+	if err != nil {
+		return err
+	}
+
+	ps, err := pathspec.New("", opts.Paths)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		ps.Match(entry.Path)
+	}
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		return fmt.Errorf("pathspec '%s' did not match any files", unmatched[0])
+	}
+
+	attrs, err := archiveAttributes(gitDir, store, entries)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if opts.Output != "" {
+		file, err := os.Create(opts.Output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", opts.Output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	w, err := archive.NewWriter(format, out, commitHash, mtime)
+	if err != nil {
+		return err
+	}
+
+	a := &archiver{store: store, w: w, ps: ps, attrs: attrs, prefix: opts.Prefix, lazy: ps.HasWildcard()}
+	if strings.HasSuffix(opts.Prefix, "/") {
+		if err := w.WriteEntry(archive.Entry{Path: opts.Prefix, Mode: "040000", Hash: treeHash}, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := a.writeTree(treeHash, ""); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// archiveFormat guesses the format from the name of the output file
+func archiveFormat(output string) string {
+	for _, format := range []string{"tar.gz", "tgz", "zip"} {
+		if strings.HasSuffix(output, "."+format) {
+			return format
+		}
+	}
+
+	return "tar"
+}
+
+// archiveAttributes gathers the attribute rules for an archive: those in
+// core.attributesFile, then the .gitattributes files of the tree from the
+// top down, then .git/info/attributes
+func archiveAttributes(gitDir string, store storage.ObjectStore, entries []index.Entry) (*attributes.Matcher, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	attrs := &attributes.Matcher{}
+	if global := attributes.GlobalFile(cfg); global != "" {
+		if data, err := os.ReadFile(global); err == nil {
+			attrs.Add(attributes.Parse(string(data), "")...)
+		}
+	}
+
+	var files []index.Entry
+	for _, entry := range entries {
+		if path.Base(entry.Path) == ".gitattributes" {
+			files = append(files, entry)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return strings.Count(files[i].Path, "/") < strings.Count(files[j].Path, "/")
+	})
+
+	for _, file := range files {
+		data, err := readBlob(store, file.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		base := path.Dir(file.Path)
+		if base == "." {
+			base = ""
+		}
+		attrs.Add(attributes.Parse(string(data), base)...)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(gitDir, "info", "attributes")); err == nil {
+		attrs.Add(attributes.Parse(string(data), "")...)
+	}
+
+	return attrs, nil
+}
+
+func (a *archiver) writeTree(treeHash, dir string) error {
+	obj, err := storage.LoadObject(a.store, treeHash)
+	if err != nil {
+		return fmt.Errorf("failed to load tree %s: %w", treeHash, err)
+	}
+
+	t, ok := obj.(*objects.Tree)
+	if !ok {
+		return fmt.Errorf("object %s is a %s, not a tree", treeHash, obj.Type())
+	}
+
+	for _, entry := range t.Entries {
+		name := path.Join(dir, entry.Name)
+
+		if entry.IsDir() {
+			if a.exportIgnored(name, true) || !a.ps.Leads(name) {
+				continue
+			}
+
+			dirEntry := archive.Entry{Path: a.prefix + name + "/", Mode: "040000", Hash: entry.Hash}
+			if a.lazy {
+				a.queued = append(a.queued, dirEntry)
+			} else if err := a.w.WriteEntry(dirEntry, nil); err != nil {
+				return err
+			}
+
+			if err := a.writeTree(entry.Hash, name); err != nil {
+				return err
+			}
+
+			// Nothing inside was written, so neither is the directory
+			if n := len(a.queued); n > 0 && a.queued[n-1].Path == dirEntry.Path {
+				a.queued = a.queued[:n-1]
+			}
+			continue
+		}
+
+		submodule := entry.Mode == "160000"
+		if a.exportIgnored(name, submodule) || !a.ps.Match(name) {
+			continue
+		}
+
+		if err := a.writeQueued(); err != nil {
+			return err
+		}
+		if err := a.writeFile(entry, name, submodule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFile adds a blob, streamed from the store, or a submodule, which is
+// archived as an empty directory
+func (a *archiver) writeFile(entry objects.TreeEntry, name string, submodule bool) error {
+	if submodule {
+		return a.w.WriteEntry(archive.Entry{Path: a.prefix + name + "/", Mode: entry.Mode, Hash: entry.Hash}, nil)
+	}
+
+	_, size, r, err := storage.OpenStream(a.store, entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", entry.Hash, err)
+	}
+	defer r.Close()
+
+	return a.w.WriteEntry(archive.Entry{Path: a.prefix + name, Mode: entry.Mode, Hash: entry.Hash, Size: size}, r)
+}
+
+// writeQueued writes the directories held back for the file about to be
+// written
+func (a *archiver) writeQueued() error {
+	for _, dir := range a.queued {
+		if err := a.w.WriteEntry(dir, nil); err != nil {
+			return err
+		}
+	}
+	a.queued = a.queued[:0]
+
+	return nil
+}
+
+func (a *archiver) exportIgnored(name string, isDir bool) bool {
+	return a.attrs.Get(name, isDir, "export-ignore").State == attributes.Set
+}
@@ -0,0 +1,653 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/bisect"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+)
+
+const bisectRefs = "refs/bisect/"
+
+// bisectFiles are the state files a bisection keeps in the git directory
+var bisectFiles = []string{
+	"BISECT_START", "BISECT_TERMS", "BISECT_LOG", "BISECT_NAMES",
+	"BISECT_EXPECTED_REV", "BISECT_ANCESTORS_OK", "BISECT_RUN",
+}
+
+type BisectOptions struct {
+	// Command is start, bad, good, skip, reset, log, replay or run
+	Command string
+	// Args are the revisions to mark, the commit to reset to, the file to
+	// replay or the command to run
+	Args []string
+}
+
+// bisectState is what has been learned so far: the bad commit, if known,
+// and the good and skipped ones
+type bisectState struct {
+	bad  string
+	good []string
+	skip []string
+}
+
+// bisector binary searches the history between a bad commit and good ones
+// for the commit that introduced a change. Like Git it keeps its state in
+// .git/BISECT_* files and refs under refs/bisect.
+type bisector struct {
+	gitDir string
+	store  storage.ObjectStore
+	res    *revision.Resolver
+	graph  *history.Graph
+}
+
+func Bisect(opts BisectOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	b := &bisector{gitDir: gitDir, store: store, res: revision.New(gitDir, store), graph: history.New(store)}
+
+	switch opts.Command {
+	case "start":
+		if err := b.start(opts.Args); err != nil {
+			return err
+		}
+		_, err := b.next()
+		return err
+	case "replay":
+		return b.replay(opts.Args[0])
+	}
+
+	if !b.bisecting() {
+		if opts.Command == "reset" {
+			fmt.Println("We are not bisecting.")
+			return nil
+		}
+		return fmt.Errorf("you need to start by \"mygit bisect start\"")
+	}
+
+	switch opts.Command {
+	case "bad", "good", "skip":
+		if err := b.mark(opts.Command, opts.Args); err != nil {
+			return err
+		}
+		_, err := b.next()
+		return err
+	case "reset":
+		return b.reset(opts.Args)
+	case "log":
+		data, err := os.ReadFile(b.path("BISECT_LOG"))
+		if err != nil {
+			return fmt.Errorf("failed to read bisect log: %w", err)
+		}
+		fmt.Print(string(data))
+		return nil
+	case "run":
+		return b.run(opts.Args)
+	}
+
+	return fmt.Errorf("unknown bisect command: %s", opts.Command)
+}
+
+func (b *bisector) path(name string) string {
+	return filepath.Join(b.gitDir, name)
+}
+
+func (b *bisector) bisecting() bool {
+	return exists(b.path("BISECT_START"))
+}
+
+// start begins a bisection, or restarts one from the same original HEAD.
+// The first revision given is bad and the rest are good.
+func (b *bisector) start(args []string) error {
+	hashes := make([]string, len(args))
+	for i, arg := range args {
+		hash, err := b.res.Commit(arg)
+		if err != nil {
+			return err
+		}
+		hashes[i] = hash
+	}
+
+	origHead := ""
+	if b.bisecting() {
+		data, err := os.ReadFile(b.path("BISECT_START"))
+		if err != nil {
+			return fmt.Errorf("failed to read bisect state: %w", err)
+		}
+		origHead = strings.TrimSpace(string(data))
+	} else if branch, err := refs.GetCurrentBranch(b.gitDir); err == nil {
+		origHead = strings.TrimPrefix(branch, "refs/heads/")
+	} else if origHead, err = refs.ReadRef(b.gitDir, "HEAD"); err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if origHead == "" {
+		return fmt.Errorf("bad HEAD - a commit is needed to bisect from")
+	}
+
+	if err := b.cleanup(); err != nil {
+		return err
+	}
+
+	for name, value := range map[string]string{"BISECT_START": origHead, "BISECT_TERMS": "bad\ngood", "BISECT_NAMES": ""} {
+		if err := b.writeState(name, value+"\n"); err != nil {
+			return err
+		}
+	}
+
+	for i, hash := range hashes {
+		term := "good"
+		if i == 0 {
+			term = "bad"
+		}
+		if err := b.markCommit(term, hash); err != nil {
+			return err
+		}
+	}
+
+	line := "git bisect start"
+	for _, arg := range args {
+		line += " " + shellQuote(arg)
+	}
+
+	return b.appendLog(line)
+}
+
+// mark records the verdict on revisions, HEAD when none are given
+func (b *bisector) mark(term string, args []string) error {
+	if len(args) == 0 {
+		args = []string{"HEAD"}
+	}
+	if term == "bad" && len(args) > 1 {
+		return fmt.Errorf("'mygit bisect bad' can take only one argument")
+	}
+
+	for _, arg := range args {
+		hash, err := b.res.Commit(arg)
+		if err != nil {
+			return err
+		}
+		if err := b.markCommit(term, hash); err != nil {
+			return err
+		}
+		if err := b.appendLog("git bisect " + term + " " + hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// markCommit stores one verdict as a ref, with a comment in the log
+func (b *bisector) markCommit(term, hash string) error {
+	ref := bisectRefs + term
+	if term != "bad" {
+		ref += "-" + hash
+	}
+	if err := refs.WriteRef(b.gitDir, ref, hash); err != nil {
+		return err
+	}
+
+	return b.appendLog(fmt.Sprintf("# %s: %s", term, b.describe(hash)))
+}
+
+// next checks out the next commit to test, or reports the first bad
+// commit, which makes it return true
+func (b *bisector) next() (bool, error) {
+	state, err := b.readState()
+	if err != nil {
+		return false, err
+	}
+
+	if state.bad == "" || len(state.good) == 0 {
+		status := "waiting for both good and bad commits"
+		switch {
+		case state.bad != "":
+			status = "waiting for good commit(s), bad commit known"
+		case len(state.good) > 0:
+			status = fmt.Sprintf("waiting for bad commit, %d good %s known", len(state.good), plural(len(state.good), "commit", "commits"))
+		}
+		fmt.Printf("status: %s\n", status)
+		return false, b.appendLog("# status: " + status)
+	}
+
+	base, err := b.untestedMergeBase(state)
+	if err != nil {
+		return false, err
+	}
+	if base != "" {
+		fmt.Println("Bisecting: a merge base must be tested")
+		return false, b.checkout(base)
+	}
+
+	step, err := bisect.Next(b.graph, state.bad, state.good, state.skip)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case step.Done:
+		return true, b.reportFirstBad(step.Commit)
+	case len(step.Suspects) > 0:
+		return false, b.reportSuspects(step.Suspects)
+	}
+
+	fmt.Printf("Bisecting: %d %s left to test after this (roughly %d %s)\n",
+		step.Remaining, plural(step.Remaining, "revision", "revisions"), step.Steps, plural(step.Steps, "step", "steps"))
+
+	return false, b.checkout(step.Commit)
+}
+
+// untestedMergeBase finds a merge base of the bad commit and a good one that
+// is not its ancestor, when that merge base has not been tested. Everything
+// below a good commit is taken to be good, so that must be checked first.
+// Once they pass, BISECT_ANCESTORS_OK saves checking again.
+func (b *bisector) untestedMergeBase(state bisectState) (string, error) {
+	if exists(b.path("BISECT_ANCESTORS_OK")) {
+		return "", nil
+	}
+
+	tested := make(map[string]bool)
+	for _, hash := range append(state.good, state.skip...) {
+		tested[hash] = true
+	}
+
+	for _, hash := range state.good {
+		bases, err := b.graph.MergeBases(state.bad, hash)
+		if err != nil {
+			return "", err
+		}
+
+		for _, base := range bases {
+			if base == state.bad {
+				return "", fmt.Errorf("the merge base %s is bad\nThis means the bug has been fixed between %s and [%s]", base, base, strings.Join(state.good, " "))
+			}
+			if !tested[base] {
+				return base, nil
+			}
+		}
+	}
+
+	return "", b.writeState("BISECT_ANCESTORS_OK", "")
+}
+
+// reportFirstBad prints the first bad commit with its message and
+// diffstat, as "mygit show --stat" would
+func (b *bisector) reportFirstBad(hash string) error {
+	commit, err := loadCommit(b.store, hash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is the first bad commit\n", hash)
+	fmt.Printf("commit %s\n", hash)
+	fmt.Printf("Author: %s\n", commit.Author)
+	fmt.Printf("Date:   %s\n\n", commit.Timestamp.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+
+	// Like Git, merges are shown without a diffstat
+	if len(commit.Parents) <= 1 {
+		var parent []index.Entry
+		if len(commit.Parents) == 1 {
+			if parent, err = commitEntries(b.store, commit.Parents[0]); err != nil {
+				return err
+			}
+		}
+
+		entries, err := tree.Flatten(b.store, commit.Tree)
+		if err != nil {
+			return err
+		}
+
+		if changes := tree.Diff(parent, entries); len(changes) > 0 {
+			fmt.Println()
+			if err := writeStat(os.Stdout, b.store, changes); err != nil {
+				return err
+			}
+		}
+	}
+
+	return b.appendLog("# first bad commit: " + b.describe(hash))
+}
+
+// reportSuspects explains that the first bad commit cannot be told apart
+// from the skipped commits that are left
+func (b *bisector) reportSuspects(suspects []string) error {
+	fmt.Println("There are only 'skip'ped commits left to test.")
+	fmt.Println("The first bad commit could be any of:")
+	for _, hash := range suspects {
+		fmt.Println(hash)
+	}
+
+	if err := b.appendLog("# only skipped commits left to test"); err != nil {
+		return err
+	}
+	// The bad commit comes last on screen but first in the log, as in Git
+	bad := len(suspects) - 1
+	for _, hash := range append([]string{suspects[bad]}, suspects[:bad]...) {
+		if err := b.appendLog("# possible first bad commit: " + b.describe(hash)); err != nil {
+			return err
+		}
+	}
+
+	return errCannotBisect
+}
+
+var errCannotBisect = errors.New("we cannot bisect more")
+
+// checkout detaches HEAD at a commit to be tested, refusing to overwrite
+// local changes to files that differ
+func (b *bisector) checkout(hash string) error {
+	if err := b.switchTo(hash); err != nil {
+		return err
+	}
+	if err := refs.WriteRef(b.gitDir, "HEAD", hash); err != nil {
+		return err
+	}
+	if err := b.writeState("BISECT_EXPECTED_REV", hash+"\n"); err != nil {
+		return err
+	}
+
+	fmt.Println(b.describe(hash))
+	return nil
+}
+
+// switchTo makes the index and work tree match a commit
+func (b *bisector) switchTo(hash string) error {
+	target, err := commitEntries(b.store, hash)
+	if err != nil {
+		return err
+	}
+
+	head, err := headEntries(b.gitDir, b.store)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(b.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if err := checkoutKeep(filepath.Dir(b.gitDir), b.store, idx, head, target); err != nil {
+		return err
+	}
+
+	return writeSortedIndex(b.gitDir, idx)
+}
+
+// reset ends the bisection, going back to where it started or to the
+// commit given
+func (b *bisector) reset(args []string) error {
+	data, err := os.ReadFile(b.path("BISECT_START"))
+	if err != nil {
+		return fmt.Errorf("failed to read bisect state: %w", err)
+	}
+
+	target := strings.TrimSpace(string(data))
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	if err := b.leave(target); err != nil {
+		return err
+	}
+
+	return b.cleanup()
+}
+
+// leave checks out a branch, attaching HEAD to it, or detaches HEAD at any
+// other commit
+func (b *bisector) leave(target string) error {
+	if current, err := refs.ReadRef(b.gitDir, "HEAD"); err == nil && current != "" {
+		if _, err := refs.GetCurrentBranch(b.gitDir); err != nil {
+			commit, err := loadCommit(b.store, current)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Previous HEAD position was %s %s\n", current[:7], subjectLine(commit.Message))
+		}
+	}
+
+	branchRef := "refs/heads/" + target
+	if hash, err := refs.ReadRef(b.gitDir, branchRef); err == nil && hash != "" {
+		if err := b.switchTo(hash); err != nil {
+			return err
+		}
+		if err := refs.SetHead(b.gitDir, branchRef); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to branch '%s'\n", target)
+		return nil
+	}
+
+	hash, err := b.res.Commit(target)
+	if err != nil {
+		return fmt.Errorf("could not check out original HEAD '%s': %w", target, err)
+	}
+	if err := b.switchTo(hash); err != nil {
+		return err
+	}
+
+	return refs.WriteRef(b.gitDir, "HEAD", hash)
+}
+
+// replay resets and redoes the commands of a bisect log
+func (b *bisector) replay(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	if b.bisecting() {
+		if err := b.reset(nil); err != nil {
+			return err
+		}
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rest, ok := strings.CutPrefix(line, "git bisect ")
+		if !ok {
+			if rest, ok = strings.CutPrefix(line, "mygit bisect "); !ok {
+				return fmt.Errorf("invalid line in bisect log: %s", line)
+			}
+		}
+
+		words := strings.Fields(rest)
+		for i := range words {
+			words[i] = shellUnquote(words[i])
+		}
+
+		switch words[0] {
+		case "start":
+			err = b.start(words[1:])
+		case "bad", "good", "skip":
+			err = b.mark(words[0], words[1:])
+		default:
+			err = fmt.Errorf("invalid line in bisect log: %s", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = b.next()
+	return err
+}
+
+// run tests commits with a command until the first bad one is found. The
+// command exits 0 for good, 125 to skip and 1 to 127 otherwise for bad.
+func (b *bisector) run(args []string) error {
+	state, err := b.readState()
+	if err != nil {
+		return err
+	}
+	if state.bad == "" || len(state.good) == 0 {
+		return fmt.Errorf("you need to give me at least one good and one bad revision\n(you can use \"mygit bisect bad\" and \"mygit bisect good\" for that)")
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	command := strings.Join(quoted, " ")
+
+	for {
+		fmt.Printf("running %s\n", command)
+
+		code, err := runBisectCommand(args)
+		if err != nil {
+			return err
+		}
+		if code < 0 || code >= 128 {
+			return fmt.Errorf("bisect run failed: exit code %d from %s is < 0 or >= 128", code, command)
+		}
+
+		term := "bad"
+		switch code {
+		case 0:
+			term = "good"
+		case 125:
+			term = "skip"
+		}
+
+		if err := b.mark(term, nil); err != nil {
+			return err
+		}
+
+		found, err := b.next()
+		if errors.Is(err, errCannotBisect) {
+			return fmt.Errorf("bisect run cannot continue any more")
+		}
+		if err != nil {
+			return err
+		}
+		if found {
+			fmt.Println("bisect found first bad commit")
+			return nil
+		}
+	}
+}
+
+// runBisectCommand runs a test through the shell, as Git does, and returns
+// its exit code
+func runBisectCommand(args []string) (int, error) {
+	shellArgs := []string{"-c", args[0]}
+	if len(args) > 1 {
+		shellArgs = append([]string{"-c", args[0] + ` "$@"`}, args...)
+	}
+
+	cmd := exec.Command("sh", shellArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to run %s: %w", args[0], err)
+	}
+
+	return 0, nil
+}
+
+// readState reads the verdicts recorded under refs/bisect
+func (b *bisector) readState() (bisectState, error) {
+	var state bisectState
+
+	marked, err := refs.ListRefs(b.gitDir, bisectRefs)
+	if err != nil {
+		return state, err
+	}
+
+	for name, hash := range marked {
+		switch term := strings.TrimPrefix(name, bisectRefs); {
+		case term == "bad":
+			state.bad = hash
+		case strings.HasPrefix(term, "good-"):
+			state.good = append(state.good, hash)
+		case strings.HasPrefix(term, "skip-"):
+			state.skip = append(state.skip, hash)
+		}
+	}
+	sort.Strings(state.good)
+	sort.Strings(state.skip)
+
+	return state, nil
+}
+
+// describe names a commit as the log does: "[<hash>] <subject>"
+func (b *bisector) describe(hash string) string {
+	subject := ""
+	if commit, err := loadCommit(b.store, hash); err == nil {
+		subject = subjectLine(commit.Message)
+	}
+
+	return fmt.Sprintf("[%s] %s", hash, subject)
+}
+
+func (b *bisector) appendLog(line string) error {
+	f, err := os.OpenFile(b.path("BISECT_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open bisect log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("failed to write bisect log: %w", err)
+	}
+
+	return nil
+}
+
+func (b *bisector) writeState(name, value string) error {
+	if err := os.WriteFile(b.path(name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write bisect state: %w", err)
+	}
+
+	return nil
+}
+
+// cleanup removes the bisect refs and state files
+func (b *bisector) cleanup() error {
+	marked, err := refs.ListRefs(b.gitDir, bisectRefs)
+	if err != nil {
+		return err
+	}
+	for name := range marked {
+		if err := refs.DeleteRef(b.gitDir, name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range bisectFiles {
+		if err := os.Remove(b.path(name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove bisect state: %w", err)
+		}
+	}
+
+	return nil
+}
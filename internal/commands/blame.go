@@ -0,0 +1,458 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SteliosSpanos/mygit/pkg/blame"
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+const blameDateFormat = "2006-01-02 15:04:05 -0700"
+
+type BlameOptions struct {
+	// Rev is the commit to blame the file at; the work tree file is blamed
+	// when empty
+	Rev  string
+	Path string
+	// Ranges holds each -L: "<start>,<end>" where either end may be a
+	// line number or /regex/, and end may also be +<count> or -<count>
+	Ranges           []string
+	IgnoreWhitespace bool
+	Moves            bool
+	// Copies counts the -C options given
+	Copies    int
+	Porcelain bool
+	// IgnoreRevs and IgnoreRevsFiles name commits to look past, on top of
+	// the file in blame.ignoreRevsFile
+	IgnoreRevs      []string
+	IgnoreRevsFiles []string
+}
+
+// blameCommit is what the output shows about a commit
+type blameCommit struct {
+	author    ident.Ident
+	committer ident.Ident
+	summary   string
+	boundary  bool
+}
+
+func Blame(opts BlameOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	prefix, err := worktree.Prefix(repoRoot)
+	if err != nil {
+		return err
+	}
+
+	filePath := path.Join(prefix, filepath.ToSlash(opts.Path))
+	if filePath == ".." || strings.HasPrefix(filePath, "../") {
+		return fmt.Errorf("'%s' is outside repository", opts.Path)
+	}
+
+	res := revision.New(gitDir, store)
+	graph := history.New(store)
+
+	blameOpts := blame.Options{IgnoreWhitespace: opts.IgnoreWhitespace, Moves: opts.Moves, Copies: opts.Copies}
+	if blameOpts.Ignore, err = ignoredRevs(repoRoot, cfg, res, opts); err != nil {
+		return err
+	}
+
+	lines, err := blameLines(gitDir, store, res, graph, filePath, opts.Rev, blameOpts)
+	if err != nil {
+		return err
+	}
+
+	selected, err := blameRanges(opts.Ranges, lines)
+	if err != nil {
+		return err
+	}
+
+	commits, err := blameCommits(store, graph, lines, filePath)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	if opts.Porcelain {
+		writePorcelain(out, lines, selected, commits)
+	} else {
+		writeBlame(out, lines, selected, commits, filePath)
+	}
+
+	return out.Flush()
+}
+
+func blameLines(gitDir string, store storage.ObjectStore, res *revision.Resolver, graph *history.Graph, filePath, rev string, opts blame.Options) ([]blame.Line, error) {
+	if rev != "" {
+		commit, err := res.Commit(rev)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := graph.Commit(commit)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := tree.Lookup(store, c.Tree, filePath)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil || entry.IsDir() {
+			return nil, fmt.Errorf("no such path '%s' in %s", filePath, rev)
+		}
+
+		return blame.File(store, graph, commit, filePath, opts)
+	}
+
+	head, err := refs.ReadRef(gitDir, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	data, err := os.ReadFile(worktreePath(filepath.Dir(gitDir), filePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such path '%s' in HEAD", filePath)
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	return blame.Worktree(store, graph, head, filePath, data, opts)
+}
+
+// ignoredRevs gathers the commits to look past from --ignore-rev,
+// --ignore-revs-file and blame.ignoreRevsFile
+func ignoredRevs(repoRoot string, cfg *config.Config, res *revision.Resolver, opts BlameOptions) (map[string]bool, error) {
+	ignored := make(map[string]bool)
+
+	files := opts.IgnoreRevsFiles
+	if file, ok := cfg.Get("blame.ignoreRevsFile"); ok && file != "" {
+		files = append([]string{filepath.Join(repoRoot, file)}, files...)
+	}
+
+	revs := append([]string(nil), opts.IgnoreRevs...)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not open object name list: %s", file)
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			if line = strings.TrimSpace(line); line != "" {
+				revs = append(revs, line)
+			}
+		}
+	}
+
+	for _, rev := range revs {
+		hash, err := res.Commit(rev)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find revision %s to ignore: %w", rev, err)
+		}
+		ignored[hash] = true
+	}
+
+	return ignored, nil
+}
+
+// blameRanges turns the -L options into the set of lines to show, or nil
+// for all of them
+func blameRanges(ranges []string, lines []blame.Line) (map[int]bool, error) {
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+
+	selected := make(map[int]bool)
+	for _, spec := range ranges {
+		start, end, err := parseBlameRange(spec, lines)
+		if err != nil {
+			return nil, err
+		}
+		for i := start; i <= end; i++ {
+			selected[i] = true
+		}
+	}
+
+	return selected, nil
+}
+
+// parseBlameRange returns the first and last line, counting from 0, that
+// an -L option selects
+func parseBlameRange(spec string, lines []blame.Line) (int, int, error) {
+	if strings.HasPrefix(spec, ":") {
+		return 0, 0, fmt.Errorf("-L :<funcname> is not supported")
+	}
+
+	startSpec, endSpec, hasEnd := splitBlameRange(spec)
+
+	start, err := blameLineSpec(startSpec, lines, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start >= len(lines) {
+		return 0, 0, fmt.Errorf("file has only %d lines", len(lines))
+	}
+
+	end := len(lines) - 1
+	switch {
+	case !hasEnd || endSpec == "":
+	case strings.HasPrefix(endSpec, "+"):
+		n, err := strconv.Atoi(endSpec[1:])
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid -L argument '%s'", spec)
+		}
+		end = start + n - 1
+		if n == 0 {
+			end = start
+		}
+	case strings.HasPrefix(endSpec, "-"):
+		n, err := strconv.Atoi(endSpec[1:])
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid -L argument '%s'", spec)
+		}
+		start, end = start-n+1, start
+		if start < 0 {
+			start = 0
+		}
+	default:
+		if end, err = blameLineSpec(endSpec, lines, start+1); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if end < start {
+		start, end = end, start
+	}
+	if end >= len(lines) {
+		end = len(lines) - 1
+	}
+
+	return start, end, nil
+}
+
+// splitBlameRange splits an -L argument at the comma that is not inside a
+// /regex/
+func splitBlameRange(spec string) (string, string, bool) {
+	i := 0
+	if strings.HasPrefix(spec, "/") {
+		i = 1
+		for i < len(spec) && spec[i] != '/' {
+			if spec[i] == '\\' {
+				i++
+			}
+			i++
+		}
+	}
+
+	comma := strings.IndexByte(spec[min(i, len(spec)):], ',')
+	if comma < 0 {
+		return spec, "", false
+	}
+	comma += min(i, len(spec))
+
+	return spec[:comma], spec[comma+1:], true
+}
+
+// blameLineSpec resolves a line number, or the first line matching
+// /regex/ at or after from
+func blameLineSpec(spec string, lines []blame.Line, from int) (int, error) {
+	if strings.HasPrefix(spec, "/") {
+		pattern := strings.TrimSuffix(spec[1:], "/")
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return 0, fmt.Errorf("invalid regex in -L: %w", err)
+		}
+
+		for i := from; i < len(lines); i++ {
+			if re.MatchString(lines[i].Text) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("-L parameter '%s': no match", pattern)
+	}
+
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid -L argument '%s'", spec)
+	}
+
+	return n - 1, nil
+}
+
+// blameCommits loads the details shown for every commit lines are blamed
+// on
+func blameCommits(store storage.ObjectStore, graph *history.Graph, lines []blame.Line, filePath string) (map[string]*blameCommit, error) {
+	commits := make(map[string]*blameCommit)
+	zero := store.Algorithm().ZeroHash()
+
+	for _, line := range lines {
+		if _, ok := commits[line.Commit]; ok {
+			continue
+		}
+
+		if line.Commit == zero {
+			uncommitted := ident.Ident{Name: "Not Committed Yet", Email: "not.committed.yet", When: time.Now()}
+			commits[zero] = &blameCommit{
+				author:    uncommitted,
+				committer: uncommitted,
+				summary:   fmt.Sprintf("Version of %s from %s", filePath, filePath),
+			}
+			continue
+		}
+
+		c, err := graph.Commit(line.Commit)
+		if err != nil {
+			return nil, err
+		}
+
+		author, err := commitAuthor(c)
+		if err != nil {
+			return nil, err
+		}
+		committer, err := ident.Parse(c.Committer)
+		if err != nil {
+			return nil, err
+		}
+		committer.When = c.CommitTime
+
+		commits[line.Commit] = &blameCommit{author: author, committer: committer, summary: subjectLine(c.Message), boundary: len(c.Parents) == 0}
+	}
+
+	return commits, nil
+}
+
+// writeBlame prints one annotated line per line of the file, like
+// "abcd1234 (Author 2006-01-02 15:04:05 -0700 1) text", naming the file
+// as well when lines came from other paths
+func writeBlame(w *bufio.Writer, lines []blame.Line, selected map[int]bool, commits map[string]*blameCommit, filePath string) {
+	showName := false
+	authorWidth, pathWidth, last := 0, 0, 0
+	for i, line := range lines {
+		if selected != nil && !selected[i] {
+			continue
+		}
+
+		if line.Path != filePath {
+			showName = true
+		}
+		authorWidth = max(authorWidth, utf8.RuneCountInString(commits[line.Commit].author.Name))
+		pathWidth = max(pathWidth, len(line.Path))
+		last = i + 1
+	}
+	numberWidth := len(strconv.Itoa(last))
+
+	for i, line := range lines {
+		if selected != nil && !selected[i] {
+			continue
+		}
+
+		commit := commits[line.Commit]
+		hash := line.Commit[:8]
+		if commit.boundary {
+			hash = "^" + line.Commit[:7]
+		}
+
+		fmt.Fprint(w, hash)
+		if showName {
+			fmt.Fprintf(w, " %-*s", pathWidth, line.Path)
+		}
+
+		name := commit.author.Name
+		pad := strings.Repeat(" ", authorWidth-utf8.RuneCountInString(name))
+		fmt.Fprintf(w, " (%s%s %s %*d) %s", name, pad, commit.author.When.Format(blameDateFormat), numberWidth, i+1, strings.TrimSuffix(line.Text, "\n"))
+		fmt.Fprintln(w)
+	}
+}
+
+// writePorcelain prints the machine-readable format: a header for each
+// group of lines from the same place, the commit's details the first time
+// it appears, and each line prefixed by a tab
+func writePorcelain(w *bufio.Writer, lines []blame.Line, selected map[int]bool, commits map[string]*blameCommit) {
+	shown := make(map[string]bool)
+
+	for i := 0; i < len(lines); i++ {
+		if selected != nil && !selected[i] {
+			continue
+		}
+
+		line := lines[i]
+		group := 1
+		for next := i + 1; next < len(lines) && (selected == nil || selected[next]); next++ {
+			other := lines[next]
+			if other.Commit != line.Commit || other.Path != line.Path || other.OrigLine != line.OrigLine+(next-i) {
+				break
+			}
+			group++
+		}
+
+		for n := 0; n < group; n++ {
+			current := lines[i+n]
+			if n == 0 {
+				fmt.Fprintf(w, "%s %d %d %d\n", current.Commit, current.OrigLine, i+1, group)
+				if !shown[current.Commit] {
+					shown[current.Commit] = true
+					writePorcelainCommit(w, current, commits[current.Commit])
+				}
+			} else {
+				fmt.Fprintf(w, "%s %d %d\n", current.Commit, current.OrigLine, i+n+1)
+			}
+
+			text := current.Text
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			fmt.Fprintf(w, "\t%s", text)
+		}
+
+		i += group - 1
+	}
+}
+
+func writePorcelainCommit(w *bufio.Writer, line blame.Line, commit *blameCommit) {
+	for _, who := range []struct {
+		role string
+		id   ident.Ident
+	}{{"author", commit.author}, {"committer", commit.committer}} {
+		fmt.Fprintf(w, "%s %s\n", who.role, who.id.Name)
+		fmt.Fprintf(w, "%s-mail <%s>\n", who.role, who.id.Email)
+		fmt.Fprintf(w, "%s-time %d\n", who.role, who.id.When.Unix())
+		fmt.Fprintf(w, "%s-tz %s\n", who.role, who.id.When.Format("-0700"))
+	}
+
+	fmt.Fprintf(w, "summary %s\n", commit.summary)
+	if commit.boundary {
+		fmt.Fprintln(w, "boundary")
+	}
+	if line.Previous != "" {
+		fmt.Fprintf(w, "previous %s %s\n", line.Previous, line.PreviousPath)
+	}
+	fmt.Fprintf(w, "filename %s\n", line.Path)
+}
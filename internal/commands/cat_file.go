@@ -2,33 +2,54 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
 	"github.com/SteliosSpanos/mygit/pkg/storage"
 )
 
-func CatFile(hash string) error {
-	gitDir, err := FindGitDir()
+func CatFile(rev string) error {
+	gitDir, store, err := openStore()
 	if err != nil {
 		return err
 	}
 
-	obj, err := storage.LoadObject(gitDir, hash)
+	hash, err := revision.New(gitDir, store).Resolve(rev)
+	if err != nil {
+		return err
+	}
+
+	objType, _, r, err := storage.OpenStream(store, hash)
+	if err != nil {
+		return fmt.Errorf("failed to load object %s: %w", hash, err)
+	}
+	defer r.Close()
+
+	// Blobs are piped straight through so huge files use constant memory
+	if objType == objects.BlobObject {
+		if _, err := io.Copy(os.Stdout, r); err != nil {
+			return fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		return nil
+	}
+
+	obj, err := storage.LoadObject(store, hash)
 	if err != nil {
 		return fmt.Errorf("failed to load object %s: %w", hash, err)
 	}
 
 	switch obj.Type() {
-	case objects.BlobObject:
-		blob := obj.(*objects.Blob)
-		os.Stdout.Write(blob.Data)
 	case objects.TreeObject:
 		tree := obj.(*objects.Tree)
 		PrintTree(tree)
 	case objects.CommitObject:
 		commit := obj.(*objects.Commit)
 		PrintCommit(commit)
+	case objects.TagObject:
+		tag := obj.(*objects.Tag)
+		PrintTag(tag)
 	default:
 		return fmt.Errorf("unknown object type: %s", obj.Type())
 	}
@@ -68,3 +89,13 @@ func PrintCommit(commit *objects.Commit) {
 	fmt.Printf("committer %s\n", commit.Committer)
 	fmt.Printf("\n%s\n", commit.Message)
 }
+
+func PrintTag(tag *objects.Tag) {
+	fmt.Printf("object %s\n", tag.Object)
+	fmt.Printf("type %s\n", tag.ObjType)
+	fmt.Printf("tag %s\n", tag.Name)
+	if tag.Tagger != "" {
+		fmt.Printf("tagger %s\n", tag.Tagger)
+	}
+	fmt.Printf("\n%s\n", tag.Message)
+}
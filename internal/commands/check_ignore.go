@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/ignore"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+type CheckIgnoreOptions struct {
+	Paths []string
+	// Verbose prints the deciding pattern, including negations
+	Verbose bool
+	// NonMatching also lists paths no pattern matched, with Verbose
+	NonMatching bool
+	// Stdin reads paths one per line after those given
+	Stdin bool
+	// NoIndex checks tracked files too instead of never ignoring them
+	NoIndex bool
+}
+
+// CheckIgnore prints the given paths that are ignored and reports whether
+// there were any
+func CheckIgnore(opts CheckIgnoreOptions) (bool, error) {
+	gitDir, err := FindGitDir()
+	if err != nil {
+		return false, err
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+
+	prefix, err := worktree.Prefix(repoRoot)
+	if err != nil {
+		return false, err
+	}
+
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return false, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	matcher, err := ignore.New(repoRoot, gitDir, cfg)
+	if err != nil {
+		return false, err
+	}
+
+	idx := index.NewIndex()
+	if !opts.NoIndex {
+		if idx, err = index.ReadIndex(gitDir); err != nil {
+			return false, fmt.Errorf("failed to read index: %w", err)
+		}
+	}
+
+	paths := opts.Paths
+	if opts.Stdin {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				paths = append(paths, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return false, fmt.Errorf("failed to read stdin: %w", err)
+		}
+	}
+
+	found := false
+	for _, arg := range paths {
+		ignored, p, err := checkIgnorePath(repoRoot, prefix, arg, idx, matcher)
+		if err != nil {
+			return false, err
+		}
+		found = found || ignored
+
+		switch {
+		case opts.Verbose && p != nil:
+			fmt.Printf("%s:%d:%s\t%s\n", p.Source, p.Line, p.Text, arg)
+		case opts.Verbose && opts.NonMatching:
+			fmt.Printf("::\t%s\n", arg)
+		case !opts.Verbose && ignored:
+			fmt.Println(arg)
+		}
+	}
+
+	return found, nil
+}
+
+func checkIgnorePath(repoRoot, prefix, arg string, idx *index.Index, matcher *ignore.Matcher) (bool, *ignore.Pattern, error) {
+	path, err := pathspec.Resolve(prefix, arg)
+	if err != nil {
+		return false, nil, err
+	}
+	if path == "" {
+		return false, nil, nil
+	}
+
+	if _, tracked := idx.Get(path); tracked {
+		return false, nil, nil
+	}
+
+	isDir := strings.HasSuffix(arg, "/")
+	if info, err := os.Lstat(filepath.Join(repoRoot, filepath.FromSlash(path))); err == nil {
+		isDir = info.IsDir()
+	}
+
+	return matcher.Ignored(path, isDir)
+}
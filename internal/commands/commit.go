@@ -2,87 +2,331 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"os/user"
+	"path/filepath"
+	"strings"
 
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/ident"
 	"github.com/SteliosSpanos/mygit/pkg/index"
 	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
 	"github.com/SteliosSpanos/mygit/pkg/refs"
 	"github.com/SteliosSpanos/mygit/pkg/storage"
 	"github.com/SteliosSpanos/mygit/pkg/tree"
 )
 
-func Commit(message string) error {
-	gitDir, err := FindGitDir()
+const commitTemplate = `
+# Please enter the commit message for your changes. Lines starting
+# with '#' will be ignored, and an empty message aborts the commit.
+#
+# On branch %s
+`
+
+type CommitOptions struct {
+	// Messages holds each -m, joined as separate paragraphs
+	Messages []string
+	// File is read for the message, "-" meaning stdin
+	File       string
+	Amend      bool
+	All        bool
+	AllowEmpty bool
+	// Author and Date override the resolved author identity and date
+	Author string
+	Date   string
+}
+
+func Commit(opts CommitOptions) error {
+	gitDir, store, err := openStore()
 	if err != nil {
 		return err
 	}
 
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
 	idx, err := index.ReadIndex(gitDir)
 	if err != nil {
 		return fmt.Errorf("failed to read index: %w", err)
 	}
 
-	if len(idx.Entries) == 0 {
-		return fmt.Errorf("nothing to commit (index is empty)")
+	if opts.All {
+		if err := stageTracked(gitDir, store, idx); err != nil {
+			return err
+		}
 	}
 
-	treeHash, err := tree.BuildTreeFromIndex(gitDir, idx)
+	if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+		return fmt.Errorf("committing is not possible because you have unmerged files:\n    %s", strings.Join(unmerged, "\n    "))
+	}
+
+	// On a detached HEAD, as in a stopped rebase, HEAD itself moves
+	currentBranch, branchName := "HEAD", "detached HEAD"
+	if branch, err := refs.GetCurrentBranch(gitDir); err == nil {
+		currentBranch, branchName = branch, strings.TrimPrefix(branch, "refs/heads/")
+	}
+
+	headHash, err := refs.ReadRef(gitDir, currentBranch)
 	if err != nil {
-		return fmt.Errorf("failed to build tree: %w", err)
+		return fmt.Errorf("failed to read branch: %w", err)
 	}
 
-	author := getAuthor()
+	var amended *objects.Commit
+	parents := []string{}
+	if opts.Amend {
+		if headHash == "" {
+			return fmt.Errorf("you have nothing to amend")
+		}
+		if amended, err = loadCommit(store, headHash); err != nil {
+			return err
+		}
+		parents = amended.Parents
+	} else if headHash != "" {
+		parents = []string{headHash}
+	}
 
-	commit := objects.NewCommit(treeHash, author, message)
+	treeHash, err := tree.BuildTreeFromIndex(store, idx)
+	if err != nil {
+		return fmt.Errorf("failed to build tree: %w", err)
+	}
 
-	currentBranch, err := refs.GetCurrentBranch(gitDir)
+	if !opts.AllowEmpty && !opts.Amend {
+		if err := checkChanges(store, idx, treeHash, headHash); err != nil {
+			return err
+		}
+	}
+
+	message, err := commitMessage(gitDir, cfg, opts, branchName, amended)
 	if err != nil {
-		return fmt.Errorf("failed to get current branch: %w", err)
+		return err
 	}
 
-	parentHash, err := refs.ReadRef(gitDir, currentBranch)
+	author, committer, err := resolveIdents(cfg, opts)
 	if err != nil {
-		return fmt.Errorf("failed to read branch: %w", err)
+		return err
+	}
+
+	commit := objects.NewCommit(treeHash, author.String(), message)
+	commit.Timestamp = author.When
+	commit.Committer = committer.String()
+	commit.CommitTime = committer.When
+
+	// A commit concluding a stopped cherry-pick keeps the original author
+	if picked, err := pickedCommit(gitDir, store); err != nil {
+		return err
+	} else if picked != nil && opts.Author == "" && amended == nil {
+		commit.Author = picked.Author
+		if opts.Date == "" {
+			commit.Timestamp = picked.Timestamp
+		}
 	}
 
-	if parentHash != "" {
-		commit.AddParent(parentHash)
+	// An amended commit keeps its authorship unless told otherwise
+	if amended != nil {
+		if opts.Author == "" {
+			commit.Author = amended.Author
+		}
+		if opts.Date == "" {
+			commit.Timestamp = amended.Timestamp
+		}
 	}
 
-	commitHash, err := storage.WriteObject(gitDir, commit)
+	for _, parent := range parents {
+		commit.AddParent(parent)
+	}
+
+	commitHash, err := storage.WriteObject(store, commit)
 	if err != nil {
 		return fmt.Errorf("failed to write commit: %w", err)
 	}
 
+	if opts.All {
+		if err := index.WriteIndex(gitDir, idx); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+
 	if err := refs.WriteRef(gitDir, currentBranch, commitHash); err != nil {
 		return fmt.Errorf("failed to update branch: %w", err)
 	}
 
-	branchName := currentBranch[len("refs/heads/"):]
-	if parentHash == "" {
-		fmt.Printf("[%s (root-commit) %s] %s\n", branchName, commitHash[:7], message)
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+		if err := os.Remove(filepath.Join(gitDir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+
+	subject := strings.SplitN(message, "\n", 2)[0]
+	if len(parents) == 0 {
+		fmt.Printf("[%s (root-commit) %s] %s\n", branchName, commitHash[:7], subject)
 	} else {
-		fmt.Printf("[%s %s] %s\n", branchName, commitHash[:7], message)
+		fmt.Printf("[%s %s] %s\n", branchName, commitHash[:7], subject)
+	}
+
+	return nil
+}
+
+func loadCommit(store storage.ObjectStore, hash string) (*objects.Commit, error) {
+	obj, err := storage.LoadObject(store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load commit %s: %w", hash, err)
+	}
+
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", hash, obj.Type())
+	}
+
+	return commit, nil
+}
+
+// pickedCommit loads the commit named by CHERRY_PICK_HEAD, or nil when no
+// cherry-pick is stopped
+func pickedCommit(gitDir string, store storage.ObjectStore) (*objects.Commit, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "CHERRY_PICK_HEAD"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CHERRY_PICK_HEAD: %w", err)
+	}
+
+	return loadCommit(store, strings.TrimSpace(string(data)))
+}
+
+// checkChanges refuses a commit whose tree is the same as its parent's
+func checkChanges(store storage.ObjectStore, idx *index.Index, treeHash, headHash string) error {
+	if headHash == "" {
+		if len(idx.Entries) == 0 {
+			return fmt.Errorf("nothing to commit (index is empty)")
+		}
+		return nil
+	}
+
+	head, err := loadCommit(store, headHash)
+	if err != nil {
+		return err
+	}
+
+	if head.Tree == treeHash {
+		return fmt.Errorf("nothing to commit, use --allow-empty to record an empty commit")
 	}
 
 	return nil
 }
 
-func getAuthor() string {
-	name := os.Getenv("GIT_AUTHOR_NAME")
-	email := os.Getenv("GIT_AUTHOR_EMAIL")
+// stageTracked updates the index with the work tree content of every
+// tracked file, dropping entries whose files were deleted
+func stageTracked(gitDir string, store storage.ObjectStore, idx *index.Index) error {
+	ps, err := pathspec.New("", nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = stagePaths(filepath.Dir(gitDir), store, idx, ps, AddOptions{Update: true, quiet: true})
+	return err
+}
+
+// commitMessage takes the message from -m or -F, or asks for one in the
+// editor, prefilled with the amended commit's message
+func commitMessage(gitDir string, cfg *config.Config, opts CommitOptions, branch string, amended *objects.Commit) (string, error) {
+	var message string
+
+	switch {
+	case len(opts.Messages) > 0 && opts.File != "":
+		return "", fmt.Errorf("options -m and -F cannot be used together")
+	case len(opts.Messages) > 0:
+		message = cleanupMessage(strings.Join(opts.Messages, "\n\n"), false)
+	case opts.File != "":
+		data, err := readMessageFile(opts.File)
+		if err != nil {
+			return "", err
+		}
+		message = cleanupMessage(data, false)
+	default:
+		initial := ""
+		if amended != nil {
+			initial = amended.Message + "\n"
+		} else if data, err := os.ReadFile(filepath.Join(gitDir, "MERGE_MSG")); err == nil {
+			// A stopped cherry-pick or revert leaves its message behind
+			initial = string(data)
+		}
+
+		edited, err := editMessage(gitDir, cfg, initial+fmt.Sprintf(commitTemplate, branch))
+		if err != nil {
+			return "", err
+		}
+		message = cleanupMessage(edited, true)
+	}
+
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+
+	return message, nil
+}
+
+func readMessageFile(path string) (string, error) {
+	var data []byte
+	var err error
+
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read message file: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// editMessage writes the template to COMMIT_EDITMSG, opens the editor on it
+// and returns what was saved
+func editMessage(gitDir string, cfg *config.Config, template string) (string, error) {
+	path := filepath.Join(gitDir, "COMMIT_EDITMSG")
+
+	if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+		return "", fmt.Errorf("failed to write COMMIT_EDITMSG: %w", err)
+	}
+
+	if err := launchEditor(cfg, path); err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read COMMIT_EDITMSG: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func resolveIdents(cfg *config.Config, opts CommitOptions) (author, committer ident.Ident, err error) {
+	if author, err = ident.Author(cfg); err != nil {
+		return author, committer, err
+	}
+	if committer, err = ident.Committer(cfg); err != nil {
+		return author, committer, err
+	}
 
-	if name == "" || email == "" {
-		currentUser, err := user.Current()
-		if err == nil {
-			name = currentUser.Username
-		} else {
-			name = "Unknown"
+	if opts.Author != "" {
+		override, err := ident.Parse(opts.Author)
+		if err != nil {
+			return author, committer, err
 		}
+		author.Name, author.Email = override.Name, override.Email
+	}
 
-		email = name + "@localhost"
+	if opts.Date != "" {
+		if author.When, err = ident.ParseDate(opts.Date); err != nil {
+			return author, committer, err
+		}
 	}
 
-	return fmt.Sprintf("%s <%s>", name, email)
+	return author, committer, nil
 }
@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+)
+
+type ConfigOptions struct {
+	Action string // "get", "get-all", "set", "add", "unset", "unset-all" or "list"
+	Scope  config.Scope
+	Type   string // "bool", "int" or empty for plain strings
+	Name   string
+	Value  string
+}
+
+func Config(opts ConfigOptions) error {
+	gitDir, err := FindGitDir()
+	if err != nil {
+		// Only the local scope needs a repository
+		if opts.Scope == config.ScopeLocal {
+			return err
+		}
+		gitDir = ""
+	}
+
+	switch opts.Action {
+	case "get", "get-all", "list":
+		cfg, err := loadConfig(opts.Scope, gitDir)
+		if err != nil {
+			return err
+		}
+		return printConfig(cfg, opts)
+	}
+
+	if opts.Scope == "" {
+		opts.Scope = config.ScopeLocal
+	}
+
+	path, err := config.Path(opts.Scope, gitDir)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Action {
+	case "set", "add":
+		value, err := canonicalValue(opts.Type, opts.Value)
+		if err != nil {
+			return err
+		}
+		if opts.Action == "add" {
+			return config.Add(path, opts.Name, value)
+		}
+		return config.Set(path, opts.Name, value)
+	case "unset", "unset-all":
+		return config.Unset(path, opts.Name, opts.Action == "unset-all")
+	}
+
+	return fmt.Errorf("unknown config action: %s", opts.Action)
+}
+
+// loadConfig reads a single scope, or all of them merged when scope is empty
+func loadConfig(scope config.Scope, gitDir string) (*config.Config, error) {
+	if scope == "" {
+		return config.Load(gitDir)
+	}
+
+	path, err := config.Path(scope, gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.LoadFile(path, scope, gitDir)
+}
+
+func printConfig(cfg *config.Config, opts ConfigOptions) error {
+	if opts.Action == "list" {
+		for _, entry := range cfg.Entries {
+			if entry.Implicit {
+				fmt.Println(entry.Name())
+				continue
+			}
+			fmt.Printf("%s=%s\n", entry.Name(), entry.Value)
+		}
+		return nil
+	}
+
+	entries := cfg.Lookup(opts.Name)
+	if len(entries) == 0 {
+		return fmt.Errorf("key %s is not set", opts.Name)
+	}
+
+	if opts.Action == "get" {
+		entries = entries[len(entries)-1:]
+	}
+
+	for _, entry := range entries {
+		value := entry.Value
+		if entry.Implicit && opts.Type == "bool" {
+			value = "true"
+		}
+
+		value, err := canonicalValue(opts.Type, value)
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+	}
+
+	return nil
+}
+
+// canonicalValue checks a value against the requested type and returns it
+// in Git's canonical form
+func canonicalValue(valueType, value string) (string, error) {
+	switch valueType {
+	case "bool":
+		b, err := config.ParseBool(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(b), nil
+	case "int":
+		n, err := config.ParseInt(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,238 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/describe"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// defaultAbbrev is how many hex digits an abbreviated hash has at least
+const defaultAbbrev = 7
+
+type DescribeOptions struct {
+	// Commits are described in turn, HEAD when empty
+	Commits []string
+	// Tags uses lightweight tags as well as annotated ones
+	Tags bool
+	// Long always adds the distance and hash, even on a tagged commit
+	Long bool
+	// Always falls back to the abbreviated hash when no tag is found
+	Always bool
+	// Dirty is appended when the work tree has local changes, if set
+	Dirty string
+	// Match and Exclude are globs on tag names that limit which are used
+	Match   []string
+	Exclude []string
+	// Abbrev is the least number of hex digits shown, the default when
+	// negative, and 0 shows the tag alone
+	Abbrev int
+}
+
+// namingRef is a ref that names commits, with the tag it points at when it
+// is annotated
+type namingRef struct {
+	name   string
+	commit string
+	tag    *objects.Tag
+}
+
+func Describe(opts DescribeOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	if opts.Dirty != "" && len(opts.Commits) > 0 {
+		return fmt.Errorf("--dirty cannot be used with commits")
+	}
+	if len(opts.Commits) == 0 {
+		opts.Commits = []string{"HEAD"}
+	}
+	if opts.Abbrev < 0 {
+		opts.Abbrev = defaultAbbrev
+	}
+
+	suffix := ""
+	if opts.Dirty != "" {
+		dirty, err := hasLocalChanges(gitDir, store)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			suffix = opts.Dirty
+		}
+	}
+
+	all, err := namingRefs(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	res := revision.New(gitDir, store)
+	graph := history.New(store)
+
+	var tags []describe.Ref
+	for _, ref := range all {
+		name, ok := strings.CutPrefix(ref.name, "refs/tags/")
+		if !ok || !matchesAny(opts.Match, name, true) || matchesAny(opts.Exclude, name, false) {
+			continue
+		}
+
+		tag, err := ref.describeRef(graph, name)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, tag)
+	}
+
+	for _, rev := range opts.Commits {
+		hash, err := res.Commit(rev)
+		if err != nil {
+			return err
+		}
+
+		name, err := describeCommit(res, graph, tags, hash, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Println(name + suffix)
+	}
+
+	return nil
+}
+
+// describeCommit names one commit after its nearest tag, as
+// "<tag>-<depth>-g<hash>", or just "<tag>" on the tagged commit itself
+func describeCommit(res *revision.Resolver, graph *history.Graph, tags []describe.Ref, hash string, opts DescribeOptions) (string, error) {
+	result, err := describe.Describe(graph, tags, hash, describe.Options{Tags: opts.Tags})
+	if err != nil {
+		return "", err
+	}
+
+	if result.Name == "" {
+		if !opts.Always {
+			if result.Unannotated {
+				return "", fmt.Errorf("no annotated tags can describe '%s'\nHowever, there were unannotated tags: try --tags", hash)
+			}
+			return "", fmt.Errorf("no tags can describe '%s'\nTry --always, or create some tags", hash)
+		}
+		return res.Abbrev(hash, max(opts.Abbrev, defaultAbbrev))
+	}
+
+	if opts.Abbrev == 0 || (result.Exact && !opts.Long) {
+		return result.Name, nil
+	}
+
+	abbrev, err := res.Abbrev(hash, opts.Abbrev)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%d-g%s", result.Name, result.Depth, abbrev), nil
+}
+
+// hasLocalChanges reports whether tracked files differ from HEAD in the
+// index or work tree
+func hasLocalChanges(gitDir string, store storage.ObjectStore) (bool, error) {
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return false, fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(idx.Unmerged()) > 0 {
+		return true, nil
+	}
+
+	staged, unstaged, err := localChanges(gitDir, store, idx)
+	return staged || unstaged, err
+}
+
+// matchesAny reports whether name matches one of the globs, or returns
+// empty when there are none
+func matchesAny(patterns []string, name string, empty bool) bool {
+	if len(patterns) == 0 {
+		return empty
+	}
+
+	for _, pattern := range patterns {
+		if pathspec.Wildmatch(pattern, name, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// namingRefs lists every ref peeled to a commit, in name order. Refs to
+// other objects are left out.
+func namingRefs(gitDir string, store storage.ObjectStore) ([]namingRef, error) {
+	all, err := refs.ListRefs(gitDir, "refs/")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []namingRef
+	for _, name := range names {
+		ref := namingRef{name: name, commit: all[name]}
+
+		for {
+			obj, err := storage.LoadObject(store, ref.commit)
+			if err != nil {
+				return nil, err
+			}
+
+			tag, ok := obj.(*objects.Tag)
+			if !ok {
+				if obj.Type() == objects.CommitObject {
+					result = append(result, ref)
+				}
+				break
+			}
+
+			if ref.tag == nil {
+				ref.tag = tag
+			}
+			ref.commit = tag.Object
+		}
+	}
+
+	return result, nil
+}
+
+// describeRef shows a ref as name. Its date is when an annotated tag was
+// made, or else when its commit was.
+func (r namingRef) describeRef(graph *history.Graph, name string) (describe.Ref, error) {
+	ref := describe.Ref{
+		Name:      name,
+		Commit:    r.commit,
+		Tag:       strings.HasPrefix(r.name, "refs/tags/"),
+		Annotated: r.tag != nil,
+	}
+
+	if r.tag != nil {
+		ref.Date = r.tag.Timestamp
+		return ref, nil
+	}
+
+	commit, err := graph.Commit(r.commit)
+	if err != nil {
+		return ref, err
+	}
+	ref.Date = commit.CommitTime
+
+	return ref, nil
+}
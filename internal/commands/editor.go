@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+)
+
+// editorCommand picks the editor the way Git does: GIT_EDITOR, core.editor,
+// VISUAL, EDITOR, then vi
+func editorCommand(cfg *config.Config) string {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor, ok := cfg.Get("core.editor"); ok && editor != "" {
+		return editor
+	}
+	if editor := os.Getenv("VISUAL"); editor != "" {
+		return editor
+	}
+	if editor := os.Getenv("EDITOR"); editor != "" {
+		return editor
+	}
+
+	return "vi"
+}
+
+// sequenceEditorCommand picks the editor for rebase todo lists:
+// GIT_SEQUENCE_EDITOR, sequence.editor, then the usual editor
+func sequenceEditorCommand(cfg *config.Config) string {
+	if editor := os.Getenv("GIT_SEQUENCE_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor, ok := cfg.Get("sequence.editor"); ok && editor != "" {
+		return editor
+	}
+
+	return editorCommand(cfg)
+}
+
+// launchEditor opens path in the user's editor and waits for it to exit
+func launchEditor(cfg *config.Config, path string) error {
+	return runEditor(editorCommand(cfg), path)
+}
+
+// runEditor runs an editor on path through the shell, so the editor may
+// carry its own arguments
+func runEditor(editor, path string) error {
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s': %w", editor, err)
+	}
+
+	return nil
+}
+
+// cleanupMessage strips trailing whitespace, collapses runs of blank lines
+// and trims leading and trailing blank lines. With stripComments, lines
+// starting with '#' are dropped as well.
+func cleanupMessage(message string, stripComments bool) string {
+	var lines []string
+	blank := false
+
+	for _, line := range strings.Split(message, "\n") {
+		if stripComments && strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,332 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/mailbox"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+)
+
+// defaultSignature closes each patch when format.signature is not set
+const defaultSignature = "mygit"
+
+// patchNameMax is the longest a patch file name may be, as in Git
+const patchNameMax = 64
+
+type FormatPatchOptions struct {
+	// Revs select the commits. A single revision means the commits since it
+	// up to HEAD; ranges are read as "git rev-list" reads them.
+	Revs []string
+	// Count, when set, takes only the last Count commits, counting back
+	// from the single revision given or from HEAD
+	Count int
+	// Stdout prints the patches as one mbox instead of writing files
+	Stdout bool
+	// OutputDir is where the files go, the current directory when empty
+	OutputDir string
+	// Numbered gives "[PATCH n/m]" even to a single patch, NoNumbered never
+	Numbered   bool
+	NoNumbered bool
+	// SubjectPrefix replaces "PATCH" in the subject tag
+	SubjectPrefix string
+	// Signature ends each patch after a "-- " line, in place of
+	// format.signature. NoSignature leaves it out.
+	Signature   string
+	NoSignature bool
+}
+
+func FormatPatch(opts FormatPatchOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	hashes, err := patchCommits(gitDir, store, opts)
+	if err != nil {
+		return err
+	}
+
+	signature := opts.Signature
+	if signature == "" {
+		signature, _ = cfg.Get("format.signature")
+	}
+	if signature == "" {
+		signature = defaultSignature
+	}
+	if opts.NoSignature {
+		signature = ""
+	}
+
+	prefix := opts.SubjectPrefix
+	if prefix == "" {
+		prefix = "PATCH"
+	}
+	numbered := (len(hashes) > 1 || opts.Numbered) && !opts.NoNumbered
+
+	if !opts.Stdout && opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", opts.OutputDir, err)
+		}
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+
+	written := false
+	for i, hash := range hashes {
+		commit, err := loadCommit(store, hash)
+		if err != nil {
+			return err
+		}
+
+		changes, err := commitChanges(store, commit)
+		if err != nil {
+			return err
+		}
+
+		tag := "[" + prefix + "] "
+		if numbered {
+			tag = fmt.Sprintf("[%s %d/%d] ", prefix, i+1, len(hashes))
+		}
+
+		if opts.Stdout {
+			if len(changes) == 0 {
+				continue
+			}
+			// Mails in one stream are kept apart by a blank line
+			if written {
+				fmt.Fprintln(stdout)
+			}
+			written = true
+			if err := writeMailPatch(stdout, store, hash, commit, changes, tag, signature); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := filepath.Join(opts.OutputDir, patchFileName(i+1, commit.Message))
+		if err := writePatchFile(name, store, hash, commit, changes, tag, signature); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, name)
+	}
+
+	return nil
+}
+
+// patchCommits lists the commits to format, oldest first, leaving out
+// merges, which have no single patch
+func patchCommits(gitDir string, store storage.ObjectStore, opts FormatPatchOptions) ([]string, error) {
+	revs := opts.Revs
+	switch {
+	case len(revs) == 0:
+		if opts.Count == 0 {
+			return nil, fmt.Errorf("no revisions given")
+		}
+		revs = []string{"HEAD"}
+	case len(revs) == 1 && opts.Count == 0 && !strings.Contains(revs[0], "..") && !strings.HasPrefix(revs[0], "^"):
+		revs = []string{revs[0] + "..HEAD"}
+	}
+
+	include, exclude, err := revision.New(gitDir, store).Range(revs)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := history.New(store)
+	hashes, err := graph.Range(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []string
+	for _, hash := range hashes {
+		commit, err := graph.Commit(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) > 1 {
+			continue
+		}
+
+		if opts.Count > 0 && len(selected) == opts.Count {
+			break
+		}
+		selected = append(selected, hash)
+	}
+
+	slices.Reverse(selected)
+	return selected, nil
+}
+
+func writePatchFile(name string, store storage.ObjectStore, hash string, commit *objects.Commit, changes []tree.Change, tag, signature string) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+
+	w := bufio.NewWriter(file)
+	if err := writeMailPatch(w, store, hash, commit, changes, tag, signature); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	return file.Close()
+}
+
+// commitChanges diffs a commit against its first parent
+func commitChanges(store storage.ObjectStore, commit *objects.Commit) ([]tree.Change, error) {
+	var parentEntries []index.Entry
+	if len(commit.Parents) > 0 {
+		var err error
+		if parentEntries, err = commitEntries(store, commit.Parents[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := tree.Flatten(store, commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.Diff(parentEntries, entries), nil
+}
+
+// writeMailPatch writes one commit as a mail in mbox format: the headers
+// carry its author, date and subject, the body the rest of its message,
+// then come a diffstat and the diff. As in Git, a commit that changes
+// nothing gives no mail at all.
+func writeMailPatch(w io.Writer, store storage.ObjectStore, hash string, commit *objects.Commit, changes []tree.Change, tag, signature string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	author, err := commitAuthor(commit)
+	if err != nil {
+		return err
+	}
+
+	title, body := splitMessage(commit.Message)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s Mon Sep 17 00:00:00 2001\n", hash)
+	fmt.Fprintln(&b, mailbox.FromHeader(author))
+	fmt.Fprintf(&b, "Date: %s\n", author.When.Format(mailbox.DateFormat))
+	fmt.Fprintln(&b, mailbox.SubjectHeader(tag, title))
+	if mailbox.NeedsMIME(commit.Message) {
+		b.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n")
+	}
+	b.WriteString("\n")
+	if body != "" {
+		b.WriteString(body + "\n")
+	}
+	b.WriteString("---\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	if err := writeStatWidth(w, store, changes, mailStatWidth); err != nil {
+		return err
+	}
+	if err := writeSummary(w, changes); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	if err := writePatch(w, store, changes); err != nil {
+		return err
+	}
+
+	if signature != "" {
+		if _, err := fmt.Fprintf(w, "-- \n%s\n\n", strings.TrimRight(signature, "\n")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitMessage separates the title, the lines of the first paragraph
+// joined by spaces, from the rest of a message
+func splitMessage(message string) (title, body string) {
+	lines := strings.Split(strings.TrimLeft(message, "\n"), "\n")
+
+	i := 0
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+		i++
+	}
+	title = strings.Join(lines[:i], " ")
+
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	body = strings.TrimRight(strings.Join(lines[i:], "\n"), "\n")
+
+	return title, body
+}
+
+// patchFileName names a patch "NNNN-<subject>.patch", keeping only
+// letters, digits, '.' and '_' from the first line of the message and
+// joining words with '-'
+func patchFileName(n int, message string) string {
+	title := subjectLine(strings.TrimLeft(message, "\n"))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%04d-", n)
+	start := b.Len()
+
+	space := false
+	for i := 0; i < len(title); i++ {
+		c := title[i]
+		if !isTitleChar(c) {
+			space = b.Len() > start
+			continue
+		}
+
+		if space {
+			b.WriteByte('-')
+		}
+		space = false
+		b.WriteByte(c)
+		for c == '.' && i+1 < len(title) && title[i+1] == '.' {
+			i++
+		}
+	}
+
+	name := b.String()
+	for len(name) > start && (name[len(name)-1] == '.' || name[len(name)-1] == '-') {
+		name = name[:len(name)-1]
+	}
+	if limit := patchNameMax - len(".patch") - 1; len(name) > limit {
+		name = name[:limit]
+	}
+
+	return name + ".patch"
+}
+
+func isTitleChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_'
+}
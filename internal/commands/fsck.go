@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/SteliosSpanos/mygit/pkg/fsck"
+)
+
+func Fsck(showDangling bool) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	report, err := fsck.Check(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range report.Corrupt {
+		fmt.Printf("error in %s %s: %s\n", issue.Type, issue.Hash, issue.Message)
+	}
+
+	for _, issue := range report.Missing {
+		fmt.Printf("missing %s %s (%s)\n", issue.Type, issue.Hash, issue.Message)
+	}
+
+	if showDangling {
+		for _, issue := range report.Dangling {
+			fmt.Printf("dangling %s %s\n", issue.Type, issue.Hash)
+		}
+	}
+
+	fmt.Printf("Checked %d objects\n", report.Checked)
+
+	if len(report.Corrupt) > 0 || len(report.Missing) > 0 {
+		return fmt.Errorf("found %d corrupt and %d missing objects", len(report.Corrupt), len(report.Missing))
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/pack"
+	"github.com/SteliosSpanos/mygit/pkg/reachable"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+type GCOptions struct {
+	DryRun   bool
+	NoRepack bool
+	Prune    string // Expiry date for unreachable objects, "never" keeps them
+}
+
+func GC(opts GCOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	if opts.Prune == "" {
+		opts.Prune = DefaultPruneExpire
+	}
+
+	expire, err := parseExpiry(opts.Prune)
+	if err != nil {
+		return err
+	}
+
+	live, err := reachable.Mark(gitDir, store)
+	if err != nil {
+		return fmt.Errorf("failed to mark reachable objects: %w", err)
+	}
+
+	if !opts.NoRepack {
+		if err := repackReachable(store, live, expire, opts.DryRun); err != nil {
+			return err
+		}
+	}
+
+	pruned, err := pruneLoose(store, live, expire, opts.DryRun, opts.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		fmt.Printf("Would prune %d unreachable loose objects\n", pruned)
+	} else {
+		fmt.Printf("Pruned %d unreachable loose objects\n", pruned)
+	}
+
+	return nil
+}
+
+// repackReachable packs every live object into a single new pack and drops
+// the old packs. Unreachable objects from those packs that are still inside
+// the grace period are written back as loose objects so prune can expire
+// them later.
+func repackReachable(store *storage.DiskStore, live map[string]string, expire time.Time, dryRun bool) error {
+	packs, err := store.Packs.Packs()
+	if err != nil {
+		return err
+	}
+
+	loose, err := store.Loose.List()
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]string, 0, len(live))
+	for hash := range live {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	if dryRun {
+		dropped := 0
+		for _, p := range packs {
+			for _, hash := range p.Index.Hashes {
+				if _, ok := live[hash]; !ok {
+					dropped++
+				}
+			}
+		}
+
+		fmt.Printf("Would pack %d reachable objects, replacing %d packs\n", len(hashes), len(packs))
+		fmt.Printf("Would remove %d unreachable packed objects\n", dropped)
+		return nil
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	newPack, count, err := writePack(store, hashes, live, RepackOptions{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Packed %d reachable objects into %s\n", count, filepath.Base(newPack))
+
+	for _, p := range packs {
+		if p.Path == newPack {
+			continue
+		}
+
+		if err := explodeUnreachable(store, p, live, expire); err != nil {
+			return err
+		}
+
+		if err := store.Packs.Remove(p.Path); err != nil {
+			return err
+		}
+	}
+
+	for _, hash := range loose {
+		if _, ok := live[hash]; !ok {
+			continue
+		}
+		if err := store.Loose.Remove(hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func explodeUnreachable(store *storage.DiskStore, p *pack.Pack, live map[string]string, expire time.Time) error {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat pack: %w", err)
+	}
+
+	mtime := info.ModTime()
+	if mtime.Before(expire) {
+		return nil
+	}
+
+	for _, hash := range p.Index.Hashes {
+		if _, ok := live[hash]; ok {
+			continue
+		}
+
+		objType, data, err := p.Read(hash)
+		if err != nil {
+			return fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+
+		if _, err := store.Loose.Write(objType, data); err != nil {
+			return err
+		}
+
+		// Keep the pack's age so the grace period is not restarted
+		os.Chtimes(store.Loose.Path(hash), mtime, mtime)
+	}
+
+	return nil
+}
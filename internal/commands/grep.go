@@ -0,0 +1,313 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/grep"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+type GrepOptions struct {
+	// Patterns match a line if any of them does
+	Patterns []string
+	// Revs are trees to search instead of the work tree
+	Revs []string
+	// RevsMayBePaths treats the first of Revs that names no revision, and
+	// those after it, as paths, for arguments given without "--"
+	RevsMayBePaths bool
+	Cached         bool
+	Paths          []string
+	grep.Options
+	LineNumber       bool
+	FilesWithMatches bool
+	Count            bool
+	// Threads is the number of files searched at once, the number of CPUs
+	// when 0
+	Threads int
+}
+
+// grepFile is one file to search: a blob, or a work tree file when hash
+// is empty
+type grepFile struct {
+	// name is what the output calls the file, with any revision prefix
+	name string
+	path string
+	hash string
+}
+
+type grepMatch struct {
+	number int
+	text   string
+}
+
+type grepResult struct {
+	matches []grepMatch
+	binary  bool
+	err     error
+}
+
+// Grep prints the lines of tracked files that match and reports whether
+// there were any
+func Grep(opts GrepOptions) (bool, error) {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return false, err
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+
+	if opts.RevsMayBePaths {
+		splitGrepRevs(gitDir, store, &opts)
+	}
+	if opts.Cached && len(opts.Revs) > 0 {
+		return false, fmt.Errorf("--cached cannot be used with revisions")
+	}
+
+	matcher, err := grep.Compile(opts.Patterns, opts.Options)
+	if err != nil {
+		return false, err
+	}
+
+	prefix, err := worktree.Prefix(repoRoot)
+	if err != nil {
+		return false, err
+	}
+
+	// Like Git, searching from a subdirectory only looks below it
+	paths := opts.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	ps, err := pathspec.New(prefix, paths)
+	if err != nil {
+		return false, err
+	}
+
+	files, err := grepFiles(gitDir, store, ps, prefix, opts)
+	if err != nil {
+		return false, err
+	}
+
+	return searchFiles(repoRoot, store, matcher, files, opts)
+}
+
+// splitGrepRevs moves the arguments from the first one that is not a
+// revision onwards to the paths
+func splitGrepRevs(gitDir string, store storage.ObjectStore, opts *GrepOptions) {
+	res := revision.New(gitDir, store)
+
+	for i, rev := range opts.Revs {
+		if _, err := res.Tree(rev); errors.Is(err, revision.ErrUnknown) {
+			opts.Paths = append(append([]string(nil), opts.Revs[i:]...), opts.Paths...)
+			opts.Revs = opts.Revs[:i]
+			return
+		}
+	}
+}
+
+// grepFiles lists the files to search, in path order within each source
+func grepFiles(gitDir string, store storage.ObjectStore, ps *pathspec.Pathspec, prefix string, opts GrepOptions) ([]grepFile, error) {
+	var files []grepFile
+
+	if len(opts.Revs) > 0 {
+		res := revision.New(gitDir, store)
+		for _, rev := range opts.Revs {
+			treeHash, err := res.Tree(rev)
+			if err != nil {
+				return nil, err
+			}
+
+			entries, err := tree.Flatten(store, treeHash)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if ps.Match(entry.Path) && entry.Mode != "160000" {
+					files = append(files, grepFile{name: rev + ":" + relativePath(prefix, entry.Path), path: entry.Path, hash: entry.Hash})
+				}
+			}
+		}
+		return files, nil
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range idx.Entries {
+		if seen[entry.Path] || !ps.Match(entry.Path) || entry.Mode == "160000" {
+			continue
+		}
+		seen[entry.Path] = true
+
+		file := grepFile{name: relativePath(prefix, entry.Path), path: entry.Path}
+		if opts.Cached {
+			// An unmerged path has no single staged version to search
+			if entry.Stage > 0 {
+				continue
+			}
+			file.hash = entry.Hash
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// searchFiles searches the files on worker goroutines and prints the
+// results in order as they become ready
+func searchFiles(repoRoot string, store storage.ObjectStore, matcher *grep.Matcher, files []grepFile, opts GrepOptions) (bool, error) {
+	threads := opts.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	results := make([]chan grepResult, len(files))
+	for i := range results {
+		results[i] = make(chan grepResult, 1)
+	}
+
+	jobs := make(chan int)
+	go func() {
+		for i := range files {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for w := 0; w < threads; w++ {
+		go func() {
+			for i := range jobs {
+				results[i] <- searchFile(repoRoot, store, matcher, files[i], opts.FilesWithMatches)
+			}
+		}()
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	found := false
+	var firstErr error
+
+	// Every result is received, even after an error, so no worker blocks
+	for i, file := range files {
+		result := <-results[i]
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			continue
+		}
+		if firstErr != nil || len(result.matches) == 0 {
+			continue
+		}
+
+		found = true
+		printGrepResult(out, file, result, opts)
+	}
+
+	if err := out.Flush(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return found, firstErr
+}
+
+// searchFile finds the matching lines of one file. With firstOnly it stops
+// at the first match.
+func searchFile(repoRoot string, store storage.ObjectStore, matcher *grep.Matcher, file grepFile, firstOnly bool) grepResult {
+	var data []byte
+	var err error
+
+	if file.hash != "" {
+		data, err = readBlob(store, file.hash)
+	} else {
+		data, err = readGrepWorktreeFile(repoRoot, file.path)
+	}
+	if err != nil || data == nil {
+		return grepResult{err: err}
+	}
+
+	result := grepResult{binary: isBinary(data)}
+	if len(data) == 0 {
+		return result
+	}
+
+	for n, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if !matcher.Match(strings.TrimSuffix(line, "\r")) {
+			continue
+		}
+
+		result.matches = append(result.matches, grepMatch{number: n + 1, text: line})
+		if firstOnly {
+			break
+		}
+	}
+
+	return result
+}
+
+// readGrepWorktreeFile reads a tracked file from the work tree, or returns
+// nil if it has been deleted
+func readGrepWorktreeFile(repoRoot, path string) ([]byte, error) {
+	full := worktreePath(repoRoot, path)
+
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if !worktree.Tracked(info) {
+		return nil, nil
+	}
+
+	return readWorktreeFile(full, info)
+}
+
+func printGrepResult(out *bufio.Writer, file grepFile, result grepResult, opts GrepOptions) {
+	switch {
+	case opts.FilesWithMatches:
+		fmt.Fprintln(out, file.name)
+	case opts.Count:
+		fmt.Fprintf(out, "%s:%d\n", file.name, len(result.matches))
+	case result.binary:
+		fmt.Fprintf(out, "Binary file %s matches\n", file.name)
+	default:
+		for _, match := range result.matches {
+			if opts.LineNumber {
+				fmt.Fprintf(out, "%s:%d:%s\n", file.name, match.number, match.text)
+			} else {
+				fmt.Fprintf(out, "%s:%s\n", file.name, match.text)
+			}
+		}
+	}
+}
+
+// relativePath shows a path from the top of the work tree relative to the
+// current directory, prefix
+func relativePath(prefix, path string) string {
+	if prefix == "" {
+		return path
+	}
+
+	rel, err := filepath.Rel(filepath.FromSlash(prefix), filepath.FromSlash(path))
+	if err != nil {
+		return path
+	}
+
+	return filepath.ToSlash(rel)
+}
@@ -3,53 +3,44 @@ package commands
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/SteliosSpanos/mygit/pkg/objects"
 	"github.com/SteliosSpanos/mygit/pkg/storage"
 )
 
 func HashObject(filepath string) error {
-	data, err := os.ReadFile(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to read file %s: %w", filepath, err)
-	}
-
-	blob := objects.NewBlob(data)
-
-	gitDir, err := FindGitDir()
+	_, store, err := openStore()
 	if err != nil {
 		return fmt.Errorf("failed to write object: %w", err)
 	}
 
-	hash, err := storage.WriteObject(gitDir, blob)
+	hash, err := writeBlobFile(store, filepath)
 	if err != nil {
-		return fmt.Errorf("failed to write object: %w", err)
+		return err
 	}
 
 	fmt.Println(hash)
 	return nil
 }
 
-func FindGitDir() (string, error) {
-	dir, err := os.Getwd()
+// writeBlobFile streams a file into the store as a blob, so large files are
+// never held in memory
+func writeBlobFile(store storage.ObjectStore, path string) (string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return "", fmt.Errorf("failed to get curret directory: %w", err)
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
 	}
+	defer file.Close()
 
-	for {
-		gitDir := filepath.Join(dir, ".git")
-
-		info, err := os.Stat(gitDir)
-		if err == nil && info.IsDir() {
-			return gitDir, nil
-		}
-
-		parent := filepath.Dir(dir)
-		if parent == dir {
-			return "", fmt.Errorf("not a git repository")
-		}
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file %s: %w", path, err)
+	}
 
-		dir = parent
+	hash, err := storage.WriteStream(store, objects.BlobObject, info.Size(), file)
+	if err != nil {
+		return "", fmt.Errorf("failed to write object: %w", err)
 	}
+
+	return hash, nil
 }
@@ -1,12 +1,19 @@
 package commands
+
 import (
 	"fmt"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
 	"github.com/SteliosSpanos/mygit/pkg/repository"
 )
 
+func Init(objectFormat string) error {
+	format, err := objects.ParseHashAlgorithm(objectFormat)
+	if err != nil {
+		return err
+	}
 
-func Init() error {
-	repo, err := repository.Init("")
+	repo, err := repository.Init("", format)
 	if err != nil {
 		return err
 	}
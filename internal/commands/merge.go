@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/merge"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+// errMergeConflict is returned once a merge with conflicts has been written
+// out, for the user to resolve
+var errMergeConflict = errors.New("fix conflicts and then add the resolved files")
+
+// checkoutMerge writes the paths a merge changed to the work tree, taking
+// the index as our side. Nothing is touched if a changed path has local
+// modifications or an untracked file is in the way. The index itself is
+// left to the caller.
+func checkoutMerge(repoRoot string, store storage.ObjectStore, idx *index.Index, result *merge.Result) error {
+	ours := make(map[string]index.Entry)
+	for _, entry := range idx.Entries {
+		if entry.Stage == 0 {
+			ours[entry.Path] = entry
+		}
+	}
+	wanted := entryMap(result.Worktree)
+
+	var touched []string
+	for _, path := range mergedPaths(ours, wanted) {
+		before, had := ours[path]
+		after, keep := wanted[path]
+		if had != keep || (had && (before.Hash != after.Hash || before.Mode != after.Mode)) {
+			touched = append(touched, path)
+		}
+	}
+
+	var local, untracked []string
+	for _, path := range touched {
+		before, had := ours[path]
+		_, keep := wanted[path]
+
+		switch {
+		case had:
+			if !keep && !exists(worktreePath(repoRoot, path)) {
+				continue
+			}
+			changed, err := worktree.Changed(store.Algorithm(), repoRoot, before)
+			if err != nil {
+				return err
+			}
+			if changed {
+				local = append(local, path)
+			}
+		case exists(worktreePath(repoRoot, path)):
+			untracked = append(untracked, path)
+		}
+	}
+
+	if len(local) > 0 {
+		return fmt.Errorf("your local changes to the following files would be overwritten by merge:\n    %s\nPlease commit your changes or stash them before you merge", strings.Join(local, "\n    "))
+	}
+	if len(untracked) > 0 {
+		return fmt.Errorf("the following untracked working tree files would be overwritten by merge:\n    %s\nPlease move or remove them before you merge", strings.Join(untracked, "\n    "))
+	}
+
+	for _, path := range touched {
+		entry, keep := wanted[path]
+		if !keep {
+			if err := worktree.Remove(repoRoot, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := worktree.Checkout(store, repoRoot, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mergedPaths(a, b map[string]index.Entry) []string {
+	var paths []string
+	for path := range a {
+		paths = append(paths, path)
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// printConflicts reports each conflicted path the way Git does
+func printConflicts(result *merge.Result) {
+	for _, c := range result.Conflicts {
+		fmt.Println(c)
+	}
+}
@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+type MvOptions struct {
+	Sources     []string
+	Destination string
+	// Force overwrites an existing destination file
+	Force bool
+	// SkipErrors leaves out moves that would fail instead of aborting
+	SkipErrors bool
+	DryRun     bool
+}
+
+// move is one source renamed to its target, both from the top of the work
+// tree
+type move struct {
+	src, dst string
+	isDir    bool
+}
+
+func Mv(opts MvOptions) error {
+	gitDir, err := FindGitDir()
+	if err != nil {
+		return err
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+
+	prefix, err := worktree.Prefix(repoRoot)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	moves, err := planMoves(repoRoot, prefix, idx, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range moves {
+		if opts.DryRun {
+			fmt.Printf("Renaming %s to %s\n", m.src, m.dst)
+			continue
+		}
+
+		if err := os.Rename(worktreePath(repoRoot, m.src), worktreePath(repoRoot, m.dst)); err != nil {
+			return fmt.Errorf("renaming '%s' failed: %w", m.src, err)
+		}
+		renameEntries(idx, m)
+		fmt.Printf("Renamed '%s' to '%s'\n", m.src, m.dst)
+	}
+
+	if opts.DryRun || len(moves) == 0 {
+		return nil
+	}
+
+	if err := index.WriteIndex(gitDir, idx); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	return nil
+}
+
+// planMoves works out the target of every source and checks that each move
+// is possible before anything is touched
+func planMoves(repoRoot, prefix string, idx *index.Index, opts MvOptions) ([]move, error) {
+	dst, err := pathspec.Resolve(prefix, opts.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	dstInfo, err := os.Lstat(worktreePath(repoRoot, dst))
+	intoDir := err == nil && dstInfo.IsDir()
+	if len(opts.Sources) > 1 && !intoDir {
+		return nil, fmt.Errorf("destination '%s' is not a directory", opts.Destination)
+	}
+
+	var moves []move
+	targets := make(map[string]bool)
+
+	for _, arg := range opts.Sources {
+		src, err := pathspec.Resolve(prefix, arg)
+		if err != nil {
+			return nil, err
+		}
+
+		m := move{src: src, dst: dst}
+		if intoDir {
+			m.dst = path.Join(dst, path.Base(src))
+		}
+
+		problem := checkMove(repoRoot, idx, &m, opts.Force)
+		if problem == "" && targets[m.dst] {
+			problem = "multiple sources for the same target"
+		}
+
+		if problem != "" {
+			if opts.SkipErrors {
+				continue
+			}
+			return nil, fmt.Errorf("%s, source=%s, destination=%s", problem, m.src, m.dst)
+		}
+
+		targets[m.dst] = true
+		moves = append(moves, m)
+	}
+
+	return moves, nil
+}
+
+// checkMove describes why a move cannot be done, or returns ""
+func checkMove(repoRoot string, idx *index.Index, m *move, force bool) string {
+	if m.src == "" {
+		return "bad source"
+	}
+
+	srcInfo, err := os.Lstat(worktreePath(repoRoot, m.src))
+	if err != nil {
+		return "bad source"
+	}
+
+	if srcInfo.IsDir() {
+		m.isDir = true
+		if m.dst == m.src || strings.HasPrefix(m.dst, m.src+"/") {
+			return "can not move directory into itself"
+		}
+
+		entries := entriesUnder(idx, m.src)
+		if len(entries) == 0 {
+			return "source directory is empty"
+		}
+		for _, entry := range entries {
+			if entry.Stage > 0 {
+				return "conflicted"
+			}
+		}
+	} else if len(idx.Conflicts(m.src)) > 0 {
+		return "conflicted"
+	} else if _, tracked := idx.Get(m.src); !tracked {
+		return "not under version control"
+	}
+
+	if _, err := os.Lstat(worktreePath(repoRoot, path.Dir(m.dst))); err != nil {
+		return "destination directory does not exist"
+	}
+
+	if dstInfo, err := os.Lstat(worktreePath(repoRoot, m.dst)); err == nil {
+		if !force || m.isDir || dstInfo.IsDir() {
+			return "destination exists"
+		}
+	}
+
+	return ""
+}
+
+func entriesUnder(idx *index.Index, dir string) []index.Entry {
+	var entries []index.Entry
+	for _, entry := range idx.Entries {
+		if strings.HasPrefix(entry.Path, dir+"/") {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// renameEntries moves the staged entries of a file or directory, keeping
+// their content and mode
+func renameEntries(idx *index.Index, m move) {
+	if !m.isDir {
+		entry, _ := idx.Get(m.src)
+		idx.Remove(m.src)
+		idx.Add(entry.Mode, entry.Hash, m.dst)
+		return
+	}
+
+	for _, entry := range entriesUnder(idx, m.src) {
+		idx.Remove(entry.Path)
+		idx.Add(entry.Mode, entry.Hash, m.dst+strings.TrimPrefix(entry.Path, m.src))
+	}
+}
+
+func worktreePath(repoRoot, path string) string {
+	return filepath.Join(repoRoot, filepath.FromSlash(path))
+}
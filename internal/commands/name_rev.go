@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/describe"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+)
+
+type NameRevOptions struct {
+	Commits []string
+	// All names every commit reachable from the refs used
+	All bool
+	// Tags only uses tags to name commits
+	Tags bool
+	// Refs and Exclude are globs on ref names that limit which are used.
+	// They may match the whole name or the part after any '/'.
+	Refs    []string
+	Exclude []string
+	// NameOnly prints the name without the commit it names
+	NameOnly bool
+	// NoUndefined fails rather than print "undefined" for an unnamed commit
+	NoUndefined bool
+	// Always prints an abbreviated hash for an unnamed commit
+	Always bool
+}
+
+func NameRev(opts NameRevOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	all, err := namingRefs(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	res := revision.New(gitDir, store)
+	graph := history.New(store)
+
+	var tips []describe.Ref
+	var tipHashes []string
+	for _, ref := range all {
+		name, ok := nameRevRef(ref.name, opts)
+		if !ok {
+			continue
+		}
+
+		tip, err := ref.describeRef(graph, name)
+		if err != nil {
+			return err
+		}
+		tips = append(tips, tip)
+		tipHashes = append(tipHashes, tip.Commit)
+	}
+
+	names, err := describe.NameRevs(graph, tips)
+	if err != nil {
+		return err
+	}
+
+	if opts.All {
+		reachable, err := graph.Range(tipHashes, nil)
+		if err != nil {
+			return err
+		}
+		for _, hash := range reachable {
+			printRevName(hash, names[hash], opts.NameOnly)
+		}
+		return nil
+	}
+
+	for _, rev := range opts.Commits {
+		hash, err := res.Commit(rev)
+		if err != nil {
+			return err
+		}
+
+		name, ok := names[hash]
+		switch {
+		case ok:
+		case opts.Always:
+			if name, err = res.Abbrev(hash, defaultAbbrev); err != nil {
+				return err
+			}
+		case opts.NoUndefined:
+			return fmt.Errorf("cannot describe '%s'", hash)
+		default:
+			name = "undefined"
+		}
+
+		printRevName(rev, name, opts.NameOnly)
+	}
+
+	return nil
+}
+
+// nameRevRef decides whether a ref names commits and how it is shown:
+// without "refs/heads/" or "refs/", or as short as possible when only tag
+// names are wanted or a pattern matched the end of it
+func nameRevRef(ref string, opts NameRevOptions) (string, bool) {
+	if opts.Tags && !strings.HasPrefix(ref, "refs/tags/") {
+		return "", false
+	}
+
+	for _, pattern := range opts.Exclude {
+		if subpathMatch(pattern, ref) >= 0 {
+			return "", false
+		}
+	}
+
+	short := opts.Tags && opts.NameOnly
+	if len(opts.Refs) > 0 {
+		matched := false
+		for _, pattern := range opts.Refs {
+			switch subpathMatch(pattern, ref) {
+			case -1:
+			case 0:
+				matched = true
+			default:
+				matched, short = true, true
+			}
+		}
+		if !matched {
+			return "", false
+		}
+	}
+
+	if short {
+		for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+			if name, ok := strings.CutPrefix(ref, prefix); ok {
+				return name, true
+			}
+		}
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return name, true
+	}
+
+	return strings.TrimPrefix(ref, "refs/"), true
+}
+
+// subpathMatch matches a glob against ref or the part of it after any
+// '/', returning where the match starts or -1
+func subpathMatch(pattern, ref string) int {
+	for i := 0; ; {
+		if pathspec.Wildmatch(pattern, ref[i:], false) {
+			return i
+		}
+
+		slash := strings.IndexByte(ref[i:], '/')
+		if slash < 0 {
+			return -1
+		}
+		i += slash + 1
+	}
+}
+
+func printRevName(what, name string, nameOnly bool) {
+	if name == "" {
+		name = "undefined"
+	}
+
+	if nameOnly {
+		fmt.Println(name)
+	} else {
+		fmt.Printf("%s %s\n", what, name)
+	}
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/pack"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// PackObjects reads object hashes (optionally followed by a path) from
+// stdin and writes them into <baseName>-<checksum>.pack and .idx
+func PackObjects(baseName string, window, depth int) error {
+	_, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	var objs []*pack.Object
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(os.Stdin)
+
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if fields[0] == "" || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+
+		obj, err := readPackObject(store, fields[0])
+		if err != nil {
+			return err
+		}
+		if len(fields) == 2 {
+			obj.Name = fields[1]
+		}
+		objs = append(objs, obj)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read object list: %w", err)
+	}
+
+	name, err := pack.Write(filepath.Dir(baseName), filepath.Base(baseName), objs, pack.Options{
+		Window:    window,
+		Depth:     depth,
+		Algorithm: store.Algorithm(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write pack: %w", err)
+	}
+
+	fmt.Println(name)
+	return nil
+}
+
+func readPackObject(store storage.ObjectStore, hash string) (*pack.Object, error) {
+	objType, data, err := storage.ReadObject(store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+
+	return &pack.Object{Hash: hash, Type: objType, Data: data}, nil
+}
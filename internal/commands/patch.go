@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/diff"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+)
+
+// statWidth is the width of a diffstat line, as for an 80 column terminal
+const statWidth = 80
+
+// mailStatWidth is the narrower width used in patches sent by mail
+const mailStatWidth = 72
+
+// fileDiff is a changed file with its old and new content loaded
+type fileDiff struct {
+	tree.Change
+	old, new []byte
+	binary   bool
+	script   []diff.Line
+}
+
+func loadFileDiff(store storage.ObjectStore, change tree.Change) (*fileDiff, error) {
+	fd := &fileDiff{Change: change}
+
+	var err error
+	if change.Old != nil {
+		if fd.old, err = readBlob(store, change.Old.Hash); err != nil {
+			return nil, err
+		}
+	}
+	if change.New != nil {
+		if fd.new, err = readBlob(store, change.New.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	fd.binary = isBinary(fd.old) || isBinary(fd.new)
+	if !fd.binary {
+		fd.script = diff.Lines(diff.SplitLines(string(fd.old)), diff.SplitLines(string(fd.new)))
+	}
+
+	return fd, nil
+}
+
+// counts returns the number of inserted and deleted lines
+func (fd *fileDiff) counts() (insertions, deletions int) {
+	for _, line := range fd.script {
+		switch line.Op {
+		case diff.Insert:
+			insertions++
+		case diff.Delete:
+			deletions++
+		}
+	}
+
+	return insertions, deletions
+}
+
+// writePatch prints the changes as a Git-style unified diff
+func writePatch(w io.Writer, store storage.ObjectStore, changes []tree.Change) error {
+	for _, change := range changes {
+		fd, err := loadFileDiff(store, change)
+		if err != nil {
+			return err
+		}
+		if err := fd.write(w, store.Algorithm()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fd *fileDiff) write(w io.Writer, algo objects.HashAlgorithm) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", fd.Path, fd.Path)
+
+	oldHash, newHash := algo.ZeroHash(), algo.ZeroHash()
+	switch {
+	case fd.Old == nil:
+		fmt.Fprintf(&b, "new file mode %s\n", fd.New.Mode)
+		newHash = fd.New.Hash
+	case fd.New == nil:
+		fmt.Fprintf(&b, "deleted file mode %s\n", fd.Old.Mode)
+		oldHash = fd.Old.Hash
+	default:
+		oldHash, newHash = fd.Old.Hash, fd.New.Hash
+		if fd.Old.Mode != fd.New.Mode {
+			fmt.Fprintf(&b, "old mode %s\nnew mode %s\n", fd.Old.Mode, fd.New.Mode)
+		}
+	}
+
+	if oldHash != newHash {
+		fmt.Fprintf(&b, "index %s..%s", oldHash[:7], newHash[:7])
+		if fd.Old != nil && fd.New != nil && fd.Old.Mode == fd.New.Mode {
+			fmt.Fprintf(&b, " %s", fd.Old.Mode)
+		}
+		b.WriteString("\n")
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	if fd.binary {
+		_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n", fd.side("a", fd.Old), fd.side("b", fd.New))
+		return err
+	}
+
+	hunks := diff.Hunks(fd.script, diff.DefaultContext)
+	if len(hunks) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", fd.side("a", fd.Old), fd.side("b", fd.New)); err != nil {
+		return err
+	}
+
+	for _, h := range hunks {
+		if err := h.Write(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// side names the old or new file in headers, /dev/null when it is absent
+func (fd *fileDiff) side(prefix string, entry *index.Entry) string {
+	if entry == nil {
+		return "/dev/null"
+	}
+
+	return prefix + "/" + fd.Path
+}
+
+// writeStat prints a diffstat: one line per file with a graph of its
+// insertions and deletions, then the totals
+func writeStat(w io.Writer, store storage.ObjectStore, changes []tree.Change) error {
+	return writeStatWidth(w, store, changes, statWidth)
+}
+
+func writeStatWidth(w io.Writer, store storage.ObjectStore, changes []tree.Change, width int) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	diffs := make([]*fileDiff, len(changes))
+	nameWidth, maxChanged, totalIns, totalDel := 0, 0, 0, 0
+
+	for i, change := range changes {
+		fd, err := loadFileDiff(store, change)
+		if err != nil {
+			return err
+		}
+		diffs[i] = fd
+
+		if len(fd.Path) > nameWidth {
+			nameWidth = len(fd.Path)
+		}
+
+		ins, del := fd.counts()
+		totalIns += ins
+		totalDel += del
+		if ins+del > maxChanged {
+			maxChanged = ins + del
+		}
+	}
+
+	countWidth := len(fmt.Sprint(maxChanged))
+	graphWidth := width - nameWidth - countWidth - 6
+	if graphWidth < 10 {
+		graphWidth = 10
+	}
+
+	for _, fd := range diffs {
+		if fd.binary {
+			if _, err := fmt.Fprintf(w, " %-*s | Bin %d -> %d bytes\n", nameWidth, fd.Path, len(fd.old), len(fd.new)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ins, del := fd.counts()
+		changed := ins + del
+		if maxChanged > graphWidth {
+			ins, del = scaleStat(ins, maxChanged, graphWidth), scaleStat(del, maxChanged, graphWidth)
+		}
+
+		graph := strings.Repeat("+", ins) + strings.Repeat("-", del)
+		if _, err := fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf(" %-*s | %*d %s", nameWidth, fd.Path, countWidth, changed, graph), " ")); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintln(w, statSummary(len(diffs), totalIns, totalDel))
+	return err
+}
+
+// writeSummary prints the files created and deleted and the modes changed,
+// as shown after a diffstat
+func writeSummary(w io.Writer, changes []tree.Change) error {
+	for _, change := range changes {
+		var err error
+		switch {
+		case change.Old == nil:
+			_, err = fmt.Fprintf(w, " create mode %s %s\n", change.New.Mode, change.Path)
+		case change.New == nil:
+			_, err = fmt.Fprintf(w, " delete mode %s %s\n", change.Old.Mode, change.Path)
+		case change.Old.Mode != change.New.Mode:
+			_, err = fmt.Fprintf(w, " mode change %s => %s %s\n", change.Old.Mode, change.New.Mode, change.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scaleStat shrinks a count to fit the graph, keeping any change visible
+func scaleStat(n, max, width int) int {
+	if n == 0 {
+		return 0
+	}
+
+	scaled := n * width / max
+	if scaled == 0 {
+		scaled = 1
+	}
+
+	return scaled
+}
+
+func statSummary(files, insertions, deletions int) string {
+	summary := fmt.Sprintf(" %d %s changed", files, plural(files, "file", "files"))
+
+	if insertions > 0 || deletions == 0 {
+		summary += fmt.Sprintf(", %d %s(+)", insertions, plural(insertions, "insertion", "insertions"))
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += fmt.Sprintf(", %d %s(-)", deletions, plural(deletions, "deletion", "deletions"))
+	}
+
+	return summary
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+
+	return many
+}
+
+func readBlob(store storage.ObjectStore, hash string) ([]byte, error) {
+	objType, data, err := storage.ReadObject(store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	if objType != objects.BlobObject {
+		return nil, fmt.Errorf("object %s is a %s, not a blob", hash, objType)
+	}
+
+	return data, nil
+}
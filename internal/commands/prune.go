@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+	"github.com/SteliosSpanos/mygit/pkg/reachable"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// DefaultPruneExpire is the grace period unreachable objects are kept for
+const DefaultPruneExpire = "2.weeks.ago"
+
+type PruneOptions struct {
+	DryRun  bool
+	Verbose bool
+	Expire  string
+}
+
+func Prune(opts PruneOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	if opts.Expire == "" {
+		opts.Expire = DefaultPruneExpire
+	}
+
+	expire, err := parseExpiry(opts.Expire)
+	if err != nil {
+		return err
+	}
+
+	live, err := reachable.Mark(gitDir, store)
+	if err != nil {
+		return fmt.Errorf("failed to mark reachable objects: %w", err)
+	}
+
+	_, err = pruneLoose(store, live, expire, opts.DryRun, opts.Verbose || opts.DryRun)
+	return err
+}
+
+// pruneLoose removes loose objects that are not in live and were last
+// modified before expire, and returns how many it removed
+func pruneLoose(store *storage.DiskStore, live map[string]string, expire time.Time, dryRun, verbose bool) (int, error) {
+	loose, err := store.Loose.List()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, hash := range loose {
+		if _, ok := live[hash]; ok {
+			continue
+		}
+
+		info, err := os.Stat(store.Loose.Path(hash))
+		if err != nil {
+			return count, fmt.Errorf("failed to stat object %s: %w", hash, err)
+		}
+		if !info.ModTime().Before(expire) {
+			continue
+		}
+
+		if verbose {
+			objType, _, err := store.Loose.Read(hash)
+			if err != nil {
+				objType = "unknown"
+			}
+			fmt.Printf("%s %s\n", hash, objType)
+		}
+
+		if !dryRun {
+			if err := store.Loose.Remove(hash); err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// parseExpiry understands "now", "never", relative dates such as
+// "2.weeks.ago" and absolute YYYY-MM-DD dates
+func parseExpiry(value string) (time.Time, error) {
+	now := time.Now()
+
+	switch value {
+	case "now", "all":
+		return now, nil
+	case "never":
+		return time.Time{}, nil
+	}
+
+	if t, err := ident.ParseDate(value); err == nil {
+		return t, nil
+	}
+
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == '.' || r == ' '
+	})
+	if len(fields) != 3 || fields[2] != "ago" {
+		return time.Time{}, fmt.Errorf("invalid expiry date: %s", value)
+	}
+
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry date: %s", value)
+	}
+
+	switch strings.TrimSuffix(fields[1], "s") {
+	case "second":
+		return now.Add(-time.Duration(n) * time.Second), nil
+	case "minute":
+		return now.Add(-time.Duration(n) * time.Minute), nil
+	case "hour":
+		return now.Add(-time.Duration(n) * time.Hour), nil
+	case "day":
+		return now.AddDate(0, 0, -n), nil
+	case "week":
+		return now.AddDate(0, 0, -7*n), nil
+	case "month":
+		return now.AddDate(0, -n, 0), nil
+	case "year":
+		return now.AddDate(-n, 0, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry date: %s", value)
+}
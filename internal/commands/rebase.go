@@ -0,0 +1,1120 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+// detachedHeadName is written to head-name when the rebase started on a
+// detached HEAD
+const detachedHeadName = "detached HEAD"
+
+const rebaseMessageTemplate = `
+# Please enter the commit message for your changes. Lines starting
+# with '#' will be ignored, and an empty message aborts the commit.
+#
+# interactive rebase in progress; onto %s
+`
+
+type RebaseOptions struct {
+	Upstream string
+	// Onto is the new base, Upstream when empty
+	Onto string
+	// Branch is switched to before rebasing
+	Branch      string
+	Interactive bool
+	// Autosquash moves "fixup!" and "squash!" commits next to their targets
+	Autosquash bool
+	Continue   bool
+	Abort      bool
+	Skip       bool
+}
+
+// rebase replays commits onto a new base with HEAD detached, keeping its
+// state in .git/rebase-merge like Git so it can stop and resume
+type rebase struct {
+	gitDir   string
+	store    storage.ObjectStore
+	cfg      *config.Config
+	res      *revision.Resolver
+	headName string
+	onto     string
+	origHead string
+	todo     []rebaseStep
+}
+
+func Rebase(opts RebaseOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	r := &rebase{gitDir: gitDir, store: store, cfg: cfg, res: revision.New(gitDir, store)}
+
+	switch {
+	case opts.Continue:
+		return r.resume(r.commitStopped)
+	case opts.Skip:
+		return r.resume(r.skip)
+	case opts.Abort:
+		return r.abort()
+	}
+
+	return r.start(opts)
+}
+
+func (r *rebase) dir() string {
+	return filepath.Join(r.gitDir, "rebase-merge")
+}
+
+func (r *rebase) path(name string) string {
+	return filepath.Join(r.dir(), name)
+}
+
+func (r *rebase) start(opts RebaseOptions) error {
+	if exists(r.dir()) {
+		return fmt.Errorf("a rebase is already in progress\n(try \"mygit rebase --continue\", \"--skip\" or \"--abort\")")
+	}
+	if opts.Upstream == "" {
+		return fmt.Errorf("no upstream given")
+	}
+
+	upstream, err := r.res.Commit(opts.Upstream)
+	if err != nil {
+		return err
+	}
+
+	r.onto = upstream
+	if opts.Onto != "" {
+		if r.onto, err = r.res.Commit(opts.Onto); err != nil {
+			return err
+		}
+	}
+
+	if err := checkCleanTree(r.gitDir, r.store); err != nil {
+		return err
+	}
+
+	if opts.Branch != "" {
+		if err := r.switchBranch(opts.Branch); err != nil {
+			return err
+		}
+	}
+
+	r.headName = detachedHeadName
+	if branch, err := refs.GetCurrentBranch(r.gitDir); err == nil {
+		r.headName = branch
+	}
+
+	if r.origHead, err = refs.ReadRef(r.gitDir, "HEAD"); err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if r.origHead == "" {
+		return fmt.Errorf("cannot rebase an unborn branch")
+	}
+
+	graph := history.New(r.store)
+	if !opts.Interactive && r.onto == upstream {
+		upToDate, err := graph.IsAncestor(upstream, r.origHead)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			fmt.Printf("Current branch %s is up to date.\n", strings.TrimPrefix(r.headName, "refs/heads/"))
+			return nil
+		}
+	}
+
+	steps, err := r.commitSteps(graph, upstream)
+	if err != nil {
+		return err
+	}
+
+	autoSquash, err := r.cfg.Bool("rebase.autosquash", false)
+	if err != nil {
+		return err
+	}
+	if opts.Autosquash || (autoSquash && opts.Interactive) {
+		steps = autosquash(steps)
+	}
+
+	if err := r.saveState(opts.Interactive); err != nil {
+		return err
+	}
+
+	r.todo = steps
+	if opts.Interactive {
+		if r.todo, err = r.editTodo(upstream); err != nil {
+			if cleanErr := r.cleanup(); cleanErr != nil {
+				return cleanErr
+			}
+			return err
+		}
+	}
+
+	if err := r.detach(); err != nil {
+		if cleanErr := r.cleanup(); cleanErr != nil {
+			return cleanErr
+		}
+		return err
+	}
+
+	return r.run()
+}
+
+// commitSteps lists the commits to replay as picks, oldest first, leaving
+// out merges
+func (r *rebase) commitSteps(graph *history.Graph, upstream string) ([]rebaseStep, error) {
+	hashes, err := graph.Range([]string{r.origHead}, []string{upstream})
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []rebaseStep
+	for i := len(hashes) - 1; i >= 0; i-- {
+		commit, err := graph.Commit(hashes[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) > 1 {
+			continue
+		}
+		steps = append(steps, rebaseStep{action: "pick", hash: hashes[i], arg: subjectLine(commit.Message)})
+	}
+
+	return steps, nil
+}
+
+// switchBranch checks out a branch before rebasing it
+func (r *rebase) switchBranch(name string) error {
+	branchRef := "refs/heads/" + name
+
+	hash, err := refs.ReadRef(r.gitDir, branchRef)
+	if err != nil {
+		return err
+	}
+	if hash == "" {
+		return fmt.Errorf("no such branch: %s", name)
+	}
+
+	if err := r.checkout(hash); err != nil {
+		return err
+	}
+
+	return refs.SetHead(r.gitDir, branchRef)
+}
+
+// checkout makes the index and work tree match a commit, updating only the
+// paths that differ from HEAD. It refuses rather than overwrite local
+// changes or untracked files in the way.
+func (r *rebase) checkout(hash string) error {
+	target, err := commitEntries(r.store, hash)
+	if err != nil {
+		return err
+	}
+
+	head, err := headEntries(r.gitDir, r.store)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(r.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if err := checkoutKeep(filepath.Dir(r.gitDir), r.store, idx, head, target); err != nil {
+		return err
+	}
+
+	return writeSortedIndex(r.gitDir, idx)
+}
+
+// detach moves a detached HEAD to the new base
+func (r *rebase) detach() error {
+	if err := r.checkout(r.onto); err != nil {
+		return err
+	}
+
+	return refs.WriteRef(r.gitDir, "HEAD", r.onto)
+}
+
+// editTodo lets the user edit the todo list in the sequence editor
+func (r *rebase) editTodo(upstream string) ([]rebaseStep, error) {
+	path := r.path("git-rebase-todo")
+	help := fmt.Sprintf(todoHelp, upstream[:7]+".."+r.origHead[:7], r.onto[:7], len(r.todo), plural(len(r.todo), "command", "commands"))
+
+	if err := os.WriteFile(path, []byte(formatTodo(r.todo)+help), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write todo list: %w", err)
+	}
+	if err := runEditor(sequenceEditorCommand(r.cfg), path); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read todo list: %w", err)
+	}
+
+	steps, err := parseTodo(r.res, string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("nothing to do")
+	}
+	if steps[0].isSquash() {
+		return nil, fmt.Errorf("cannot '%s' without a previous commit", steps[0].action)
+	}
+
+	return steps, nil
+}
+
+// run works through the todo list, moving each step to the done file
+// before carrying it out. It returns nil when a step stops the rebase for
+// the user.
+func (r *rebase) run() error {
+	for len(r.todo) > 0 {
+		step := r.todo[0]
+		if err := r.finishStep(); err != nil {
+			return err
+		}
+
+		stopped, err := r.execute(step)
+		if err != nil {
+			// A commit that failed without stopping on a conflict, such as
+			// one refused because it would overwrite local files, has not
+			// been applied, so it goes back to be tried again
+			if step.hash != "" && !exists(r.path("stopped-sha")) {
+				return r.reschedule(step, err)
+			}
+			return err
+		}
+		if err := r.endSquash(step); err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+
+	return r.finish()
+}
+
+func (r *rebase) execute(step rebaseStep) (bool, error) {
+	switch step.action {
+	case "drop":
+		return false, nil
+	case "break":
+		return true, nil
+	case "exec":
+		return false, r.exec(step.arg)
+	}
+
+	return r.pick(step)
+}
+
+func (r *rebase) exec(command string) error {
+	fmt.Printf("Executing: %s\n", command)
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = filepath.Dir(r.gitDir)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("execution failed: %s: %w\nYou can fix the problem, and then run\n\n  mygit rebase --continue", command, err)
+	}
+
+	return nil
+}
+
+// pick applies a commit's change on top of HEAD, or folds it into HEAD
+// for squash and fixup
+func (r *rebase) pick(step rebaseStep) (bool, error) {
+	commit, err := loadCommit(r.store, step.hash)
+	if err != nil {
+		return false, err
+	}
+	if len(commit.Parents) > 1 {
+		return false, fmt.Errorf("cannot rebase merge commit %s", step.hash[:7])
+	}
+
+	parent := ""
+	if len(commit.Parents) == 1 {
+		parent = commit.Parents[0]
+	}
+
+	head, err := refs.ReadRef(r.gitDir, "HEAD")
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	// A commit already sitting on HEAD is reused as it is
+	if parent == head && (step.action == "pick" || step.action == "edit") {
+		if err := r.checkout(step.hash); err != nil {
+			return false, err
+		}
+		if err := refs.WriteRef(r.gitDir, "HEAD", step.hash); err != nil {
+			return false, err
+		}
+		return r.afterPick(step)
+	}
+
+	result, idx, err := mergeChange(r.gitDir, r.store, step.hash, commit, parent, false)
+	if err != nil {
+		return false, err
+	}
+
+	message, author, err := r.pickMessage(step, commit)
+	if err != nil {
+		return false, err
+	}
+
+	if !result.Clean() {
+		if err := r.stopConflicted(step, message, author); err != nil {
+			return false, err
+		}
+		printConflicts(result)
+		return false, fmt.Errorf("could not apply %s... %s: %w\n(then run \"mygit rebase --continue\")", step.hash[:7], step.arg, errMergeConflict)
+	}
+
+	if err := r.commit(step, idx, message, author, commit.Tree == r.parentTree(parent)); err != nil {
+		return false, err
+	}
+
+	return r.afterPick(step)
+}
+
+// parentTree returns the tree of a commit's parent, the empty tree for a
+// root commit, or "" if it cannot be loaded
+func (r *rebase) parentTree(parent string) string {
+	if parent == "" {
+		treeHash, err := tree.BuildTreeFromIndex(r.store, index.NewIndex())
+		if err != nil {
+			return ""
+		}
+		return treeHash
+	}
+
+	commit, err := loadCommit(r.store, parent)
+	if err != nil {
+		return ""
+	}
+
+	return commit.Tree
+}
+
+// pickMessage returns the message and author a step commits with. A squash
+// or fixup rewrites HEAD, so it keeps HEAD's author.
+func (r *rebase) pickMessage(step rebaseStep, commit *objects.Commit) (string, ident.Ident, error) {
+	if !step.isSquash() {
+		author, err := commitAuthor(commit)
+		return commit.Message, author, err
+	}
+
+	head, err := r.headCommit()
+	if err != nil {
+		return "", ident.Ident{}, err
+	}
+
+	author, err := commitAuthor(head)
+	if err != nil {
+		return "", author, err
+	}
+
+	if step.action == "fixup" {
+		return head.Message, author, nil
+	}
+
+	return head.Message + "\n\n" + commit.Message, author, nil
+}
+
+func (r *rebase) headCommit() (*objects.Commit, error) {
+	head, err := refs.ReadRef(r.gitDir, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	return loadCommit(r.store, head)
+}
+
+// commit records the index for a step: a new commit on HEAD, or a
+// replacement for HEAD when squashing. A commit whose change is already
+// upstream is dropped unless it was empty to begin with.
+func (r *rebase) commit(step rebaseStep, idx *index.Index, message string, author ident.Ident, wasEmpty bool) error {
+	head, err := refs.ReadRef(r.gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	headCommit, err := loadCommit(r.store, head)
+	if err != nil {
+		return err
+	}
+
+	treeHash, err := tree.BuildTreeFromIndex(r.store, idx)
+	if err != nil {
+		return fmt.Errorf("failed to build tree: %w", err)
+	}
+
+	parents := []string{head}
+	if step.isSquash() {
+		parents = headCommit.Parents
+		if err := r.addFixup(step); err != nil {
+			return err
+		}
+	} else if treeHash == headCommit.Tree && !wasEmpty {
+		return nil
+	}
+
+	if step.action == "reword" {
+		if message, err = r.editMessage(message); err != nil {
+			return err
+		}
+	}
+
+	hash, err := commitTree(r.gitDir, r.store, r.cfg, treeHash, parents, message, author)
+	if err != nil {
+		return err
+	}
+
+	if step.action == "reword" {
+		printCommitSummary(r.gitDir, hash, message)
+	}
+
+	return nil
+}
+
+// afterPick stops at an edit step so the user can amend the commit
+func (r *rebase) afterPick(step rebaseStep) (bool, error) {
+	if step.action != "edit" {
+		return false, nil
+	}
+
+	head, err := refs.ReadRef(r.gitDir, "HEAD")
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	if err := r.writeState("amend", head); err != nil {
+		return false, err
+	}
+	if err := r.writeState("stopped-sha", step.hash); err != nil {
+		return false, err
+	}
+
+	fmt.Printf("Stopped at %s...  %s\n", step.hash[:7], step.arg)
+	fmt.Println("You can amend the commit now, with")
+	fmt.Println()
+	fmt.Println("  mygit commit --amend")
+	fmt.Println()
+	fmt.Println("Once you are satisfied with your changes, run")
+	fmt.Println()
+	fmt.Println("  mygit rebase --continue")
+
+	return true, nil
+}
+
+// editMessage asks for a commit message in the editor, starting from
+// message
+func (r *rebase) editMessage(message string) (string, error) {
+	edited, err := editMessage(r.gitDir, r.cfg, message+"\n"+fmt.Sprintf(rebaseMessageTemplate, r.onto[:7]))
+	if err != nil {
+		return "", err
+	}
+
+	message = cleanupMessage(edited, true)
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+
+	return message, nil
+}
+
+// addFixup records a squash or fixup in the chain being folded into HEAD
+func (r *rebase) addFixup(step rebaseStep) error {
+	file, err := os.OpenFile(r.path("current-fixups"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write rebase state: %w", err)
+	}
+	if _, err := fmt.Fprintf(file, "%s %s\n", step.action, step.hash); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write rebase state: %w", err)
+	}
+
+	return file.Close()
+}
+
+// endSquash finishes a chain of squashes and fixups once the next step
+// is not one, asking for the combined message if any step was a squash
+func (r *rebase) endSquash(step rebaseStep) error {
+	if !step.isSquash() || (len(r.todo) > 0 && r.todo[0].isSquash()) {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.path("current-fixups"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read rebase state: %w", err)
+	}
+	if err := os.Remove(r.path("current-fixups")); err != nil {
+		return fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+
+	if !strings.Contains(string(data), "squash ") {
+		return nil
+	}
+
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+
+	message, err := r.editMessage(head.Message)
+	if err != nil {
+		return err
+	}
+
+	author, err := commitAuthor(head)
+	if err != nil {
+		return err
+	}
+
+	hash, err := commitTree(r.gitDir, r.store, r.cfg, head.Tree, head.Parents, message, author)
+	if err != nil {
+		return err
+	}
+
+	printCommitSummary(r.gitDir, hash, message)
+	return nil
+}
+
+// stopConflicted saves what --continue needs to commit the step once its
+// conflicts are resolved
+func (r *rebase) stopConflicted(step rebaseStep, message string, author ident.Ident) error {
+	if err := r.writeState("stopped-sha", step.hash); err != nil {
+		return err
+	}
+	if err := r.writeState("message", message); err != nil {
+		return err
+	}
+
+	return r.writeState("author-script", authorScript(author))
+}
+
+// resume loads a stopped rebase, settles the stopped step with settle,
+// and carries on with the rest unless settling stopped it again
+func (r *rebase) resume(settle func() (bool, error)) error {
+	if err := r.load(); err != nil {
+		return err
+	}
+
+	stopped, err := settle()
+	if err != nil || stopped {
+		return err
+	}
+
+	return r.run()
+}
+
+// commitStopped commits the resolved conflict of the stopped step, or
+// folds staged changes into the commit an edit step stopped at
+func (r *rebase) commitStopped() (bool, error) {
+	idx, err := index.ReadIndex(r.gitDir)
+	if err != nil {
+		return false, fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(idx.Unmerged()) > 0 {
+		return false, fmt.Errorf("you must edit all merge conflicts and then mark them as resolved using mygit add")
+	}
+
+	if exists(r.path("message")) {
+		return r.commitResolved(idx)
+	}
+
+	amend, err := r.readState("amend")
+	if err != nil {
+		return false, err
+	}
+	if err := r.clearStop(); err != nil {
+		return false, err
+	}
+
+	head, err := r.headCommit()
+	if err != nil {
+		return false, err
+	}
+
+	treeHash, err := tree.BuildTreeFromIndex(r.store, idx)
+	if err != nil {
+		return false, fmt.Errorf("failed to build tree: %w", err)
+	}
+	if treeHash == head.Tree {
+		return false, nil
+	}
+
+	// Changes staged at an edit stop amend the commit it stopped at
+	headHash, err := refs.ReadRef(r.gitDir, "HEAD")
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if amend == "" || amend != headHash {
+		return false, fmt.Errorf("you have staged changes in your working tree\ncommit them first and then run \"mygit rebase --continue\" again")
+	}
+
+	author, err := commitAuthor(head)
+	if err != nil {
+		return false, err
+	}
+
+	hash, err := commitTree(r.gitDir, r.store, r.cfg, treeHash, head.Parents, head.Message, author)
+	if err != nil {
+		return false, err
+	}
+
+	printCommitSummary(r.gitDir, hash, head.Message)
+	return false, nil
+}
+
+// commitResolved commits the step that stopped on a conflict with the
+// message and author saved when it stopped
+func (r *rebase) commitResolved(idx *index.Index) (bool, error) {
+	message, err := r.readState("message")
+	if err != nil {
+		return false, err
+	}
+
+	script, err := r.readState("author-script")
+	if err != nil {
+		return false, err
+	}
+	author, err := parseAuthorScript(script)
+	if err != nil {
+		return false, err
+	}
+
+	step, err := r.lastDone()
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.commit(step, idx, message, author, false); err != nil {
+		return false, err
+	}
+	if err := r.clearStop(); err != nil {
+		return false, err
+	}
+	if err := r.endSquash(step); err != nil {
+		return false, err
+	}
+
+	return r.afterPick(step)
+}
+
+// skip throws away the stopped step's changes, or drops a step that was
+// rescheduled because it failed
+func (r *rebase) skip() (bool, error) {
+	if exists(r.path("rescheduled")) && len(r.todo) > 0 {
+		if err := r.finishStep(); err != nil {
+			return false, err
+		}
+	}
+	if err := r.clearStop(); err != nil {
+		return false, err
+	}
+	if err := os.Remove(r.path("current-fixups")); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+
+	return false, discardChanges(r.gitDir, r.store)
+}
+
+// clearStop removes what was saved about the step the rebase stopped at
+func (r *rebase) clearStop() error {
+	for _, name := range []string{"stopped-sha", "message", "author-script", "amend", "rescheduled"} {
+		if err := os.Remove(r.path(name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove rebase state: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// abort returns HEAD, the index and the work tree to where the rebase
+// started
+func (r *rebase) abort() error {
+	if err := r.load(); err != nil {
+		return err
+	}
+
+	target, err := commitEntries(r.store, r.origHead)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(r.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	// Conflicted files are reset too, which a plain checkout would refuse
+	if err := checkoutResetMerge(filepath.Dir(r.gitDir), r.store, idx, target); err != nil {
+		return err
+	}
+	if err := writeSortedIndex(r.gitDir, idx); err != nil {
+		return err
+	}
+
+	if r.headName == detachedHeadName {
+		if err := refs.WriteRef(r.gitDir, "HEAD", r.origHead); err != nil {
+			return err
+		}
+	} else if err := refs.SetHead(r.gitDir, r.headName); err != nil {
+		return err
+	}
+
+	return r.cleanup()
+}
+
+// finish points the rebased branch at the new HEAD and attaches HEAD to
+// it again
+func (r *rebase) finish() error {
+	head, err := refs.ReadRef(r.gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	if r.headName != detachedHeadName {
+		if err := refs.WriteRef(r.gitDir, r.headName, head); err != nil {
+			return err
+		}
+		if err := refs.SetHead(r.gitDir, r.headName); err != nil {
+			return err
+		}
+	}
+
+	if err := refs.WriteRef(r.gitDir, "ORIG_HEAD", r.origHead); err != nil {
+		return err
+	}
+
+	if err := r.cleanup(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully rebased and updated %s.\n", r.headName)
+	return nil
+}
+
+// finishStep moves the first todo step to the done file
+func (r *rebase) finishStep() error {
+	file, err := os.OpenFile(r.path("done"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write rebase state: %w", err)
+	}
+	if _, err := fmt.Fprintln(file, r.todo[0]); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write rebase state: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	r.todo = r.todo[1:]
+	return r.saveTodo()
+}
+
+// reschedule puts a step that failed back at the head of the todo list
+func (r *rebase) reschedule(step rebaseStep, cause error) error {
+	done, err := r.doneSteps()
+	if err != nil {
+		return err
+	}
+	if len(done) > 0 {
+		done = done[:len(done)-1]
+	}
+	if err := r.writeState("done", strings.Join(done, "\n")); err != nil {
+		return err
+	}
+
+	r.todo = append([]rebaseStep{step}, r.todo...)
+	if err := r.saveTodo(); err != nil {
+		return err
+	}
+	if err := r.writeState("rescheduled", step.hash); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w\nCould not execute the todo command\n\n    %s\n\nIt has been rescheduled. Fix the problem and run \"mygit rebase --continue\",\nor drop it with \"mygit rebase --skip\"", cause, step)
+}
+
+// saveTodo writes the remaining steps, and msgnum and end, which count
+// the steps done and in total
+func (r *rebase) saveTodo() error {
+	done, err := r.doneSteps()
+	if err != nil {
+		return err
+	}
+
+	if err := r.writeState("git-rebase-todo", formatTodo(r.todo)); err != nil {
+		return err
+	}
+	if err := r.writeState("msgnum", strconv.Itoa(len(done))); err != nil {
+		return err
+	}
+
+	return r.writeState("end", strconv.Itoa(len(done)+len(r.todo)))
+}
+
+// saveState writes the files describing the rebase as a whole
+func (r *rebase) saveState(interactive bool) error {
+	if err := os.MkdirAll(r.dir(), 0755); err != nil {
+		return fmt.Errorf("failed to create rebase directory: %w", err)
+	}
+
+	state := map[string]string{"head-name": r.headName, "onto": r.onto, "orig-head": r.origHead}
+	if interactive {
+		state["interactive"] = ""
+	}
+
+	for name, value := range state {
+		if err := r.writeState(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *rebase) load() error {
+	if !exists(r.dir()) {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	var err error
+	if r.headName, err = r.readState("head-name"); err != nil {
+		return err
+	}
+	if r.onto, err = r.readState("onto"); err != nil {
+		return err
+	}
+	if r.origHead, err = r.readState("orig-head"); err != nil {
+		return err
+	}
+
+	todo, err := r.readState("git-rebase-todo")
+	if err != nil {
+		return err
+	}
+	r.todo, err = parseTodo(r.res, todo)
+	return err
+}
+
+func (r *rebase) doneSteps() ([]string, error) {
+	data, err := os.ReadFile(r.path("done"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rebase state: %w", err)
+	}
+
+	done := strings.TrimSpace(string(data))
+	if done == "" {
+		return nil, nil
+	}
+
+	return strings.Split(done, "\n"), nil
+}
+
+// lastDone returns the step the rebase stopped at
+func (r *rebase) lastDone() (rebaseStep, error) {
+	done, err := r.doneSteps()
+	if err != nil {
+		return rebaseStep{}, err
+	}
+	if len(done) == 0 {
+		return rebaseStep{}, fmt.Errorf("no rebase step to continue")
+	}
+
+	steps, err := parseTodo(r.res, done[len(done)-1])
+	if err != nil {
+		return rebaseStep{}, err
+	}
+
+	return steps[0], nil
+}
+
+func (r *rebase) writeState(name, value string) error {
+	if value != "" && !strings.HasSuffix(value, "\n") {
+		value += "\n"
+	}
+
+	if err := os.WriteFile(r.path(name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write rebase state: %w", err)
+	}
+
+	return nil
+}
+
+// readState reads a state file, returning "" if it does not exist
+func (r *rebase) readState(name string) (string, error) {
+	data, err := os.ReadFile(r.path(name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read rebase state: %w", err)
+	}
+
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+func (r *rebase) cleanup() error {
+	if err := os.RemoveAll(r.dir()); err != nil {
+		return fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+
+	return nil
+}
+
+// checkCleanTree refuses to go on while the index or the work tree has
+// changes to tracked files
+func checkCleanTree(gitDir string, store storage.ObjectStore) error {
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(idx.Unmerged()) > 0 {
+		return fmt.Errorf("cannot rebase: you have unmerged files")
+	}
+
+	staged, unstaged, err := localChanges(gitDir, store, idx)
+	if err != nil {
+		return err
+	}
+	if unstaged {
+		return fmt.Errorf("cannot rebase: you have unstaged changes\n(commit or stash them)")
+	}
+	if staged {
+		return fmt.Errorf("cannot rebase: your index contains uncommitted changes\n(commit or stash them)")
+	}
+
+	return nil
+}
+
+// localChanges reports whether the index differs from HEAD and whether
+// tracked files in the work tree differ from the index
+func localChanges(gitDir string, store storage.ObjectStore, idx *index.Index) (staged, unstaged bool, err error) {
+	head, err := headEntries(gitDir, store)
+	if err != nil {
+		return false, false, err
+	}
+
+	staged = len(head) != len(idx.Entries)
+	for _, entry := range idx.Entries {
+		if committed, ok := head[entry.Path]; !ok || committed != entry {
+			staged = true
+		}
+
+		if !exists(worktreePath(filepath.Dir(gitDir), entry.Path)) {
+			unstaged = true
+			continue
+		}
+		changed, err := worktree.Changed(store.Algorithm(), filepath.Dir(gitDir), entry)
+		if err != nil {
+			return false, false, err
+		}
+		if changed {
+			unstaged = true
+		}
+	}
+
+	return staged, unstaged, nil
+}
+
+// commitAuthor returns a commit's author with the date it was authored
+func commitAuthor(commit *objects.Commit) (ident.Ident, error) {
+	author, err := ident.Parse(commit.Author)
+	if err != nil {
+		return author, err
+	}
+	author.When = commit.Timestamp
+
+	return author, nil
+}
+
+// authorScript renders an author as the shell assignments Git keeps in
+// author-script
+func authorScript(author ident.Ident) string {
+	date := strings.TrimPrefix(author.Signature(), author.String()+" ")
+
+	return fmt.Sprintf("GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n",
+		shellQuote(author.Name), shellQuote(author.Email), shellQuote("@"+date))
+}
+
+func parseAuthorScript(script string) (ident.Ident, error) {
+	var author ident.Ident
+
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = shellUnquote(value)
+
+		switch key {
+		case "GIT_AUTHOR_NAME":
+			author.Name = value
+		case "GIT_AUTHOR_EMAIL":
+			author.Email = value
+		case "GIT_AUTHOR_DATE":
+			when, err := ident.ParseDate(value)
+			if err != nil {
+				return author, err
+			}
+			author.When = when
+		}
+	}
+
+	if author.Name == "" || author.When.IsZero() {
+		return author, fmt.Errorf("invalid author-script in rebase state")
+	}
+
+	return author, nil
+}
+
+// shellQuote wraps a value in single quotes for sh
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func shellUnquote(value string) string {
+	value = strings.ReplaceAll(value, `'\''`, "'")
+	return strings.TrimSuffix(strings.TrimPrefix(value, "'"), "'")
+}
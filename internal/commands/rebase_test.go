@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+)
+
+// newTestRepo creates a repository in a temporary directory and moves into
+// it, with a fixed identity so commits do not depend on the environment
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(name, "Test")
+	}
+	for _, name := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(name, "test@example.com")
+	}
+
+	if err := Init(""); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	return filepath.Join(dir, ".git")
+}
+
+func writeTestFile(t *testing.T, name, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commitTestFile writes a file, commits it and returns the new HEAD
+func commitTestFile(t *testing.T, gitDir, name, content, message string) string {
+	t.Helper()
+
+	writeTestFile(t, name, content)
+	if err := Add(AddOptions{Paths: []string{name}}); err != nil {
+		t.Fatalf("add %s: %v", name, err)
+	}
+	if err := Commit(CommitOptions{Messages: []string{message}}); err != nil {
+		t.Fatalf("commit %s: %v", message, err)
+	}
+
+	return readTestHead(t, gitDir)
+}
+
+func readTestHead(t *testing.T, gitDir string) string {
+	t.Helper()
+
+	head, err := refs.ReadRef(gitDir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return head
+}
+
+func checkTestFile(t *testing.T, name, want string) {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("%s holds %q, want %q", name, data, want)
+	}
+}
+
+// An untracked file that the new base adds must stop the rebase from
+// starting rather than be overwritten
+func TestRebaseStartKeepsUntrackedFile(t *testing.T) {
+	gitDir := newTestRepo(t)
+
+	base := commitTestFile(t, gitDir, "f", "base\n", "base")
+	up := commitTestFile(t, gitDir, "new", "upstream\n", "up")
+	if err := Reset(ResetOptions{Mode: ResetHard, Rev: base}); err != nil {
+		t.Fatal(err)
+	}
+	feat := commitTestFile(t, gitDir, "g", "feat\n", "feat")
+
+	writeTestFile(t, "new", "MY UNTRACKED WORK\n")
+
+	if err := Rebase(RebaseOptions{Upstream: up}); err == nil {
+		t.Fatal("rebase succeeded over an untracked file")
+	}
+
+	checkTestFile(t, "new", "MY UNTRACKED WORK\n")
+	if head := readTestHead(t, gitDir); head != feat {
+		t.Errorf("HEAD moved to %s, want %s", head, feat)
+	}
+	if exists(filepath.Join(gitDir, "rebase-merge")) {
+		t.Error("rebase state left behind after refusing to start")
+	}
+}
+
+// A pick that reuses a commit already on HEAD checks it out directly; an
+// untracked file in the way must stop it and keep the step to retry
+func TestRebaseFastForwardKeepsUntrackedFile(t *testing.T) {
+	gitDir := newTestRepo(t)
+
+	base := commitTestFile(t, gitDir, "f", "base\n", "base")
+	commitTestFile(t, gitDir, "n2", "committed\n", "add n2")
+	tip := commitTestFile(t, gitDir, "x", "x\n", "add x")
+
+	t.Setenv("GIT_SEQUENCE_EDITOR", "sed -i -e '1i break'")
+	if err := Rebase(RebaseOptions{Upstream: base, Interactive: true}); err != nil {
+		t.Fatalf("rebase: %v", err)
+	}
+	if head := readTestHead(t, gitDir); head != base {
+		t.Fatalf("rebase did not stop at the break: HEAD is %s", head)
+	}
+
+	writeTestFile(t, "n2", "MY UNTRACKED WORK\n")
+
+	if err := Rebase(RebaseOptions{Continue: true}); err == nil {
+		t.Fatal("rebase continued over an untracked file")
+	}
+	checkTestFile(t, "n2", "MY UNTRACKED WORK\n")
+	if head := readTestHead(t, gitDir); head != base {
+		t.Errorf("HEAD moved to %s, want %s", head, base)
+	}
+
+	if err := os.Remove("n2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rebase(RebaseOptions{Continue: true}); err != nil {
+		t.Fatalf("rebase --continue: %v", err)
+	}
+
+	if head := readTestHead(t, gitDir); head != tip {
+		t.Errorf("HEAD is %s after the rebase, want %s", head, tip)
+	}
+	checkTestFile(t, "n2", "committed\n")
+}
@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+)
+
+const todoHelp = `
+# Rebase %s onto %s (%d %s)
+#
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash" but keep only the previous
+#                    commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# b, break = stop here (continue rebase later with 'mygit rebase --continue')
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+var todoAliases = map[string]string{
+	"p": "pick", "r": "reword", "e": "edit", "s": "squash",
+	"f": "fixup", "x": "exec", "b": "break", "d": "drop",
+}
+
+// rebaseStep is one line of a rebase todo list. arg is the subject for
+// commit commands and the command line for exec.
+type rebaseStep struct {
+	action string
+	hash   string
+	arg    string
+}
+
+func (s rebaseStep) String() string {
+	switch s.action {
+	case "break":
+		return s.action
+	case "exec":
+		return s.action + " " + s.arg
+	}
+
+	return strings.TrimRight(fmt.Sprintf("%s %s %s", s.action, s.hash[:7], s.arg), " ")
+}
+
+func (s rebaseStep) isCommit() bool {
+	return s.hash != ""
+}
+
+func (s rebaseStep) isSquash() bool {
+	return s.action == "squash" || s.action == "fixup"
+}
+
+// formatTodo renders steps one per line, as written to git-rebase-todo
+func formatTodo(steps []rebaseStep) string {
+	var b strings.Builder
+	for _, step := range steps {
+		b.WriteString(step.String() + "\n")
+	}
+
+	return b.String()
+}
+
+// parseTodo reads a todo list, skipping blank lines and comments
+func parseTodo(res *revision.Resolver, data string) ([]rebaseStep, error) {
+	var steps []rebaseStep
+
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		word, rest, _ := strings.Cut(line, " ")
+		action := word
+		if full, ok := todoAliases[word]; ok {
+			action = full
+		}
+		rest = strings.TrimSpace(rest)
+
+		switch action {
+		case "break":
+			steps = append(steps, rebaseStep{action: action})
+		case "exec":
+			if rest == "" {
+				return nil, fmt.Errorf("missing command on line %d of the todo list: %s", n+1, line)
+			}
+			steps = append(steps, rebaseStep{action: action, arg: rest})
+		case "pick", "reword", "edit", "squash", "fixup", "drop":
+			rev, subject, _ := strings.Cut(rest, " ")
+			if rev == "" {
+				return nil, fmt.Errorf("missing commit on line %d of the todo list: %s", n+1, line)
+			}
+
+			hash, err := res.Commit(rev)
+			if err != nil {
+				return nil, fmt.Errorf("invalid commit on line %d of the todo list: %w", n+1, err)
+			}
+			steps = append(steps, rebaseStep{action: action, hash: hash, arg: strings.TrimSpace(subject)})
+		default:
+			return nil, fmt.Errorf("invalid command '%s' on line %d of the todo list", word, n+1)
+		}
+	}
+
+	return steps, nil
+}
+
+// autosquash moves each "fixup! <subject>" and "squash! <subject>" commit
+// right after the commit it names, by subject or hash prefix, and marks it
+// fixup or squash
+func autosquash(steps []rebaseStep) []rebaseStep {
+	var groups [][]rebaseStep
+
+	for _, step := range steps {
+		action, target := squashTarget(step.arg)
+		if action != "" {
+			if g := findGroup(groups, target); g >= 0 {
+				step.action = action
+				groups[g] = append(groups[g], step)
+				continue
+			}
+		}
+
+		groups = append(groups, []rebaseStep{step})
+	}
+
+	var out []rebaseStep
+	for _, group := range groups {
+		out = append(out, group...)
+	}
+
+	return out
+}
+
+// squashTarget strips the "fixup! " or "squash! " prefixes from a subject,
+// returning the action they ask for and the subject they point at
+func squashTarget(subject string) (string, string) {
+	action := ""
+
+	for {
+		switch {
+		case strings.HasPrefix(subject, "fixup! "), strings.HasPrefix(subject, "amend! "):
+			if action == "" {
+				action = "fixup"
+			}
+			subject = subject[len("fixup! "):]
+		case strings.HasPrefix(subject, "squash! "):
+			if action == "" {
+				action = "squash"
+			}
+			subject = subject[len("squash! "):]
+		default:
+			return action, subject
+		}
+	}
+}
+
+func findGroup(groups [][]rebaseStep, target string) int {
+	for i, group := range groups {
+		first := group[0]
+		if first.isCommit() && (first.arg == target || (len(target) >= 4 && strings.HasPrefix(first.hash, target))) {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/SteliosSpanos/mygit/pkg/pack"
+	"github.com/SteliosSpanos/mygit/pkg/reachable"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+type RepackOptions struct {
+	All    bool // Pack everything, including objects already in packs
+	Delete bool // Remove redundant packs and loose objects afterwards
+	Window int
+	Depth  int
+}
+
+func Repack(opts RepackOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	loose, err := store.Loose.List()
+	if err != nil {
+		return err
+	}
+
+	packs, err := store.Packs.Packs()
+	if err != nil {
+		return err
+	}
+
+	hashes := loose
+	if opts.All {
+		for _, p := range packs {
+			hashes = append(hashes, p.Index.Hashes...)
+		}
+	}
+
+	if len(hashes) == 0 {
+		fmt.Println("Nothing new to pack.")
+		return nil
+	}
+
+	// Paths of reachable objects let the packer place different versions
+	// of the same file next to each other
+	names, err := reachable.Mark(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	newPack, count, err := writePack(store, hashes, names, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Packed %d objects into %s\n", count, filepath.Base(newPack))
+
+	if !opts.Delete {
+		return nil
+	}
+
+	if opts.All {
+		for _, p := range packs {
+			if p.Path == newPack {
+				continue
+			}
+			if err := store.Packs.Remove(p.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, hash := range loose {
+		if err := store.Loose.Remove(hash); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Removed %d loose objects\n", len(loose))
+	return nil
+}
+
+// writePack packs the given objects into a new pack in the repository and
+// returns its path and the number of objects written
+func writePack(store *storage.DiskStore, hashes []string, names map[string]string, opts RepackOptions) (string, int, error) {
+	var objs []*pack.Object
+	seen := make(map[string]bool)
+
+	for _, hash := range hashes {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		obj, err := readPackObject(store, hash)
+		if err != nil {
+			return "", 0, err
+		}
+		obj.Name = names[hash]
+		objs = append(objs, obj)
+	}
+
+	packDir := store.Packs.Dir()
+	name, err := pack.Write(packDir, "pack", objs, pack.Options{
+		Window:    opts.Window,
+		Depth:     opts.Depth,
+		Algorithm: store.Algorithm(),
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write pack: %w", err)
+	}
+
+	return filepath.Join(packDir, "pack-"+name+".pack"), len(objs), nil
+}
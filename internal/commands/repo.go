@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/SteliosSpanos/mygit/pkg/repository"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// openStore locates the repository and opens its object database
+func openStore() (string, *storage.DiskStore, error) {
+	gitDir, err := FindGitDir()
+	if err != nil {
+		return "", nil, err
+	}
+
+	format, err := repository.ObjectFormat(gitDir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return gitDir, storage.Open(gitDir, format), nil
+}
+
+func FindGitDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get curret directory: %w", err)
+	}
+
+	for {
+		gitDir := filepath.Join(dir, ".git")
+
+		info, err := os.Stat(gitDir)
+		if err == nil && info.IsDir() {
+			return gitDir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("not a git repository")
+		}
+
+		dir = parent
+	}
+}
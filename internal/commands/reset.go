@@ -0,0 +1,427 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+const (
+	ResetSoft  = "soft"
+	ResetMixed = "mixed"
+	ResetHard  = "hard"
+	ResetKeep  = "keep"
+)
+
+type ResetOptions struct {
+	// Mode is one of the Reset* constants, mixed when empty
+	Mode string
+	// Rev is the commit to reset to, HEAD when empty
+	Rev   string
+	Paths []string
+	// RevMayBePath treats Rev as the first path when it names no revision,
+	// for arguments given without "--"
+	RevMayBePath bool
+}
+
+func Reset(opts ResetOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	if opts.Mode == "" {
+		opts.Mode = ResetMixed
+	}
+
+	res := revision.New(gitDir, store)
+
+	rev := opts.Rev
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	target, err := res.Commit(rev)
+	if err != nil && opts.RevMayBePath && errors.Is(err, revision.ErrUnknown) {
+		if !exists(opts.Rev) {
+			return fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", opts.Rev)
+		}
+		opts.Paths = append([]string{opts.Rev}, opts.Paths...)
+		rev = "HEAD"
+		target, err = res.Commit(rev)
+	}
+
+	// Before the first commit, unstaging paths resets them to nothing
+	unborn := err != nil && rev == "HEAD" && len(opts.Paths) > 0
+	if err != nil && !unborn {
+		return err
+	}
+
+	var targetEntries []index.Entry
+	if !unborn {
+		commit, err := loadCommit(store, target)
+		if err != nil {
+			return err
+		}
+		if targetEntries, err = tree.Flatten(store, commit.Tree); err != nil {
+			return err
+		}
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if len(opts.Paths) > 0 {
+		if opts.Mode != ResetMixed {
+			return fmt.Errorf("cannot do %s reset with paths", opts.Mode)
+		}
+		return resetPaths(gitDir, store, idx, targetEntries, opts.Paths)
+	}
+
+	return resetHead(gitDir, store, idx, target, targetEntries, opts.Mode)
+}
+
+// resetPaths copies the target's entries for the matching paths into the
+// index, removing those the target does not have
+func resetPaths(gitDir string, store storage.ObjectStore, idx *index.Index, targetEntries []index.Entry, paths []string) error {
+	prefix, err := worktree.Prefix(filepath.Dir(gitDir))
+	if err != nil {
+		return err
+	}
+
+	ps, err := pathspec.New(prefix, paths)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range append([]index.Entry(nil), idx.Entries...) {
+		if ps.Match(entry.Path) {
+			idx.Remove(entry.Path)
+		}
+	}
+
+	for _, entry := range targetEntries {
+		if ps.Match(entry.Path) {
+			idx.Add(entry.Mode, entry.Hash, entry.Path)
+		}
+	}
+
+	if err := writeSortedIndex(gitDir, idx); err != nil {
+		return err
+	}
+
+	return printUnstaged(gitDir, store, idx)
+}
+
+func resetHead(gitDir string, store storage.ObjectStore, idx *index.Index, target string, targetEntries []index.Entry, mode string) error {
+	repoRoot := filepath.Dir(gitDir)
+
+	oldHead, err := refs.ReadRef(gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	head, err := headEntries(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case ResetSoft:
+	case ResetMixed:
+		idx.Entries = append([]index.Entry(nil), targetEntries...)
+	case ResetHard:
+		if err := checkoutHard(repoRoot, store, idx, head, targetEntries); err != nil {
+			return err
+		}
+	case ResetKeep:
+		if err := checkoutKeep(repoRoot, store, idx, head, targetEntries); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown reset mode: %s", mode)
+	}
+
+	if mode != ResetSoft {
+		if err := writeSortedIndex(gitDir, idx); err != nil {
+			return err
+		}
+	}
+
+	if oldHead != "" {
+		if err := refs.WriteRef(gitDir, "ORIG_HEAD", oldHead); err != nil {
+			return err
+		}
+	}
+
+	if err := refs.UpdateHead(gitDir, target); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	switch mode {
+	case ResetMixed:
+		return printUnstaged(gitDir, store, idx)
+	case ResetHard, ResetKeep:
+		commit, err := loadCommit(store, target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("HEAD is now at %s %s\n", target[:7], strings.SplitN(commit.Message, "\n", 2)[0])
+	}
+
+	return nil
+}
+
+// checkoutHard makes the index and work tree match the target, deleting
+// tracked files it does not have
+func checkoutHard(repoRoot string, store storage.ObjectStore, idx *index.Index, head map[string]index.Entry, targetEntries []index.Entry) error {
+	wanted := entryMap(targetEntries)
+
+	for path := range head {
+		if _, ok := wanted[path]; !ok {
+			if err := worktree.Remove(repoRoot, path); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range idx.Entries {
+		if _, ok := wanted[entry.Path]; !ok {
+			if err := worktree.Remove(repoRoot, entry.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range targetEntries {
+		changed, err := worktree.Changed(store.Algorithm(), repoRoot, entry)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := worktree.Checkout(store, repoRoot, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	idx.Entries = append([]index.Entry(nil), targetEntries...)
+	return nil
+}
+
+// checkoutKeep updates only the paths that differ between HEAD and the
+// target, refusing if any of them has local changes that would be lost
+func checkoutKeep(repoRoot string, store storage.ObjectStore, idx *index.Index, head map[string]index.Entry, targetEntries []index.Entry) error {
+	wanted := entryMap(targetEntries)
+
+	var differing []string
+	for path, entry := range head {
+		if other, ok := wanted[path]; !ok || other != entry {
+			differing = append(differing, path)
+		}
+	}
+	for path := range wanted {
+		if _, ok := head[path]; !ok {
+			differing = append(differing, path)
+		}
+	}
+	sort.Strings(differing)
+
+	for _, path := range differing {
+		if err := checkUpToDate(repoRoot, store, idx, head, path); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range differing {
+		entry, ok := wanted[path]
+		if !ok {
+			idx.Remove(path)
+			if err := worktree.Remove(repoRoot, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := worktree.Checkout(store, repoRoot, entry); err != nil {
+			return err
+		}
+		idx.Add(entry.Mode, entry.Hash, entry.Path)
+	}
+
+	return nil
+}
+
+// checkoutResetMerge makes the index match the target like "reset --merge":
+// only paths whose index entry differs from the target, or that are
+// unmerged, are updated in the work tree, and changes the user has not
+// staged elsewhere are kept. It refuses before touching anything if an
+// updated path has unstaged changes or an untracked file is in the way.
+func checkoutResetMerge(repoRoot string, store storage.ObjectStore, idx *index.Index, targetEntries []index.Entry) error {
+	staged := make(map[string]index.Entry)
+	unmerged := make(map[string]bool)
+	for _, entry := range idx.Entries {
+		if entry.Stage == 0 {
+			staged[entry.Path] = entry
+		} else {
+			unmerged[entry.Path] = true
+		}
+	}
+	wanted := entryMap(targetEntries)
+
+	paths := mergedPaths(staged, wanted)
+	for path := range unmerged {
+		if _, ok := staged[path]; !ok {
+			if _, ok := wanted[path]; !ok {
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	var update []string
+	for _, path := range paths {
+		before, had := staged[path]
+		after, keep := wanted[path]
+		same := had == keep && (!had || (before.Hash == after.Hash && before.Mode == after.Mode))
+		if same && !unmerged[path] {
+			continue
+		}
+		update = append(update, path)
+
+		// Conflicted files hold the merge's markers, not the user's work
+		if unmerged[path] {
+			continue
+		}
+
+		switch {
+		case had:
+			changed, err := worktreeChanged(repoRoot, store, before)
+			if err != nil {
+				return err
+			}
+			if changed {
+				return fmt.Errorf("entry '%s' not uptodate, cannot merge", path)
+			}
+		case exists(worktreePath(repoRoot, path)):
+			return fmt.Errorf("untracked working tree file '%s' would be overwritten", path)
+		}
+	}
+
+	for _, path := range update {
+		entry, keep := wanted[path]
+		if !keep {
+			if err := worktree.Remove(repoRoot, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := worktree.Checkout(store, repoRoot, entry); err != nil {
+			return err
+		}
+	}
+
+	idx.Entries = append([]index.Entry(nil), targetEntries...)
+	return nil
+}
+
+// checkUpToDate fails if a path's index entry or work tree file differs
+// from HEAD, so replacing it would lose work
+func checkUpToDate(repoRoot string, store storage.ObjectStore, idx *index.Index, head map[string]index.Entry, path string) error {
+	committed, inHead := head[path]
+	staged, inIndex := idx.Get(path)
+
+	if inHead != inIndex || (inHead && *staged != committed) {
+		return fmt.Errorf("entry '%s' not uptodate, cannot merge", path)
+	}
+
+	if !inHead {
+		if exists(worktreePath(repoRoot, path)) {
+			return fmt.Errorf("untracked working tree file '%s' would be overwritten", path)
+		}
+		return nil
+	}
+
+	changed, err := worktreeChanged(repoRoot, store, committed)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return fmt.Errorf("entry '%s' not uptodate, cannot merge", path)
+	}
+
+	return nil
+}
+
+// printUnstaged lists tracked files whose work tree copy differs from the
+// index
+func printUnstaged(gitDir string, store storage.ObjectStore, idx *index.Index) error {
+	repoRoot := filepath.Dir(gitDir)
+	var lines []string
+
+	for _, entry := range idx.Entries {
+		if entry.Stage > 0 {
+			if entry.Stage == idx.Conflicts(entry.Path)[0].Stage {
+				lines = append(lines, "U\t"+entry.Path)
+			}
+			continue
+		}
+
+		if !exists(worktreePath(repoRoot, entry.Path)) {
+			lines = append(lines, "D\t"+entry.Path)
+			continue
+		}
+
+		changed, err := worktree.Changed(store.Algorithm(), repoRoot, entry)
+		if err != nil {
+			return err
+		}
+		if changed {
+			lines = append(lines, "M\t"+entry.Path)
+		}
+	}
+
+	if len(lines) > 0 {
+		fmt.Println("Unstaged changes after reset:")
+		fmt.Println(strings.Join(lines, "\n"))
+	}
+
+	return nil
+}
+
+func entryMap(entries []index.Entry) map[string]index.Entry {
+	m := make(map[string]index.Entry, len(entries))
+	for _, entry := range entries {
+		m[entry.Path] = entry
+	}
+
+	return m
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func writeSortedIndex(gitDir string, idx *index.Index) error {
+	idx.Sort()
+
+	if err := index.WriteIndex(gitDir, idx); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+type RestoreOptions struct {
+	Paths []string
+	// Source is the commit or tree to restore from. When empty it is the
+	// index for the work tree and HEAD for the index.
+	Source string
+	// Staged restores the index, Worktree the work tree. The work tree is
+	// restored when neither is set.
+	Staged   bool
+	Worktree bool
+	// Ours and Theirs pick a side for unmerged paths restored from the index
+	Ours   bool
+	Theirs bool
+}
+
+func Restore(opts RestoreOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+
+	if !opts.Staged && !opts.Worktree {
+		opts.Worktree = true
+	}
+	if opts.Ours && opts.Theirs {
+		return fmt.Errorf("--ours and --theirs cannot be used together")
+	}
+	if (opts.Ours || opts.Theirs) && (opts.Staged || opts.Source != "") {
+		return fmt.Errorf("--ours and --theirs only restore the work tree from the index")
+	}
+	if len(opts.Paths) == 0 {
+		return fmt.Errorf("you must specify path(s) to restore")
+	}
+
+	prefix, err := worktree.Prefix(repoRoot)
+	if err != nil {
+		return err
+	}
+
+	ps, err := pathspec.New(prefix, opts.Paths)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	fromIndex := opts.Source == "" && !opts.Staged
+
+	var sourceEntries map[string]index.Entry
+	if !fromIndex {
+		if sourceEntries, err = restoreSource(gitDir, store, opts.Source); err != nil {
+			return err
+		}
+	}
+
+	paths := restorePaths(ps, idx, sourceEntries)
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		return fmt.Errorf("pathspec '%s' did not match any file(s) known to git", unmatched[0])
+	}
+
+	// Every path is resolved before anything is touched, so an unmerged
+	// path aborts the whole restore
+	wanted := make(map[string]*index.Entry, len(paths))
+	for _, path := range paths {
+		if !fromIndex {
+			if entry, ok := sourceEntries[path]; ok {
+				wanted[path] = &entry
+			}
+			continue
+		}
+
+		entry, err := indexVersion(idx, path, opts)
+		if err != nil {
+			return err
+		}
+		wanted[path] = entry
+	}
+
+	updated := 0
+	for _, path := range paths {
+		entry := wanted[path]
+
+		if opts.Worktree {
+			changed, err := restoreWorktree(repoRoot, store, path, entry)
+			if err != nil {
+				return err
+			}
+			if changed {
+				updated++
+			}
+		}
+
+		if opts.Staged {
+			if entry == nil {
+				idx.Remove(path)
+			} else {
+				idx.Add(entry.Mode, entry.Hash, entry.Path)
+			}
+		}
+	}
+
+	if opts.Staged {
+		if err := writeSortedIndex(gitDir, idx); err != nil {
+			return err
+		}
+	}
+
+	if opts.Worktree && updated > 0 {
+		from := "the index"
+		if !fromIndex {
+			from = abbrevRev(gitDir, store, opts.Source)
+		}
+
+		noun := "paths"
+		if updated == 1 {
+			noun = "path"
+		}
+		fmt.Printf("Updated %d %s from %s\n", updated, noun, from)
+	}
+
+	return nil
+}
+
+// restoreSource flattens the tree named by the source, HEAD when none was
+// given. An unborn HEAD has no entries.
+func restoreSource(gitDir string, store storage.ObjectStore, rev string) (map[string]index.Entry, error) {
+	if rev == "" {
+		return headEntries(gitDir, store)
+	}
+
+	treeHash, err := revision.New(gitDir, store).Tree(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := tree.Flatten(store, treeHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return entryMap(entries), nil
+}
+
+// restorePaths collects the matching paths known to the index or the
+// source, so paths missing from the source are deleted
+func restorePaths(ps *pathspec.Pathspec, idx *index.Index, sourceEntries map[string]index.Entry) []string {
+	seen := make(map[string]bool)
+	var paths []string
+
+	for _, path := range idx.Paths() {
+		if ps.Match(path) {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for path := range sourceEntries {
+		if !seen[path] && ps.Match(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// indexVersion returns the index entry for a path, choosing a stage with
+// --ours or --theirs when the path is unmerged
+func indexVersion(idx *index.Index, path string, opts RestoreOptions) (*index.Entry, error) {
+	conflicts := idx.Conflicts(path)
+	if len(conflicts) == 0 {
+		entry, _ := idx.Get(path)
+		return entry, nil
+	}
+
+	stage, side := 0, ""
+	switch {
+	case opts.Ours:
+		stage, side = 2, "our"
+	case opts.Theirs:
+		stage, side = 3, "their"
+	default:
+		return nil, fmt.Errorf("path '%s' is unmerged", path)
+	}
+
+	for _, entry := range conflicts {
+		if entry.Stage == stage {
+			entry.Stage = 0
+			return &entry, nil
+		}
+	}
+
+	return nil, fmt.Errorf("path '%s' does not have %s version", path, side)
+}
+
+// restoreWorktree checks out an entry if the work tree copy differs, or
+// deletes the file when the source does not have it
+func restoreWorktree(repoRoot string, store storage.ObjectStore, path string, entry *index.Entry) (bool, error) {
+	if entry == nil {
+		if !exists(worktreePath(repoRoot, path)) {
+			return false, nil
+		}
+		return true, worktree.Remove(repoRoot, path)
+	}
+
+	changed, err := worktree.Changed(store.Algorithm(), repoRoot, *entry)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	return true, worktree.Checkout(store, repoRoot, *entry)
+}
+
+func abbrevRev(gitDir string, store storage.ObjectStore, rev string) string {
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	hash, err := revision.New(gitDir, store).Resolve(rev)
+	if err != nil || len(hash) < 7 {
+		return rev
+	}
+
+	return hash[:7]
+}
@@ -0,0 +1,226 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+type RmOptions struct {
+	Paths []string
+	// Cached only removes the index entries, keeping the files
+	Cached    bool
+	Recursive bool
+	// Force skips the up-to-date checks
+	Force  bool
+	DryRun bool
+}
+
+func Rm(opts RmOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+
+	prefix, err := worktree.Prefix(repoRoot)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if !opts.Recursive {
+		if err := checkRecursive(prefix, opts.Paths, idx); err != nil {
+			return err
+		}
+	}
+
+	ps, err := pathspec.New(prefix, opts.Paths)
+	if err != nil {
+		return err
+	}
+
+	// An unmerged path is removed as a whole, by its first stage
+	var matched []index.Entry
+	seen := make(map[string]bool)
+	for _, entry := range idx.Entries {
+		if !seen[entry.Path] && ps.Match(entry.Path) {
+			seen[entry.Path] = true
+			matched = append(matched, entry)
+		}
+	}
+
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		return fmt.Errorf("pathspec '%s' did not match any files", unmatched[0])
+	}
+
+	if !opts.Force {
+		if err := checkRemovable(gitDir, store, matched, opts.Cached); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range matched {
+		fmt.Printf("rm '%s'\n", entry.Path)
+		if opts.DryRun {
+			continue
+		}
+
+		idx.Remove(entry.Path)
+		if !opts.Cached {
+			if err := worktree.Remove(repoRoot, entry.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	if err := index.WriteIndex(gitDir, idx); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	return nil
+}
+
+// checkRecursive refuses a plain path that names a directory of tracked
+// files, as Git wants -r for those
+func checkRecursive(prefix string, args []string, idx *index.Index) error {
+	for _, arg := range args {
+		path, err := pathspec.Resolve(prefix, arg)
+		if err != nil {
+			return err
+		}
+		if pathspec.HasWildcard(path) {
+			continue
+		}
+		if _, ok := idx.Get(path); ok {
+			continue
+		}
+
+		for _, entry := range idx.Entries {
+			if path == "" || strings.HasPrefix(entry.Path, path+"/") {
+				return fmt.Errorf("not removing '%s' recursively without -r", arg)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRemovable makes sure removing the entries loses nothing that is not
+// in HEAD or, with cached, in the work tree
+func checkRemovable(gitDir string, store storage.ObjectStore, entries []index.Entry, cached bool) error {
+	head, err := headEntries(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+	var both, staged, local []string
+
+	for _, entry := range entries {
+		if entry.Stage > 0 {
+			continue
+		}
+
+		committed, ok := head[entry.Path]
+		stagedChange := !ok || committed.Hash != entry.Hash || committed.Mode != entry.Mode
+
+		localChange, err := worktreeChanged(repoRoot, store, entry)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case stagedChange && localChange:
+			both = append(both, entry.Path)
+		case cached:
+		case stagedChange:
+			staged = append(staged, entry.Path)
+		case localChange:
+			local = append(local, entry.Path)
+		}
+	}
+
+	var problems []string
+	if len(both) > 0 {
+		problems = append(problems, fileList("staged content different from both the file and the HEAD", both)+"\n(use -f to force removal)")
+	}
+	if len(staged) > 0 {
+		problems = append(problems, fileList("changes staged in the index", staged)+"\n(use --cached to keep the file, or -f to force removal)")
+	}
+	if len(local) > 0 {
+		problems = append(problems, fileList("local modifications", local)+"\n(use --cached to keep the file, or -f to force removal)")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
+func fileList(what string, paths []string) string {
+	noun := "file has"
+	if len(paths) > 1 {
+		noun = "files have"
+	}
+
+	return fmt.Sprintf("the following %s %s:\n    %s", noun, what, strings.Join(paths, "\n    "))
+}
+
+// worktreeChanged reports whether the work tree file differs from its
+// index entry; a missing file does not count as a change
+func worktreeChanged(repoRoot string, store storage.ObjectStore, entry index.Entry) (bool, error) {
+	if _, err := os.Lstat(worktreePath(repoRoot, entry.Path)); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return worktree.Changed(store.Algorithm(), repoRoot, entry)
+}
+
+// headEntries maps each path in the tree of HEAD to its entry, and is
+// empty before the first commit
+func headEntries(gitDir string, store storage.ObjectStore) (map[string]index.Entry, error) {
+	entries := make(map[string]index.Entry)
+
+	headHash, err := refs.ReadRef(gitDir, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if headHash == "" {
+		return entries, nil
+	}
+
+	commit, err := loadCommit(store, headHash)
+	if err != nil {
+		return nil, err
+	}
+
+	flat, err := tree.Flatten(store, commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range flat {
+		entries[entry.Path] = entry
+	}
+
+	return entries, nil
+}
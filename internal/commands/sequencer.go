@@ -0,0 +1,723 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/merge"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+)
+
+type PickOptions struct {
+	Revs []string
+	// Mainline is the parent, counting from 1, that a merge commit is
+	// compared against
+	Mainline int
+	// NoCommit applies the changes to the index and work tree only
+	NoCommit bool
+	// RecordOrigin appends "(cherry picked from commit ...)" to messages
+	RecordOrigin bool
+	Continue     bool
+	Abort        bool
+	Skip         bool
+}
+
+// sequencer works through a list of commits to cherry-pick or revert,
+// keeping its state in .git/sequencer like Git so it can stop on a
+// conflict and resume
+type sequencer struct {
+	gitDir string
+	store  storage.ObjectStore
+	cfg    *config.Config
+	action string
+	opts   PickOptions
+	todo   []todoItem
+}
+
+// todoItem is one line of the todo file: "pick <hash> <subject>"
+type todoItem struct {
+	action  string
+	hash    string
+	subject string
+}
+
+func (t todoItem) String() string {
+	return fmt.Sprintf("%s %s %s", t.action, t.hash, t.subject)
+}
+
+func CherryPick(opts PickOptions) error {
+	return runSequencer("pick", opts)
+}
+
+func Revert(opts PickOptions) error {
+	return runSequencer("revert", opts)
+}
+
+func runSequencer(action string, opts PickOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	seq := &sequencer{gitDir: gitDir, store: store, cfg: cfg, action: action, opts: opts}
+
+	switch {
+	case opts.Continue:
+		return seq.resume(seq.commitResolved)
+	case opts.Skip:
+		return seq.resume(seq.skip)
+	case opts.Abort:
+		return seq.abort()
+	}
+
+	return seq.start()
+}
+
+func (s *sequencer) dir() string {
+	return filepath.Join(s.gitDir, "sequencer")
+}
+
+// pickHead is the file naming the commit being applied while it is stopped
+func (s *sequencer) pickHead() string {
+	if s.action == "revert" {
+		return filepath.Join(s.gitDir, "REVERT_HEAD")
+	}
+
+	return filepath.Join(s.gitDir, "CHERRY_PICK_HEAD")
+}
+
+func (s *sequencer) command() string {
+	if s.action == "revert" {
+		return "revert"
+	}
+
+	return "cherry-pick"
+}
+
+func (s *sequencer) start() error {
+	if exists(s.dir()) {
+		return fmt.Errorf("a cherry-pick or revert is already in progress\n(try \"mygit %s --continue\", \"--skip\" or \"--abort\")", s.command())
+	}
+	if len(s.opts.Revs) == 0 {
+		return fmt.Errorf("no commits given")
+	}
+
+	head, err := refs.ReadRef(s.gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if head == "" {
+		return fmt.Errorf("cannot %s onto an unborn branch", s.command())
+	}
+
+	res := revision.New(s.gitDir, s.store)
+	for _, rev := range s.opts.Revs {
+		hash, err := res.Commit(rev)
+		if err != nil {
+			return err
+		}
+
+		commit, err := loadCommit(s.store, hash)
+		if err != nil {
+			return err
+		}
+		s.todo = append(s.todo, todoItem{action: s.action, hash: hash, subject: subjectLine(commit.Message)})
+	}
+
+	if !s.opts.NoCommit {
+		if err := s.checkCleanIndex(head); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(s.dir(), 0755); err != nil {
+		return fmt.Errorf("failed to create sequencer directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir(), "head"), []byte(head+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+	if err := s.saveOptions(); err != nil {
+		return err
+	}
+
+	// A sequence that fails before changing anything leaves no state behind
+	total := len(s.todo)
+	if err := s.run(); err != nil {
+		if len(s.todo) == total && !exists(s.pickHead()) {
+			if cleanErr := s.cleanup(); cleanErr != nil {
+				return cleanErr
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// checkCleanIndex refuses to start while the index has staged changes,
+// which a picked commit would otherwise absorb
+func (s *sequencer) checkCleanIndex(head string) error {
+	idx, err := index.ReadIndex(s.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	headCommit, err := loadCommit(s.store, head)
+	if err != nil {
+		return err
+	}
+
+	treeHash, err := tree.BuildTreeFromIndex(s.store, idx)
+	if err != nil || treeHash != headCommit.Tree {
+		return fmt.Errorf("your local changes would be overwritten by %s\n(commit your changes or stash them to proceed)", s.command())
+	}
+
+	return nil
+}
+
+// run applies the todo list in order, saving it after every step
+func (s *sequencer) run() error {
+	for len(s.todo) > 0 {
+		if err := s.saveTodo(); err != nil {
+			return err
+		}
+		if err := s.saveHead(); err != nil {
+			return err
+		}
+
+		if err := s.apply(s.todo[0]); err != nil {
+			return err
+		}
+
+		if err := s.finishItem(); err != nil {
+			return err
+		}
+	}
+
+	return s.cleanup()
+}
+
+// apply merges one commit's change into the index and work tree and,
+// unless told not to, commits it
+func (s *sequencer) apply(item todoItem) error {
+	commit, err := loadCommit(s.store, item.hash)
+	if err != nil {
+		return err
+	}
+
+	parent, err := s.parent(item.hash, commit)
+	if err != nil {
+		return err
+	}
+
+	result, idx, err := mergeChange(s.gitDir, s.store, item.hash, commit, parent, item.action == "revert")
+	if err != nil {
+		return err
+	}
+
+	message := s.message(item, commit, parent)
+
+	if !result.Clean() {
+		if err := s.stop(item, message); err != nil {
+			return err
+		}
+		printConflicts(result)
+		return fmt.Errorf("could not apply %s... %s: %w\n(then run \"mygit %s --continue\")", item.hash[:7], item.subject, errMergeConflict, s.command())
+	}
+
+	if s.opts.NoCommit {
+		return nil
+	}
+
+	author, err := s.author(commit)
+	if err != nil {
+		return err
+	}
+
+	if err := s.commit(idx, message, author); err != nil {
+		if stopErr := s.stop(item, message); stopErr != nil {
+			return stopErr
+		}
+		return err
+	}
+
+	return nil
+}
+
+// mergeChange merges the change a commit made relative to parent, or its
+// inverse with reverse, into the index and work tree. Conflicted paths are
+// left unmerged in the index, which is written and returned.
+func mergeChange(gitDir string, store storage.ObjectStore, hash string, commit *objects.Commit, parent string, reverse bool) (*merge.Result, *index.Index, error) {
+	var parentEntries []index.Entry
+	if parent != "" {
+		var err error
+		if parentEntries, err = commitEntries(store, parent); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	commitTreeEntries, err := tree.Flatten(store, commit.Tree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	label := fmt.Sprintf("%s (%s)", hash[:7], subjectLine(commit.Message))
+	base, theirs := parentEntries, commitTreeEntries
+	labels := merge.Labels{Ours: "HEAD", Theirs: label}
+	if reverse {
+		base, theirs = commitTreeEntries, parentEntries
+		labels.Theirs = "parent of " + label
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	result, err := merge.Trees(store, base, idx.Entries, theirs, labels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkoutMerge(filepath.Dir(gitDir), store, idx, result); err != nil {
+		return nil, nil, err
+	}
+
+	idx.Entries = result.Entries
+	if err := writeSortedIndex(gitDir, idx); err != nil {
+		return nil, nil, err
+	}
+
+	return result, idx, nil
+}
+
+// parent picks the commit a change is measured against, using the
+// mainline option for merges
+func (s *sequencer) parent(hash string, commit *objects.Commit) (string, error) {
+	switch {
+	case len(commit.Parents) > 1 && s.opts.Mainline == 0:
+		return "", fmt.Errorf("commit %s is a merge but no -m option was given", hash)
+	case len(commit.Parents) <= 1 && s.opts.Mainline != 0:
+		return "", fmt.Errorf("mainline was specified but commit %s is not a merge", hash)
+	case s.opts.Mainline > len(commit.Parents):
+		return "", fmt.Errorf("commit %s does not have parent %d", hash, s.opts.Mainline)
+	case s.opts.Mainline > 0:
+		return commit.Parents[s.opts.Mainline-1], nil
+	case len(commit.Parents) == 1:
+		return commit.Parents[0], nil
+	}
+
+	return "", nil
+}
+
+func (s *sequencer) message(item todoItem, commit *objects.Commit, parent string) string {
+	if item.action == "revert" {
+		message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", item.subject, item.hash)
+		if s.opts.Mainline > 0 {
+			return message + fmt.Sprintf(", reversing\nchanges made to %s.", parent)
+		}
+		return message + "."
+	}
+
+	if s.opts.RecordOrigin {
+		return fmt.Sprintf("%s\n\n(cherry picked from commit %s)", commit.Message, item.hash)
+	}
+
+	return commit.Message
+}
+
+// author keeps the original author of a picked commit; a revert is
+// authored by whoever makes it
+func (s *sequencer) author(commit *objects.Commit) (ident.Ident, error) {
+	if s.action == "revert" {
+		return ident.Author(s.cfg)
+	}
+
+	return commitAuthor(commit)
+}
+
+func (s *sequencer) commit(idx *index.Index, message string, author ident.Ident) error {
+	head, err := refs.ReadRef(s.gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	headCommit, err := loadCommit(s.store, head)
+	if err != nil {
+		return err
+	}
+
+	treeHash, err := tree.BuildTreeFromIndex(s.store, idx)
+	if err != nil {
+		return fmt.Errorf("failed to build tree: %w", err)
+	}
+	if treeHash == headCommit.Tree {
+		return fmt.Errorf("the previous %s is now empty\n(use \"mygit %s --skip\" to skip this commit)", s.command(), s.command())
+	}
+
+	hash, err := commitTree(s.gitDir, s.store, s.cfg, treeHash, []string{head}, message, author)
+	if err != nil {
+		return err
+	}
+
+	printCommitSummary(s.gitDir, hash, message)
+	return nil
+}
+
+// stop records the commit being applied and its message for --continue
+func (s *sequencer) stop(item todoItem, message string) error {
+	if err := os.WriteFile(s.pickHead(), []byte(item.hash+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(s.pickHead()), err)
+	}
+
+	if err := os.WriteFile(filepath.Join(s.gitDir, "MERGE_MSG"), []byte(message+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write MERGE_MSG: %w", err)
+	}
+
+	return nil
+}
+
+// resume loads a stopped sequence, settles the current commit with
+// settle, and carries on with the rest. A commit settle reports as not
+// done is applied again.
+func (s *sequencer) resume(settle func() (bool, error)) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	if len(s.todo) > 0 {
+		done, err := settle()
+		if err != nil {
+			return err
+		}
+		if done {
+			if err := s.finishItem(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.run()
+}
+
+// commitResolved commits the stopped commit once its conflicts are
+// resolved. Without a pick head the commit either was committed by hand,
+// which moved HEAD, or failed before it could stop and is not done.
+func (s *sequencer) commitResolved() (bool, error) {
+	if !exists(s.pickHead()) {
+		return s.headMoved()
+	}
+
+	idx, err := index.ReadIndex(s.gitDir)
+	if err != nil {
+		return false, fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(idx.Unmerged()) > 0 {
+		return false, fmt.Errorf("you need to resolve your current index first\n(fix the conflicts, mark them with \"mygit add <paths>\" and run \"mygit %s --continue\")", s.command())
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.gitDir, "MERGE_MSG"))
+	if err != nil {
+		return false, fmt.Errorf("failed to read MERGE_MSG: %w", err)
+	}
+	message := cleanupMessage(string(data), true)
+	if message == "" {
+		return false, fmt.Errorf("aborting commit due to empty commit message")
+	}
+
+	commit, err := loadCommit(s.store, s.todo[0].hash)
+	if err != nil {
+		return false, err
+	}
+
+	author, err := s.author(commit)
+	if err != nil {
+		return false, err
+	}
+
+	if !s.opts.NoCommit {
+		if err := s.commit(idx, message, author); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// skip throws away the stopped commit's changes
+func (s *sequencer) skip() (bool, error) {
+	return true, discardChanges(s.gitDir, s.store)
+}
+
+// discardChanges resets the index, and the files that differ from it, to
+// HEAD like "reset --merge", keeping unstaged changes to other files
+func discardChanges(gitDir string, store storage.ObjectStore) error {
+	head, err := headEntries(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	target := make([]index.Entry, 0, len(head))
+	for _, entry := range head {
+		target = append(target, entry)
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if err := checkoutResetMerge(filepath.Dir(gitDir), store, idx, target); err != nil {
+		return err
+	}
+
+	return writeSortedIndex(gitDir, idx)
+}
+
+// abort returns HEAD, the index and the files the sequence changed to
+// where it started
+func (s *sequencer) abort() error {
+	repoRoot := filepath.Dir(s.gitDir)
+
+	data, err := os.ReadFile(filepath.Join(s.dir(), "head"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no cherry-pick or revert in progress")
+		}
+		return fmt.Errorf("failed to read sequencer state: %w", err)
+	}
+	orig := strings.TrimSpace(string(data))
+
+	target, err := commitEntries(s.store, orig)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(s.gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if err := checkoutResetMerge(repoRoot, s.store, idx, target); err != nil {
+		return err
+	}
+	if err := writeSortedIndex(s.gitDir, idx); err != nil {
+		return err
+	}
+	if err := refs.UpdateHead(s.gitDir, orig); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	return s.cleanup()
+}
+
+// finishItem moves the first todo item to the done file
+func (s *sequencer) finishItem() error {
+	file, err := os.OpenFile(filepath.Join(s.dir(), "done"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+	if _, err := fmt.Fprintln(file, s.todo[0]); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	s.todo = s.todo[1:]
+	return s.saveTodo()
+}
+
+// saveHead records HEAD before a commit is applied, in abort-safety as
+// Git does, so --continue can tell whether it was committed
+func (s *sequencer) saveHead() error {
+	head, err := refs.ReadRef(s.gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir(), "abort-safety"), []byte(head+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+
+	return nil
+}
+
+// headMoved reports whether HEAD is no longer where it was before the
+// current commit was applied
+func (s *sequencer) headMoved() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir(), "abort-safety"))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read sequencer state: %w", err)
+	}
+
+	head, err := refs.ReadRef(s.gitDir, "HEAD")
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	return head != strings.TrimSpace(string(data)), nil
+}
+
+func (s *sequencer) saveTodo() error {
+	var buf strings.Builder
+	for _, item := range s.todo {
+		fmt.Fprintln(&buf, item)
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir(), "todo"), []byte(buf.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+
+	return nil
+}
+
+// saveOptions writes the options file in Git's config format, so that
+// --continue behaves like the command that started the sequence
+func (s *sequencer) saveOptions() error {
+	path := filepath.Join(s.dir(), "opts")
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return fmt.Errorf("failed to write sequencer state: %w", err)
+	}
+
+	if s.opts.NoCommit {
+		if err := config.Set(path, "options.no-commit", "true"); err != nil {
+			return err
+		}
+	}
+	if s.opts.RecordOrigin {
+		if err := config.Set(path, "options.record-origin", "true"); err != nil {
+			return err
+		}
+	}
+	if s.opts.Mainline > 0 {
+		if err := config.Set(path, "options.mainline", strconv.Itoa(s.opts.Mainline)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sequencer) load() error {
+	file, err := os.Open(filepath.Join(s.dir(), "todo"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no cherry-pick or revert in progress")
+		}
+		return fmt.Errorf("failed to read sequencer state: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+
+		item := todoItem{action: fields[0], hash: fields[1]}
+		if len(fields) == 3 {
+			item.subject = fields[2]
+		}
+		s.todo = append(s.todo, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read sequencer state: %w", err)
+	}
+
+	// The sequence keeps going as whichever command started it
+	if len(s.todo) > 0 {
+		s.action = s.todo[0].action
+	}
+
+	opts, err := config.LoadFile(filepath.Join(s.dir(), "opts"), config.ScopeLocal, s.gitDir)
+	if err != nil {
+		return err
+	}
+
+	if s.opts.NoCommit, err = opts.Bool("options.no-commit", false); err != nil {
+		return err
+	}
+	if s.opts.RecordOrigin, err = opts.Bool("options.record-origin", false); err != nil {
+		return err
+	}
+	mainline, err := opts.Int("options.mainline", 0)
+	if err != nil {
+		return err
+	}
+	s.opts.Mainline = int(mainline)
+
+	return nil
+}
+
+func (s *sequencer) cleanup() error {
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+		if err := os.Remove(filepath.Join(s.gitDir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+
+	if err := os.RemoveAll(s.dir()); err != nil {
+		return fmt.Errorf("failed to remove sequencer state: %w", err)
+	}
+
+	return nil
+}
+
+// commitTree writes a commit with the given author, committed now by the
+// configured committer, and moves HEAD to it
+func commitTree(gitDir string, store storage.ObjectStore, cfg *config.Config, treeHash string, parents []string, message string, author ident.Ident) (string, error) {
+	committer, err := ident.Committer(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	commit := objects.NewCommit(treeHash, author.String(), message)
+	commit.Timestamp = author.When
+	commit.Committer = committer.String()
+	commit.CommitTime = committer.When
+	commit.Parents = parents
+
+	hash, err := storage.WriteObject(store, commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to write commit: %w", err)
+	}
+
+	if err := refs.UpdateHead(gitDir, hash); err != nil {
+		return "", fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	return hash, nil
+}
+
+// printCommitSummary prints "[branch hash] subject" the way commit does
+func printCommitSummary(gitDir, hash, message string) {
+	branch := "detached HEAD"
+	if current, err := refs.GetCurrentBranch(gitDir); err == nil {
+		branch = strings.TrimPrefix(current, "refs/heads/")
+	}
+
+	fmt.Printf("[%s %s] %s\n", branch, hash[:7], subjectLine(message))
+}
@@ -0,0 +1,330 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/revision"
+)
+
+type ShortlogOptions struct {
+	// Revs select the commits as "git rev-list" would, HEAD when empty
+	Revs []string
+	// Summary prints only how many commits each person has
+	Summary bool
+	// Numbered sorts people by their number of commits rather than by name
+	Numbered bool
+	// Email shows each person's email after their name
+	Email bool
+	// Groups are "author", "committer" or "trailer:<key>", and a commit is
+	// counted once for each person they name. Author when empty.
+	Groups []string
+}
+
+// shortlogEntry is a person and the subjects of their commits, newest first
+type shortlogEntry struct {
+	name     string
+	subjects []string
+}
+
+func Shortlog(opts ShortlogOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	groups := opts.Groups
+	if len(groups) == 0 {
+		groups = []string{"author"}
+	}
+	for _, group := range groups {
+		if group != "author" && group != "committer" && !strings.HasPrefix(group, "trailer:") {
+			return fmt.Errorf("unknown group type: %s", group)
+		}
+	}
+
+	mailmap, err := readMailmap(gitDir)
+	if err != nil {
+		return err
+	}
+
+	revs := opts.Revs
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+
+	include, exclude, err := revision.New(gitDir, store).Range(revs)
+	if err != nil {
+		return err
+	}
+
+	graph := history.New(store)
+	hashes, err := graph.Range(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]*shortlogEntry)
+	for _, hash := range hashes {
+		commit, err := graph.Commit(hash)
+		if err != nil {
+			return err
+		}
+
+		subject := shortlogSubject(commit.Message)
+		for _, name := range shortlogNames(commit, groups, mailmap, opts.Email) {
+			entry, ok := entries[name]
+			if !ok {
+				entry = &shortlogEntry{name: name}
+				entries[name] = entry
+			}
+			entry.subjects = append(entry.subjects, subject)
+		}
+	}
+
+	sorted := make([]*shortlogEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+	if opts.Numbered {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return len(sorted[i].subjects) > len(sorted[j].subjects)
+		})
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	for _, entry := range sorted {
+		if opts.Summary {
+			fmt.Fprintf(out, "%6d\t%s\n", len(entry.subjects), entry.name)
+			continue
+		}
+
+		fmt.Fprintf(out, "%s (%d):\n", entry.name, len(entry.subjects))
+		for i := len(entry.subjects) - 1; i >= 0; i-- {
+			fmt.Fprintf(out, "      %s\n", entry.subjects[i])
+		}
+		fmt.Fprintln(out)
+	}
+
+	return out.Flush()
+}
+
+// readMailmap loads .mailmap from the top of the work tree and the file
+// named by mailmap.file, either of which may be missing
+func readMailmap(gitDir string) (*ident.Mailmap, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	repoRoot := filepath.Dir(gitDir)
+	files := []string{filepath.Join(repoRoot, ".mailmap")}
+	if file, ok := cfg.Get("mailmap.file"); ok && file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(repoRoot, file)
+		}
+		files = append(files, file)
+	}
+
+	mailmap := ident.NewMailmap()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mailmap: %w", err)
+		}
+		mailmap.Parse(string(data))
+	}
+
+	return mailmap, nil
+}
+
+// shortlogNames lists the people a commit is counted for, each once
+func shortlogNames(commit *objects.Commit, groups []string, mailmap *ident.Mailmap, email bool) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	add := func(value string) {
+		if id, err := ident.Parse(value); err == nil {
+			id = mailmap.Map(id)
+			value = id.Name
+			if email {
+				value = id.String()
+			}
+		}
+
+		if !seen[value] {
+			seen[value] = true
+			names = append(names, value)
+		}
+	}
+
+	for _, group := range groups {
+		switch group {
+		case "author":
+			add(commit.Author)
+		case "committer":
+			add(commit.Committer)
+		default:
+			key := strings.TrimPrefix(group, "trailer:")
+			for _, t := range messageTrailers(commit.Message) {
+				if strings.EqualFold(t.key, key) {
+					add(t.value)
+				}
+			}
+		}
+	}
+
+	return names
+}
+
+// shortlogSubject is the first paragraph of a message on one line, without
+// a leading "[PATCH ...]"
+func shortlogSubject(message string) string {
+	message = strings.TrimLeftFunc(message, unicode.IsSpace)
+
+	firstLine, _, _ := strings.Cut(message, "\n")
+	if strings.HasPrefix(message, "[PATCH") {
+		if end := strings.IndexByte(firstLine, ']'); end != -1 {
+			message = strings.TrimLeft(message[end+1:], " \t")
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, " ")
+}
+
+// trailer is a "Key: value" line from the end of a commit message
+type trailer struct {
+	key   string
+	value string
+}
+
+// gitTrailerPrefixes start lines Git itself adds to messages. One of them
+// lets a final paragraph count as trailers even if some of its lines are not.
+var gitTrailerPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+// messageTrailers finds the trailers of a message the way Git does: they
+// are the last paragraph, other than the subject, if every line of it is a
+// trailer or continues one, or if at least a quarter are and one of them
+// is of a kind Git adds
+func messageTrailers(message string) []trailer {
+	lines := strings.Split(strings.TrimRightFunc(message, unicode.IsSpace), "\n")
+
+	title := 0
+	for title < len(lines) && strings.TrimSpace(lines[title]) != "" {
+		title++
+	}
+
+	start := -1
+	trailers, others, continuations := 0, 0, 0
+	recognized := false
+	for i := len(lines) - 1; i >= title; i-- {
+		line := lines[i]
+
+		if strings.TrimSpace(line) == "" {
+			others += continuations
+			if (recognized && trailers*3 >= others) || (trailers > 0 && others == 0) {
+				start = i + 1
+			}
+			break
+		}
+
+		switch {
+		case hasTrailerPrefix(line):
+			trailers++
+			continuations = 0
+			recognized = true
+		case trailerSeparator(line) >= 1 && !isSpace(line[0]):
+			trailers++
+			continuations = 0
+		case isSpace(line[0]):
+			continuations++
+		default:
+			others += 1 + continuations
+			continuations = 0
+		}
+	}
+	if start == -1 {
+		return nil
+	}
+
+	var result []trailer
+	last := -1
+	for _, line := range lines[start:] {
+		if isSpace(line[0]) {
+			if last >= 0 {
+				result[last].value += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+
+		sep := trailerSeparator(line)
+		if sep < 1 {
+			last = -1
+			continue
+		}
+
+		result = append(result, trailer{key: strings.TrimSpace(line[:sep]), value: strings.TrimSpace(line[sep+1:])})
+		last = len(result) - 1
+	}
+
+	return result
+}
+
+func hasTrailerPrefix(line string) bool {
+	for _, prefix := range gitTrailerPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trailerSeparator returns where the ':' after a trailer key is, or -1. The
+// key is letters, digits and dashes, and may be followed by spaces.
+func trailerSeparator(line string) int {
+	spaced := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case !spaced && (c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'):
+		case i > 0 && (c == ' ' || c == '\t'):
+			spaced = true
+		case c == ':':
+			return i
+		default:
+			return -1
+		}
+	}
+
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
@@ -0,0 +1,610 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+	"github.com/SteliosSpanos/mygit/pkg/ignore"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/merge"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+	"github.com/SteliosSpanos/mygit/pkg/worktree"
+)
+
+// stashRef holds the newest stash; older ones live in its reflog
+const stashRef = "refs/stash"
+
+type StashOptions struct {
+	// Command is push, list, show, apply, pop, drop, branch or clear
+	Command string
+	// Stash names the entry to use, stash@{0} when empty
+	Stash   string
+	Message string
+	// IncludeUntracked also saves and removes untracked files
+	IncludeUntracked bool
+	// KeepIndex leaves staged changes in place after a push
+	KeepIndex bool
+	// Index restores the staged changes too when applying
+	Index bool
+	// Patch shows the full diff instead of a diffstat
+	Patch  bool
+	Branch string
+}
+
+// stashEntry is a stash commit: its work tree state, with HEAD, the index
+// and optionally the untracked files as parents
+type stashEntry struct {
+	name   string
+	n      int
+	hash   string
+	commit *objects.Commit
+}
+
+func Stash(opts StashOptions) error {
+	gitDir, store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	switch opts.Command {
+	case "", "push":
+		return stashPush(gitDir, store, opts)
+	case "list":
+		return stashList(gitDir)
+	case "show":
+		return stashShow(gitDir, store, opts)
+	case "apply":
+		_, err := stashApply(gitDir, store, opts)
+		return err
+	case "pop":
+		return stashPop(gitDir, store, opts)
+	case "drop":
+		entry, err := readStash(gitDir, store, opts.Stash)
+		if err != nil {
+			return err
+		}
+		return dropStash(gitDir, entry)
+	case "branch":
+		return stashBranch(gitDir, store, opts)
+	case "clear":
+		return refs.DeleteRef(gitDir, stashRef)
+	}
+
+	return fmt.Errorf("unknown stash subcommand: %s", opts.Command)
+}
+
+func stashPush(gitDir string, store storage.ObjectStore, opts StashOptions) error {
+	repoRoot := filepath.Dir(gitDir)
+
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	headHash, err := refs.ReadRef(gitDir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if headHash == "" {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	headCommit, err := loadCommit(store, headHash)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+		return fmt.Errorf("cannot save the current index state: %s needs merge", unmerged[0])
+	}
+
+	indexTree, err := tree.BuildTreeFromIndex(store, idx)
+	if err != nil {
+		return fmt.Errorf("failed to build tree: %w", err)
+	}
+
+	worktreeTree, err := worktreeSnapshot(repoRoot, store, idx)
+	if err != nil {
+		return err
+	}
+
+	var untracked []string
+	if opts.IncludeUntracked {
+		matcher, err := ignore.New(repoRoot, gitDir, cfg)
+		if err != nil {
+			return err
+		}
+		if untracked, err = worktree.Untracked(repoRoot, idx, matcher); err != nil {
+			return err
+		}
+	}
+
+	if indexTree == headCommit.Tree && worktreeTree == indexTree && len(untracked) == 0 {
+		fmt.Println("No local changes to save")
+		return nil
+	}
+
+	author, err := ident.Author(cfg)
+	if err != nil {
+		return err
+	}
+	committer, err := ident.Committer(cfg)
+	if err != nil {
+		return err
+	}
+
+	branch := "(no branch)"
+	if current, err := refs.GetCurrentBranch(gitDir); err == nil {
+		branch = strings.TrimPrefix(current, "refs/heads/")
+	}
+	onto := fmt.Sprintf("%s: %s %s", branch, headHash[:7], subjectLine(headCommit.Message))
+
+	writeCommit := func(treeHash, message string, parents ...string) (string, error) {
+		commit := objects.NewCommit(treeHash, author.String(), message)
+		commit.Timestamp = author.When
+		commit.Committer = committer.String()
+		commit.CommitTime = committer.When
+		commit.Parents = parents
+
+		hash, err := storage.WriteObject(store, commit)
+		if err != nil {
+			return "", fmt.Errorf("failed to write commit: %w", err)
+		}
+		return hash, nil
+	}
+
+	indexCommit, err := writeCommit(indexTree, "index on "+onto, headHash)
+	if err != nil {
+		return err
+	}
+
+	parents := []string{headHash, indexCommit}
+	if len(untracked) > 0 {
+		untrackedTree, err := untrackedSnapshot(repoRoot, store, untracked)
+		if err != nil {
+			return err
+		}
+
+		untrackedCommit, err := writeCommit(untrackedTree, "untracked files on "+onto)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+
+	message := "WIP on " + onto
+	if opts.Message != "" {
+		message = fmt.Sprintf("On %s: %s", branch, opts.Message)
+	}
+
+	stashCommit, err := writeCommit(worktreeTree, message, parents...)
+	if err != nil {
+		return err
+	}
+
+	if err := pushStash(gitDir, store, stashCommit, committer, message); err != nil {
+		return err
+	}
+
+	if err := cleanAfterStash(gitDir, store, idx, headCommit.Tree, indexTree, untracked, opts.KeepIndex); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved working directory and index state %s\n", message)
+	return nil
+}
+
+// worktreeSnapshot writes the tree of tracked files as they are in the
+// work tree, leaving out deleted ones
+func worktreeSnapshot(repoRoot string, store storage.ObjectStore, idx *index.Index) (string, error) {
+	snapshot := index.NewIndex()
+
+	for _, entry := range idx.Entries {
+		full := worktreePath(repoRoot, entry.Path)
+
+		info, err := os.Lstat(full)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %s: %w", entry.Path, err)
+		}
+		if !worktree.Tracked(info) {
+			continue
+		}
+
+		changed, err := worktree.Changed(store.Algorithm(), repoRoot, entry)
+		if err != nil {
+			return "", err
+		}
+		if !changed {
+			snapshot.Entries = append(snapshot.Entries, entry)
+			continue
+		}
+
+		hash, err := worktree.WriteFile(store, full, info)
+		if err != nil {
+			return "", err
+		}
+		snapshot.Entries = append(snapshot.Entries, index.Entry{Mode: worktree.Mode(info), Hash: hash, Path: entry.Path})
+	}
+
+	return tree.BuildTreeFromIndex(store, snapshot)
+}
+
+func untrackedSnapshot(repoRoot string, store storage.ObjectStore, paths []string) (string, error) {
+	snapshot := index.NewIndex()
+
+	for _, path := range paths {
+		full := worktreePath(repoRoot, path)
+
+		info, err := os.Lstat(full)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		hash, err := worktree.WriteFile(store, full, info)
+		if err != nil {
+			return "", err
+		}
+		snapshot.Add(worktree.Mode(info), hash, path)
+	}
+	snapshot.Sort()
+
+	return tree.BuildTreeFromIndex(store, snapshot)
+}
+
+// pushStash points refs/stash at a new stash, logging it so older stashes
+// stay reachable as stash@{n}
+func pushStash(gitDir string, store storage.ObjectStore, hash string, who ident.Ident, message string) error {
+	old, err := refs.ReadRef(gitDir, stashRef)
+	if err != nil {
+		return err
+	}
+	if old == "" {
+		old = store.Algorithm().ZeroHash()
+	}
+
+	if err := refs.WriteRef(gitDir, stashRef, hash); err != nil {
+		return err
+	}
+
+	return refs.AppendReflog(gitDir, stashRef, refs.ReflogEntry{
+		Old:     old,
+		New:     hash,
+		Who:     who.Signature(),
+		Message: message,
+	})
+}
+
+// cleanAfterStash resets the index and work tree to HEAD, or to the
+// stashed index with keepIndex, and removes stashed untracked files
+func cleanAfterStash(gitDir string, store storage.ObjectStore, idx *index.Index, headTree, indexTree string, untracked []string, keepIndex bool) error {
+	repoRoot := filepath.Dir(gitDir)
+
+	head, err := headEntries(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	targetTree := headTree
+	if keepIndex {
+		targetTree = indexTree
+	}
+
+	target, err := tree.Flatten(store, targetTree)
+	if err != nil {
+		return err
+	}
+
+	if err := checkoutHard(repoRoot, store, idx, head, target); err != nil {
+		return err
+	}
+
+	for _, path := range untracked {
+		if err := worktree.Remove(repoRoot, path); err != nil {
+			return err
+		}
+	}
+
+	return writeSortedIndex(gitDir, idx)
+}
+
+func stashList(gitDir string) error {
+	entries, err := refs.ReadReflog(gitDir, stashRef)
+	if err != nil {
+		return err
+	}
+
+	for n := 0; n < len(entries); n++ {
+		fmt.Printf("stash@{%d}: %s\n", n, entries[len(entries)-1-n].Message)
+	}
+
+	return nil
+}
+
+func stashShow(gitDir string, store storage.ObjectStore, opts StashOptions) error {
+	entry, err := readStash(gitDir, store, opts.Stash)
+	if err != nil {
+		return err
+	}
+
+	base, err := commitEntries(store, entry.commit.Parents[0])
+	if err != nil {
+		return err
+	}
+	stashed, err := tree.Flatten(store, entry.commit.Tree)
+	if err != nil {
+		return err
+	}
+
+	changes := tree.Diff(base, stashed)
+	if opts.Patch {
+		return writePatch(os.Stdout, store, changes)
+	}
+
+	return writeStat(os.Stdout, store, changes)
+}
+
+// stashApply merges a stash's changes into the index and work tree. It
+// returns the entry so pop can drop it once it applied cleanly.
+func stashApply(gitDir string, store storage.ObjectStore, opts StashOptions) (*stashEntry, error) {
+	repoRoot := filepath.Dir(gitDir)
+
+	entry, err := readStash(gitDir, store, opts.Stash)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(idx.Unmerged()) > 0 {
+		return nil, fmt.Errorf("cannot apply a stash in the middle of a merge")
+	}
+
+	base, err := commitEntries(store, entry.commit.Parents[0])
+	if err != nil {
+		return nil, err
+	}
+	stashedIndex, err := commitEntries(store, entry.commit.Parents[1])
+	if err != nil {
+		return nil, err
+	}
+	stashedWorktree, err := tree.Flatten(store, entry.commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	var untracked []index.Entry
+	if len(entry.commit.Parents) > 2 {
+		if untracked, err = commitEntries(store, entry.commit.Parents[2]); err != nil {
+			return nil, err
+		}
+		for _, u := range untracked {
+			if exists(worktreePath(repoRoot, u.Path)) {
+				return nil, fmt.Errorf("%s already exists, no checkout", u.Path)
+			}
+		}
+	}
+
+	current := append([]index.Entry(nil), idx.Entries...)
+	labels := merge.Labels{Ours: "Updated upstream", Theirs: "Stashed changes"}
+
+	// The staged changes are merged on their own first, so a conflict
+	// there stops before anything is touched
+	var stagedResult *merge.Result
+	if opts.Index && len(tree.Diff(base, stashedIndex)) > 0 {
+		if stagedResult, err = merge.Trees(store, base, current, stashedIndex, labels); err != nil {
+			return nil, err
+		}
+		if !stagedResult.Clean() {
+			return nil, fmt.Errorf("conflicts in index, try without --index")
+		}
+	}
+
+	result, err := merge.Trees(store, base, current, stashedWorktree, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkoutMerge(repoRoot, store, idx, result); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !result.Clean():
+		idx.Entries = result.Entries
+	case stagedResult != nil:
+		idx.Entries = stagedResult.Entries
+	default:
+		// Without --index only the files the stash added are staged
+		known := entryMap(append(append([]index.Entry(nil), current...), base...))
+		for _, merged := range result.Entries {
+			if _, ok := known[merged.Path]; !ok {
+				idx.Add(merged.Mode, merged.Hash, merged.Path)
+			}
+		}
+	}
+
+	if err := writeSortedIndex(gitDir, idx); err != nil {
+		return nil, err
+	}
+
+	for _, u := range untracked {
+		if err := worktree.Checkout(store, repoRoot, u); err != nil {
+			return nil, err
+		}
+	}
+
+	if !result.Clean() {
+		printConflicts(result)
+		return nil, fmt.Errorf("could not apply %s: %w", entry.name, errMergeConflict)
+	}
+
+	return entry, nil
+}
+
+func stashPop(gitDir string, store storage.ObjectStore, opts StashOptions) error {
+	entry, err := stashApply(gitDir, store, opts)
+	if err != nil {
+		if errors.Is(err, errMergeConflict) {
+			return fmt.Errorf("%w\nThe stash entry is kept in case you need it again", err)
+		}
+		return err
+	}
+
+	return dropStash(gitDir, entry)
+}
+
+func stashBranch(gitDir string, store storage.ObjectStore, opts StashOptions) error {
+	repoRoot := filepath.Dir(gitDir)
+
+	if opts.Branch == "" {
+		return fmt.Errorf("no branch name specified")
+	}
+
+	branchRef := "refs/heads/" + opts.Branch
+	if existing, err := refs.ReadRef(gitDir, branchRef); err != nil || existing != "" {
+		return fmt.Errorf("a branch named '%s' already exists", opts.Branch)
+	}
+
+	entry, err := readStash(gitDir, store, opts.Stash)
+	if err != nil {
+		return err
+	}
+
+	// The branch starts where the stash was made, so it applies cleanly
+	start := entry.commit.Parents[0]
+	target, err := commitEntries(store, start)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	head, err := headEntries(gitDir, store)
+	if err != nil {
+		return err
+	}
+
+	if err := checkoutKeep(repoRoot, store, idx, head, target); err != nil {
+		return err
+	}
+	if err := writeSortedIndex(gitDir, idx); err != nil {
+		return err
+	}
+
+	if err := refs.WriteRef(gitDir, branchRef, start); err != nil {
+		return err
+	}
+	if err := refs.SetHead(gitDir, branchRef); err != nil {
+		return err
+	}
+	fmt.Printf("Switched to a new branch '%s'\n", opts.Branch)
+
+	opts.Index = true
+	if _, err := stashApply(gitDir, store, opts); err != nil {
+		return err
+	}
+
+	return dropStash(gitDir, entry)
+}
+
+// readStash resolves "stash@{n}", "n" or "" (the newest) to a stash entry
+func readStash(gitDir string, store storage.ObjectStore, name string) (*stashEntry, error) {
+	entries, err := refs.ReadReflog(gitDir, stashRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no stash entries found")
+	}
+
+	if name == "" {
+		name = "stash@{0}"
+	}
+
+	spec := name
+	if inner, ok := strings.CutPrefix(name, "stash@{"); ok {
+		spec = strings.TrimSuffix(inner, "}")
+	}
+
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("'%s' is not a stash reference", name)
+	}
+	if n >= len(entries) {
+		return nil, fmt.Errorf("stash@{%d} is not a valid reference", n)
+	}
+
+	hash := entries[len(entries)-1-n].New
+	commit, err := loadCommit(store, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(commit.Parents) < 2 {
+		return nil, fmt.Errorf("'%s' is not a stash-like commit", name)
+	}
+
+	return &stashEntry{name: fmt.Sprintf("stash@{%d}", n), n: n, hash: hash, commit: commit}, nil
+}
+
+// dropStash removes an entry from the stash log, moving refs/stash to the
+// next one when the newest is dropped
+func dropStash(gitDir string, entry *stashEntry) error {
+	entries, err := refs.ReadReflog(gitDir, stashRef)
+	if err != nil {
+		return err
+	}
+
+	pos := len(entries) - 1 - entry.n
+	entries = append(entries[:pos], entries[pos+1:]...)
+
+	if len(entries) == 0 {
+		if err := refs.DeleteRef(gitDir, stashRef); err != nil {
+			return err
+		}
+	} else {
+		if err := refs.WriteReflog(gitDir, stashRef, entries); err != nil {
+			return err
+		}
+		if err := refs.WriteRef(gitDir, stashRef, entries[len(entries)-1].New); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Dropped refs/%s (%s)\n", entry.name, entry.hash)
+	return nil
+}
+
+// commitEntries flattens the tree of a commit
+func commitEntries(store storage.ObjectStore, hash string) ([]index.Entry, error) {
+	commit, err := loadCommit(store, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.Flatten(store, commit.Tree)
+}
+
+func subjectLine(message string) string {
+	return strings.SplitN(message, "\n", 2)[0]
+}
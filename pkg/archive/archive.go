@@ -0,0 +1,78 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Entry is a file, symlink, submodule or directory to add to an archive
+type Entry struct {
+	// Path is where the entry goes in the archive, ending in '/' for
+	// directories and submodules
+	Path string
+	// Mode and Hash are as in the tree the entry comes from
+	Mode string
+	Hash string
+	// Size is the length of the content, 0 for directories and submodules
+	Size int64
+}
+
+func (e Entry) isDir() bool {
+	return e.Mode == "040000" || e.Mode == "160000"
+}
+
+func (e Entry) isSymlink() bool {
+	return e.Mode == "120000"
+}
+
+func (e Entry) isExecutable() bool {
+	return e.Mode == "100755"
+}
+
+// Writer adds entries to an archive one at a time, reading the content of
+// each from r, which is nil for directories
+type Writer interface {
+	WriteEntry(e Entry, r io.Reader) error
+	Close() error
+}
+
+// Formats lists the formats NewWriter understands, in the order Git lists
+// them
+var Formats = []string{"tar", "tgz", "tar.gz", "zip"}
+
+// NewWriter starts an archive in one of Formats. Entries are dated mtime,
+// and commit, when set, is recorded as Git records it: in a pax global
+// header for tar and as the archive comment for zip.
+func NewWriter(format string, w io.Writer, commit string, mtime time.Time) (Writer, error) {
+	switch format {
+	case "tar":
+		return newTarWriter(w, commit, mtime)
+	case "tgz", "tar.gz":
+		gz := gzip.NewWriter(w)
+		tw, err := newTarWriter(gz, commit, mtime)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipWriter{tarWriter: tw, gz: gz}, nil
+	case "zip":
+		return newZipWriter(w, commit, mtime), nil
+	}
+
+	return nil, fmt.Errorf("unknown archive format '%s'", format)
+}
+
+// gzipWriter compresses a tar archive as it is written
+type gzipWriter struct {
+	*tarWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipWriter) Close() error {
+	if err := w.tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return w.gz.Close()
+}
@@ -0,0 +1,246 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	recordSize = 512
+	// blockSize is what Git pads the whole archive to, as tar does
+	blockSize = 20 * recordSize
+	// tarUmask is Git's default tar.umask, applied to the modes stored
+	tarUmask = 0o002
+)
+
+// Offsets of the fields of a ustar header
+const (
+	nameOffset     = 0
+	modeOffset     = 100
+	uidOffset      = 108
+	gidOffset      = 116
+	sizeOffset     = 124
+	mtimeOffset    = 136
+	chksumOffset   = 148
+	typeflagOffset = 156
+	linknameOffset = 157
+	magicOffset    = 257
+	versionOffset  = 263
+	unameOffset    = 265
+	gnameOffset    = 297
+	devmajorOffset = 329
+	devminorOffset = 337
+	prefixOffset   = 345
+
+	nameSize   = 100
+	prefixSize = 155
+)
+
+// tarWriter writes ustar archives byte for byte as "git archive" does,
+// with pax headers for the commit id and for names too long for ustar
+type tarWriter struct {
+	w       *bufio.Writer
+	written int64
+	mtime   int64
+}
+
+func newTarWriter(w io.Writer, commit string, mtime time.Time) (*tarWriter, error) {
+	t := &tarWriter{w: bufio.NewWriter(w), mtime: mtime.Unix()}
+
+	if commit != "" {
+		if err := t.writeHeader("pax_global_header", 0o100666, 'g', "", paxRecord("comment", commit)); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func (t *tarWriter) WriteEntry(e Entry, r io.Reader) error {
+	mode, err := strconv.ParseUint(e.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %s for %s", e.Mode, e.Path)
+	}
+
+	var typeflag byte
+	switch {
+	case e.isDir():
+		typeflag = '5'
+		mode = (mode | 0o777) &^ tarUmask
+	case e.isSymlink():
+		typeflag = '2'
+		mode |= 0o777
+	default:
+		typeflag = '0'
+		if mode&0o100 != 0 {
+			mode |= 0o777
+		} else {
+			mode |= 0o666
+		}
+		mode &^= tarUmask
+	}
+
+	var ext []byte
+	prefix, name := "", e.Path
+	if len(name) > nameSize {
+		if split := pathPrefix(name); split > 0 && len(name)-split-1 <= nameSize {
+			prefix, name = name[:split], name[split+1:]
+		} else {
+			name = e.Hash + ".data"
+			ext = append(ext, paxRecord("path", e.Path)...)
+		}
+	}
+
+	link := ""
+	if e.isSymlink() {
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", e.Path, err)
+		}
+		link = string(target)
+		if len(link) > nameSize {
+			link = fmt.Sprintf("see %s.paxheader", e.Hash)
+			ext = append(ext, paxRecord("linkpath", string(target))...)
+		}
+	}
+
+	if len(ext) > 0 {
+		if err := t.writeHeader(e.Hash+".paxheader", 0o100666, 'x', "", ext); err != nil {
+			return err
+		}
+	}
+
+	size := int64(0)
+	if typeflag == '0' {
+		size = e.Size
+	}
+	if err := t.write(t.header(prefix, name, mode, typeflag, link, size)); err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	n, err := io.Copy(t.w, io.LimitReader(r, size))
+	t.written += n
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+	if n != size {
+		return fmt.Errorf("failed to write %s: expected %d bytes, got %d", e.Path, size, n)
+	}
+
+	return t.pad()
+}
+
+// Close ends the archive with at least two zero records and pads it to a
+// whole block
+func (t *tarWriter) Close() error {
+	tail := blockSize - t.written%blockSize
+	if tail < 2*recordSize {
+		tail += blockSize
+	}
+
+	if err := t.write(make([]byte, tail)); err != nil {
+		return err
+	}
+
+	return t.w.Flush()
+}
+
+// writeHeader writes a pax header of the given type with its records
+func (t *tarWriter) writeHeader(name string, mode uint64, typeflag byte, link string, records []byte) error {
+	if err := t.write(t.header("", name, mode, typeflag, link, int64(len(records)))); err != nil {
+		return err
+	}
+	if err := t.write(records); err != nil {
+		return err
+	}
+
+	return t.pad()
+}
+
+// header fills in a ustar header, with the leading directories of a long
+// name in prefix
+func (t *tarWriter) header(prefix, name string, mode uint64, typeflag byte, link string, size int64) []byte {
+	h := make([]byte, recordSize)
+
+	copy(h[prefixOffset:prefixOffset+prefixSize], prefix)
+	copy(h[nameOffset:nameOffset+nameSize], name)
+
+	copy(h[modeOffset:], fmt.Sprintf("%07o", mode&0o7777))
+	copy(h[uidOffset:], "0000000")
+	copy(h[gidOffset:], "0000000")
+	copy(h[sizeOffset:], fmt.Sprintf("%011o", size))
+	copy(h[mtimeOffset:], fmt.Sprintf("%011o", t.mtime))
+	h[typeflagOffset] = typeflag
+	copy(h[linknameOffset:linknameOffset+nameSize], link)
+	copy(h[magicOffset:], "ustar\x00")
+	copy(h[versionOffset:], "00")
+	copy(h[unameOffset:], "root")
+	copy(h[gnameOffset:], "root")
+	copy(h[devmajorOffset:], "0000000")
+	copy(h[devminorOffset:], "0000000")
+
+	// The checksum is taken with its own field as spaces
+	sum := 0
+	for i, b := range h {
+		if i >= chksumOffset && i < chksumOffset+8 {
+			b = ' '
+		}
+		sum += int(b)
+	}
+	copy(h[chksumOffset:], fmt.Sprintf("%07o", sum))
+
+	return h
+}
+
+func (t *tarWriter) write(data []byte) error {
+	n, err := t.w.Write(data)
+	t.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return nil
+}
+
+// pad fills the archive with zeros up to a whole record
+func (t *tarWriter) pad() error {
+	if rest := t.written % recordSize; rest != 0 {
+		return t.write(make([]byte, recordSize-rest))
+	}
+
+	return nil
+}
+
+// paxRecord formats a pax header record, "<length> <key>=<value>\n", where
+// the length counts its own digits
+func paxRecord(key, value string) []byte {
+	length := len(key) + len(value) + 4
+	for power := 1; length/10 >= power; power *= 10 {
+		length++
+	}
+
+	return fmt.Appendf(nil, "%d %s=%s\n", length, key, value)
+}
+
+// pathPrefix finds the last '/' at which a long path can be split for the
+// ustar prefix field, returning 0 if there is none
+func pathPrefix(name string) int {
+	i := len(name)
+	if i > 1 && name[i-1] == '/' {
+		i--
+	}
+	i = min(i, prefixSize)
+
+	for {
+		i--
+		if i == 0 || name[i] == '/' {
+			return i
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// zipUnix is the "version made by" of entries whose external attributes
+// hold a Unix mode
+const zipUnix = 3 << 8
+
+// msdosDir is the MS-DOS directory attribute, which is all Git records for
+// directories
+const msdosDir = 0x10
+
+// zipWriter writes zip archives with Git's choice of attributes: regular
+// files carry none, while executables and symlinks carry their Unix mode
+type zipWriter struct {
+	zw    *zip.Writer
+	mtime time.Time
+}
+
+func newZipWriter(w io.Writer, commit string, mtime time.Time) *zipWriter {
+	zw := zip.NewWriter(w)
+	if commit != "" {
+		zw.SetComment(commit)
+	}
+
+	return &zipWriter{zw: zw, mtime: mtime.Local()}
+}
+
+func (z *zipWriter) WriteEntry(e Entry, r io.Reader) error {
+	mode, err := strconv.ParseUint(e.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %s for %s", e.Mode, e.Path)
+	}
+
+	fh := &zip.FileHeader{Name: e.Path, Modified: z.mtime, Method: zip.Store}
+	switch {
+	case e.isDir():
+		fh.ExternalAttrs = msdosDir
+	case e.isSymlink():
+		fh.CreatorVersion = zipUnix
+		fh.ExternalAttrs = uint32(mode|0o777) << 16
+	default:
+		if e.isExecutable() {
+			fh.CreatorVersion = zipUnix
+			fh.ExternalAttrs = uint32(mode) << 16
+		}
+		if e.Size > 0 {
+			fh.Method = zip.Deflate
+		}
+	}
+
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", e.Path, err)
+	}
+	if e.isDir() {
+		return nil
+	}
+
+	if _, err := io.Copy(w, io.LimitReader(r, e.Size)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+
+	return nil
+}
+
+func (z *zipWriter) Close() error {
+	if err := z.zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip archive: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package attributes
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/ignore"
+)
+
+// State is how a rule assigns an attribute
+type State int
+
+const (
+	Unspecified State = iota
+	// Set is "attr", Unset is "-attr" and Value is "attr=value"
+	Set
+	Unset
+	Value
+)
+
+// Attr is one attribute as a rule assigns it
+type Attr struct {
+	Name  string
+	State State
+	Value string
+}
+
+// Rule is one line of a gitattributes file: a pattern, matched as in
+// gitignore files, and the attributes of the paths it matches
+type Rule struct {
+	Pattern ignore.Pattern
+	Attrs   []Attr
+}
+
+// Parse reads the rules of a gitattributes file in the directory base, ""
+// for the top. Macro definitions and negated patterns, which Git does not
+// allow in attributes, are skipped.
+func Parse(data, base string) []Rule {
+	var rules []Rule
+
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "[attr]") {
+			continue
+		}
+
+		pattern, ok := ignore.ParsePattern(fields[0])
+		if !ok || pattern.Negate {
+			continue
+		}
+		pattern.Base = base
+
+		rule := Rule{Pattern: pattern}
+		for _, field := range fields[1:] {
+			rule.Attrs = append(rule.Attrs, parseAttr(field))
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func parseAttr(field string) Attr {
+	switch {
+	case strings.HasPrefix(field, "-"):
+		return Attr{Name: field[1:], State: Unset}
+	case strings.HasPrefix(field, "!"):
+		return Attr{Name: field[1:], State: Unspecified}
+	}
+
+	if name, value, ok := strings.Cut(field, "="); ok {
+		return Attr{Name: name, State: Value, Value: value}
+	}
+
+	return Attr{Name: field, State: Set}
+}
+
+// Matcher looks up the attributes of paths. Rules are added in rising
+// order of precedence: core.attributesFile, then the .gitattributes files
+// from the top down, then .git/info/attributes.
+type Matcher struct {
+	rules []Rule
+}
+
+func (m *Matcher) Add(rules ...Rule) {
+	m.rules = append(m.rules, rules...)
+}
+
+// Get returns an attribute of a slash-separated path as the last rule to
+// match it and mention the attribute assigns it
+func (m *Matcher) Get(name string, isDir bool, attr string) Attr {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		rule := &m.rules[i]
+		if !rule.Pattern.Match(name, isDir) {
+			continue
+		}
+
+		for j := len(rule.Attrs) - 1; j >= 0; j-- {
+			if rule.Attrs[j].Name == attr {
+				return rule.Attrs[j]
+			}
+		}
+	}
+
+	return Attr{Name: attr}
+}
+
+// GlobalFile returns core.attributesFile, defaulting to Git's
+// $XDG_CONFIG_HOME/git/attributes
+func GlobalFile(cfg *config.Config) string {
+	if cfg != nil {
+		if value, ok := cfg.Get("core.attributesFile"); ok && value != "" {
+			if strings.HasPrefix(value, "~/") {
+				if home, err := os.UserHomeDir(); err == nil {
+					value = filepath.Join(home, value[2:])
+				}
+			}
+			return value
+		}
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "attributes")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "attributes")
+	}
+
+	return ""
+}
@@ -0,0 +1,315 @@
+package bisect
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/SteliosSpanos/mygit/pkg/history"
+)
+
+// Step is the outcome of one round of bisection
+type Step struct {
+	// Commit is the next commit to test, or the first bad commit when Done
+	Commit string
+	Done   bool
+	// Suspects is set instead of Commit when only skipped commits are left:
+	// the first bad commit is one of them, or the bad commit, which is last
+	Suspects []string
+	// Remaining and Steps estimate the revisions left to test after Commit
+	// and the steps that will take
+	Remaining int
+	Steps     int
+}
+
+// Next picks the commit that best halves the commits reachable from bad
+// but not from any good one, by how many of them each one reaches. It
+// chooses as Git does, so a bisection visits the same commits: the first
+// commit found to reach about half of them, working up from the oldest,
+// or else the one that splits them most evenly. Skipped commits are passed
+// over for the next best.
+func Next(graph *history.Graph, bad string, good, skip []string) (Step, error) {
+	candidates, err := graph.Range([]string{bad}, good)
+	if err != nil {
+		return Step{}, err
+	}
+
+	all := len(candidates)
+	if all <= 1 {
+		return Step{Commit: bad, Done: true}, nil
+	}
+
+	// Range lists the newest first; weights are filled in from the oldest
+	order := make([]string, all)
+	for i, hash := range candidates {
+		order[all-1-i] = hash
+	}
+
+	if len(skip) == 0 {
+		weights, best, err := weigh(graph, order, true)
+		if err != nil {
+			return Step{}, err
+		}
+		if best == "" {
+			best = evenest(order, weights)
+		}
+
+		return Step{Commit: best, Remaining: all - weights[best] - 1, Steps: estimateSteps(all)}, nil
+	}
+
+	weights, _, err := weigh(graph, order, false)
+	if err != nil {
+		return Step{}, err
+	}
+
+	ranked := rank(order, weights)
+
+	skipped := make(map[string]bool, len(skip))
+	for _, hash := range skip {
+		skipped[hash] = true
+	}
+
+	// Like Git, the estimate is for the best commit even when it is skipped
+	step := Step{Remaining: all - weights[ranked[0]] - 1, Steps: estimateSteps(all)}
+	if !skipped[ranked[0]] {
+		step.Commit = ranked[0]
+		return step, nil
+	}
+
+	var left, tried []string
+	for _, hash := range ranked {
+		if skipped[hash] {
+			tried = append(tried, hash)
+		} else {
+			left = append(left, hash)
+		}
+	}
+
+	if step.Commit = skipAway(left, bad); step.Commit == bad {
+		step.Commit = ""
+		step.Suspects = append(tried, bad)
+	}
+
+	return step, nil
+}
+
+// weigh counts, for each commit in order, the commits in order it reaches,
+// itself included. With stopHalfway it returns early with a commit that
+// reaches about half of them.
+func weigh(graph *history.Graph, order []string, stopHalfway bool) (map[string]int, string, error) {
+	all := len(order)
+
+	inRange := make(map[string]bool, all)
+	for _, hash := range order {
+		inRange[hash] = true
+	}
+
+	weights := make(map[string]int, all)
+	var merges []string
+
+	for _, hash := range order {
+		parents, err := rangeParents(graph, inRange, hash)
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch len(parents) {
+		case 0:
+			weights[hash] = 1
+		case 1:
+			// Filled in below from the parent
+		default:
+			merges = append(merges, hash)
+		}
+	}
+
+	// The parents of a merge usually share ancestors, so its weight is
+	// counted in full rather than added up
+	for _, hash := range merges {
+		weight, err := reach(graph, inRange, hash)
+		if err != nil {
+			return nil, "", err
+		}
+		weights[hash] = weight
+
+		if stopHalfway && halfway(weight, all) {
+			return weights, hash, nil
+		}
+	}
+
+	// Any other commit reaches one more than its parent
+	for len(weights) < all {
+		for _, hash := range order {
+			if _, ok := weights[hash]; ok {
+				continue
+			}
+
+			parents, err := rangeParents(graph, inRange, hash)
+			if err != nil {
+				return nil, "", err
+			}
+			parentWeight, ok := weights[parents[0]]
+			if !ok {
+				continue
+			}
+			weights[hash] = parentWeight + 1
+
+			if stopHalfway && halfway(weights[hash], all) {
+				return weights, hash, nil
+			}
+		}
+	}
+
+	return weights, "", nil
+}
+
+// rangeParents returns the parents of a commit that are in range
+func rangeParents(graph *history.Graph, inRange map[string]bool, hash string) ([]string, error) {
+	commit, err := graph.Commit(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []string
+	for _, parent := range commit.Parents {
+		if inRange[parent] {
+			parents = append(parents, parent)
+		}
+	}
+
+	return parents, nil
+}
+
+// reach counts the commits in range reachable from start, start included
+func reach(graph *history.Graph, inRange map[string]bool, start string) (int, error) {
+	seen := map[string]bool{start: true}
+	stack := []string{start}
+
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		parents, err := rangeParents(graph, inRange, hash)
+		if err != nil {
+			return 0, err
+		}
+		for _, parent := range parents {
+			if !seen[parent] {
+				seen[parent] = true
+				stack = append(stack, parent)
+			}
+		}
+	}
+
+	return len(seen), nil
+}
+
+// evenest returns the first commit in order that splits the range most
+// evenly
+func evenest(order []string, weights map[string]int) string {
+	best, bestDistance := order[0], -1
+
+	for _, hash := range order {
+		if d := distance(weights[hash], len(order)); d > bestDistance {
+			best, bestDistance = hash, d
+		}
+	}
+
+	return best
+}
+
+// rank orders commits from the most even split to the least, by hash
+// among equals
+func rank(order []string, weights map[string]int) []string {
+	ranked := append([]string(nil), order...)
+	all := len(order)
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := distance(weights[ranked[i]], all), distance(weights[ranked[j]], all)
+		if a != b {
+			return a > b
+		}
+		return ranked[i] < ranked[j]
+	})
+
+	return ranked
+}
+
+// skipAway picks a commit to test instead of a skipped best one. Rather
+// than the next best, which is likely to be broken in the same way, it
+// jumps a pseudo-random way down the list, more often not far, with the
+// same generator as Git.
+func skipAway(left []string, bad string) string {
+	if len(left) == 0 {
+		return bad
+	}
+
+	count := int32(len(left))
+	prn := int32((uint32(count)*1103515245 + 12345) / 65536 % prnModulo)
+	i := int(count * prn / prnModulo * sqrti(prn) / sqrti(prnModulo))
+
+	switch {
+	case i < 0 || i >= len(left):
+		return left[0]
+	case left[i] != bad:
+		return left[i]
+	case i > 0:
+		return left[i-1]
+	}
+
+	return left[0]
+}
+
+const prnModulo = 32768
+
+// sqrti is Git's integer square root, by Newton's method in float32
+func sqrti(val int32) int32 {
+	if val == 0 {
+		return 0
+	}
+
+	x := float32(val)
+	for {
+		y := (x + float32(val)/x) / 2
+		d := y - x
+		if d < 0 {
+			d = -d
+		}
+		x = y
+		if d < 0.5 {
+			return int32(x)
+		}
+	}
+}
+
+// distance is how many commits a test of a commit with this weight is sure
+// to rule out, whichever way it goes
+func distance(weight, all int) int {
+	return min(weight, all-weight)
+}
+
+// halfway reports whether a commit is close enough to the middle to stop
+// looking: within one, or for long ranges within about 0.1%
+func halfway(weight, all int) bool {
+	diff := 2*weight - all
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff <= 1 || diff < all/1024
+}
+
+// estimateSteps is Git's estimate of the tests needed to bisect all
+// commits: about log2(all), one less when all is just over a power of two
+func estimateSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+
+	n := bits.Len(uint(all)) - 1
+	x := all - 1<<n
+	if 1<<n < 3*x {
+		return n
+	}
+
+	return n - 1
+}
@@ -0,0 +1,595 @@
+package blame
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/SteliosSpanos/mygit/pkg/diff"
+	"github.com/SteliosSpanos/mygit/pkg/history"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+)
+
+// Git's default scores: a block of lines must hold this many alphanumeric
+// characters before it counts as moved within a file or copied from
+// another
+const (
+	moveScore = 20
+	copyScore = 40
+)
+
+type Options struct {
+	// IgnoreWhitespace compares lines with all whitespace removed
+	IgnoreWhitespace bool
+	// Moves finds lines moved or copied within the same file
+	Moves bool
+	// Copies finds lines copied from other files: at 1 from files changed
+	// in the same commit, at 2 or more from any file in the parent
+	Copies int
+	// Ignore lists commits whose changes are passed on to their first
+	// parent where possible, such as formatting commits
+	Ignore map[string]bool
+}
+
+// Line is one line of the blamed file and where it came from
+type Line struct {
+	// Commit introduced the line; it is the zero hash for uncommitted
+	// changes
+	Commit string
+	// Path is the file's path in Commit and OrigLine the line's number
+	// there, counting from 1
+	Path     string
+	OrigLine int
+	Text     string
+	// Previous and PreviousPath name the file in the commit's first parent
+	// that has it, if any
+	Previous     string
+	PreviousPath string
+}
+
+// origin is a file in one commit that lines may be blamed on
+type origin struct {
+	commit string
+	path   string
+	hash   string
+	lines  []string
+	keys   []string
+	when   time.Time
+	// previous is the origin in the first parent that has the file
+	previous *origin
+}
+
+// suspect is the current guess for a final line: a line in an origin
+type suspect struct {
+	origin *origin
+	line   int
+	done   bool
+}
+
+type scoreboard struct {
+	store   storage.ObjectStore
+	graph   *history.Graph
+	opts    Options
+	zero    string
+	head    string
+	origins map[string]*origin
+	lines   []suspect
+}
+
+// File blames the content of path in a commit
+func File(store storage.ObjectStore, graph *history.Graph, commit, path string, opts Options) ([]Line, error) {
+	sb := newScoreboard(store, graph, opts)
+
+	start, err := sb.origin(commit, path)
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		return nil, fmt.Errorf("no such path %s in %s", path, commit)
+	}
+
+	return sb.run(start)
+}
+
+// Worktree blames data, a work tree file at path, as uncommitted changes
+// on top of head, which is "" before the first commit
+func Worktree(store storage.ObjectStore, graph *history.Graph, head, path string, data []byte, opts Options) ([]Line, error) {
+	sb := newScoreboard(store, graph, opts)
+	sb.head = head
+
+	start := sb.newOrigin(sb.zero, path, "", string(data))
+	start.when = time.Now()
+	sb.origins[sb.zero+" "+path] = start
+
+	return sb.run(start)
+}
+
+func newScoreboard(store storage.ObjectStore, graph *history.Graph, opts Options) *scoreboard {
+	return &scoreboard{
+		store:   store,
+		graph:   graph,
+		opts:    opts,
+		zero:    store.Algorithm().ZeroHash(),
+		origins: make(map[string]*origin),
+	}
+}
+
+func (sb *scoreboard) newOrigin(commit, path, hash, data string) *origin {
+	o := &origin{commit: commit, path: path, hash: hash, lines: diff.SplitLines(data)}
+
+	o.keys = o.lines
+	if sb.opts.IgnoreWhitespace {
+		o.keys = make([]string, len(o.lines))
+		for i, line := range o.lines {
+			o.keys[i] = strings.Map(dropSpace, line)
+		}
+	}
+
+	return o
+}
+
+func dropSpace(r rune) rune {
+	if unicode.IsSpace(r) {
+		return -1
+	}
+
+	return r
+}
+
+// origin loads a file from a commit, or returns nil if the commit does not
+// have it
+func (sb *scoreboard) origin(commit, path string) (*origin, error) {
+	key := commit + " " + path
+	if o, ok := sb.origins[key]; ok {
+		return o, nil
+	}
+
+	c, err := sb.graph.Commit(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := tree.Lookup(sb.store, c.Tree, path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.IsDir() {
+		return nil, nil
+	}
+
+	return sb.blobOrigin(c, commit, path, entry.Hash)
+}
+
+func (sb *scoreboard) blobOrigin(c *objects.Commit, commit, path, hash string) (*origin, error) {
+	key := commit + " " + path
+	if o, ok := sb.origins[key]; ok {
+		return o, nil
+	}
+
+	objType, data, err := storage.ReadObject(sb.store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	if objType != objects.BlobObject {
+		return nil, fmt.Errorf("object %s is a %s, not a blob", hash, objType)
+	}
+
+	o := sb.newOrigin(commit, path, hash, string(data))
+	o.when = c.CommitTime
+	sb.origins[key] = o
+
+	return o, nil
+}
+
+// run starts with every line blamed on start and passes blame back
+// through history, newest commit first, until no line can move further
+func (sb *scoreboard) run(start *origin) ([]Line, error) {
+	sb.lines = make([]suspect, len(start.lines))
+	for i := range sb.lines {
+		sb.lines[i] = suspect{origin: start, line: i}
+	}
+
+	for {
+		o := sb.next()
+		if o == nil {
+			break
+		}
+
+		if err := sb.pass(o); err != nil {
+			return nil, err
+		}
+
+		for i := range sb.lines {
+			if sb.lines[i].origin == o {
+				sb.lines[i].done = true
+			}
+		}
+	}
+
+	result := make([]Line, len(sb.lines))
+	for i, s := range sb.lines {
+		result[i] = Line{Commit: s.origin.commit, Path: s.origin.path, OrigLine: s.line + 1, Text: start.lines[i]}
+		if prev := s.origin.previous; prev != nil {
+			result[i].Previous, result[i].PreviousPath = prev.commit, prev.path
+		}
+	}
+
+	return result, nil
+}
+
+// next picks the most recently committed origin that still has lines to
+// pass on; uncommitted changes come first
+func (sb *scoreboard) next() *origin {
+	var best *origin
+
+	for _, s := range sb.lines {
+		if s.done {
+			continue
+		}
+		o := s.origin
+		if o.commit == sb.zero {
+			return o
+		}
+		if best == nil || o.when.After(best.when) || (o.when.Equal(best.when) && o.commit < best.commit) {
+			best = o
+		}
+	}
+
+	return best
+}
+
+func (sb *scoreboard) parents(o *origin) ([]string, error) {
+	if o.commit == sb.zero {
+		if sb.head == "" {
+			return nil, nil
+		}
+		return []string{sb.head}, nil
+	}
+
+	c, err := sb.graph.Commit(o.commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Parents, nil
+}
+
+// pass hands the lines blamed on o to its parents wherever they were
+// already there
+func (sb *scoreboard) pass(o *origin) error {
+	parentHashes, err := sb.parents(o)
+	if err != nil {
+		return err
+	}
+
+	parents := make([]*origin, len(parentHashes))
+	for i, hash := range parentHashes {
+		p, err := sb.origin(hash, o.path)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			if p, err = sb.findRename(o, hash); err != nil {
+				return err
+			}
+		}
+		parents[i] = p
+
+		if p != nil && o.previous == nil {
+			o.previous = p
+		}
+
+		// An unchanged file hands everything to that parent
+		if p != nil && p.hash == o.hash && o.hash != "" {
+			sb.moveAll(o, p)
+			return nil
+		}
+	}
+
+	for _, p := range parents {
+		if p != nil {
+			sb.passDiff(o, p)
+		}
+	}
+
+	if sb.opts.Moves {
+		for _, p := range parents {
+			if p != nil {
+				sb.passBlocks(o, []*origin{p}, moveScore)
+			}
+		}
+	}
+
+	if sb.opts.Copies > 0 {
+		for _, hash := range parentHashes {
+			if err := sb.passCopies(o, hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sb.opts.Ignore[o.commit] && len(parents) > 0 && parents[0] != nil {
+		sb.passIgnored(o, parents[0])
+	}
+
+	return nil
+}
+
+func (sb *scoreboard) moveAll(o, p *origin) {
+	for i := range sb.lines {
+		if sb.lines[i].origin == o {
+			sb.lines[i].origin = p
+		}
+	}
+}
+
+// pending maps each line of o that is still blamed on it to the final
+// lines that hold it
+func (sb *scoreboard) pending(o *origin) map[int][]int {
+	lines := make(map[int][]int)
+	for i, s := range sb.lines {
+		if s.origin == o && !s.done {
+			lines[s.line] = append(lines[s.line], i)
+		}
+	}
+
+	return lines
+}
+
+func (sb *scoreboard) assign(finals []int, p *origin, line int) {
+	for _, i := range finals {
+		sb.lines[i].origin = p
+		sb.lines[i].line = line
+	}
+}
+
+// passDiff hands p the lines a diff shows were unchanged between them
+func (sb *scoreboard) passDiff(o, p *origin) {
+	pending := sb.pending(o)
+	if len(pending) == 0 {
+		return
+	}
+
+	pi, oi := 0, 0
+	for _, line := range diff.Lines(p.keys, o.keys) {
+		switch line.Op {
+		case diff.Equal:
+			if finals, ok := pending[oi]; ok {
+				sb.assign(finals, p, pi)
+			}
+			pi++
+			oi++
+		case diff.Delete:
+			pi++
+		case diff.Insert:
+			oi++
+		}
+	}
+}
+
+// passIgnored hands the lines an ignored commit changed to the lines they
+// replaced in the parent, pairing them up in order within each hunk
+func (sb *scoreboard) passIgnored(o, p *origin) {
+	pending := sb.pending(o)
+	if len(pending) == 0 {
+		return
+	}
+
+	pi, oi := 0, 0
+	var deleted []int
+	for _, line := range diff.Lines(p.keys, o.keys) {
+		switch line.Op {
+		case diff.Equal:
+			deleted = deleted[:0]
+			pi++
+			oi++
+		case diff.Delete:
+			deleted = append(deleted, pi)
+			pi++
+		case diff.Insert:
+			if len(deleted) > 0 {
+				if finals, ok := pending[oi]; ok {
+					sb.assign(finals, p, deleted[0])
+				}
+				deleted = deleted[1:]
+			}
+			oi++
+		}
+	}
+}
+
+// passBlocks looks for runs of o's remaining lines anywhere in the
+// candidates and hands each run to the candidate with the best match,
+// if it scores at least minScore
+func (sb *scoreboard) passBlocks(o *origin, candidates []*origin, minScore int) {
+	pending := sb.pending(o)
+	if len(pending) == 0 {
+		return
+	}
+
+	positions := make([]map[string][]int, len(candidates))
+	for c, p := range candidates {
+		positions[c] = make(map[string][]int)
+		for j, key := range p.keys {
+			positions[c][key] = append(positions[c][key], j)
+		}
+	}
+
+	for i := 0; i < len(o.keys); {
+		if _, ok := pending[i]; !ok {
+			i++
+			continue
+		}
+
+		var best *origin
+		bestStart, bestLen, bestScore := 0, 0, 0
+		for c, p := range candidates {
+			for _, j := range positions[c][o.keys[i]] {
+				n := 0
+				for i+n < len(o.keys) && j+n < len(p.keys) && o.keys[i+n] == p.keys[j+n] {
+					if _, ok := pending[i+n]; !ok {
+						break
+					}
+					n++
+				}
+				if matched := score(o.lines[i : i+n]); matched > bestScore {
+					best, bestStart, bestLen, bestScore = p, j, n, matched
+				}
+			}
+		}
+
+		if best == nil || bestScore < minScore {
+			i++
+			continue
+		}
+
+		for n := 0; n < bestLen; n++ {
+			sb.assign(pending[i+n], best, bestStart+n)
+		}
+		i += bestLen
+	}
+}
+
+// score counts the alphanumeric characters in lines
+func score(lines []string) int {
+	n := 0
+	for _, line := range lines {
+		for _, r := range line {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// passCopies looks for o's remaining lines in other files of a parent:
+// those the commit changed, or with Copies above 1 all of them
+func (sb *scoreboard) passCopies(o *origin, parent string) error {
+	if len(sb.pending(o)) == 0 {
+		return nil
+	}
+
+	parentCommit, err := sb.graph.Commit(parent)
+	if err != nil {
+		return err
+	}
+
+	entries, err := tree.Flatten(sb.store, parentCommit.Tree)
+	if err != nil {
+		return err
+	}
+
+	var changed map[string]bool
+	if sb.opts.Copies < 2 {
+		if changed, err = sb.changedPaths(o, entries); err != nil {
+			return err
+		}
+	}
+
+	var candidates []*origin
+	for _, entry := range entries {
+		if entry.Path == o.path || (changed != nil && !changed[entry.Path]) {
+			continue
+		}
+
+		p, err := sb.blobOrigin(parentCommit, parent, entry.Path, entry.Hash)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, p)
+	}
+
+	sb.passBlocks(o, candidates, copyScore)
+	return nil
+}
+
+// changedPaths lists the paths whose content differs between the parent
+// entries and o's commit; for uncommitted changes every path counts
+func (sb *scoreboard) changedPaths(o *origin, parentEntries []index.Entry) (map[string]bool, error) {
+	changed := make(map[string]bool)
+	if o.commit == sb.zero {
+		for _, entry := range parentEntries {
+			changed[entry.Path] = true
+		}
+		return changed, nil
+	}
+
+	c, err := sb.graph.Commit(o.commit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := tree.Flatten(sb.store, c.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range tree.Diff(parentEntries, entries) {
+		changed[change.Path] = true
+	}
+
+	return changed, nil
+}
+
+// findRename looks for the file o was renamed from: a file of the parent
+// that o's commit no longer has, sharing at least half of its lines
+func (sb *scoreboard) findRename(o *origin, parent string) (*origin, error) {
+	parentCommit, err := sb.graph.Commit(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	parentEntries, err := tree.Flatten(sb.store, parentCommit.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]bool)
+	if o.commit != sb.zero {
+		c, err := sb.graph.Commit(o.commit)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := tree.Flatten(sb.store, c.Tree)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			current[entry.Path] = true
+		}
+	}
+
+	var best *origin
+	bestCommon := 0
+	for _, entry := range parentEntries {
+		if current[entry.Path] {
+			continue
+		}
+
+		p, err := sb.blobOrigin(parentCommit, parent, entry.Path, entry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if p.hash == o.hash {
+			return p, nil
+		}
+
+		common := 0
+		for _, line := range diff.Lines(p.keys, o.keys) {
+			if line.Op == diff.Equal {
+				common++
+			}
+		}
+		if common > bestCommon && 2*common >= len(o.lines) {
+			best, bestCommon = p, common
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,437 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Scope string
+
+const (
+	ScopeSystem Scope = "system"
+	ScopeGlobal Scope = "global"
+	ScopeLocal  Scope = "local"
+)
+
+const maxIncludeDepth = 10
+
+// Entry is a single key = value setting. Section and Key are lower case;
+// Subsection keeps its case.
+type Entry struct {
+	Section    string
+	Subsection string
+	Key        string
+	Value      string
+	Implicit   bool // Written without "=", meaning boolean true
+	Origin     string
+	Scope      Scope
+}
+
+// Name returns the entry's canonical "section.subsection.key" name
+func (e Entry) Name() string {
+	if e.Subsection != "" {
+		return e.Section + "." + e.Subsection + "." + e.Key
+	}
+
+	return e.Section + "." + e.Key
+}
+
+// Config is the merged view of one or more config files. Entries are kept
+// in the order they were read, so later entries take precedence.
+type Config struct {
+	Entries []Entry
+}
+
+// Load reads the system, global and local config files in that order, so
+// that more specific files override broader ones. gitDir may be empty when
+// there is no repository.
+func Load(gitDir string) (*Config, error) {
+	cfg := &Config{}
+
+	for _, scope := range []Scope{ScopeSystem, ScopeGlobal, ScopeLocal} {
+		if scope == ScopeLocal && gitDir == "" {
+			continue
+		}
+
+		paths, err := readPaths(scope, gitDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			if err := cfg.readFile(path, scope, gitDir, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// LoadFile reads a single config file and the files it includes
+func LoadFile(path string, scope Scope, gitDir string) (*Config, error) {
+	cfg := &Config{}
+	if err := cfg.readFile(path, scope, gitDir, 0); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Path returns the file that settings for scope are written to
+func Path(scope Scope, gitDir string) (string, error) {
+	switch scope {
+	case ScopeSystem:
+		if path := os.Getenv("GIT_CONFIG_SYSTEM"); path != "" {
+			return path, nil
+		}
+		return "/etc/gitconfig", nil
+	case ScopeGlobal:
+		if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+			return path, nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		return filepath.Join(home, ".gitconfig"), nil
+	case ScopeLocal:
+		if gitDir == "" {
+			return "", fmt.Errorf("not a git repository")
+		}
+		return filepath.Join(gitDir, "config"), nil
+	default:
+		return "", fmt.Errorf("unknown config scope: %s", scope)
+	}
+}
+
+// readPaths lists the files read for a scope. The global scope reads the
+// XDG config file before ~/.gitconfig.
+func readPaths(scope Scope, gitDir string) ([]string, error) {
+	switch scope {
+	case ScopeSystem:
+		if os.Getenv("GIT_CONFIG_NOSYSTEM") != "" {
+			return nil, nil
+		}
+	case ScopeGlobal:
+		if os.Getenv("GIT_CONFIG_GLOBAL") != "" {
+			break
+		}
+
+		var paths []string
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if home, err := os.UserHomeDir(); err == nil {
+			if xdg == "" {
+				xdg = filepath.Join(home, ".config")
+			}
+			paths = append(paths, filepath.Join(xdg, "git", "config"), filepath.Join(home, ".gitconfig"))
+		}
+		return paths, nil
+	}
+
+	path, err := Path(scope, gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{path}, nil
+}
+
+func (c *Config) readFile(path string, scope Scope, gitDir string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("exceeded maximum include depth at %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	tokens, err := parse(path, data)
+	if err != nil {
+		return err
+	}
+
+	for _, tok := range tokens {
+		if tok.kind != keyToken {
+			continue
+		}
+
+		c.Entries = append(c.Entries, Entry{
+			Section:    tok.section,
+			Subsection: tok.subsection,
+			Key:        tok.key,
+			Value:      tok.value,
+			Implicit:   tok.implicit,
+			Origin:     path,
+			Scope:      scope,
+		})
+
+		// Included files are read as if they appeared at this point
+		if tok.key != "path" || tok.implicit {
+			continue
+		}
+		if tok.section == "include" && tok.subsection == "" ||
+			tok.section == "includeif" && includeApplies(tok.subsection, gitDir) {
+			if err := c.readFile(includePath(path, tok.value), scope, gitDir, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// includePath resolves an include path relative to the including file
+func includePath(from, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+// includeApplies evaluates an includeIf condition: "gitdir:<pattern>",
+// "gitdir/i:<pattern>" or "onbranch:<pattern>"
+func includeApplies(condition, gitDir string) bool {
+	if gitDir == "" {
+		return false
+	}
+
+	kind, pattern, ok := strings.Cut(condition, ":")
+	if !ok {
+		return false
+	}
+
+	switch kind {
+	case "gitdir", "gitdir/i":
+		dir, err := filepath.Abs(gitDir)
+		if err != nil {
+			return false
+		}
+		dir = filepath.ToSlash(dir)
+
+		if strings.HasPrefix(pattern, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				pattern = filepath.ToSlash(home) + pattern[1:]
+			}
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		if kind == "gitdir/i" {
+			pattern = strings.ToLower(pattern)
+			dir = strings.ToLower(dir)
+		}
+
+		return matchPath(pattern, dir) || matchPath(pattern, dir+"/")
+	case "onbranch":
+		head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+		if err != nil {
+			return false
+		}
+		branch, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: refs/heads/")
+		if !ok {
+			return false
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return matchPath(pattern, branch)
+	}
+
+	return false
+}
+
+// matchPath matches a slash separated path against a glob where "**"
+// matches any number of directories
+func matchPath(pattern, path string) bool {
+	if pattern == "" {
+		return path == ""
+	}
+
+	if rest, ok := strings.CutPrefix(pattern, "**"); ok {
+		rest = strings.TrimPrefix(rest, "/")
+		for i := 0; i <= len(path); i++ {
+			if (i == 0 || path[i-1] == '/' || rest == "") && matchPath(rest, path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	patSeg, patRest, patMore := strings.Cut(pattern, "/")
+	pathSeg, pathRest, pathMore := strings.Cut(path, "/")
+
+	if ok, err := filepath.Match(patSeg, pathSeg); err != nil || !ok {
+		return false
+	}
+	if !patMore {
+		return !pathMore
+	}
+	if !pathMore {
+		return patRest == "**" || patRest == ""
+	}
+
+	return matchPath(patRest, pathRest)
+}
+
+// splitName splits "section.subsection.key" into its parts, lowering the
+// case of the section and key
+func splitName(name string) (string, string, string, error) {
+	first := strings.Index(name, ".")
+	last := strings.LastIndex(name, ".")
+	if first <= 0 || last == len(name)-1 {
+		return "", "", "", fmt.Errorf("key does not contain a section: %s", name)
+	}
+
+	section := strings.ToLower(name[:first])
+	key := strings.ToLower(name[last+1:])
+
+	subsection := ""
+	if first != last {
+		subsection = name[first+1 : last]
+	}
+
+	if !isAlpha(key[0]) {
+		return "", "", "", fmt.Errorf("invalid key: %s", name)
+	}
+	for i := 0; i < len(key); i++ {
+		if !isAlnum(key[i]) && key[i] != '-' {
+			return "", "", "", fmt.Errorf("invalid key: %s", name)
+		}
+	}
+
+	return section, subsection, key, nil
+}
+
+func (e Entry) matches(section, subsection, key string) bool {
+	return e.Section == section && e.Subsection == subsection && e.Key == key
+}
+
+// Get returns the last value set for name
+func (c *Config) Get(name string) (string, bool) {
+	section, subsection, key, err := splitName(name)
+	if err != nil {
+		return "", false
+	}
+
+	for i := len(c.Entries) - 1; i >= 0; i-- {
+		if c.Entries[i].matches(section, subsection, key) {
+			return c.Entries[i].Value, true
+		}
+	}
+
+	return "", false
+}
+
+// GetAll returns every value set for a multi-valued name, in order
+func (c *Config) GetAll(name string) []string {
+	var values []string
+	for _, entry := range c.Lookup(name) {
+		values = append(values, entry.Value)
+	}
+
+	return values
+}
+
+// Lookup returns every entry for name, in order
+func (c *Config) Lookup(name string) []Entry {
+	section, subsection, key, err := splitName(name)
+	if err != nil {
+		return nil
+	}
+
+	var entries []Entry
+	for _, entry := range c.Entries {
+		if entry.matches(section, subsection, key) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Bool returns name interpreted as a boolean, or def when it is not set
+func (c *Config) Bool(name string, def bool) (bool, error) {
+	section, subsection, key, err := splitName(name)
+	if err != nil {
+		return false, err
+	}
+
+	for i := len(c.Entries) - 1; i >= 0; i-- {
+		entry := c.Entries[i]
+		if !entry.matches(section, subsection, key) {
+			continue
+		}
+		if entry.Implicit {
+			return true, nil
+		}
+		return ParseBool(entry.Value)
+	}
+
+	return def, nil
+}
+
+// Int returns name interpreted as an integer, or def when it is not set
+func (c *Config) Int(name string, def int64) (int64, error) {
+	value, ok := c.Get(name)
+	if !ok {
+		return def, nil
+	}
+
+	return ParseInt(value)
+}
+
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("bad boolean config value '%s'", value)
+}
+
+// ParseInt parses an integer with an optional k, m or g suffix
+func ParseInt(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	multiplier := int64(1)
+
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s'", value)
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	sectionToken tokenKind = iota
+	keyToken
+)
+
+// token is a section header or a key line, along with the span of the file
+// it occupies so that it can be edited in place
+type token struct {
+	kind       tokenKind
+	section    string
+	subsection string
+	key        string
+	value      string
+	implicit   bool // Key without "=", which is a boolean true
+	start, end int
+	line       int
+}
+
+type parser struct {
+	data []byte
+	pos  int
+	line int
+	path string
+}
+
+func parse(path string, data []byte) ([]token, error) {
+	p := &parser{data: data, line: 1, path: path}
+	var tokens []token
+	section, subsection := "", ""
+
+	for p.pos < len(p.data) {
+		start := p.pos
+		p.skipSpace()
+
+		if p.pos >= len(p.data) {
+			break
+		}
+
+		c := p.data[p.pos]
+		switch {
+		case c == '\n':
+			p.pos++
+			p.line++
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			sec, sub, err := p.parseHeader()
+			if err != nil {
+				return nil, err
+			}
+			section, subsection = sec, sub
+
+			// A header alone on its line owns the whole line
+			rest := p.pos
+			p.skipSpace()
+			if p.pos >= len(p.data) || p.data[p.pos] == '\n' || p.data[p.pos] == '#' || p.data[p.pos] == ';' {
+				p.skipLine()
+			} else {
+				p.pos = rest
+			}
+
+			tokens = append(tokens, token{
+				kind:       sectionToken,
+				section:    section,
+				subsection: subsection,
+				start:      start,
+				end:        p.pos,
+				line:       p.line,
+			})
+		case isAlpha(c):
+			if section == "" {
+				return nil, p.errorf("key outside of a section")
+			}
+
+			line := p.line
+			key, value, implicit, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{
+				kind:       keyToken,
+				section:    section,
+				subsection: subsection,
+				key:        key,
+				value:      value,
+				implicit:   implicit,
+				start:      start,
+				end:        p.pos,
+				line:       line,
+			})
+		default:
+			return nil, p.errorf("unexpected character %q", c)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("bad config line %d in %s: %s", p.line, p.path, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t' || p.data[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// skipLine moves past the end of the current line, including its newline
+func (p *parser) skipLine() {
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		p.pos++
+	}
+	if p.pos < len(p.data) {
+		p.pos++
+		p.line++
+	}
+}
+
+// parseHeader reads "[section]", "[section "subsection"]" or the legacy
+// "[section.subsection]" form
+func (p *parser) parseHeader() (string, string, error) {
+	p.pos++
+	start := p.pos
+
+	for p.pos < len(p.data) && (isAlnum(p.data[p.pos]) || p.data[p.pos] == '-' || p.data[p.pos] == '.') {
+		p.pos++
+	}
+	name := string(p.data[start:p.pos])
+	if name == "" {
+		return "", "", p.errorf("empty section name")
+	}
+
+	if p.pos < len(p.data) && p.data[p.pos] == ']' {
+		p.pos++
+		if section, sub, ok := strings.Cut(name, "."); ok {
+			return strings.ToLower(section), strings.ToLower(sub), nil
+		}
+		return strings.ToLower(name), "", nil
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+		return "", "", p.errorf("invalid section header")
+	}
+	p.pos++
+
+	var sub strings.Builder
+	for {
+		if p.pos >= len(p.data) || p.data[p.pos] == '\n' {
+			return "", "", p.errorf("unterminated subsection name")
+		}
+
+		c := p.data[p.pos]
+		p.pos++
+
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			if p.pos >= len(p.data) || p.data[p.pos] == '\n' {
+				return "", "", p.errorf("unterminated subsection name")
+			}
+			c = p.data[p.pos]
+			p.pos++
+		}
+		sub.WriteByte(c)
+	}
+
+	if p.pos >= len(p.data) || p.data[p.pos] != ']' {
+		return "", "", p.errorf("invalid section header")
+	}
+	p.pos++
+
+	return strings.ToLower(name), sub.String(), nil
+}
+
+func (p *parser) parseKey() (string, string, bool, error) {
+	start := p.pos
+	for p.pos < len(p.data) && (isAlnum(p.data[p.pos]) || p.data[p.pos] == '-') {
+		p.pos++
+	}
+	key := strings.ToLower(string(p.data[start:p.pos]))
+
+	p.skipSpace()
+	if p.pos >= len(p.data) || p.data[p.pos] == '\n' || p.data[p.pos] == '#' || p.data[p.pos] == ';' {
+		p.skipLine()
+		return key, "", true, nil
+	}
+
+	if p.data[p.pos] != '=' {
+		return "", "", false, p.errorf("expected '=' after %s", key)
+	}
+	p.pos++
+	p.skipSpace()
+
+	value, err := p.parseValue()
+	if err != nil {
+		return "", "", false, err
+	}
+
+	return key, value, false, nil
+}
+
+// parseValue reads a value up to the end of its line, handling quotes,
+// escapes, comments and backslash line continuations. Unquoted whitespace
+// at the end of the value is dropped.
+func (p *parser) parseValue() (string, error) {
+	var value strings.Builder
+	var pending strings.Builder
+	quoted := false
+
+	for {
+		if p.pos >= len(p.data) {
+			if quoted {
+				return "", p.errorf("unterminated quoted value")
+			}
+			return value.String(), nil
+		}
+
+		c := p.data[p.pos]
+		p.pos++
+
+		switch {
+		case c == '\n':
+			p.line++
+			if quoted {
+				return "", p.errorf("unterminated quoted value")
+			}
+			return value.String(), nil
+		case !quoted && (c == ' ' || c == '\t' || c == '\r'):
+			if value.Len() > 0 {
+				pending.WriteByte(c)
+			}
+			continue
+		case !quoted && (c == '#' || c == ';'):
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+
+		value.WriteString(pending.String())
+		pending.Reset()
+
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if p.pos >= len(p.data) {
+				return "", p.errorf("unfinished escape")
+			}
+			e := p.data[p.pos]
+			p.pos++
+
+			switch e {
+			case '\n':
+				p.line++
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			case '\\', '"':
+				value.WriteByte(e)
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isAlnum(c byte) bool {
+	return isAlpha(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Set gives name a single value in the config file at path, replacing the
+// existing value or adding a new one. It refuses to touch multi-valued keys.
+func Set(path, name, value string) error {
+	return edit(path, name, func(data []byte, tokens []token, matches []token) ([]byte, error) {
+		if len(matches) > 1 {
+			return nil, fmt.Errorf("cannot overwrite multiple values of %s", name)
+		}
+
+		line := formatLine(name, value)
+		if len(matches) == 1 {
+			return splice(data, matches[0].start, matches[0].end, line), nil
+		}
+
+		return insert(data, tokens, name, line)
+	})
+}
+
+// Add appends another value for name, keeping the existing ones
+func Add(path, name, value string) error {
+	return edit(path, name, func(data []byte, tokens []token, matches []token) ([]byte, error) {
+		return insert(data, tokens, name, formatLine(name, value))
+	})
+}
+
+// Unset removes name from the config file at path. Unless all is set it
+// refuses to remove a key that has several values.
+func Unset(path, name string, all bool) error {
+	return edit(path, name, func(data []byte, tokens []token, matches []token) ([]byte, error) {
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("key %s is not set", name)
+		}
+		if len(matches) > 1 && !all {
+			return nil, fmt.Errorf("key %s has multiple values", name)
+		}
+
+		for i := len(matches) - 1; i >= 0; i-- {
+			data = splice(data, matches[i].start, matches[i].end, "")
+		}
+
+		return data, nil
+	})
+}
+
+type editFunc func(data []byte, tokens []token, matches []token) ([]byte, error)
+
+func edit(path, name string, fn editFunc) error {
+	section, subsection, key, err := splitName(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	tokens, err := parse(path, data)
+	if err != nil {
+		return err
+	}
+
+	var matches []token
+	for _, tok := range tokens {
+		if tok.kind == keyToken && tok.section == section && tok.subsection == subsection && tok.key == key {
+			matches = append(matches, tok)
+		}
+	}
+
+	updated, err := fn(data, tokens, matches)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	tmp := path + ".lock"
+	if err := os.WriteFile(tmp, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write config %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write config %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// insert adds line at the end of the last matching section, creating the
+// section at the end of the file if there is none
+func insert(data []byte, tokens []token, name, line string) ([]byte, error) {
+	section, subsection, _, err := splitName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	at := -1
+	inSection := false
+	for _, tok := range tokens {
+		if tok.kind == sectionToken {
+			inSection = tok.section == section && tok.subsection == subsection
+		}
+		if inSection {
+			at = tok.end
+		}
+	}
+
+	if at >= 0 {
+		// The last line of a file may lack its newline
+		if at > 0 && data[at-1] != '\n' {
+			line = "\n" + line
+		}
+		return splice(data, at, at, line), nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+
+	if subsection != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+		buf.WriteString(fmt.Sprintf("[%s \"%s\"]\n", section, escaped))
+	} else {
+		buf.WriteString(fmt.Sprintf("[%s]\n", section))
+	}
+	buf.WriteString(line)
+
+	return buf.Bytes(), nil
+}
+
+func splice(data []byte, start, end int, text string) []byte {
+	out := make([]byte, 0, len(data)-(end-start)+len(text))
+	out = append(out, data[:start]...)
+	out = append(out, text...)
+
+	return append(out, data[end:]...)
+}
+
+func formatLine(name, value string) string {
+	key := name[strings.LastIndex(name, ".")+1:]
+	return fmt.Sprintf("\t%s = %s\n", key, quote(value))
+}
+
+// quote escapes a value so that it reads back unchanged
+func quote(value string) string {
+	needsQuotes := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+
+	escaped := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\t", `\t`,
+		"\b", `\b`,
+	).Replace(value)
+
+	if needsQuotes {
+		return `"` + escaped + `"`
+	}
+
+	return escaped
+}
@@ -0,0 +1,237 @@
+package describe
+
+import (
+	"sort"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/history"
+)
+
+// Ref is a ref that can name commits, peeled to the commit it points at
+type Ref struct {
+	// Name is how the ref is shown
+	Name   string
+	Commit string
+	// Tag is set for refs under refs/tags, and Annotated for those that
+	// point at a tag object
+	Tag       bool
+	Annotated bool
+	// Date is the tagger date of an annotated tag, otherwise the commit date
+	Date time.Time
+}
+
+type Options struct {
+	// Tags counts lightweight tags too, not just annotated ones
+	Tags bool
+	// Candidates is how many tags to consider before settling on the
+	// closest, 10 when 0
+	Candidates int
+}
+
+// Result names a commit by a tag and how many commits it is ahead of it.
+// Name is empty when no tag could describe the commit, and Unannotated is
+// then set if there were lightweight tags that would have.
+type Result struct {
+	Name        string
+	Depth       int
+	Exact       bool
+	Unannotated bool
+}
+
+// candidate is a tag found while walking back from the commit. flag marks
+// the commits it reaches, and depth counts those it does not.
+type candidate struct {
+	ref   Ref
+	depth int
+	flag  uint
+	order int
+}
+
+// walk is the state of a search back through history, newest commits
+// first, with the commits each candidate reaches marked as they are found
+type walk struct {
+	graph *history.Graph
+	list  []string
+	seen  map[string]bool
+	flags map[string]uint
+}
+
+// Describe finds the tag nearest to commit, the way Git does: it walks
+// back from commit in date order collecting the first tags it meets, then
+// picks the one with the fewest commits ahead of it that it does not reach
+func Describe(graph *history.Graph, refs []Ref, commit string, opts Options) (Result, error) {
+	names := make(map[string]Ref)
+	for _, ref := range refs {
+		if current, ok := names[ref.Commit]; !ok || replaces(ref, current) {
+			names[ref.Commit] = ref
+		}
+	}
+
+	if ref, ok := names[commit]; ok && (opts.Tags || ref.Annotated) {
+		return Result{Name: ref.Name, Exact: true}, nil
+	}
+
+	maxCandidates := opts.Candidates
+	if maxCandidates <= 0 {
+		maxCandidates = 10
+	}
+
+	w := &walk{graph: graph, list: []string{commit}, seen: map[string]bool{commit: true}, flags: make(map[string]uint)}
+
+	var matches []*candidate
+	var result Result
+	annotated, seenCommits := 0, 0
+	gaveUpOn := ""
+
+	for len(w.list) > 0 {
+		c := w.pop()
+		seenCommits++
+
+		if ref, ok := names[c]; ok {
+			if !opts.Tags && !ref.Annotated {
+				result.Unannotated = true
+			} else if len(matches) < maxCandidates {
+				m := &candidate{ref: ref, depth: seenCommits - 1, flag: 1 << (len(matches) + 1), order: len(matches) + 1}
+				matches = append(matches, m)
+				w.flags[c] |= m.flag
+				if ref.Annotated {
+					annotated++
+				}
+			} else {
+				gaveUpOn = c
+				break
+			}
+		}
+
+		for _, m := range matches {
+			if w.flags[c]&m.flag == 0 {
+				m.depth++
+			}
+		}
+
+		// Every path left leads to a tag already found
+		if annotated > 0 && len(w.list) == 0 {
+			break
+		}
+
+		if err := w.queueParents(c); err != nil {
+			return result, err
+		}
+	}
+
+	if len(matches) == 0 {
+		return result, nil
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].depth != matches[j].depth {
+			return matches[i].depth < matches[j].depth
+		}
+		return matches[i].order < matches[j].order
+	})
+
+	best := matches[0]
+	if gaveUpOn != "" {
+		if err := w.insert(gaveUpOn); err != nil {
+			return result, err
+		}
+	}
+	if err := w.finishDepth(best); err != nil {
+		return result, err
+	}
+
+	return Result{Name: best.ref.Name, Depth: best.depth}, nil
+}
+
+// replaces reports whether ref should name its commit instead of current:
+// annotated tags win over lightweight ones, and newer over older
+func replaces(ref, current Ref) bool {
+	if ref.Annotated != current.Annotated {
+		return ref.Annotated
+	}
+
+	return ref.Annotated && current.Date.Before(ref.Date)
+}
+
+// finishDepth carries on walking until every commit left is reached by
+// best, counting those that are not
+func (w *walk) finishDepth(best *candidate) error {
+	for len(w.list) > 0 {
+		c := w.pop()
+
+		if w.flags[c]&best.flag != 0 {
+			covered := true
+			for _, other := range w.list {
+				if w.flags[other]&best.flag == 0 {
+					covered = false
+					break
+				}
+			}
+			if covered {
+				return nil
+			}
+		} else {
+			best.depth++
+		}
+
+		if err := w.queueParents(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *walk) pop() string {
+	c := w.list[0]
+	w.list = w.list[1:]
+	return c
+}
+
+// queueParents queues the parents of c not seen before and passes on the
+// marks of the candidates that reach c
+func (w *walk) queueParents(c string) error {
+	commit, err := w.graph.Commit(c)
+	if err != nil {
+		return err
+	}
+
+	for _, parent := range commit.Parents {
+		if !w.seen[parent] {
+			w.seen[parent] = true
+			if err := w.insert(parent); err != nil {
+				return err
+			}
+		}
+		w.flags[parent] |= w.flags[c]
+	}
+
+	return nil
+}
+
+// insert adds a commit to the list after those committed at the same time
+// or later
+func (w *walk) insert(hash string) error {
+	commit, err := w.graph.Commit(hash)
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for i < len(w.list) {
+		other, err := w.graph.Commit(w.list[i])
+		if err != nil {
+			return err
+		}
+		if other.CommitTime.Before(commit.CommitTime) {
+			break
+		}
+		i++
+	}
+
+	w.list = append(w.list, "")
+	copy(w.list[i+1:], w.list[i:])
+	w.list[i] = hash
+
+	return nil
+}
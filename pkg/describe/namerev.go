@@ -0,0 +1,158 @@
+package describe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/history"
+)
+
+// mergeWeight is what following a merge to a parent other than the first
+// costs, so names through first parents are preferred
+const mergeWeight = 65535
+
+// revName is the best name found so far for a commit: generation steps of
+// first parents back from tip
+type revName struct {
+	tip        string
+	date       time.Time
+	generation int
+	distance   int
+	fromTag    bool
+}
+
+func (n *revName) String() string {
+	if n.generation == 0 {
+		return n.tip
+	}
+
+	return fmt.Sprintf("%s~%d", strings.TrimSuffix(n.tip, "^0"), n.generation)
+}
+
+// NameRevs gives every commit reachable from refs a name relative to one of
+// them, like "main~2" or "tags/v1.0~1^2". As in Git, names from tags win,
+// the oldest tag first, and otherwise the name with the fewest steps.
+// Annotated tags name their own commit with a "^0" suffix.
+func NameRevs(graph *history.Graph, refs []Ref) (map[string]string, error) {
+	tips := append([]Ref(nil), refs...)
+	sort.SliceStable(tips, func(i, j int) bool {
+		if tips[i].Tag != tips[j].Tag {
+			return tips[i].Tag
+		}
+		return tips[i].Date.Before(tips[j].Date)
+	})
+
+	names := make(map[string]*revName)
+	for _, tip := range tips {
+		if err := nameFrom(graph, names, tip); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string]string, len(names))
+	for hash, name := range names {
+		result[hash] = name.String()
+	}
+
+	return result, nil
+}
+
+// nameFrom names the commits reachable from a ref that it names better,
+// depth first with first parents ahead of the others
+func nameFrom(graph *history.Graph, names map[string]*revName, ref Ref) error {
+	start := update(names, ref.Commit, ref, 0, 0)
+	if start == nil {
+		return nil
+	}
+
+	start.tip = ref.Name
+	if ref.Annotated {
+		start.tip += "^0"
+	}
+
+	stack := []string{ref.Commit}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		commit, err := graph.Commit(hash)
+		if err != nil {
+			return err
+		}
+
+		name := names[hash]
+		var queued []string
+
+		for i, parent := range commit.Parents {
+			generation, distance := name.generation+1, name.distance+1
+			if i > 0 {
+				generation, distance = 0, name.distance+mergeWeight
+			}
+
+			parentName := update(names, parent, ref, generation, distance)
+			if parentName == nil {
+				continue
+			}
+
+			parentName.tip = name.tip
+			if i > 0 {
+				parentName.tip = mergeParentName(name, i+1)
+			}
+			queued = append(queued, parent)
+		}
+
+		for i := len(queued) - 1; i >= 0; i-- {
+			stack = append(stack, queued[i])
+		}
+	}
+
+	return nil
+}
+
+// update records a name for hash if it is better than the one it has,
+// returning it for the caller to fill in the tip, or nil otherwise
+func update(names map[string]*revName, hash string, ref Ref, generation, distance int) *revName {
+	name, ok := names[hash]
+	if !ok {
+		name = &revName{}
+		names[hash] = name
+	} else if !better(name, ref, distance) {
+		return nil
+	}
+
+	name.date = ref.Date
+	name.generation = generation
+	name.distance = distance
+	name.fromTag = ref.Tag
+
+	return name
+}
+
+// better reports whether a name from ref, distance steps away, beats the
+// current one
+func better(current *revName, ref Ref, distance int) bool {
+	// Between tags the older wins, even if it is further away
+	if current.fromTag && ref.Tag {
+		return current.date.After(ref.Date) || (current.date.Equal(ref.Date) && current.distance > distance)
+	}
+	if current.fromTag != ref.Tag {
+		return ref.Tag
+	}
+	if current.distance != distance {
+		return current.distance > distance
+	}
+
+	return current.date.After(ref.Date)
+}
+
+// mergeParentName names parent n of a merge commit
+func mergeParentName(name *revName, n int) string {
+	tip := strings.TrimSuffix(name.tip, "^0")
+	if name.generation > 0 {
+		return fmt.Sprintf("%s~%d^%d", tip, name.generation, n)
+	}
+
+	return fmt.Sprintf("%s^%d", tip, n)
+}
@@ -0,0 +1,230 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around changes
+const DefaultContext = 3
+
+// Hunk is a run of changes with its surrounding context. OldPos and NewPos
+// are the 0-based indexes of the first old and new line it covers.
+type Hunk struct {
+	OldPos int
+	NewPos int
+	Lines  []Line
+}
+
+// Hunks groups an edit script into hunks, merging changes whose context
+// would overlap
+func Hunks(script []Line, context int) []Hunk {
+	var hunks []Hunk
+	oldPos, newPos := 0, 0
+	lastChange := -1
+
+	for i, line := range script {
+		if line.Op != Equal {
+			start := i - context
+			if start < 0 {
+				start = 0
+			}
+
+			if lastChange >= 0 && start <= lastChange+context+1 {
+				h := &hunks[len(hunks)-1]
+				h.Lines = append(h.Lines, script[lastChange+1:i+1]...)
+			} else {
+				if lastChange >= 0 {
+					closeHunk(&hunks[len(hunks)-1], script, lastChange, context)
+				}
+
+				o, n := oldPos, newPos
+				for j := i - 1; j >= start; j-- {
+					o--
+					n--
+				}
+				hunks = append(hunks, Hunk{OldPos: o, NewPos: n, Lines: append([]Line(nil), script[start:i+1]...)})
+			}
+			lastChange = i
+		}
+
+		if line.Op != Insert {
+			oldPos++
+		}
+		if line.Op != Delete {
+			newPos++
+		}
+	}
+
+	if lastChange >= 0 {
+		closeHunk(&hunks[len(hunks)-1], script, lastChange, context)
+	}
+
+	return hunks
+}
+
+func closeHunk(h *Hunk, script []Line, lastChange, context int) {
+	end := lastChange + 1 + context
+	if end > len(script) {
+		end = len(script)
+	}
+
+	h.Lines = append(h.Lines, script[lastChange+1:end]...)
+}
+
+// Counts returns how many old and new lines the hunk spans
+func (h Hunk) Counts() (oldLines, newLines int) {
+	for _, line := range h.Lines {
+		if line.Op != Insert {
+			oldLines++
+		}
+		if line.Op != Delete {
+			newLines++
+		}
+	}
+
+	return oldLines, newLines
+}
+
+// Header renders the "@@ -a,b +c,d @@" line
+func (h Hunk) Header() string {
+	oldLines, newLines := h.Counts()
+	return fmt.Sprintf("@@ -%s +%s @@", rangeSpec(h.OldPos, oldLines), rangeSpec(h.NewPos, newLines))
+}
+
+// rangeSpec numbers lines from 1, with an empty range naming the line
+// before it, and leaves out a count of one like diff does
+func rangeSpec(pos, count int) string {
+	start := pos + 1
+	if count == 0 {
+		start = pos
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// Write prints the hunk in unified format
+func (h Hunk) Write(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, h.Header()); err != nil {
+		return err
+	}
+
+	for _, line := range h.Lines {
+		prefix := " "
+		switch line.Op {
+		case Delete:
+			prefix = "-"
+		case Insert:
+			prefix = "+"
+		}
+
+		text := line.Text
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n\\ No newline at end of file\n"
+		}
+		if _, err := io.WriteString(w, prefix+text); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Split breaks a hunk at the unchanged lines between its groups of
+// changes. Each piece keeps the context around it, so neighbouring pieces
+// share the lines between them. A hunk with one group is returned as is.
+func (h Hunk) Split() []Hunk {
+	type group struct{ start, end int }
+	var groups []group
+
+	for i := 0; i < len(h.Lines); i++ {
+		if h.Lines[i].Op == Equal {
+			continue
+		}
+
+		g := group{start: i}
+		for i < len(h.Lines) && h.Lines[i].Op != Equal {
+			i++
+		}
+		g.end = i
+		groups = append(groups, g)
+	}
+
+	if len(groups) < 2 {
+		return []Hunk{h}
+	}
+
+	pieces := make([]Hunk, len(groups))
+	for n := range groups {
+		from, to := 0, len(h.Lines)
+		if n > 0 {
+			from = groups[n-1].end
+		}
+		if n < len(groups)-1 {
+			to = groups[n+1].start
+		}
+
+		skipped := Hunk{Lines: h.Lines[:from]}
+		oldSkipped, newSkipped := skipped.Counts()
+
+		pieces[n] = Hunk{
+			OldPos: h.OldPos + oldSkipped,
+			NewPos: h.NewPos + newSkipped,
+			Lines:  append([]Line(nil), h.Lines[from:to]...),
+		}
+	}
+
+	return pieces
+}
+
+// Apply builds new content from old by applying hunks, which must be in
+// order. Only the old side of each hunk's position is trusted, so hunks
+// may have been edited or split with shared context.
+func Apply(old []string, hunks []Hunk) ([]string, error) {
+	var out []string
+	pos := 0
+
+	for _, h := range hunks {
+		if h.OldPos > len(old) {
+			return nil, fmt.Errorf("hunk at line %d is past the end of the file", h.OldPos+1)
+		}
+
+		// Context shared with the previous hunk has already been copied
+		skip := 0
+		if h.OldPos < pos {
+			skip = pos - h.OldPos
+		} else {
+			out = append(out, old[pos:h.OldPos]...)
+			pos = h.OldPos
+		}
+
+		for _, line := range h.Lines {
+			if line.Op == Insert {
+				out = append(out, line.Text)
+				continue
+			}
+
+			if skip > 0 {
+				if line.Op != Equal {
+					return nil, fmt.Errorf("overlapping hunks at line %d", pos+1)
+				}
+				skip--
+				continue
+			}
+
+			if pos >= len(old) || old[pos] != line.Text {
+				return nil, fmt.Errorf("hunk does not apply at line %d", pos+1)
+			}
+			if line.Op == Equal {
+				out = append(out, line.Text)
+			}
+			pos++
+		}
+	}
+
+	return append(out, old[pos:]...), nil
+}
@@ -0,0 +1,182 @@
+package diff
+
+import "strings"
+
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is one line of an edit script. Text keeps its trailing newline, so
+// a last line without one is preserved exactly.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// SplitLines cuts data into lines, each keeping its "\n"
+func SplitLines(data string) []string {
+	if data == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(data, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Lines returns the shortest edit script turning a into b, computed with
+// Myers' O(ND) algorithm
+func Lines(a, b []string) []Line {
+	x, y := intern(a, b)
+
+	// Common prefix and suffix never need the search
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var script []Line
+	for i := 0; i < prefix; i++ {
+		script = append(script, Line{Equal, a[i]})
+	}
+
+	ops := myers(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])
+	i, j := prefix, prefix
+	for _, op := range ops {
+		switch op {
+		case Equal:
+			script = append(script, Line{Equal, a[i]})
+			i++
+			j++
+		case Delete:
+			script = append(script, Line{Delete, a[i]})
+			i++
+		case Insert:
+			script = append(script, Line{Insert, b[j]})
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		script = append(script, Line{Equal, a[i]})
+	}
+
+	return script
+}
+
+// intern maps each distinct line to an integer so comparisons are cheap
+func intern(a, b []string) ([]int, []int) {
+	ids := make(map[string]int)
+	convert := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	return convert(a), convert(b)
+}
+
+// myers finds the furthest-reaching paths for each edit distance, keeping
+// the diagonals -d..d of every round's frontier so the path can be walked
+// back in O(D²) space
+func myers(a, b []int) []Op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+
+	return nil
+}
+
+func backtrack(trace [][]int, n, m int) []Op {
+	var ops []Op
+	x, y := n, m
+
+	for d := len(trace) - 1; d > 0; d-- {
+		// trace[d] holds diagonals -d..d, so diagonal k is at k+d
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[k-1+d] < v[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := v[prevK+d]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, Equal)
+			x--
+			y--
+		}
+
+		if x == prevX {
+			ops = append(ops, Insert)
+		} else {
+			ops = append(ops, Delete)
+		}
+		x, y = prevX, prevY
+	}
+
+	for x > 0 && y > 0 {
+		ops = append(ops, Equal)
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
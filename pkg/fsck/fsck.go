@@ -0,0 +1,243 @@
+package fsck
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/reachable"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// Issue describes a single problem found in the object database
+type Issue struct {
+	Hash    string
+	Type    objects.ObjectType
+	Message string
+}
+
+type Report struct {
+	Checked  int
+	Corrupt  []Issue // Objects or packs that failed hash, header or syntax checks
+	Missing  []Issue // Objects referenced by something but not present
+	Dangling []Issue // Objects present but referenced by nothing
+}
+
+type link struct {
+	hash    string
+	objType objects.ObjectType
+	from    string // Where a root link comes from
+}
+
+// Check verifies every object in the repository and its connectivity from
+// HEAD, refs, reflogs and the index
+func Check(gitDir string, store *storage.DiskStore) (*Report, error) {
+	report := &Report{}
+
+	all, err := listObjects(store, report)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]objects.ObjectType)
+	links := make(map[string][]link)
+	referenced := make(map[string]bool)
+
+	for _, hash := range all {
+		report.Checked++
+
+		objType, data, err := storage.VerifyObject(store, hash)
+		if err != nil {
+			report.Corrupt = append(report.Corrupt, Issue{Hash: hash, Type: objType, Message: err.Error()})
+			continue
+		}
+		types[hash] = objType
+
+		if err := CheckSyntax(store.Algorithm(), objType, data); err != nil {
+			report.Corrupt = append(report.Corrupt, Issue{Hash: hash, Type: objType, Message: err.Error()})
+			continue
+		}
+
+		out, err := objectLinks(store.Algorithm(), objType, data)
+		if err != nil {
+			report.Corrupt = append(report.Corrupt, Issue{Hash: hash, Type: objType, Message: err.Error()})
+			continue
+		}
+
+		links[hash] = out
+		for _, l := range out {
+			referenced[l.hash] = true
+		}
+	}
+
+	roots, err := rootLinks(gitDir, store)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[string]bool)
+	checkLink := func(from string, l link) {
+		actual, ok := types[l.hash]
+		if !ok {
+			if !missing[l.hash] && !isCorrupt(report, l.hash) {
+				missing[l.hash] = true
+				report.Missing = append(report.Missing, Issue{Hash: l.hash, Type: l.objType, Message: "referenced by " + from})
+			}
+			return
+		}
+		if l.objType != "" && actual != l.objType {
+			report.Corrupt = append(report.Corrupt, Issue{
+				Hash:    l.hash,
+				Type:    actual,
+				Message: fmt.Sprintf("%s expects a %s", from, l.objType),
+			})
+		}
+	}
+
+	for _, root := range roots {
+		checkLink(root.from, root)
+	}
+	for _, hash := range all {
+		for _, l := range links[hash] {
+			checkLink(fmt.Sprintf("%s %s", types[hash], hash), l)
+		}
+	}
+
+	live := make(map[string]bool)
+	stack := make([]string, 0, len(roots))
+	for _, root := range roots {
+		stack = append(stack, root.hash)
+	}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if live[hash] {
+			continue
+		}
+		live[hash] = true
+		for _, l := range links[hash] {
+			stack = append(stack, l.hash)
+		}
+	}
+
+	for _, hash := range all {
+		if _, ok := types[hash]; ok && !live[hash] && !referenced[hash] {
+			report.Dangling = append(report.Dangling, Issue{Hash: hash, Type: types[hash]})
+		}
+	}
+
+	return report, nil
+}
+
+// listObjects returns the sorted hashes of every loose and packed object,
+// verifying each pack on the way
+func listObjects(store *storage.DiskStore, report *Report) ([]string, error) {
+	packs, err := store.Packs.Packs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packs {
+		if err := p.Verify(); err != nil {
+			report.Corrupt = append(report.Corrupt, Issue{Hash: filepath.Base(p.Path), Type: "pack", Message: err.Error()})
+		}
+	}
+
+	var all []string
+	err = store.Iterate(func(hash string) error {
+		all = append(all, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(all)
+	return all, nil
+}
+
+func objectLinks(algo objects.HashAlgorithm, objType objects.ObjectType, data []byte) ([]link, error) {
+	switch objType {
+	case objects.CommitObject:
+		commit := objects.NewCommit("", "", "")
+		if err := commit.Deserialize(data); err != nil {
+			return nil, err
+		}
+
+		out := []link{{hash: commit.Tree, objType: objects.TreeObject}}
+		for _, parent := range commit.Parents {
+			out = append(out, link{hash: parent, objType: objects.CommitObject})
+		}
+		return out, nil
+	case objects.TreeObject:
+		tree := objects.NewTree()
+		tree.Algorithm = algo
+		if err := tree.Deserialize(data); err != nil {
+			return nil, err
+		}
+
+		var out []link
+		for _, entry := range tree.Entries {
+			switch {
+			case entry.IsDir():
+				out = append(out, link{hash: entry.Hash, objType: objects.TreeObject})
+			case entry.Mode != "160000":
+				out = append(out, link{hash: entry.Hash, objType: objects.BlobObject})
+			}
+		}
+		return out, nil
+	case objects.TagObject:
+		tag := &objects.Tag{}
+		if err := tag.Deserialize(data); err != nil {
+			return nil, err
+		}
+		return []link{{hash: tag.Object, objType: tag.ObjType}}, nil
+	}
+
+	return nil, nil
+}
+
+func rootLinks(gitDir string, store storage.ObjectStore) ([]link, error) {
+	var roots []link
+
+	refRoots, err := reachable.Roots(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range refRoots {
+		roots = append(roots, link{hash: hash, from: "refs"})
+	}
+
+	logRoots, err := reachable.ReflogRoots(gitDir, store)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range logRoots {
+		roots = append(roots, link{hash: hash, from: "reflog"})
+	}
+
+	indexObjects, err := reachable.IndexObjects(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	for hash := range indexObjects {
+		roots = append(roots, link{hash: hash, objType: objects.BlobObject, from: "index"})
+	}
+
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].hash < roots[j].hash
+	})
+
+	return roots, nil
+}
+
+func isCorrupt(report *Report, hash string) bool {
+	for _, issue := range report.Corrupt {
+		if issue.Hash == hash {
+			return true
+		}
+	}
+
+	return false
+}
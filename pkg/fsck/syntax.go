@@ -0,0 +1,160 @@
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+var identPattern = regexp.MustCompile(`^[^<>\n]*<[^<>\n]*> \d+ [+-]\d{4}$`)
+
+// CheckSyntax validates the serialized content of an object of the given
+// type, returning the first problem found
+func CheckSyntax(algo objects.HashAlgorithm, objType objects.ObjectType, data []byte) error {
+	switch objType {
+	case objects.BlobObject:
+		return nil
+	case objects.TreeObject:
+		return checkTree(algo, data)
+	case objects.CommitObject:
+		return checkCommit(algo, data)
+	case objects.TagObject:
+		return checkTag(algo, data)
+	default:
+		return fmt.Errorf("unknown object type %q", objType)
+	}
+}
+
+func checkTree(algo objects.HashAlgorithm, data []byte) error {
+	var prev *objects.TreeEntry
+	names := make(map[string]bool)
+
+	for len(data) > 0 {
+		nullIdx := bytes.IndexByte(data, 0)
+		if nullIdx == -1 {
+			return fmt.Errorf("entry without null byte")
+		}
+
+		mode, name, ok := strings.Cut(string(data[:nullIdx]), " ")
+		if !ok {
+			return fmt.Errorf("entry without mode")
+		}
+
+		switch mode {
+		case "100644", "100755", "120000", "40000", "160000":
+		case "040000":
+			return fmt.Errorf("zero-padded file mode for %q", name)
+		default:
+			return fmt.Errorf("invalid mode %s for %q", mode, name)
+		}
+
+		if name == "" || name == "." || name == ".." || name == ".git" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid entry name %q", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate entry %q", name)
+		}
+		names[name] = true
+
+		if len(data) < nullIdx+1+algo.Size() {
+			return fmt.Errorf("truncated hash for %q", name)
+		}
+
+		entry := objects.TreeEntry{Mode: mode, Name: name}
+		if prev != nil && prev.SortKey() >= entry.SortKey() {
+			return fmt.Errorf("entries not sorted: %q before %q", prev.Name, name)
+		}
+		prev = &entry
+
+		data = data[nullIdx+1+algo.Size():]
+	}
+
+	return nil
+}
+
+func checkCommit(algo objects.HashAlgorithm, data []byte) error {
+	header, _, ok := strings.Cut(string(data), "\n\n")
+	if !ok {
+		return fmt.Errorf("missing blank line before message")
+	}
+
+	lines := strings.Split(header, "\n")
+	pos := 0
+
+	next := func(key string) (string, bool) {
+		if pos < len(lines) && strings.HasPrefix(lines[pos], key+" ") {
+			pos++
+			return strings.TrimPrefix(lines[pos-1], key+" "), true
+		}
+		return "", false
+	}
+
+	tree, ok := next("tree")
+	if !ok {
+		return fmt.Errorf("missing tree line")
+	}
+	if !algo.IsValid(tree) {
+		return fmt.Errorf("invalid tree hash %q", tree)
+	}
+
+	for {
+		parent, ok := next("parent")
+		if !ok {
+			break
+		}
+		if !algo.IsValid(parent) {
+			return fmt.Errorf("invalid parent hash %q", parent)
+		}
+	}
+
+	for _, key := range []string{"author", "committer"} {
+		ident, ok := next(key)
+		if !ok {
+			return fmt.Errorf("missing %s line", key)
+		}
+		if !identPattern.MatchString(ident) {
+			return fmt.Errorf("invalid %s line %q", key, ident)
+		}
+	}
+
+	return nil
+}
+
+func checkTag(algo objects.HashAlgorithm, data []byte) error {
+	header, _, ok := strings.Cut(string(data), "\n\n")
+	if !ok {
+		return fmt.Errorf("missing blank line before message")
+	}
+
+	lines := strings.Split(header, "\n")
+	if len(lines) < 3 {
+		return fmt.Errorf("truncated tag header")
+	}
+
+	object, ok := strings.CutPrefix(lines[0], "object ")
+	if !ok || !algo.IsValid(object) {
+		return fmt.Errorf("invalid object line %q", lines[0])
+	}
+
+	switch objects.ObjectType(strings.TrimPrefix(lines[1], "type ")) {
+	case objects.BlobObject, objects.TreeObject, objects.CommitObject, objects.TagObject:
+	default:
+		return fmt.Errorf("invalid type line %q", lines[1])
+	}
+
+	if name, ok := strings.CutPrefix(lines[2], "tag "); !ok || name == "" {
+		return fmt.Errorf("invalid tag line %q", lines[2])
+	}
+
+	if len(lines) > 3 {
+		tagger, ok := strings.CutPrefix(lines[3], "tagger ")
+		if ok && !identPattern.MatchString(tagger) {
+			return fmt.Errorf("invalid tagger line %q", tagger)
+		}
+	}
+
+	return nil
+}
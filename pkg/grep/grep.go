@@ -0,0 +1,180 @@
+package grep
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mode is how patterns are read
+type Mode int
+
+const (
+	// Basic is POSIX basic regular expressions, Git's default
+	Basic Mode = iota
+	Extended
+	// Fixed matches patterns as literal strings
+	Fixed
+	// Perl accepts the Perl syntax Go's regexp package understands, such as
+	// \d and non-greedy repetition
+	Perl
+)
+
+type Options struct {
+	Mode       Mode
+	IgnoreCase bool
+	// WordRegexp only counts matches that start and end at word boundaries
+	WordRegexp bool
+}
+
+// Matcher reports whether lines match any of a set of patterns
+type Matcher struct {
+	patterns []*regexp.Regexp
+	word     bool
+}
+
+func Compile(patterns []string, opts Options) (*Matcher, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no pattern given")
+	}
+
+	m := &Matcher{word: opts.WordRegexp}
+	for _, pattern := range patterns {
+		expr := pattern
+		switch opts.Mode {
+		case Basic:
+			expr = basicToExtended(pattern)
+		case Fixed:
+			expr = regexp.QuoteMeta(pattern)
+		}
+		if opts.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m, nil
+}
+
+// Match reports whether line, without its newline, matches
+func (m *Matcher) Match(line string) bool {
+	for _, re := range m.patterns {
+		if !m.word {
+			if re.MatchString(line) {
+				return true
+			}
+			continue
+		}
+
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			if wordBoundary(line, loc[0], loc[1]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// wordBoundary reports whether line[start:end] is not joined to word
+// characters on either side, as Git's -w requires
+func wordBoundary(line string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(line[:start])
+		if isWord(r) {
+			return false
+		}
+	}
+	if end < len(line) {
+		r, _ := utf8.DecodeRuneInString(line[end:])
+		if isWord(r) {
+			return false
+		}
+	}
+
+	return start != end
+}
+
+func isWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// basicToExtended rewrites a POSIX basic regular expression in the
+// extended syntax: \( \) \{ \} \| \+ \? become operators, their bare forms
+// literals, and \< \> word boundaries
+func basicToExtended(pattern string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			next := pattern[i]
+			switch {
+			case strings.IndexByte("(){}|+?", next) >= 0:
+				b.WriteByte(next)
+			case next == '<' || next == '>':
+				b.WriteString(`\b`)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(next)
+			}
+		case strings.IndexByte("(){}|+?", c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '*' && leadingStar(pattern, i):
+			b.WriteString(`\*`)
+		case c == '[':
+			// Bracket expressions carry over unchanged
+			end := bracketEnd(pattern, i)
+			b.WriteString(pattern[i:end])
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// leadingStar reports whether the '*' at i has nothing to repeat, in which
+// case basic expressions treat it as a literal
+func leadingStar(pattern string, i int) bool {
+	before := pattern[:i]
+	return before == "" || before == "^" || strings.HasSuffix(before, `\(`) || strings.HasSuffix(before, `\|`)
+}
+
+// bracketEnd returns the index just past the bracket expression at start
+func bracketEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+
+	for i < len(pattern) {
+		if pattern[i] == '[' && i+1 < len(pattern) && strings.IndexByte(":.=", pattern[i+1]) >= 0 {
+			if end := strings.Index(pattern[i+2:], string(pattern[i+1])+"]"); end >= 0 {
+				i += end + 4
+				continue
+			}
+		}
+		if pattern[i] == ']' {
+			return i + 1
+		}
+		i++
+	}
+
+	return len(pattern)
+}
@@ -0,0 +1,202 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// Graph walks the commit graph, loading each commit once
+type Graph struct {
+	store   storage.ObjectStore
+	commits map[string]*objects.Commit
+}
+
+func New(store storage.ObjectStore) *Graph {
+	return &Graph{store: store, commits: make(map[string]*objects.Commit)}
+}
+
+// Commit loads a commit, or returns it from the cache
+func (g *Graph) Commit(hash string) (*objects.Commit, error) {
+	if commit, ok := g.commits[hash]; ok {
+		return commit, nil
+	}
+
+	obj, err := storage.LoadObject(g.store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load commit %s: %w", hash, err)
+	}
+
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", hash, obj.Type())
+	}
+
+	g.commits[hash] = commit
+	return commit, nil
+}
+
+// Ancestors returns every commit reachable from starts, starts included
+func (g *Graph) Ancestors(starts ...string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	stack := append([]string(nil), starts...)
+
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		commit, err := g.Commit(hash)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, commit.Parents...)
+	}
+
+	return seen, nil
+}
+
+// IsAncestor reports whether ancestor can be reached from descendant
+func (g *Graph) IsAncestor(ancestor, descendant string) (bool, error) {
+	ancestors, err := g.Ancestors(descendant)
+	if err != nil {
+		return false, err
+	}
+
+	return ancestors[ancestor], nil
+}
+
+// Range lists the commits reachable from include but not from exclude,
+// like "git rev-list include ^exclude": newest first, and never a parent
+// before its children
+func (g *Graph) Range(include, exclude []string) ([]string, error) {
+	excluded, err := g.Ancestors(exclude...)
+	if err != nil {
+		return nil, err
+	}
+
+	included, err := g.Ancestors(include...)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool)
+	for hash := range included {
+		if !excluded[hash] {
+			selected[hash] = true
+		}
+	}
+
+	return g.topoSort(selected)
+}
+
+// topoSort orders commits so that children come before their parents,
+// taking the newest commit date first among those that are ready
+func (g *Graph) topoSort(selected map[string]bool) ([]string, error) {
+	children := make(map[string]int, len(selected))
+	for hash := range selected {
+		commit, err := g.Commit(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range commit.Parents {
+			if selected[parent] {
+				children[parent]++
+			}
+		}
+	}
+
+	var ready []string
+	for hash := range selected {
+		if children[hash] == 0 {
+			ready = append(ready, hash)
+		}
+	}
+
+	order := make([]string, 0, len(selected))
+	for len(ready) > 0 {
+		g.sortByDate(ready)
+		hash := ready[0]
+		ready = ready[1:]
+		order = append(order, hash)
+
+		commit := g.commits[hash]
+		for _, parent := range commit.Parents {
+			if !selected[parent] {
+				continue
+			}
+			children[parent]--
+			if children[parent] == 0 {
+				ready = append(ready, parent)
+			}
+		}
+	}
+
+	return order, nil
+}
+
+// sortByDate puts the most recently committed first, breaking ties by hash
+// so the order is stable
+func (g *Graph) sortByDate(hashes []string) {
+	sort.Slice(hashes, func(i, j int) bool {
+		a, b := g.commits[hashes[i]].CommitTime, g.commits[hashes[j]].CommitTime
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return hashes[i] < hashes[j]
+	})
+}
+
+// MergeBases returns the best common ancestors of two commits: those
+// reachable from both that are not ancestors of another such commit
+func (g *Graph) MergeBases(a, b string) ([]string, error) {
+	fromA, err := g.Ancestors(a)
+	if err != nil {
+		return nil, err
+	}
+	fromB, err := g.Ancestors(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var common, parents []string
+	for hash := range fromA {
+		if fromB[hash] {
+			common = append(common, hash)
+			parents = append(parents, g.commits[hash].Parents...)
+		}
+	}
+
+	// Anything reachable from a common ancestor's parents is not the best
+	below, err := g.Ancestors(parents...)
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []string
+	for _, hash := range common {
+		if !below[hash] {
+			bases = append(bases, hash)
+		}
+	}
+	g.sortByDate(bases)
+
+	return bases, nil
+}
+
+// MergeBase returns one best common ancestor, or "" if the commits share
+// no history
+func (g *Graph) MergeBase(a, b string) (string, error) {
+	bases, err := g.MergeBases(a, b)
+	if err != nil || len(bases) == 0 {
+		return "", err
+	}
+
+	return bases[0], nil
+}
@@ -0,0 +1,100 @@
+package ident
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dateLayouts = []string{
+	time.RFC1123Z, // Mon, 02 Jan 2006 15:04:05 -0700
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"02 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700", // Git's default log format
+	time.RFC3339,                    // 2006-01-02T15:04:05-07:00
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04:05Z07:00",
+	"2006.01.02 15:04:05 -0700",
+}
+
+var localLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseDate understands the date formats Git accepts in GIT_*_DATE and
+// --date: its internal "<unix> <+hhmm>" form, "@<unix> [+hhmm]", RFC 2822
+// and ISO 8601. Dates without a zone are in local time.
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return time.Now(), nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(value, "@"))
+	if len(fields) >= 1 && len(fields) <= 2 {
+		if unix, err := strconv.ParseInt(fields[0], 10, 64); err == nil && (strings.HasPrefix(value, "@") || len(fields) == 2) {
+			t := time.Unix(unix, 0).UTC()
+			if len(fields) == 2 {
+				zone, err := ParseZone(fields[1])
+				if err != nil {
+					return time.Time{}, err
+				}
+				t = t.In(zone)
+			}
+			return t, nil
+		}
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+}
+
+// FormatDate renders a time as Git stores it in objects and reflogs: Unix
+// seconds and the "+hhmm" offset of its zone
+func FormatDate(t time.Time) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+
+	return fmt.Sprintf("%d %c%02d%02d", t.Unix(), sign, offset/3600, (offset%3600)/60)
+}
+
+// ParseZone turns a "+hhmm" or "-hhmm" offset into a fixed time zone
+func ParseZone(zone string) (*time.Location, error) {
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return nil, fmt.Errorf("invalid time zone: %s", zone)
+	}
+
+	hours, err1 := strconv.Atoi(zone[1:3])
+	minutes, err2 := strconv.Atoi(zone[3:5])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid time zone: %s", zone)
+	}
+
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+
+	return time.FixedZone(zone, offset), nil
+}
@@ -0,0 +1,119 @@
+package ident
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+)
+
+// Ident is a person and the moment they authored or committed something
+type Ident struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// String renders the identity as "Name <email>", without the date
+func (i Ident) String() string {
+	return fmt.Sprintf("%s <%s>", i.Name, i.Email)
+}
+
+// Signature renders the identity followed by Git's "<unix> <+hhmm>" date,
+// as used in reflogs
+func (i Ident) Signature() string {
+	return fmt.Sprintf("%s %s", i, FormatDate(i.When))
+}
+
+// Parse reads a "Name <email>" identity
+func Parse(value string) (Ident, error) {
+	open := strings.Index(value, "<")
+	end := strings.LastIndex(value, ">")
+	if open == -1 || end < open {
+		return Ident{}, fmt.Errorf("invalid identity %q, expected 'Name <email>'", value)
+	}
+
+	name := strings.TrimSpace(value[:open])
+	if name == "" {
+		return Ident{}, fmt.Errorf("empty name in identity %q", value)
+	}
+
+	return Ident{Name: name, Email: strings.TrimSpace(value[open+1 : end])}, nil
+}
+
+// Author resolves the author identity the way Git does: GIT_AUTHOR_* from
+// the environment, then author.* and user.* from config, then the system
+// user. GIT_AUTHOR_DATE sets the date, which is otherwise now.
+func Author(cfg *config.Config) (Ident, error) {
+	return resolve(cfg, "GIT_AUTHOR", "author")
+}
+
+// Committer is like Author but uses GIT_COMMITTER_* and committer.*
+func Committer(cfg *config.Config) (Ident, error) {
+	return resolve(cfg, "GIT_COMMITTER", "committer")
+}
+
+func resolve(cfg *config.Config, envPrefix, section string) (Ident, error) {
+	name := firstSet(
+		os.Getenv(envPrefix+"_NAME"),
+		configValue(cfg, section+".name"),
+		configValue(cfg, "user.name"),
+	)
+	email := firstSet(
+		os.Getenv(envPrefix+"_EMAIL"),
+		configValue(cfg, section+".email"),
+		configValue(cfg, "user.email"),
+		os.Getenv("EMAIL"),
+	)
+
+	if name == "" || email == "" {
+		username := "Unknown"
+		if current, err := user.Current(); err == nil {
+			username = current.Username
+		}
+
+		if name == "" {
+			name = username
+		}
+		if email == "" {
+			host, err := os.Hostname()
+			if err != nil || host == "" {
+				host = "localhost"
+			}
+			email = username + "@" + host
+		}
+	}
+
+	when := time.Now()
+	if date := os.Getenv(envPrefix + "_DATE"); date != "" {
+		parsed, err := ParseDate(date)
+		if err != nil {
+			return Ident{}, fmt.Errorf("invalid %s_DATE: %w", envPrefix, err)
+		}
+		when = parsed
+	}
+
+	return Ident{Name: name, Email: email, When: when}, nil
+}
+
+func configValue(cfg *config.Config, name string) string {
+	if cfg == nil {
+		return ""
+	}
+
+	value, _ := cfg.Get(name)
+	return value
+}
+
+func firstSet(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
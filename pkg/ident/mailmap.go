@@ -0,0 +1,121 @@
+package ident
+
+import "strings"
+
+// Mailmap maps the names and emails commits were made with to the ones
+// people want to be known by, as listed in .mailmap files
+type Mailmap struct {
+	entries map[string]*mailmapEntry
+}
+
+// mailmapEntry holds what to show for one commit email: the replacements
+// for any name, and those for particular names
+type mailmapEntry struct {
+	mailmapTarget
+	names map[string]mailmapTarget
+}
+
+// mailmapTarget is a replacement name and email, either of which may be
+// empty to keep the original
+type mailmapTarget struct {
+	name  string
+	email string
+}
+
+func NewMailmap() *Mailmap {
+	return &Mailmap{entries: make(map[string]*mailmapEntry)}
+}
+
+// Parse adds the lines of a mailmap file. Each maps a commit email, and
+// optionally a commit name, to a proper name, email or both:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//
+// Later lines win, and anything after a '#' at the start of a line is a
+// comment.
+func (m *Mailmap) Parse(data string) {
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name1, email1, rest, ok := nameAndEmail(line, false)
+		if !ok {
+			continue
+		}
+
+		name2, email2, _, hasOld := nameAndEmail(rest, true)
+		if !hasOld {
+			name2, email2 = "", ""
+		}
+		m.add(name1, email1, name2, email2, hasOld)
+	}
+}
+
+// nameAndEmail reads "Name <email>" from the start of line, returning what
+// follows the '>'
+func nameAndEmail(line string, allowEmpty bool) (name, email, rest string, ok bool) {
+	open := strings.IndexByte(line, '<')
+	if open == -1 {
+		return "", "", "", false
+	}
+	end := strings.IndexByte(line[open+1:], '>')
+	if end == -1 || (end == 0 && !allowEmpty) {
+		return "", "", "", false
+	}
+	end += open + 1
+
+	return strings.TrimSpace(line[:open]), line[open+1 : end], line[end+1:], true
+}
+
+func (m *Mailmap) add(newName, newEmail, oldName, oldEmail string, hasOld bool) {
+	if !hasOld {
+		oldEmail, newEmail = newEmail, ""
+	}
+
+	key := strings.ToLower(oldEmail)
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &mailmapEntry{names: make(map[string]mailmapTarget)}
+		m.entries[key] = entry
+	}
+
+	if oldName == "" {
+		if newName != "" {
+			entry.name = newName
+		}
+		if newEmail != "" {
+			entry.email = newEmail
+		}
+		return
+	}
+
+	entry.names[strings.ToLower(oldName)] = mailmapTarget{name: newName, email: newEmail}
+}
+
+// Map returns the identity to show for id. Emails and names are compared
+// without regard to case, and an entry for the name as well as the email
+// wins over one for the email alone.
+func (m *Mailmap) Map(id Ident) Ident {
+	entry, ok := m.entries[strings.ToLower(id.Email)]
+	if !ok {
+		return id
+	}
+
+	target := entry.mailmapTarget
+	if named, ok := entry.names[strings.ToLower(id.Name)]; ok {
+		target = named
+	}
+
+	if target.name != "" {
+		id.Name = target.name
+	}
+	if target.email != "" {
+		id.Email = target.email
+	}
+
+	return id
+}
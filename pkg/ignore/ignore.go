@@ -0,0 +1,156 @@
+package ignore
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/config"
+)
+
+// Matcher decides which work tree paths are ignored. Patterns come from
+// .gitignore files in each directory, .git/info/exclude and the file named
+// by core.excludesFile, in falling order of precedence; within one file the
+// last matching line wins.
+type Matcher struct {
+	root    string
+	exclude []Pattern
+	dirs    map[string][]Pattern
+}
+
+// New loads the repository-wide exclude files. Per-directory .gitignore
+// files are read as paths below them are matched.
+func New(root, gitDir string, cfg *config.Config) (*Matcher, error) {
+	m := &Matcher{root: root, dirs: make(map[string][]Pattern)}
+
+	if global := excludesFile(cfg); global != "" {
+		patterns, err := loadFile(global, global, "")
+		if err != nil {
+			return nil, err
+		}
+		m.exclude = append(m.exclude, patterns...)
+	}
+
+	infoExclude := filepath.Join(gitDir, "info", "exclude")
+	patterns, err := loadFile(infoExclude, ".git/info/exclude", "")
+	if err != nil {
+		return nil, err
+	}
+	m.exclude = append(m.exclude, patterns...)
+
+	return m, nil
+}
+
+// excludesFile returns core.excludesFile, defaulting to Git's
+// $XDG_CONFIG_HOME/git/ignore
+func excludesFile(cfg *config.Config) string {
+	if cfg != nil {
+		if value, ok := cfg.Get("core.excludesFile"); ok && value != "" {
+			if strings.HasPrefix(value, "~/") {
+				if home, err := os.UserHomeDir(); err == nil {
+					value = filepath.Join(home, value[2:])
+				}
+			}
+			return value
+		}
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+
+	return ""
+}
+
+func loadFile(path, source, base string) ([]Pattern, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", source, err)
+	}
+	defer file.Close()
+
+	return ParsePatterns(file, source, base)
+}
+
+func (m *Matcher) dirPatterns(dir string) ([]Pattern, error) {
+	if patterns, ok := m.dirs[dir]; ok {
+		return patterns, nil
+	}
+
+	source := path.Join(dir, ".gitignore")
+	patterns, err := loadFile(filepath.Join(m.root, filepath.FromSlash(source)), source, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dirs[dir] = patterns
+	return patterns, nil
+}
+
+// Match returns the pattern that decides a path, which may be a negation,
+// or nil if none applies. Parent directories are not considered; use
+// Ignored for that.
+func (m *Matcher) Match(name string, isDir bool) (*Pattern, error) {
+	dir := path.Dir(name)
+	for {
+		if dir == "." {
+			dir = ""
+		}
+
+		patterns, err := m.dirPatterns(dir)
+		if err != nil {
+			return nil, err
+		}
+		if p := lastMatch(patterns, name, isDir); p != nil {
+			return p, nil
+		}
+
+		if dir == "" {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+
+	return lastMatch(m.exclude, name, isDir), nil
+}
+
+func lastMatch(patterns []Pattern, name string, isDir bool) *Pattern {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].Match(name, isDir) {
+			return &patterns[i]
+		}
+	}
+
+	return nil
+}
+
+// Ignored reports whether a path is excluded, either by its own pattern or
+// because a parent directory is; a file inside an ignored directory cannot
+// be re-included. The deciding pattern is returned too.
+func (m *Matcher) Ignored(name string, isDir bool) (bool, *Pattern, error) {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		p, err := m.Match(strings.Join(parts[:i], "/"), true)
+		if err != nil {
+			return false, nil, err
+		}
+		if p != nil && !p.Negate {
+			return true, p, nil
+		}
+	}
+
+	p, err := m.Match(name, isDir)
+	if err != nil {
+		return false, nil, err
+	}
+
+	return p != nil && !p.Negate, p, nil
+}
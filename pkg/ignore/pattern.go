@@ -0,0 +1,113 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/pathspec"
+)
+
+// Pattern is one line of a gitignore file
+type Pattern struct {
+	// Text is the line as written, for reporting
+	Text    string
+	pattern string
+	Negate  bool
+	DirOnly bool
+	// Anchored patterns contain a '/' and match from Base rather than
+	// against the file name at any depth
+	Anchored bool
+	// Base is the directory holding the file, "" for the top
+	Base   string
+	Source string
+	Line   int
+}
+
+// ParsePatterns reads gitignore lines, skipping blanks and comments
+func ParsePatterns(r io.Reader, source, base string) ([]Pattern, error) {
+	var patterns []Pattern
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if p, ok := ParsePattern(scanner.Text()); ok {
+			p.Source = source
+			p.Base = base
+			p.Line = line
+			patterns = append(patterns, p)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", source, err)
+	}
+
+	return patterns, nil
+}
+
+// ParsePattern reads one gitignore line, reporting false for blank lines
+// and comments
+func ParsePattern(line string) (Pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Pattern{}, false
+	}
+
+	p := Pattern{Text: trimTrailingSpaces(line)}
+	text := p.Text
+
+	if strings.HasPrefix(text, "!") {
+		p.Negate = true
+		text = text[1:]
+	}
+
+	if strings.HasSuffix(text, "/") {
+		p.DirOnly = true
+		text = strings.TrimRight(text, "/")
+	}
+
+	if strings.Contains(text, "/") {
+		p.Anchored = true
+		text = strings.TrimPrefix(text, "/")
+	}
+
+	if text == "" {
+		return Pattern{}, false
+	}
+
+	p.pattern = text
+	return p, true
+}
+
+// trimTrailingSpaces drops trailing spaces unless escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	return line
+}
+
+// Match reports whether the pattern applies to a slash-separated path from
+// the top of the work tree, without looking at its parent directories
+func (p *Pattern) Match(name string, isDir bool) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+
+	rel := name
+	if p.Base != "" {
+		if !strings.HasPrefix(name, p.Base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(name, p.Base+"/")
+	}
+
+	if p.Anchored {
+		return pathspec.Wildmatch(p.pattern, rel, true)
+	}
+
+	return pathspec.Wildmatch(p.pattern, path.Base(rel), true)
+}
@@ -5,13 +5,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/repository"
 )
 
 type Entry struct {
 	Mode string
 	Hash string
 	Path string
+	// Stage is 0 for a normal entry. An unmerged path has instead up to
+	// three entries: 1 for the common base, 2 for ours and 3 for theirs.
+	Stage int
 }
 
 type Index struct {
@@ -24,40 +31,55 @@ func NewIndex() *Index {
 	}
 }
 
+// Add stages a path, which also resolves any conflict recorded for it
 func (idx *Index) Add(mode, hash, path string) {
-	for i, entry := range idx.Entries {
-		if entry.Path == path {
-			idx.Entries[i] = Entry{
-				Mode: mode,
-				Hash: hash,
-				Path: path,
-			}
+	idx.removeStages(path, func(stage int) bool { return stage > 0 })
+	idx.set(Entry{Mode: mode, Hash: hash, Path: path})
+}
+
+// AddConflict records one side of an unmerged path, replacing its normal
+// entry
+func (idx *Index) AddConflict(stage int, mode, hash, path string) {
+	idx.removeStages(path, func(s int) bool { return s == 0 })
+	idx.set(Entry{Mode: mode, Hash: hash, Path: path, Stage: stage})
+}
 
+func (idx *Index) set(entry Entry) {
+	for i, existing := range idx.Entries {
+		if existing.Path == entry.Path && existing.Stage == entry.Stage {
+			idx.Entries[i] = entry
 			return
 		}
 	}
 
-	idx.Entries = append(idx.Entries, Entry{
-		Mode: mode,
-		Hash: hash,
-		Path: path,
-	})
+	idx.Entries = append(idx.Entries, entry)
 }
 
-func (idx *Index) Remove(path string) bool {
-	for i, entry := range idx.Entries {
-		if entry.Path == path {
-			idx.Entries = append(idx.Entries[:i], idx.Entries[i+1:]...)
-			return true
+func (idx *Index) removeStages(path string, match func(stage int) bool) bool {
+	kept := idx.Entries[:0]
+	removed := false
+
+	for _, entry := range idx.Entries {
+		if entry.Path == path && match(entry.Stage) {
+			removed = true
+			continue
 		}
+		kept = append(kept, entry)
 	}
 
-	return false
+	idx.Entries = kept
+	return removed
 }
 
+// Remove drops every entry for a path, including conflict stages
+func (idx *Index) Remove(path string) bool {
+	return idx.removeStages(path, func(int) bool { return true })
+}
+
+// Get returns the normal, stage 0 entry for a path
 func (idx *Index) Get(path string) (*Entry, bool) {
 	for _, entry := range idx.Entries {
-		if entry.Path == path {
+		if entry.Path == path && entry.Stage == 0 {
 			return &entry, true
 		}
 	}
@@ -65,6 +87,60 @@ func (idx *Index) Get(path string) (*Entry, bool) {
 	return nil, false
 }
 
+// Conflicts returns the stage 1-3 entries of an unmerged path
+func (idx *Index) Conflicts(path string) []Entry {
+	var stages []Entry
+	for _, entry := range idx.Entries {
+		if entry.Path == path && entry.Stage > 0 {
+			stages = append(stages, entry)
+		}
+	}
+
+	return stages
+}
+
+// Unmerged lists the paths that have conflict stages, sorted
+func (idx *Index) Unmerged() []string {
+	seen := make(map[string]bool)
+	var paths []string
+
+	for _, entry := range idx.Entries {
+		if entry.Stage > 0 && !seen[entry.Path] {
+			seen[entry.Path] = true
+			paths = append(paths, entry.Path)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// Paths lists each path once, in index order
+func (idx *Index) Paths() []string {
+	seen := make(map[string]bool)
+	var paths []string
+
+	for _, entry := range idx.Entries {
+		if !seen[entry.Path] {
+			seen[entry.Path] = true
+			paths = append(paths, entry.Path)
+		}
+	}
+
+	return paths
+}
+
+// Sort orders entries by path and then stage, as Git keeps them
+func (idx *Index) Sort() {
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Stage < b.Stage
+	})
+}
+
 func ReadIndex(gitDir string) (*Index, error) {
 	indexPath := filepath.Join(gitDir, "index")
 
@@ -78,6 +154,11 @@ func ReadIndex(gitDir string) (*Index, error) {
 	}
 	defer file.Close()
 
+	format, err := repository.ObjectFormat(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
 	idx := NewIndex()
 	scanner := bufio.NewScanner(file)
 
@@ -91,8 +172,21 @@ func ReadIndex(gitDir string) (*Index, error) {
 		if len(parts) != 3 {
 			return nil, fmt.Errorf("invalid index line: %s", line)
 		}
+		if !format.IsValid(parts[1]) {
+			return nil, fmt.Errorf("invalid %s hash in index for %s", format, parts[2])
+		}
 
-		idx.Add(parts[0], parts[1], parts[2])
+		mode, stageText, unmerged := strings.Cut(parts[0], ":")
+		if !unmerged {
+			idx.Add(mode, parts[1], parts[2])
+			continue
+		}
+
+		stage, err := strconv.Atoi(stageText)
+		if err != nil || stage < 1 || stage > 3 {
+			return nil, fmt.Errorf("invalid stage in index line: %s", line)
+		}
+		idx.AddConflict(stage, mode, parts[1], parts[2])
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -105,6 +199,17 @@ func ReadIndex(gitDir string) (*Index, error) {
 func WriteIndex(gitDir string, idx *Index) error {
 	indexPath := filepath.Join(gitDir, "index")
 
+	format, err := repository.ObjectFormat(gitDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range idx.Entries {
+		if !format.IsValid(entry.Hash) {
+			return fmt.Errorf("invalid %s hash for %s", format, entry.Path)
+		}
+	}
+
 	file, err := os.Create(indexPath)
 	if err != nil {
 		return fmt.Errorf("failed to create index file: %w", err)
@@ -114,7 +219,12 @@ func WriteIndex(gitDir string, idx *Index) error {
 	writer := bufio.NewWriter(file)
 
 	for _, entry := range idx.Entries {
-		line := fmt.Sprintf("%s %s %s\n", entry.Mode, entry.Hash, entry.Path)
+		mode := entry.Mode
+		if entry.Stage > 0 {
+			mode = fmt.Sprintf("%s:%d", entry.Mode, entry.Stage)
+		}
+
+		line := fmt.Sprintf("%s %s %s\n", mode, entry.Hash, entry.Path)
 		if _, err := writer.WriteString(line); err != nil {
 			return fmt.Errorf("failed to write index entry: %w", err)
 		}
@@ -0,0 +1,159 @@
+package mailbox
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+)
+
+const (
+	// headerWidth is where header lines are folded
+	headerWidth = 78
+	// encodedWidth is the longest an RFC 2047 encoded word may make a line
+	encodedWidth = 76
+	// DateFormat is the RFC 2822 date of mail headers
+	DateFormat = "Mon, 2 Jan 2006 15:04:05 -0700"
+)
+
+// FromHeader renders "From: Name <email>", quoting the name when it has
+// characters special in addresses and encoding it when it is not ASCII
+func FromHeader(id ident.Ident) string {
+	const prefix = "From: "
+
+	name := id.Name
+	switch {
+	case needsEncoding(name):
+		name = encode(name, len(prefix), true)
+	case strings.ContainsAny(name, `()<>@,;:\".[]`):
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+
+	return fmt.Sprintf("%s%s <%s>", prefix, name, id.Email)
+}
+
+// SubjectHeader renders "Subject: <tag><title>", folded at 78 columns. A
+// title of more than one line, or one that is not ASCII, is encoded; the
+// tag, such as "[PATCH] ", never is.
+func SubjectHeader(tag, title string) string {
+	prefix := "Subject: " + tag
+
+	if needsEncoding(title) {
+		return prefix + encode(title, len(prefix), false)
+	}
+
+	return prefix + fold(title, len(prefix))
+}
+
+// NeedsMIME reports whether text needs the headers declaring 8-bit UTF-8
+func NeedsMIME(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+
+	return false
+}
+
+func needsEncoding(value string) bool {
+	return NeedsMIME(value) || strings.ContainsAny(value, "\n") || strings.Contains(value, "=?")
+}
+
+// encode writes value as RFC 2047 "Q" encoded words, starting a new one on a
+// continuation line before a line grows too long. Characters are never
+// split across words.
+func encode(value string, used int, address bool) string {
+	const open = "=?UTF-8?q?"
+
+	var b strings.Builder
+	b.WriteString(open)
+	width := used + len(open)
+
+	for len(value) > 0 {
+		_, size := utf8.DecodeRuneInString(value)
+		char := value[:size]
+		value = value[size:]
+
+		encoded := char
+		if size > 1 || isSpecial(char[0], address) {
+			encoded = ""
+			for i := 0; i < size; i++ {
+				encoded += fmt.Sprintf("=%02X", char[i])
+			}
+		}
+
+		if width+len(encoded)+2 > encodedWidth {
+			b.WriteString("?=\n " + open)
+			width = len(open) + 1
+		}
+		b.WriteString(encoded)
+		width += len(encoded)
+	}
+	b.WriteString("?=")
+
+	return b.String()
+}
+
+// isSpecial reports whether a byte must be escaped in an encoded word. In
+// an address only a few punctuation characters may stand for themselves.
+func isSpecial(c byte, address bool) bool {
+	if c >= utf8.RuneSelf || c < ' ' || c == 0x7f || strings.IndexByte(" =?_", c) >= 0 {
+		return true
+	}
+	if !address {
+		return false
+	}
+
+	isAlnum := c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	return !isAlnum && strings.IndexByte("!*+-/", c) < 0
+}
+
+// fold wraps a header value at spaces so that lines stay within 78
+// columns, given how much of the first line the header name used.
+// Continuation lines are indented by one space, and a word too long for
+// any line is left whole.
+func fold(value string, used int) string {
+	var b strings.Builder
+	bol, space, hasSpace := 0, 0, true
+	width, indent := used, 0
+
+	for i := 0; ; i++ {
+		end := i == len(value)
+		if !end && value[i] != ' ' {
+			width++
+			continue
+		}
+
+		if width <= headerWidth || !hasSpace {
+			start := bol
+			if end && i == start {
+				break
+			}
+			if hasSpace {
+				start = space
+			} else {
+				b.WriteString(strings.Repeat(" ", indent))
+			}
+			b.WriteString(value[start:i])
+			if end {
+				break
+			}
+			space, hasSpace = i, true
+			width++
+			continue
+		}
+
+		b.WriteString("\n")
+		bol = space
+		if value[space] == ' ' {
+			bol++
+		}
+		i = bol - 1
+		hasSpace = false
+		width, indent = 1, 1
+	}
+
+	return b.String()
+}
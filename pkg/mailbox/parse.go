@@ -0,0 +1,203 @@
+package mailbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+)
+
+// Mail is a patch read from an email
+type Mail struct {
+	Author  ident.Ident
+	Subject string
+	// Body is the rest of the commit message, the text between the
+	// headers and the patch
+	Body string
+	// Patch is everything from the "---" line or the first diff on
+	Patch string
+}
+
+// Message is the commit message the mail describes
+func (m *Mail) Message() string {
+	if m.Body == "" {
+		return m.Subject
+	}
+
+	return m.Subject + "\n\n" + m.Body
+}
+
+// Split breaks an mbox into its mails at the "From " lines that start each
+// one. Text that does not start with such a line is taken as a single mail.
+func Split(data string) []string {
+	lines := strings.SplitAfter(data, "\n")
+	if len(lines) == 0 || !isFromLine(lines[0]) {
+		if strings.TrimSpace(data) == "" {
+			return nil
+		}
+		return []string{data}
+	}
+
+	var mails []string
+	var current strings.Builder
+	for i, line := range lines {
+		if isFromLine(line) {
+			if i > 0 {
+				mails = append(mails, current.String())
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+	}
+
+	return append(mails, current.String())
+}
+
+// isFromLine recognizes the "From <sender> <date>" line that separates
+// mails in an mbox the way Git does: it must end with a time and a year
+func isFromLine(line string) bool {
+	line = strings.TrimSuffix(line, "\n")
+	if len(line) < 19 || !strings.HasPrefix(line, "From ") {
+		return false
+	}
+
+	colon := strings.LastIndexByte(line[5:len(line)-1], ':')
+	if colon < 0 {
+		return false
+	}
+	colon += 5
+
+	for _, i := range []int{colon - 4, colon - 2, colon - 1, colon + 1, colon + 2} {
+		if i < 0 || i >= len(line) || line[i] < '0' || line[i] > '9' {
+			return false
+		}
+	}
+
+	year, err := strconv.Atoi(strings.Fields(line[colon+3:] + " 0")[0])
+	return err == nil && year > 90
+}
+
+// Parse reads a mail: the author from From and Date, the subject without
+// "Re:" and "[PATCH]" prefixes, and a body split into message and patch
+func Parse(text string) (*Mail, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mail: %w", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+
+	author, err := parseFrom(decoder, msg.Header.Get("From"))
+	if err != nil {
+		return nil, err
+	}
+	m := &Mail{Author: author}
+
+	if date := msg.Header.Get("Date"); date != "" {
+		if m.Author.When, err = mail.ParseDate(date); err != nil {
+			return nil, fmt.Errorf("invalid date in mail: %s", date)
+		}
+	}
+
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject in mail: %w", err)
+	}
+	m.Subject = cleanSubject(subject)
+
+	body, err := decodeBody(msg)
+	if err != nil {
+		return nil, err
+	}
+	m.Body, m.Patch = splitBody(body)
+
+	return m, nil
+}
+
+// parseFrom reads the author from a From header. Addresses that are not
+// strictly valid, such as names with unquoted dots, are read loosely.
+func parseFrom(decoder *mime.WordDecoder, from string) (ident.Ident, error) {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		name := addr.Name
+		if name == "" {
+			name, _, _ = strings.Cut(addr.Address, "@")
+		}
+		return ident.Ident{Name: name, Email: addr.Address}, nil
+	}
+
+	decoded, err := decoder.DecodeHeader(from)
+	if err == nil {
+		if id, err := ident.Parse(strings.Trim(decoded, " ")); err == nil {
+			id.Name = strings.Trim(id.Name, `"`)
+			return id, nil
+		}
+	}
+
+	return ident.Ident{}, fmt.Errorf("patch does not have a valid e-mail address: %s", from)
+}
+
+// decodeBody undoes the body's transfer encoding
+func decodeBody(msg *mail.Message) (string, error) {
+	var r io.Reader = msg.Body
+	switch strings.ToLower(strings.TrimSpace(msg.Header.Get("Content-Transfer-Encoding"))) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return "", fmt.Errorf("failed to decode mail body: %w", err)
+	}
+
+	return strings.ReplaceAll(buf.String(), "\r\n", "\n"), nil
+}
+
+// splitBody separates the message from the patch, which starts at a "---"
+// line or at the first "diff -" or "Index: " line
+func splitBody(body string) (message, patch string) {
+	offset := 0
+	for _, line := range strings.SplitAfter(body, "\n") {
+		trimmed := strings.TrimRight(line, "\n")
+		if trimmed == "---" || strings.HasPrefix(trimmed, "--- ") || strings.HasPrefix(trimmed, "---\t") ||
+			strings.HasPrefix(trimmed, "diff -") || strings.HasPrefix(trimmed, "Index: ") {
+			break
+		}
+		offset += len(line)
+	}
+
+	return strings.TrimSpace(body[:offset]), body[offset:]
+}
+
+// cleanSubject strips what mailers and format-patch put in front of a
+// subject: "Re:", bracketed tags such as "[PATCH 1/2]", and the spaces and
+// colons between them
+func cleanSubject(subject string) string {
+	for subject != "" {
+		switch c := subject[0]; {
+		case c == ' ' || c == '\t' || c == ':':
+			subject = subject[1:]
+			continue
+		case (c == 'r' || c == 'R') && len(subject) > 3 && (subject[1] == 'e' || subject[1] == 'E') && subject[2] == ':':
+			subject = subject[3:]
+			continue
+		case c == '[':
+			if end := strings.IndexByte(subject, ']'); end >= 0 {
+				subject = subject[end+1:]
+				continue
+			}
+		}
+		break
+	}
+
+	return strings.TrimSpace(subject)
+}
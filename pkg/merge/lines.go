@@ -0,0 +1,134 @@
+package merge
+
+import (
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/diff"
+)
+
+// Labels name the two sides in conflict markers and messages
+type Labels struct {
+	Ours   string
+	Theirs string
+}
+
+// Lines merges the changes from base to ours and from base to theirs.
+// Regions changed differently on both sides are written between conflict
+// markers, and the number of such regions is returned.
+func Lines(base, ours, theirs []string, labels Labels) ([]string, int) {
+	oursAt := matches(base, ours)
+	theirsAt := matches(base, theirs)
+
+	var out []string
+	conflicts := 0
+	i, a, b := 0, 0, 0
+
+	for i < len(base) || a < len(ours) || b < len(theirs) {
+		// A base line kept in place by both sides is stable
+		if i < len(base) && oursAt[i] == a && theirsAt[i] == b {
+			out = append(out, base[i])
+			i, a, b = i+1, a+1, b+1
+			continue
+		}
+
+		// Otherwise the chunk runs up to the next base line both sides kept
+		j := i
+		for j < len(base) && (oursAt[j] < 0 || theirsAt[j] < 0) {
+			j++
+		}
+
+		endA, endB := len(ours), len(theirs)
+		if j < len(base) {
+			endA, endB = oursAt[j], theirsAt[j]
+		}
+
+		chunk, conflicted := mergeChunk(base[i:j], ours[a:endA], theirs[b:endB], labels)
+		out = append(out, chunk...)
+		if conflicted {
+			conflicts++
+		}
+
+		i, a, b = j, endA, endB
+	}
+
+	return out, conflicts
+}
+
+// matches maps each line of base to the line of other it is kept as, or -1
+// when other drops it
+func matches(base, other []string) []int {
+	at := make([]int, len(base))
+	i, j := 0, 0
+
+	for _, line := range diff.Lines(base, other) {
+		switch line.Op {
+		case diff.Equal:
+			at[i] = j
+			i, j = i+1, j+1
+		case diff.Delete:
+			at[i] = -1
+			i++
+		case diff.Insert:
+			j++
+		}
+	}
+
+	return at
+}
+
+// mergeChunk takes whichever side changed a chunk, or marks a conflict
+// around the lines that differ when both did
+func mergeChunk(base, ours, theirs []string, labels Labels) ([]string, bool) {
+	switch {
+	case equal(ours, theirs), equal(base, theirs):
+		return ours, false
+	case equal(base, ours):
+		return theirs, false
+	}
+
+	// Lines both sides agree on are left outside the markers
+	head := 0
+	for head < len(ours) && head < len(theirs) && ours[head] == theirs[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(ours)-head && tail < len(theirs)-head && ours[len(ours)-1-tail] == theirs[len(theirs)-1-tail] {
+		tail++
+	}
+
+	out := append([]string(nil), ours[:head]...)
+	out = append(out, "<<<<<<< "+labels.Ours+"\n")
+	out = append(out, terminated(ours[head:len(ours)-tail])...)
+	out = append(out, "=======\n")
+	out = append(out, terminated(theirs[head:len(theirs)-tail])...)
+	out = append(out, ">>>>>>> "+labels.Theirs+"\n")
+	out = append(out, ours[len(ours)-tail:]...)
+
+	return out, true
+}
+
+// terminated makes sure a last line without a newline does not run into
+// the marker after it
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+
+	out := append([]string(nil), lines...)
+	out[len(out)-1] += "\n"
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,247 @@
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/diff"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// Conflict is a path both sides changed in ways that could not be combined
+type Conflict struct {
+	Path string
+	// Kind is "content", "add/add" or "modify/delete"
+	Kind string
+	// Deleted names the side that deleted the path in a modify/delete
+	// conflict
+	Deleted string
+}
+
+// String renders the conflict the way Git reports it
+func (c Conflict) String() string {
+	if c.Kind == "modify/delete" {
+		return fmt.Sprintf("CONFLICT (modify/delete): %s deleted in %s and modified in the other side", c.Path, c.Deleted)
+	}
+
+	return fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", c.Kind, c.Path)
+}
+
+// Result is the outcome of a three-way merge of flattened trees
+type Result struct {
+	// Entries is the merged index, with each conflicted path at stages 1
+	// (base), 2 (ours) and 3 (theirs) for the sides that have it
+	Entries []index.Entry
+	// Worktree is what to check out for every path the merge keeps, with
+	// conflict markers in the blobs of conflicted paths
+	Worktree  []index.Entry
+	Conflicts []Conflict
+}
+
+// Clean reports whether the merge had no conflicts
+func (r *Result) Clean() bool {
+	return len(r.Conflicts) == 0
+}
+
+// Trees merges the changes from base to ours and from base to theirs, path
+// by path. Files changed on both sides are merged line by line and the
+// merged blobs are written to the store.
+func Trees(store storage.ObjectStore, base, ours, theirs []index.Entry, labels Labels) (*Result, error) {
+	baseMap, oursMap, theirsMap := entryMap(base), entryMap(ours), entryMap(theirs)
+
+	paths := make(map[string]bool)
+	for _, m := range []map[string]index.Entry{baseMap, oursMap, theirsMap} {
+		for path := range m {
+			paths[path] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	result := &Result{}
+	for _, path := range sorted {
+		if err := result.mergePath(store, path, lookup(baseMap, path), lookup(oursMap, path), lookup(theirsMap, path), labels); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (r *Result) mergePath(store storage.ObjectStore, path string, base, ours, theirs *index.Entry, labels Labels) error {
+	switch {
+	case same(ours, theirs), same(base, theirs):
+		r.keep(ours)
+		return nil
+	case same(base, ours):
+		r.keep(theirs)
+		return nil
+	}
+
+	if ours == nil || theirs == nil {
+		deleted, left := labels.Ours, theirs
+		if theirs == nil {
+			deleted, left = labels.Theirs, ours
+		}
+
+		r.conflict(path, base, ours, theirs, *left, Conflict{Path: path, Kind: "modify/delete", Deleted: deleted})
+		return nil
+	}
+
+	kind := "content"
+	if base == nil {
+		kind = "add/add"
+	}
+
+	mode, modeClean := mergeMode(base, ours, theirs)
+
+	hash, contentClean, err := mergeContent(store, base, ours, theirs, labels)
+	if err != nil {
+		return fmt.Errorf("failed to merge %s: %w", path, err)
+	}
+
+	merged := index.Entry{Mode: mode, Hash: hash, Path: path}
+	if modeClean && contentClean {
+		r.keep(&merged)
+		return nil
+	}
+
+	r.conflict(path, base, ours, theirs, merged, Conflict{Path: path, Kind: kind})
+	return nil
+}
+
+// keep records a cleanly merged entry, nil for a deleted path
+func (r *Result) keep(entry *index.Entry) {
+	if entry == nil {
+		return
+	}
+
+	r.Entries = append(r.Entries, *entry)
+	r.Worktree = append(r.Worktree, *entry)
+}
+
+func (r *Result) conflict(path string, base, ours, theirs *index.Entry, worktree index.Entry, c Conflict) {
+	for stage, entry := range []*index.Entry{base, ours, theirs} {
+		if entry != nil {
+			staged := *entry
+			staged.Stage = stage + 1
+			r.Entries = append(r.Entries, staged)
+		}
+	}
+
+	r.Worktree = append(r.Worktree, worktree)
+	r.Conflicts = append(r.Conflicts, c)
+}
+
+// mergeMode takes the mode only one side changed, preferring ours when
+// both changed it differently
+func mergeMode(base, ours, theirs *index.Entry) (string, bool) {
+	switch {
+	case ours.Mode == theirs.Mode:
+		return ours.Mode, true
+	case base != nil && base.Mode == ours.Mode:
+		return theirs.Mode, true
+	case base != nil && base.Mode == theirs.Mode:
+		return ours.Mode, true
+	}
+
+	return ours.Mode, false
+}
+
+// mergeContent returns the merged blob. Binary files and symlinks cannot
+// be merged line by line, so a conflict there keeps our side.
+func mergeContent(store storage.ObjectStore, base, ours, theirs *index.Entry, labels Labels) (string, bool, error) {
+	switch {
+	case ours.Hash == theirs.Hash:
+		return ours.Hash, true, nil
+	case base != nil && base.Hash == ours.Hash:
+		return theirs.Hash, true, nil
+	case base != nil && base.Hash == theirs.Hash:
+		return ours.Hash, true, nil
+	}
+
+	var baseData []byte
+	if base != nil {
+		var err error
+		if baseData, err = readBlob(store, base.Hash); err != nil {
+			return "", false, err
+		}
+	}
+
+	oursData, err := readBlob(store, ours.Hash)
+	if err != nil {
+		return "", false, err
+	}
+	theirsData, err := readBlob(store, theirs.Hash)
+	if err != nil {
+		return "", false, err
+	}
+
+	if ours.Mode == "120000" || theirs.Mode == "120000" || binary(baseData) || binary(oursData) || binary(theirsData) {
+		return ours.Hash, false, nil
+	}
+
+	lines, conflicts := Lines(diff.SplitLines(string(baseData)), diff.SplitLines(string(oursData)), diff.SplitLines(string(theirsData)), labels)
+
+	hash, err := store.Write(objects.BlobObject, []byte(strings.Join(lines, "")))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to write merged blob: %w", err)
+	}
+
+	return hash, conflicts == 0, nil
+}
+
+func readBlob(store storage.ObjectStore, hash string) ([]byte, error) {
+	objType, data, err := storage.ReadObject(store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	if objType != objects.BlobObject {
+		return nil, fmt.Errorf("object %s is a %s, not a blob", hash, objType)
+	}
+
+	return data, nil
+}
+
+// binary uses Git's heuristic of a NUL byte in the first 8000 bytes
+func binary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+
+	return bytes.IndexByte(data, 0) != -1
+}
+
+func same(a, b *index.Entry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Mode == b.Mode && a.Hash == b.Hash
+}
+
+func entryMap(entries []index.Entry) map[string]index.Entry {
+	m := make(map[string]index.Entry, len(entries))
+	for _, entry := range entries {
+		m[entry.Path] = entry
+	}
+
+	return m
+}
+
+func lookup(m map[string]index.Entry, path string) *index.Entry {
+	entry, ok := m[path]
+	if !ok {
+		return nil
+	}
+
+	return &entry
+}
@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/ident"
 )
 
 type Commit struct {
@@ -14,17 +16,21 @@ type Commit struct {
 	Author    string
 	Committer string
 	Message   string
-	Timestamp time.Time
+	// Timestamp is the author date, CommitTime the committer date
+	Timestamp  time.Time
+	CommitTime time.Time
 }
 
 func NewCommit(tree, author, message string) *Commit {
+	now := time.Now()
 	return &Commit{
-		Tree:      tree,
-		Parents:   make([]string, 0),
-		Author:    author,
-		Committer: author,
-		Message:   message,
-		Timestamp: time.Now(),
+		Tree:       tree,
+		Parents:    make([]string, 0),
+		Author:     author,
+		Committer:  author,
+		Message:    message,
+		Timestamp:  now,
+		CommitTime: now,
 	}
 }
 
@@ -45,14 +51,8 @@ func (c *Commit) Serialize() ([]byte, error) {
 		buf.WriteString(fmt.Sprintf("parent %s\n", parent))
 	}
 
-	timestamp := c.Timestamp.Unix()
-	_, offset := c.Timestamp.Zone()
-	offsetHours := offset / 3600
-	offsetMinutes := (offset % 3600) / 60
-	timezone := fmt.Sprintf("%+03d%02d", offsetHours, offsetMinutes)
-
-	buf.WriteString(fmt.Sprintf("author %s %d %s\n", c.Author, timestamp, timezone))
-	buf.WriteString(fmt.Sprintf("committer %s %d %s\n", c.Committer, timestamp, timezone))
+	buf.WriteString(fmt.Sprintf("author %s %s\n", c.Author, ident.FormatDate(c.Timestamp)))
+	buf.WriteString(fmt.Sprintf("committer %s %s\n", c.Committer, ident.FormatDate(c.CommitTime)))
 
 	buf.WriteString("\n")
 	buf.WriteString(c.Message)
@@ -87,9 +87,8 @@ func (c *Commit) Deserialize(data []byte) error {
 			author, timestamp := parseAuthorLine(value)
 			c.Author = author
 			c.Timestamp = timestamp
-		case "commiter":
-			committer, _ := parseAuthorLine(value)
-			c.Committer = committer
+		case "committer":
+			c.Committer, c.CommitTime = parseAuthorLine(value)
 		}
 	}
 
@@ -114,5 +113,10 @@ func parseAuthorLine(line string) (string, time.Time) {
 		return author, time.Now()
 	}
 
-	return author, time.Unix(timestamp, 0)
+	zone, err := ident.ParseZone(parts[len(parts)-1])
+	if err != nil {
+		zone = time.UTC
+	}
+
+	return author, time.Unix(timestamp, 0).In(zone)
 }
@@ -0,0 +1,71 @@
+package objects
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// HashAlgorithm is the function a repository names its objects with, as
+// recorded by Git's extensions.objectformat setting
+type HashAlgorithm string
+
+const (
+	SHA1   HashAlgorithm = "sha1"
+	SHA256 HashAlgorithm = "sha256"
+)
+
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "sha1":
+		return SHA1, nil
+	case "sha256":
+		return SHA256, nil
+	default:
+		return "", fmt.Errorf("unknown object format: %s", name)
+	}
+}
+
+func (a HashAlgorithm) New() hash.Hash {
+	if a == SHA256 {
+		return sha256.New()
+	}
+
+	return sha1.New()
+}
+
+// Size is the length of a raw object name in bytes
+func (a HashAlgorithm) Size() int {
+	if a == SHA256 {
+		return sha256.Size
+	}
+
+	return sha1.Size
+}
+
+// HexSize is the length of an object name in hex characters
+func (a HashAlgorithm) HexSize() int {
+	return a.Size() * 2
+}
+
+// ZeroHash is the all-zero object name used for refs that do not exist
+func (a HashAlgorithm) ZeroHash() string {
+	return strings.Repeat("0", a.HexSize())
+}
+
+// IsValid reports whether s is a full lowercase hex object name
+func (a HashAlgorithm) IsValid(s string) bool {
+	if len(s) != a.HexSize() {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
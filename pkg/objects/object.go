@@ -2,8 +2,8 @@ package objects
 import (
 	"bytes"
 	"compress/zlib"
-	"crypto/sha1"
 	"fmt"
+	"hash"
 	"io"
 )
 
@@ -14,6 +14,7 @@ const (
 	BlobObject ObjectType = "blob"
 	TreeObject ObjectType = "tree"
 	CommitObject ObjectType = "commit"
+	TagObject ObjectType = "tag"
 )
 
 
@@ -24,18 +25,35 @@ type Object interface {
 }
 
 
-func Hash(obj Object) (string, error) {
+func Hash(algo HashAlgorithm, obj Object) (string, error) {
 	data, err := obj.Serialize()
 	if err != nil {
 		return "", err
 	}
 
-	//Create the git object format: <type> <size>\0<content>
-	header := fmt.Sprintf("%s %d\x00", obj.Type(), len(data))
-	store := append([]byte(header), data...)
+	return HashData(algo, obj.Type(), data), nil
+}
+
+// HashData hashes already serialized object content
+func HashData(algo HashAlgorithm, objType ObjectType, data []byte) string {
+	h := NewObjectHash(algo, objType, int64(len(data)))
+	h.Write(data)
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// NewObjectHash returns a hash that has already consumed the object header,
+// so content can be streamed into it
+func NewObjectHash(algo HashAlgorithm, objType ObjectType, size int64) hash.Hash {
+	h := algo.New()
+	h.Write([]byte(Header(objType, size)))
+
+	return h
+}
 
-	hash := sha1.Sum(store)
-	return fmt.Sprintf("%x", hash), nil
+// Header returns the git object format prefix: <type> <size>\0
+func Header(objType ObjectType, size int64) string {
+	return fmt.Sprintf("%s %d\x00", objType, size)
 }
 
 
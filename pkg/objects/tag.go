@@ -0,0 +1,77 @@
+package objects
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SteliosSpanos/mygit/pkg/ident"
+)
+
+// Tag is an annotated tag pointing at another object
+type Tag struct {
+	Object    string
+	ObjType   ObjectType
+	Name      string
+	Tagger    string
+	Timestamp time.Time
+	Message   string
+}
+
+func NewTag(object string, objType ObjectType, name, tagger, message string) *Tag {
+	return &Tag{
+		Object:    object,
+		ObjType:   objType,
+		Name:      name,
+		Tagger:    tagger,
+		Timestamp: time.Now(),
+		Message:   message,
+	}
+}
+
+func (t *Tag) Type() ObjectType {
+	return TagObject
+}
+
+func (t *Tag) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(fmt.Sprintf("object %s\n", t.Object))
+	buf.WriteString(fmt.Sprintf("type %s\n", t.ObjType))
+	buf.WriteString(fmt.Sprintf("tag %s\n", t.Name))
+	if t.Tagger != "" {
+		buf.WriteString(fmt.Sprintf("tagger %s %s\n", t.Tagger, ident.FormatDate(t.Timestamp)))
+	}
+
+	buf.WriteString("\n")
+	buf.WriteString(t.Message)
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+func (t *Tag) Deserialize(data []byte) error {
+	header, message, _ := strings.Cut(string(data), "\n\n")
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "object":
+			t.Object = value
+		case "type":
+			t.ObjType = ObjectType(value)
+		case "tag":
+			t.Name = value
+		case "tagger":
+			t.Tagger, t.Timestamp = parseAuthorLine(value)
+		}
+	}
+
+	t.Message = strings.TrimSpace(message)
+	return nil
+}
@@ -16,6 +16,9 @@ type TreeEntry struct {
 
 type Tree struct {
 	Entries []TreeEntry
+
+	// Algorithm decides how long entry hashes are; empty means SHA-1
+	Algorithm HashAlgorithm
 }
 
 func NewTree() *Tree {
@@ -36,17 +39,25 @@ func (t *Tree) Type() ObjectType {
 
 func (t *Tree) Serialize() ([]byte, error) {
 	sort.Slice(t.Entries, func(i, j int) bool {
-		return t.Entries[i].Name < t.Entries[j].Name
+		return t.Entries[i].SortKey() < t.Entries[j].SortKey()
 	})
 
 	var buf bytes.Buffer
 
 	for _, entry := range t.Entries {
-		buf.WriteString(fmt.Sprintf("%s %s\x00", entry.Mode, entry.Name))
+		mode := entry.Mode
+		if mode == "040000" {
+			mode = "40000" // Git stores tree modes without the leading zero
+		}
+
+		buf.WriteString(fmt.Sprintf("%s %s\x00", mode, entry.Name))
 		hashBytes, err := HexToBytes(entry.Hash)
 		if err != nil {
 			return nil, fmt.Errorf("invalid hash for %s: %w", entry.Name, err)
 		}
+		if len(hashBytes) != t.Algorithm.Size() {
+			return nil, fmt.Errorf("invalid hash for %s: not a %s hash", entry.Name, t.Algorithm)
+		}
 		buf.Write(hashBytes)
 	}
 
@@ -55,6 +66,7 @@ func (t *Tree) Serialize() ([]byte, error) {
 
 func (t *Tree) Deserialize(data []byte) error {
 	t.Entries = make([]TreeEntry, 0)
+	hashSize := t.Algorithm.Size()
 
 	for len(data) > 0 {
 		nullIdx := bytes.IndexByte(data, 0) //First occurance of 0
@@ -64,35 +76,53 @@ func (t *Tree) Deserialize(data []byte) error {
 
 		header := string(data[:nullIdx])
 		parts := strings.SplitN(header, " ", 2)
-		if len(parts) > 2 {
+		if len(parts) != 2 {
 			return fmt.Errorf("invalid tree format: %s", header)
 		}
 
 		mode := parts[0]
 		name := parts[1]
+		if mode == "40000" {
+			mode = "040000"
+		}
 
 		hashStart := nullIdx + 1
-		if len(data) < hashStart+20 {
+		if len(data) < hashStart+hashSize {
 			return fmt.Errorf("invalid tree format: not enough data for hash")
 		}
 
-		hashBytes := data[hashStart : hashStart+20]
+		hashBytes := data[hashStart : hashStart+hashSize]
 		hash := fmt.Sprintf("%x", hashBytes)
 
 		t.AddEntry(mode, name, hash)
 
-		data = data[hashStart+20:] //Move to next extry
+		data = data[hashStart+hashSize:] //Move to next extry
 	}
 	return nil
 }
 
+// IsDir reports whether the entry points at a subtree
+func (e TreeEntry) IsDir() bool {
+	return e.Mode == "040000" || e.Mode == "40000"
+}
+
+// SortKey is the name Git orders tree entries by: subtrees sort as if their
+// name ended in a slash
+func (e TreeEntry) SortKey() string {
+	if e.IsDir() {
+		return e.Name + "/"
+	}
+
+	return e.Name
+}
+
 func HexToBytes(hexStr string) ([]byte, error) {
-	if len(hexStr) != 40 {
-		return nil, fmt.Errorf("hash must be 40 characters, got %d", len(hexStr))
+	if len(hexStr) != SHA1.HexSize() && len(hexStr) != SHA256.HexSize() {
+		return nil, fmt.Errorf("hash must be %d or %d characters, got %d", SHA1.HexSize(), SHA256.HexSize(), len(hexStr))
 	}
 
-	bytes := make([]byte, 20)
-	for i := 0; i < 20; i++ {
+	bytes := make([]byte, len(hexStr)/2)
+	for i := 0; i < len(bytes); i++ {
 		b, err := strconv.ParseUint(hexStr[i*2:i*2+2], 16, 8) //Each slice is one byte (2 hex)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,211 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	deltaBlockSize = 16
+	maxCopySize    = 0x10000
+	maxInsertSize  = 0x7f
+	maxCandidates  = 4
+)
+
+// CreateDelta encodes target as a sequence of copy and insert instructions
+// against base, using Git's delta format
+func CreateDelta(base, target []byte) []byte {
+	var buf bytes.Buffer
+	writeVarint(&buf, len(base))
+	writeVarint(&buf, len(target))
+
+	blocks := make(map[uint32][]int)
+	for off := 0; off+deltaBlockSize <= len(base); off += deltaBlockSize {
+		h := blockHash(base[off : off+deltaBlockSize])
+		if len(blocks[h]) < maxCandidates {
+			blocks[h] = append(blocks[h], off)
+		}
+	}
+
+	var insert []byte
+	flush := func() {
+		if len(insert) == 0 {
+			return
+		}
+		buf.WriteByte(byte(len(insert)))
+		buf.Write(insert)
+		insert = insert[:0]
+	}
+
+	for i := 0; i < len(target); {
+		bestOff, bestLen := 0, 0
+
+		if i+deltaBlockSize <= len(target) {
+			for _, off := range blocks[blockHash(target[i:i+deltaBlockSize])] {
+				n := matchLength(base[off:], target[i:])
+				if n > bestLen {
+					bestOff, bestLen = off, n
+				}
+			}
+		}
+
+		if bestLen < deltaBlockSize {
+			insert = append(insert, target[i])
+			if len(insert) == maxInsertSize {
+				flush()
+			}
+			i++
+			continue
+		}
+
+		flush()
+		for n := bestLen; n > 0; {
+			size := n
+			if size > maxCopySize {
+				size = maxCopySize
+			}
+			writeCopy(&buf, bestOff, size)
+			bestOff += size
+			n -= size
+		}
+		i += bestLen
+	}
+	flush()
+
+	return buf.Bytes()
+}
+
+// ApplyDelta reconstructs the target object from base and a delta
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, pos, err := readVarint(delta, 0)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("delta base size mismatch: expected %d, got %d", srcSize, len(base))
+	}
+
+	trgSize, pos, err := readVarint(delta, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, trgSize)
+
+	for pos < len(delta) {
+		op := delta[pos]
+		pos++
+
+		switch {
+		case op&0x80 != 0:
+			var offset, size int
+			for b := 0; b < 4; b++ {
+				if op&(1<<b) != 0 {
+					if pos >= len(delta) {
+						return nil, fmt.Errorf("truncated delta copy instruction")
+					}
+					offset |= int(delta[pos]) << (8 * b)
+					pos++
+				}
+			}
+			for b := 0; b < 3; b++ {
+				if op&(1<<(4+b)) != 0 {
+					if pos >= len(delta) {
+						return nil, fmt.Errorf("truncated delta copy instruction")
+					}
+					size |= int(delta[pos]) << (8 * b)
+					pos++
+				}
+			}
+			if size == 0 {
+				size = maxCopySize
+			}
+			if offset+size > len(base) {
+				return nil, fmt.Errorf("delta copy out of bounds")
+			}
+			out = append(out, base[offset:offset+size]...)
+		case op != 0:
+			n := int(op)
+			if pos+n > len(delta) {
+				return nil, fmt.Errorf("truncated delta insert instruction")
+			}
+			out = append(out, delta[pos:pos+n]...)
+			pos += n
+		default:
+			return nil, fmt.Errorf("invalid delta opcode 0")
+		}
+	}
+
+	if len(out) != trgSize {
+		return nil, fmt.Errorf("delta result size mismatch: expected %d, got %d", trgSize, len(out))
+	}
+
+	return out, nil
+}
+
+func writeCopy(buf *bytes.Buffer, offset, size int) {
+	op := byte(0x80)
+	var args []byte
+
+	for b := 0; b < 4; b++ {
+		if v := byte(offset >> (8 * b)); v != 0 {
+			op |= 1 << b
+			args = append(args, v)
+		}
+	}
+
+	if size != maxCopySize {
+		for b := 0; b < 3; b++ {
+			if v := byte(size >> (8 * b)); v != 0 {
+				op |= 1 << (4 + b)
+				args = append(args, v)
+			}
+		}
+	}
+
+	buf.WriteByte(op)
+	buf.Write(args)
+}
+
+func writeVarint(buf *bytes.Buffer, n int) {
+	for n >= 0x80 {
+		buf.WriteByte(byte(n) | 0x80)
+		n >>= 7
+	}
+	buf.WriteByte(byte(n))
+}
+
+func readVarint(data []byte, pos int) (int, int, error) {
+	n, shift := 0, uint(0)
+	for {
+		if pos >= len(data) {
+			return 0, pos, fmt.Errorf("truncated delta header")
+		}
+		c := data[pos]
+		pos++
+		n |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return n, pos, nil
+		}
+	}
+}
+
+func blockHash(block []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range block {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+
+	return h
+}
+
+func matchLength(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
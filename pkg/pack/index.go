@@ -0,0 +1,169 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+var indexSignature = []byte{0xff, 't', 'O', 'c'}
+
+const indexVersion = 2
+
+type indexEntry struct {
+	Hash   string
+	Offset int64
+	CRC    uint32
+}
+
+// Index is the in-memory form of a version 2 .idx file. Object names and
+// checksums use the repository's hash algorithm.
+type Index struct {
+	Hashes       []string
+	Offsets      []int64
+	CRCs         []uint32
+	PackChecksum string
+	Algorithm    objects.HashAlgorithm
+}
+
+func ReadIndex(path string, algo objects.HashAlgorithm) (*Index, error) {
+	hashSize := algo.Size()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", err)
+	}
+
+	if len(data) < 8+256*4+2*hashSize || !bytes.Equal(data[:4], indexSignature) {
+		return nil, fmt.Errorf("invalid pack index: %s", path)
+	}
+
+	if v := binary.BigEndian.Uint32(data[4:8]); v != indexVersion {
+		return nil, fmt.Errorf("unsupported pack index version: %d", v)
+	}
+
+	fanout := data[8 : 8+256*4]
+	count := int(binary.BigEndian.Uint32(fanout[255*4:]))
+
+	pos := 8 + 256*4
+	need := pos + count*(hashSize+4+4) + 2*hashSize
+	if len(data) < need {
+		return nil, fmt.Errorf("truncated pack index: %s", path)
+	}
+
+	idx := &Index{
+		Hashes:    make([]string, count),
+		Offsets:   make([]int64, count),
+		CRCs:      make([]uint32, count),
+		Algorithm: algo,
+	}
+
+	for i := 0; i < count; i++ {
+		idx.Hashes[i] = hex.EncodeToString(data[pos : pos+hashSize])
+		pos += hashSize
+	}
+
+	for i := 0; i < count; i++ {
+		idx.CRCs[i] = binary.BigEndian.Uint32(data[pos:])
+		pos += 4
+	}
+
+	offsetTable := pos
+	largeTable := offsetTable + count*4
+
+	for i := 0; i < count; i++ {
+		off := binary.BigEndian.Uint32(data[offsetTable+i*4:])
+		if off&0x80000000 == 0 {
+			idx.Offsets[i] = int64(off)
+			continue
+		}
+
+		large := largeTable + int(off&0x7fffffff)*8
+		if large+8 > len(data)-2*hashSize {
+			return nil, fmt.Errorf("invalid large offset in pack index: %s", path)
+		}
+		idx.Offsets[i] = int64(binary.BigEndian.Uint64(data[large:]))
+	}
+
+	trailer := data[len(data)-2*hashSize:]
+	idx.PackChecksum = hex.EncodeToString(trailer[:hashSize])
+
+	h := algo.New()
+	h.Write(data[:len(data)-hashSize])
+	if !bytes.Equal(h.Sum(nil), trailer[hashSize:]) {
+		return nil, fmt.Errorf("pack index checksum mismatch: %s", path)
+	}
+
+	return idx, nil
+}
+
+// Find returns the pack offset of the object with the given hash
+func (idx *Index) Find(hash string) (int64, bool) {
+	i := sort.SearchStrings(idx.Hashes, hash)
+	if i < len(idx.Hashes) && idx.Hashes[i] == hash {
+		return idx.Offsets[i], true
+	}
+
+	return 0, false
+}
+
+func writeIndex(w io.Writer, algo objects.HashAlgorithm, entries []indexEntry, packChecksum []byte) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Hash < entries[j].Hash
+	})
+
+	var buf bytes.Buffer
+	buf.Write(indexSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(indexVersion))
+
+	var fanout [256]uint32
+	for _, entry := range entries {
+		first, err := hex.DecodeString(entry.Hash[:2])
+		if err != nil {
+			return fmt.Errorf("invalid object hash %s: %w", entry.Hash, err)
+		}
+		for b := int(first[0]); b < 256; b++ {
+			fanout[b]++
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+
+	for _, entry := range entries {
+		raw, err := hex.DecodeString(entry.Hash)
+		if err != nil || len(raw) != algo.Size() {
+			return fmt.Errorf("invalid object hash %s", entry.Hash)
+		}
+		buf.Write(raw)
+	}
+
+	for _, entry := range entries {
+		binary.Write(&buf, binary.BigEndian, entry.CRC)
+	}
+
+	var large []uint64
+	for _, entry := range entries {
+		if entry.Offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(entry.Offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, uint64(entry.Offset))
+	}
+	for _, off := range large {
+		binary.Write(&buf, binary.BigEndian, off)
+	}
+
+	buf.Write(packChecksum)
+	h := algo.New()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
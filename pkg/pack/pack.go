@@ -0,0 +1,134 @@
+package pack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+// Object type codes as stored in pack entry headers
+const (
+	typeCommit   = 1
+	typeTree     = 2
+	typeBlob     = 3
+	typeTag      = 4
+	typeOfsDelta = 6
+	typeRefDelta = 7
+)
+
+const (
+	packSignature = "PACK"
+	packVersion   = 2
+)
+
+func typeCode(t objects.ObjectType) (int, error) {
+	switch t {
+	case objects.CommitObject:
+		return typeCommit, nil
+	case objects.TreeObject:
+		return typeTree, nil
+	case objects.BlobObject:
+		return typeBlob, nil
+	case objects.TagObject:
+		return typeTag, nil
+	default:
+		return 0, fmt.Errorf("unsupported object type: %s", t)
+	}
+}
+
+func objectType(code int) (objects.ObjectType, error) {
+	switch code {
+	case typeCommit:
+		return objects.CommitObject, nil
+	case typeTree:
+		return objects.TreeObject, nil
+	case typeBlob:
+		return objects.BlobObject, nil
+	case typeTag:
+		return objects.TagObject, nil
+	default:
+		return "", fmt.Errorf("unsupported pack object type: %d", code)
+	}
+}
+
+// NameHash returns Git's pack name hash, which groups objects whose paths
+// end in the same characters so that similar files land next to each other
+func NameHash(name string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		hash = (hash >> 2) + (uint32(c) << 24)
+	}
+
+	return hash
+}
+
+func encodeHeader(code int, size int) []byte {
+	b := byte(code<<4) | byte(size&0x0f)
+	size >>= 4
+
+	var out []byte
+	for size != 0 {
+		out = append(out, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+
+	return append(out, b)
+}
+
+func readHeader(r *bufio.Reader) (int, int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	code := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	shift := uint(4)
+
+	for c&0x80 != 0 {
+		c, err = r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		size |= int64(c&0x7f) << shift
+		shift += 7
+	}
+
+	return code, size, nil
+}
+
+// encodeOffset writes the distance back to an OFS_DELTA base
+func encodeOffset(offset int64) []byte {
+	buf := []byte{byte(offset & 0x7f)}
+	for offset >>= 7; offset != 0; offset >>= 7 {
+		offset--
+		buf = append([]byte{byte(0x80 | (offset & 0x7f))}, buf...)
+	}
+
+	return buf
+}
+
+func readOffset(r io.ByteReader) (int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	offset := int64(c & 0x7f)
+	for c&0x80 != 0 {
+		c, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		offset = ((offset + 1) << 7) | int64(c&0x7f)
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,251 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+// Pack gives random access to the objects of a .pack file through its .idx
+type Pack struct {
+	Path  string
+	Index *Index
+	file  *os.File
+	size  int64
+}
+
+// Open opens the pack at packPath, which must have a matching .idx next to it
+func Open(packPath string, algo objects.HashAlgorithm) (*Pack, error) {
+	idx, err := ReadIndex(strings.TrimSuffix(packPath, ".pack")+".idx", algo)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat pack: %w", err)
+	}
+
+	header := make([]byte, 12)
+	if _, err := file.ReadAt(header, 0); err != nil || string(header[:4]) != packSignature {
+		file.Close()
+		return nil, fmt.Errorf("invalid pack file: %s", packPath)
+	}
+
+	return &Pack{
+		Path:  packPath,
+		Index: idx,
+		file:  file,
+		size:  info.Size(),
+	}, nil
+}
+
+func (p *Pack) Close() error {
+	return p.file.Close()
+}
+
+func (p *Pack) Has(hash string) bool {
+	_, ok := p.Index.Find(hash)
+	return ok
+}
+
+// Read returns the fully resolved type and content of an object
+func (p *Pack) Read(hash string) (objects.ObjectType, []byte, error) {
+	offset, ok := p.Index.Find(hash)
+	if !ok {
+		return "", nil, fmt.Errorf("object %s not in pack", hash)
+	}
+
+	return p.readAt(offset)
+}
+
+func (p *Pack) readAt(offset int64) (objects.ObjectType, []byte, error) {
+	r := bufio.NewReader(io.NewSectionReader(p.file, offset, p.size-offset))
+
+	code, size, err := readHeader(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read pack entry header: %w", err)
+	}
+
+	switch code {
+	case typeOfsDelta:
+		rel, err := readOffset(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read delta offset: %w", err)
+		}
+		if rel <= 0 || rel > offset {
+			return "", nil, fmt.Errorf("invalid delta base offset at %d", offset)
+		}
+
+		baseType, base, err := p.readAt(offset - rel)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return p.applyDelta(r, size, baseType, base)
+	case typeRefDelta:
+		raw := make([]byte, p.Index.Algorithm.Size())
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return "", nil, fmt.Errorf("failed to read delta base: %w", err)
+		}
+
+		baseType, base, err := p.Read(fmt.Sprintf("%x", raw))
+		if err != nil {
+			return "", nil, err
+		}
+
+		return p.applyDelta(r, size, baseType, base)
+	default:
+		objType, err := objectType(code)
+		if err != nil {
+			return "", nil, err
+		}
+
+		data, err := inflate(r, size)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return objType, data, nil
+	}
+}
+
+func (p *Pack) applyDelta(r io.Reader, size int64, baseType objects.ObjectType, base []byte) (objects.ObjectType, []byte, error) {
+	delta, err := inflate(r, size)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := ApplyDelta(base, delta)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to apply delta: %w", err)
+	}
+
+	return baseType, data, nil
+}
+
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress pack entry: %w", err)
+	}
+	defer zr.Close()
+
+	var buf bytes.Buffer
+	buf.Grow(int(size))
+	if _, err := io.CopyN(&buf, zr, size); err != nil {
+		return nil, fmt.Errorf("failed to decompress pack entry: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Verify checks the pack's trailing checksum against its content and its
+// index, and the CRC of every entry recorded in the index
+func (p *Pack) Verify() error {
+	hashSize := int64(p.Index.Algorithm.Size())
+	if p.size < 12+hashSize {
+		return fmt.Errorf("pack %s is truncated", p.Path)
+	}
+
+	body := io.NewSectionReader(p.file, 0, p.size-hashSize)
+	sum := p.Index.Algorithm.New()
+	if _, err := io.Copy(sum, body); err != nil {
+		return fmt.Errorf("failed to read pack %s: %w", p.Path, err)
+	}
+
+	trailer := make([]byte, hashSize)
+	if _, err := p.file.ReadAt(trailer, p.size-hashSize); err != nil {
+		return fmt.Errorf("failed to read pack trailer: %w", err)
+	}
+
+	if !bytes.Equal(sum.Sum(nil), trailer) {
+		return fmt.Errorf("pack %s checksum mismatch", p.Path)
+	}
+	if hex.EncodeToString(trailer) != p.Index.PackChecksum {
+		return fmt.Errorf("pack %s does not match its index", p.Path)
+	}
+
+	order := make([]int, len(p.Index.Offsets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return p.Index.Offsets[order[a]] < p.Index.Offsets[order[b]]
+	})
+
+	for n, i := range order {
+		start := p.Index.Offsets[i]
+		end := p.size - hashSize
+		if n+1 < len(order) {
+			end = p.Index.Offsets[order[n+1]]
+		}
+
+		entry := make([]byte, end-start)
+		if _, err := p.file.ReadAt(entry, start); err != nil {
+			return fmt.Errorf("failed to read pack entry %s: %w", p.Index.Hashes[i], err)
+		}
+
+		if crc32.ChecksumIEEE(entry) != p.Index.CRCs[i] {
+			return fmt.Errorf("pack entry %s has a bad CRC", p.Index.Hashes[i])
+		}
+	}
+
+	return nil
+}
+
+// OpenStream returns a reader over an object's content. Whole objects are
+// inflated as they are read; deltified objects have to be resolved in memory
+// first.
+func (p *Pack) OpenStream(hash string) (objects.ObjectType, int64, io.ReadCloser, error) {
+	offset, ok := p.Index.Find(hash)
+	if !ok {
+		return "", 0, nil, fmt.Errorf("object %s not in pack", hash)
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(p.file, offset, p.size-offset))
+	code, size, err := readHeader(r)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("failed to read pack entry header: %w", err)
+	}
+
+	if code == typeOfsDelta || code == typeRefDelta {
+		objType, data, err := p.readAt(offset)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return objType, int64(len(data)), io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	objType, err := objectType(code)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("failed to decompress pack entry: %w", err)
+	}
+
+	return objType, size, &limitedReadCloser{Reader: io.LimitReader(zr, size), Closer: zr}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,197 @@
+package pack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+const (
+	DefaultWindow = 10
+	DefaultDepth  = 50
+
+	// Objects smaller than this are never worth deltifying
+	minDeltaSize = 50
+)
+
+// Object is a single object to be written into a pack. Name is the path the
+// object was found at, used to pick likely delta bases.
+type Object struct {
+	Hash string
+	Type objects.ObjectType
+	Data []byte
+	Name string
+}
+
+type Options struct {
+	Window    int
+	Depth     int
+	Algorithm objects.HashAlgorithm
+}
+
+// Write stores objs as a new pack in dir named <prefix>-<checksum>.pack along
+// with its .idx, and returns the pack checksum
+func Write(dir, prefix string, objs []*Object, opts Options) (string, error) {
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = DefaultDepth
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = objects.SHA1
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	sorted := make([]*Object, len(objs))
+	copy(sorted, objs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if ha, hb := NameHash(a.Name), NameHash(b.Name); ha != hb {
+			return ha < hb
+		}
+		return len(a.Data) > len(b.Data)
+	})
+
+	bases, deltas := findDeltas(sorted, opts)
+
+	packFile, err := os.CreateTemp(dir, "tmp_pack_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create pack file: %w", err)
+	}
+	defer os.Remove(packFile.Name())
+	defer packFile.Close()
+
+	checksum := opts.Algorithm.New()
+	w := io.MultiWriter(packFile, checksum)
+
+	var header bytes.Buffer
+	header.WriteString(packSignature)
+	binary.Write(&header, binary.BigEndian, uint32(packVersion))
+	binary.Write(&header, binary.BigEndian, uint32(len(sorted)))
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return "", fmt.Errorf("failed to write pack header: %w", err)
+	}
+
+	offset := int64(header.Len())
+	offsets := make([]int64, len(sorted))
+	entries := make([]indexEntry, len(sorted))
+
+	for i, obj := range sorted {
+		var entry bytes.Buffer
+		payload := obj.Data
+
+		if bases[i] >= 0 {
+			payload = deltas[i]
+			entry.Write(encodeHeader(typeOfsDelta, len(payload)))
+			entry.Write(encodeOffset(offset - offsets[bases[i]]))
+		} else {
+			code, err := typeCode(obj.Type)
+			if err != nil {
+				return "", err
+			}
+			entry.Write(encodeHeader(code, len(payload)))
+		}
+
+		zw := zlib.NewWriter(&entry)
+		if _, err := zw.Write(payload); err != nil {
+			return "", fmt.Errorf("failed to compress object %s: %w", obj.Hash, err)
+		}
+		if err := zw.Close(); err != nil {
+			return "", fmt.Errorf("failed to compress object %s: %w", obj.Hash, err)
+		}
+
+		if _, err := w.Write(entry.Bytes()); err != nil {
+			return "", fmt.Errorf("failed to write pack entry: %w", err)
+		}
+
+		offsets[i] = offset
+		entries[i] = indexEntry{
+			Hash:   obj.Hash,
+			Offset: offset,
+			CRC:    crc32.ChecksumIEEE(entry.Bytes()),
+		}
+		offset += int64(entry.Len())
+	}
+
+	sum := checksum.Sum(nil)
+	if _, err := packFile.Write(sum); err != nil {
+		return "", fmt.Errorf("failed to write pack trailer: %w", err)
+	}
+	if err := packFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to close pack file: %w", err)
+	}
+
+	name := hex.EncodeToString(sum)
+	base := filepath.Join(dir, fmt.Sprintf("%s-%s", prefix, name))
+
+	var idx bytes.Buffer
+	if err := writeIndex(&idx, opts.Algorithm, entries, sum); err != nil {
+		return "", fmt.Errorf("failed to build pack index: %w", err)
+	}
+
+	if err := os.Rename(packFile.Name(), base+".pack"); err != nil {
+		return "", fmt.Errorf("failed to move pack file: %w", err)
+	}
+
+	if err := os.WriteFile(base+".idx", idx.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("failed to write pack index: %w", err)
+	}
+
+	return name, nil
+}
+
+// findDeltas picks a delta base for each object by trying the previous
+// Window objects of the same type and keeping the smallest delta. Bases
+// always come earlier in objs, so they are written first.
+func findDeltas(objs []*Object, opts Options) ([]int, [][]byte) {
+	bases := make([]int, len(objs))
+	deltas := make([][]byte, len(objs))
+	depths := make([]int, len(objs))
+
+	for i, obj := range objs {
+		bases[i] = -1
+		if len(obj.Data) < minDeltaSize {
+			continue
+		}
+
+		limit := len(obj.Data)/2 - 20
+
+		for j := i - 1; j >= 0 && j >= i-opts.Window; j-- {
+			base := objs[j]
+			if base.Type != obj.Type {
+				break
+			}
+			if depths[j] >= opts.Depth || len(base.Data) < len(obj.Data)/32 {
+				continue
+			}
+
+			delta := CreateDelta(base.Data, obj.Data)
+			if len(delta) >= limit {
+				continue
+			}
+
+			bases[i] = j
+			deltas[i] = delta
+			depths[i] = depths[j] + 1
+			limit = len(delta)
+		}
+	}
+
+	return bases, deltas
+}
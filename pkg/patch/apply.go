@@ -0,0 +1,85 @@
+package patch
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/diff"
+)
+
+// Apply returns the content of the file after the patch, given what it was
+// before. Hunks that no longer sit at the lines they name are looked for
+// nearby, nearest first, as "git apply" does.
+func (f *File) Apply(old []byte) ([]byte, error) {
+	image := diff.SplitLines(string(old))
+	offset := 0
+	// floor keeps a hunk from matching what an earlier one produced
+	floor := 0
+
+	for _, h := range f.Hunks {
+		var pre, post []string
+		trailing := 0
+		for _, line := range h.Lines {
+			if line.Op != diff.Insert {
+				pre = append(pre, line.Text)
+			}
+			if line.Op != diff.Delete {
+				post = append(post, line.Text)
+			}
+			if line.Op == diff.Equal {
+				trailing++
+			} else {
+				trailing = 0
+			}
+		}
+
+		// A hunk at the top of the file must match there, and one without
+		// trailing context must match at the end, unless that fails
+		atStart := h.OldPos == 0
+		atEnd := trailing == 0
+
+		pos := findHunk(image, pre, h.OldPos+offset, floor, atStart, atEnd)
+		if pos < 0 && (atStart || atEnd) {
+			pos = findHunk(image, pre, h.OldPos+offset, floor, false, false)
+		}
+		if pos < 0 {
+			return nil, fmt.Errorf("patch failed: %s:%d", f.Path(), h.OldPos+1)
+		}
+
+		image = slices.Concat(image[:pos], post, image[pos+len(pre):])
+		offset = pos - h.OldPos + len(post) - len(pre)
+		floor = pos + len(post)
+	}
+
+	return []byte(strings.Join(image, "")), nil
+}
+
+// findHunk looks for the preimage lines in image at or after floor,
+// starting from where they are expected and moving outwards. It returns -1
+// if they are not there.
+func findHunk(image, pre []string, expected, floor int, atStart, atEnd bool) int {
+	last := len(image) - len(pre)
+	if last < floor {
+		return -1
+	}
+	expected = max(floor, min(expected, last))
+
+	matches := func(pos int) bool {
+		if atStart && pos != 0 || atEnd && pos != last {
+			return false
+		}
+		return slices.Equal(image[pos:pos+len(pre)], pre)
+	}
+
+	for delta := 0; expected-delta >= floor || expected+delta <= last; delta++ {
+		if pos := expected - delta; pos >= floor && matches(pos) {
+			return pos
+		}
+		if pos := expected + delta; delta > 0 && pos <= last && matches(pos) {
+			return pos
+		}
+	}
+
+	return -1
+}
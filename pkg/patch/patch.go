@@ -0,0 +1,251 @@
+package patch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/diff"
+)
+
+// File is the change a Git diff makes to one file
+type File struct {
+	OldPath string
+	NewPath string
+	// OldMode and NewMode are empty when the diff does not give them
+	OldMode string
+	NewMode string
+	// OldHash and NewHash are the abbreviated blob names from the index
+	// line, empty when there is none
+	OldHash string
+	NewHash string
+	New     bool
+	Deleted bool
+	// Renamed is set when OldPath goes away; a copy keeps it
+	Renamed bool
+	// Binary is set for "Binary files ... differ", which has no hunks
+	Binary bool
+	Hunks  []diff.Hunk
+}
+
+// Path is where the file ends up, or where it was for a deletion
+func (f *File) Path() string {
+	if f.Deleted {
+		return f.OldPath
+	}
+
+	return f.NewPath
+}
+
+// Parse reads the files of a patch in Git's unified diff format. Text
+// before the first "diff --git" line and after the last hunk is ignored.
+func Parse(text string) ([]*File, error) {
+	lines := diff.SplitLines(text)
+
+	var files []*File
+	for i := 0; i < len(lines); {
+		if !strings.HasPrefix(lines[i], "diff --git ") {
+			i++
+			continue
+		}
+
+		f, next, err := parseFile(lines, i)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		i = next
+	}
+
+	return files, nil
+}
+
+// parseFile reads one file's diff starting at its "diff --git" line and
+// returns where the next one may start
+func parseFile(lines []string, i int) (*File, int, error) {
+	header := strings.TrimSuffix(lines[i], "\n")
+	f := &File{}
+	if err := f.parseGitHeader(strings.TrimPrefix(header, "diff --git ")); err != nil {
+		return nil, 0, err
+	}
+
+	for i++; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\n")
+
+		switch {
+		case strings.HasPrefix(line, "new file mode "):
+			f.New = true
+			f.NewMode = strings.TrimPrefix(line, "new file mode ")
+		case strings.HasPrefix(line, "deleted file mode "):
+			f.Deleted = true
+			f.OldMode = strings.TrimPrefix(line, "deleted file mode ")
+		case strings.HasPrefix(line, "old mode "):
+			f.OldMode = strings.TrimPrefix(line, "old mode ")
+		case strings.HasPrefix(line, "new mode "):
+			f.NewMode = strings.TrimPrefix(line, "new mode ")
+		case strings.HasPrefix(line, "rename from "), strings.HasPrefix(line, "copy from "):
+			f.Renamed = strings.HasPrefix(line, "rename ")
+			_, f.OldPath, _ = strings.Cut(line, " from ")
+		case strings.HasPrefix(line, "rename to "), strings.HasPrefix(line, "copy to "):
+			_, f.NewPath, _ = strings.Cut(line, " to ")
+		case strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, "index "):
+			f.parseIndex(strings.TrimPrefix(line, "index "))
+		case strings.HasPrefix(line, "Binary files "), line == "GIT binary patch":
+			f.Binary = true
+			return f, i + 1, nil
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+		case strings.HasPrefix(line, "@@ "):
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %w", f.Path(), err)
+			}
+			f.Hunks = append(f.Hunks, h)
+			i = next - 1
+		default:
+			return f, i, nil
+		}
+	}
+
+	return f, i, nil
+}
+
+// parseGitHeader takes the paths from "a/<old> b/<new>". When they are the
+// same, as they are unless the file was renamed, the split is found by
+// looking for the two halves to match.
+func (f *File) parseGitHeader(paths string) error {
+	if !strings.HasPrefix(paths, "a/") {
+		return fmt.Errorf("unsupported diff header: diff --git %s", paths)
+	}
+
+	rest := paths[2:]
+	if n := len(rest); n%2 == 1 && n > 3 {
+		half := rest[:(n-3)/2]
+		if rest[len(half):] == " b/"+half {
+			f.OldPath, f.NewPath = half, half
+			return nil
+		}
+	}
+
+	old, new, ok := strings.Cut(rest, " b/")
+	if !ok {
+		return fmt.Errorf("unsupported diff header: diff --git %s", paths)
+	}
+	f.OldPath, f.NewPath = old, new
+
+	return nil
+}
+
+// parseIndex reads "<old>..<new> [<mode>]"
+func (f *File) parseIndex(value string) {
+	hashes, mode, _ := strings.Cut(value, " ")
+	f.OldHash, f.NewHash, _ = strings.Cut(hashes, "..")
+
+	if mode != "" {
+		if f.OldMode == "" {
+			f.OldMode = mode
+		}
+		if f.NewMode == "" {
+			f.NewMode = mode
+		}
+	}
+}
+
+// parseHunk reads a hunk from its "@@" line, using the line counts to know
+// where it ends
+func parseHunk(lines []string, i int) (diff.Hunk, int, error) {
+	header := strings.TrimSuffix(lines[i], "\n")
+	var h diff.Hunk
+
+	fields := strings.Fields(header)
+	if len(fields) < 4 || fields[3] != "@@" {
+		return h, 0, fmt.Errorf("corrupt hunk header: %s", header)
+	}
+
+	oldPos, oldCount, err1 := parseRange(fields[1], "-")
+	newPos, newCount, err2 := parseRange(fields[2], "+")
+	if err1 != nil || err2 != nil {
+		return h, 0, fmt.Errorf("corrupt hunk header: %s", header)
+	}
+	h.OldPos, h.NewPos = oldPos, newPos
+
+	for i++; oldCount > 0 || newCount > 0; i++ {
+		if i >= len(lines) {
+			return h, 0, fmt.Errorf("truncated hunk: %s", header)
+		}
+
+		line := lines[i]
+		text := line[1:]
+		// Blank context lines often lose their space in mail
+		if line == "\n" {
+			text = "\n"
+			line = " \n"
+		}
+
+		var op diff.Op
+		switch line[0] {
+		case ' ':
+			op = diff.Equal
+			oldCount--
+			newCount--
+		case '-':
+			op = diff.Delete
+			oldCount--
+		case '+':
+			op = diff.Insert
+			newCount--
+		case '\\':
+			noNewline(&h)
+			continue
+		default:
+			return h, 0, fmt.Errorf("corrupt line in hunk %s: %q", header, strings.TrimSuffix(line, "\n"))
+		}
+
+		if oldCount < 0 || newCount < 0 {
+			return h, 0, fmt.Errorf("hunk %s has more lines than it says", header)
+		}
+		h.Lines = append(h.Lines, diff.Line{Op: op, Text: text})
+	}
+
+	if i < len(lines) && strings.HasPrefix(lines[i], "\\") {
+		noNewline(&h)
+		i++
+	}
+
+	return h, i, nil
+}
+
+// noNewline handles "\ No newline at end of file" after the line it marks
+func noNewline(h *diff.Hunk) {
+	if n := len(h.Lines); n > 0 {
+		h.Lines[n-1].Text = strings.TrimSuffix(h.Lines[n-1].Text, "\n")
+	}
+}
+
+// parseRange reads "-<start>[,<count>]" into a 0-based position, where an
+// empty range names the line before it
+func parseRange(field, sign string) (int, int, error) {
+	spec, ok := strings.CutPrefix(field, sign)
+	if !ok {
+		return 0, 0, fmt.Errorf("bad range %s", field)
+	}
+
+	startText, countText, hasCount := strings.Cut(spec, ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := 1
+	if hasCount {
+		if count, err = strconv.Atoi(countText); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if count == 0 {
+		return start, 0, nil
+	}
+
+	return start - 1, count, nil
+}
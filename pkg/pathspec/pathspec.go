@@ -0,0 +1,128 @@
+package pathspec
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Pathspec limits a command to the paths matching any of its patterns. A
+// pattern matches a path equal to it, anything inside it when it names a
+// directory, or, if it has wildcards, any path it globs, with '*' crossing
+// directories as in Git.
+type Pathspec struct {
+	args     []string
+	patterns []string
+	matched  []bool
+}
+
+// New resolves patterns given relative to prefix, the current directory
+// within the work tree ("" at the top). A leading ":/" makes a pattern
+// relative to the top instead.
+func New(prefix string, args []string) (*Pathspec, error) {
+	ps := &Pathspec{
+		args:     args,
+		patterns: make([]string, len(args)),
+		matched:  make([]bool, len(args)),
+	}
+
+	for i, arg := range args {
+		pattern, err := Resolve(prefix, arg)
+		if err != nil {
+			return nil, err
+		}
+		ps.patterns[i] = pattern
+	}
+
+	return ps, nil
+}
+
+// Resolve turns a path given relative to prefix into one from the top of
+// the work tree, "" meaning the top itself
+func Resolve(prefix, arg string) (string, error) {
+	base := prefix
+	if strings.HasPrefix(arg, ":/") {
+		base = ""
+		arg = strings.TrimPrefix(arg, ":/")
+	}
+
+	resolved := path.Clean(path.Join(base, arg))
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", fmt.Errorf("%s: '%s' is outside repository", arg, resolved)
+	}
+	if resolved == "." {
+		resolved = ""
+	}
+
+	return resolved, nil
+}
+
+// Empty reports whether there are no patterns, so everything matches
+func (ps *Pathspec) Empty() bool {
+	return len(ps.patterns) == 0
+}
+
+// Match reports whether a slash-separated path from the top of the work
+// tree matches, remembering which patterns were used
+func (ps *Pathspec) Match(name string) bool {
+	if ps.Empty() {
+		return true
+	}
+
+	found := false
+	for i, pattern := range ps.patterns {
+		if matchPattern(pattern, name) {
+			ps.matched[i] = true
+			found = true
+		}
+	}
+
+	return found
+}
+
+// Unmatched returns the patterns, as given, that no call to Match matched
+func (ps *Pathspec) Unmatched() []string {
+	var unmatched []string
+	for i, matched := range ps.matched {
+		if !matched {
+			unmatched = append(unmatched, ps.args[i])
+		}
+	}
+
+	return unmatched
+}
+
+// HasWildcard reports whether any pattern is a glob
+func (ps *Pathspec) HasWildcard() bool {
+	for _, pattern := range ps.patterns {
+		if HasWildcard(pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Leads reports whether a directory leads to or lies within what a pattern
+// names, so that something below it may match. Globs lead anywhere.
+func (ps *Pathspec) Leads(dir string) bool {
+	if ps.Empty() {
+		return true
+	}
+
+	for _, pattern := range ps.patterns {
+		if HasWildcard(pattern) || matchPattern(pattern, dir) || strings.HasPrefix(pattern, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPattern(pattern, name string) bool {
+	if pattern == "" || pattern == name || strings.HasPrefix(name, pattern+"/") {
+		return true
+	}
+
+	return HasWildcard(pattern) && Wildmatch(pattern, name, false)
+}
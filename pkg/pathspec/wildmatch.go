@@ -0,0 +1,153 @@
+package pathspec
+
+// Wildmatch matches text against a shell glob with Git's rules: '*' and '?'
+// match any character, "[...]" a class, and backslash escapes. With
+// pathname set, '*' and '?' stop at '/' and only a "**" standing as a whole
+// path component crosses directories.
+func Wildmatch(pattern, text string, pathname bool) bool {
+	return wildmatch(pattern, 0, text, 0, pathname)
+}
+
+// HasWildcard reports whether a pattern contains any glob characters
+func HasWildcard(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+
+	return false
+}
+
+func wildmatch(p string, pi int, t string, ti int, pathname bool) bool {
+	for pi < len(p) {
+		switch p[pi] {
+		case '?':
+			if ti >= len(t) || (pathname && t[ti] == '/') {
+				return false
+			}
+			pi++
+			ti++
+
+		case '*':
+			start := pi
+			for pi < len(p) && p[pi] == '*' {
+				pi++
+			}
+
+			crossSlash := !pathname
+			if pathname && pi-start >= 2 && (start == 0 || p[start-1] == '/') && (pi == len(p) || p[pi] == '/') {
+				crossSlash = true
+
+				// "**/" may also match no directories at all
+				if pi < len(p) && wildmatch(p, pi+1, t, ti, pathname) {
+					return true
+				}
+			}
+
+			if pi == len(p) {
+				if crossSlash {
+					return true
+				}
+				for i := ti; i < len(t); i++ {
+					if t[i] == '/' {
+						return false
+					}
+				}
+				return true
+			}
+
+			for i := ti; i <= len(t); i++ {
+				if wildmatch(p, pi, t, i, pathname) {
+					return true
+				}
+				if i < len(t) && !crossSlash && t[i] == '/' {
+					return false
+				}
+			}
+			return false
+
+		case '[':
+			end, ok := classEnd(p, pi)
+			if !ok {
+				if ti >= len(t) || t[ti] != '[' {
+					return false
+				}
+				pi++
+				ti++
+				continue
+			}
+
+			if ti >= len(t) || (pathname && t[ti] == '/') || !matchClass(p[pi+1:end], t[ti]) {
+				return false
+			}
+			pi = end + 1
+			ti++
+
+		default:
+			if p[pi] == '\\' && pi+1 < len(p) {
+				pi++
+			}
+			if ti >= len(t) || t[ti] != p[pi] {
+				return false
+			}
+			pi++
+			ti++
+		}
+	}
+
+	return ti == len(t)
+}
+
+// classEnd finds the ']' closing the class opened at p[start]
+func classEnd(p string, start int) (int, bool) {
+	i := start + 1
+	if i < len(p) && (p[i] == '!' || p[i] == '^') {
+		i++
+	}
+	if i < len(p) && p[i] == ']' {
+		i++
+	}
+
+	for ; i < len(p); i++ {
+		if p[i] == '\\' {
+			i++
+			continue
+		}
+		if p[i] == ']' {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func matchClass(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && (class[0] == '!' || class[0] == '^') {
+		negate = true
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		lo := class[i]
+		if lo == '\\' && i+1 < len(class) {
+			i++
+			lo = class[i]
+		}
+
+		hi := lo
+		if i+2 < len(class) && class[i+1] == '-' {
+			hi = class[i+2]
+			i += 2
+		}
+
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+
+	return matched != negate
+}
@@ -0,0 +1,177 @@
+package reachable
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// Visitor is called once for every reachable object. name is the path the
+// object was first seen at inside a tree, and is empty for commits.
+type Visitor func(hash string, objType objects.ObjectType, name string) error
+
+type item struct {
+	hash    string
+	objType objects.ObjectType
+	name    string
+}
+
+// Walk visits every object reachable from roots, which may be commits or
+// trees. Blobs are reported from their tree entries and are never loaded.
+func Walk(store storage.ObjectStore, roots []string, visit Visitor) error {
+	seen := make(map[string]bool)
+	stack := make([]item, 0, len(roots))
+
+	for _, root := range roots {
+		stack = append(stack, item{hash: root})
+	}
+
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if seen[cur.hash] {
+			continue
+		}
+		seen[cur.hash] = true
+
+		if cur.objType == objects.BlobObject {
+			if err := visit(cur.hash, cur.objType, cur.name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		obj, err := storage.LoadObject(store, cur.hash)
+		if err != nil {
+			return fmt.Errorf("failed to load object %s: %w", cur.hash, err)
+		}
+
+		if err := visit(cur.hash, obj.Type(), cur.name); err != nil {
+			return err
+		}
+
+		switch o := obj.(type) {
+		case *objects.Commit:
+			for _, parent := range o.Parents {
+				stack = append(stack, item{hash: parent, objType: objects.CommitObject})
+			}
+			stack = append(stack, item{hash: o.Tree, objType: objects.TreeObject})
+		case *objects.Tag:
+			stack = append(stack, item{hash: o.Object, objType: o.ObjType})
+		case *objects.Tree:
+			for i := len(o.Entries) - 1; i >= 0; i-- {
+				entry := o.Entries[i]
+				objType := objects.BlobObject
+				if entry.IsDir() {
+					objType = objects.TreeObject
+				} else if entry.Mode == "160000" {
+					continue
+				}
+				stack = append(stack, item{hash: entry.Hash, objType: objType, name: path.Join(cur.name, entry.Name)})
+			}
+		}
+	}
+
+	return nil
+}
+
+// Mark returns every object reachable from HEAD, refs, reflogs and the
+// index, mapped to the path it was first seen at
+func Mark(gitDir string, store storage.ObjectStore) (map[string]string, error) {
+	live, err := IndexObjects(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := Roots(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	logRoots, err := ReflogRoots(gitDir, store)
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, logRoots...)
+
+	err = Walk(store, roots, func(hash string, objType objects.ObjectType, name string) error {
+		if _, ok := live[hash]; !ok {
+			live[hash] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return live, nil
+}
+
+// Roots returns the commits pointed to by HEAD and every ref
+func Roots(gitDir string) ([]string, error) {
+	all, err := refs.ListRefs(gitDir, "refs/")
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]string, 0, len(all)+1)
+	if head, err := refs.ReadRef(gitDir, "HEAD"); err == nil && head != "" {
+		roots = append(roots, head)
+	}
+	for _, hash := range all {
+		roots = append(roots, hash)
+	}
+	sort.Strings(roots)
+
+	return roots, nil
+}
+
+// ReflogRoots returns every existing object mentioned by a reflog entry.
+// Reflogs may also mention commits that are long gone, which are skipped.
+func ReflogRoots(gitDir string, store storage.ObjectStore) ([]string, error) {
+	logs, err := refs.ListReflogs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []string
+	for _, name := range logs {
+		entries, err := refs.ReadReflog(gitDir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			for _, hash := range []string{entry.Old, entry.New} {
+				if strings.Trim(hash, "0") != "" && store.Has(hash) {
+					roots = append(roots, hash)
+				}
+			}
+		}
+	}
+
+	return roots, nil
+}
+
+// IndexObjects returns the blobs staged in the index keyed by hash, with
+// their paths
+func IndexObjects(gitDir string) (map[string]string, error) {
+	idx, err := index.ReadIndex(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		result[entry.Hash] = entry.Path
+	}
+
+	return result, nil
+}
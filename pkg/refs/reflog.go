@@ -0,0 +1,141 @@
+package refs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReflogEntry is one line of a ref's log: the old and new values of the ref,
+// the identity and timestamp of whoever moved it, and a message
+type ReflogEntry struct {
+	Old     string
+	New     string
+	Who     string
+	Message string
+}
+
+func reflogPath(gitDir, refName string) string {
+	return filepath.Join(gitDir, "logs", filepath.FromSlash(refName))
+}
+
+// ReadReflog returns the entries of a ref's log, oldest first. A ref without
+// a log has no entries.
+func ReadReflog(gitDir, refName string) ([]ReflogEntry, error) {
+	file, err := os.Open(reflogPath(gitDir, refName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open reflog for %s: %w", refName, err)
+	}
+	defer file.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		head, message, _ := strings.Cut(line, "\t")
+		parts := strings.SplitN(head, " ", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid reflog line for %s: %s", refName, line)
+		}
+
+		entries = append(entries, ReflogEntry{
+			Old:     parts[0],
+			New:     parts[1],
+			Who:     parts[2],
+			Message: message,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading reflog for %s: %w", refName, err)
+	}
+
+	return entries, nil
+}
+
+// ListReflogs returns the names of all refs that have a log
+func ListReflogs(gitDir string) ([]string, error) {
+	logsDir := filepath.Join(gitDir, "logs")
+	var names []string
+
+	err := filepath.WalkDir(logsDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(logsDir, path)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list reflogs: %w", err)
+	}
+
+	return names, nil
+}
+
+// AppendReflog adds an entry to the end of a ref's log, creating the log if
+// needed
+func AppendReflog(gitDir, refName string, entry ReflogEntry) error {
+	path := reflogPath(gitDir, refName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog for %s: %w", refName, err)
+	}
+
+	if _, err := file.WriteString(formatReflogEntry(entry)); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write reflog for %s: %w", refName, err)
+	}
+
+	return file.Close()
+}
+
+// WriteReflog replaces a ref's log with entries, oldest first. No entries
+// removes the log.
+func WriteReflog(gitDir, refName string, entries []ReflogEntry) error {
+	path := reflogPath(gitDir, refName)
+
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove reflog for %s: %w", refName, err)
+		}
+		return nil
+	}
+
+	var buf strings.Builder
+	for _, entry := range entries {
+		buf.WriteString(formatReflogEntry(entry))
+	}
+
+	if err := os.WriteFile(path, []byte(buf.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write reflog for %s: %w", refName, err)
+	}
+
+	return nil
+}
+
+func formatReflogEntry(entry ReflogEntry) string {
+	return fmt.Sprintf("%s %s %s\t%s\n", entry.Old, entry.New, entry.Who, entry.Message)
+}
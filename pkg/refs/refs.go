@@ -5,41 +5,72 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/repository"
 )
 
+// maxSymrefDepth is how many symbolic refs may be followed in a row, as in
+// Git, so that a loop of them ends in an error
+const maxSymrefDepth = 5
+
 func ReadRef(gitDir, refName string) (string, error) {
-	if refName == "HEAD" {
-		headPath := filepath.Join(gitDir, "HEAD")
-		data, err := os.ReadFile(headPath)
-		if err != nil {
+	return readRef(gitDir, refName, 0)
+}
+
+func readRef(gitDir, refName string, depth int) (string, error) {
+	refPath := filepath.Join(gitDir, refName)
+	data, err := os.ReadFile(refPath)
+	if err != nil {
+		if refName == "HEAD" {
 			return "", fmt.Errorf("failed to read HEAD: %w", err)
 		}
+		if os.IsNotExist(err) {
+			return "", nil // Branch doesnt exist yet
+		}
+
+		return "", fmt.Errorf("failed to read ref %s: %w", refName, err)
+	}
 
-		content := strings.TrimSpace(string(data))
+	content := strings.TrimSpace(string(data))
 
-		// HEAD can be "ref: refs/heads/main" or direct hash
-		if strings.HasPrefix(content, "ref: ") {
-			targetRef := strings.TrimPrefix(content, "ref: ")
-			return ReadRef(gitDir, targetRef)
+	// Any ref, HEAD most often, can be "ref: refs/heads/main" or a direct hash
+	if targetRef, ok := strings.CutPrefix(content, "ref: "); ok {
+		if depth >= maxSymrefDepth {
+			return "", fmt.Errorf("symbolic ref %s nests too deeply", refName)
 		}
+		return readRef(gitDir, targetRef, depth+1)
+	}
 
-		return content, nil
+	if err := checkHash(gitDir, content); err != nil {
+		if refName == "HEAD" {
+			return "", fmt.Errorf("invalid HEAD: %w", err)
+		}
+		return "", fmt.Errorf("invalid ref %s: %w", refName, err)
 	}
 
-	refPath := filepath.Join(gitDir, refName)
-	data, err := os.ReadFile(refPath)
+	return content, nil
+}
+
+// checkHash makes sure a ref value is a full object name in the
+// repository's object format
+func checkHash(gitDir, hash string) error {
+	format, err := repository.ObjectFormat(gitDir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return "", nil // Branch doesnt exist yet
-		}
+		return err
+	}
 
-		return "", fmt.Errorf("failed to read ref %s: %w", refName, err)
+	if !format.IsValid(hash) {
+		return fmt.Errorf("%q is not a valid %s object name", hash, format)
 	}
 
-	return strings.TrimSpace(string(data)), nil
+	return nil
 }
 
 func WriteRef(gitDir, refName, commitHash string) error {
+	if err := checkHash(gitDir, commitHash); err != nil {
+		return err
+	}
+
 	refPath := filepath.Join(gitDir, refName)
 
 	refDir := filepath.Dir(refPath)
@@ -70,3 +101,74 @@ func GetCurrentBranch(gitDir string) (string, error) {
 
 	return "", fmt.Errorf("HEAD is detached (not pointing to a branch)")
 }
+
+// ListRefs returns every ref under refs/ whose name starts with prefix,
+// mapped to the hash it points at
+func ListRefs(gitDir, prefix string) (map[string]string, error) {
+	result := make(map[string]string)
+	refsDir := filepath.Join(gitDir, "refs")
+
+	err := filepath.WalkDir(refsDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(gitDir, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		hash, err := ReadRef(gitDir, name)
+		if err != nil {
+			return err
+		}
+		if hash != "" {
+			result[name] = hash
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	return result, nil
+}
+
+// UpdateHead moves the branch HEAD points at to hash, or HEAD itself when
+// it is detached
+func UpdateHead(gitDir, hash string) error {
+	branch, err := GetCurrentBranch(gitDir)
+	if err != nil {
+		return WriteRef(gitDir, "HEAD", hash)
+	}
+
+	return WriteRef(gitDir, branch, hash)
+}
+
+// DeleteRef removes a ref and its log. A ref that does not exist is not an
+// error.
+func DeleteRef(gitDir, refName string) error {
+	if err := os.Remove(filepath.Join(gitDir, refName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete ref %s: %w", refName, err)
+	}
+
+	return WriteReflog(gitDir, refName, nil)
+}
+
+// SetHead attaches HEAD to a branch, given by its full ref name
+func SetHead(gitDir, branch string) error {
+	if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: "+branch+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write HEAD: %w", err)
+	}
+
+	return nil
+}
@@ -1,21 +1,32 @@
 package repository
+
 import (
 	"fmt"
 	"os"
 	"path/filepath"
-)
+	"sync"
 
+	"github.com/SteliosSpanos/mygit/pkg/config"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
 
 const GitDir = ".git"
 
+// formats remembers each repository's object format, which is fixed when
+// the repository is created, so the config is read once rather than on
+// every ref and index access
+var (
+	formatsMu sync.Mutex
+	formats   = make(map[string]objects.HashAlgorithm)
+)
 
 type Repository struct {
-	WorkTree string
-	GitDir string
+	WorkTree     string
+	GitDir       string
+	ObjectFormat objects.HashAlgorithm
 }
 
-
-func Init(path string) (*Repository, error) {
+func Init(path string, format objects.HashAlgorithm) (*Repository, error) {
 	if path == "" {
 		var err error
 		path, err = os.Getwd()
@@ -30,7 +41,6 @@ func Init(path string) (*Repository, error) {
 		return nil, fmt.Errorf("repository already exists at %s", gitDir)
 	}
 
-
 	dirs := []string{
 		gitDir,
 		filepath.Join(gitDir, "objects"),
@@ -46,16 +56,77 @@ func Init(path string) (*Repository, error) {
 	}
 
 	headPath := filepath.Join(gitDir, "HEAD")
-	headContent :=  "ref: refs/heads/main\n"
+	headContent := "ref: refs/heads/main\n"
 
 	if err := os.WriteFile(headPath, []byte(headContent), 0644); err != nil {
 		return nil, fmt.Errorf("failed to create HEAD: %w", err)
 	}
 
+	if err := writeConfig(gitDir, format); err != nil {
+		return nil, err
+	}
+
+	formatsMu.Lock()
+	formats[filepath.Clean(gitDir)] = format
+	formatsMu.Unlock()
+
 	repo := &Repository{
-		WorkTree: path,
-		GitDir: gitDir,
+		WorkTree:     path,
+		GitDir:       gitDir,
+		ObjectFormat: format,
 	}
 
 	return repo, nil
 }
+
+// writeConfig records the repository format. SHA-256 repositories need
+// format version 1 so that Git honors extensions.objectformat.
+func writeConfig(gitDir string, format objects.HashAlgorithm) error {
+	path := filepath.Join(gitDir, "config")
+
+	settings := [][2]string{
+		{"core.repositoryformatversion", "0"},
+		{"core.filemode", "true"},
+		{"core.bare", "false"},
+	}
+
+	if format != objects.SHA1 {
+		settings[0][1] = "1"
+		settings = append(settings, [2]string{"extensions.objectformat", string(format)})
+	}
+
+	for _, setting := range settings {
+		if err := config.Set(path, setting[0], setting[1]); err != nil {
+			return fmt.Errorf("failed to create config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ObjectFormat returns the hash algorithm of the repository at gitDir. A
+// repository without a config file uses SHA-1.
+func ObjectFormat(gitDir string) (objects.HashAlgorithm, error) {
+	key := filepath.Clean(gitDir)
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if format, ok := formats[key]; ok {
+		return format, nil
+	}
+
+	cfg, err := config.LoadFile(filepath.Join(gitDir, "config"), config.ScopeLocal, gitDir)
+	if err != nil {
+		return "", err
+	}
+
+	name, _ := cfg.Get("extensions.objectformat")
+	format, err := objects.ParseHashAlgorithm(name)
+	if err != nil {
+		return "", err
+	}
+
+	formats[key] = format
+	return format, nil
+}
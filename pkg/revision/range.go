@@ -0,0 +1,77 @@
+package revision
+
+import (
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/history"
+)
+
+// Range resolves revision arguments the way "git rev-list" reads them into
+// the commits to walk from and those to stop at: "a..b" is b without a,
+// "a...b" is a and b without their merge bases, "^a" excludes a, and an
+// empty side of ".." or "..." stands for HEAD
+func (r *Resolver) Range(args []string) (include, exclude []string, err error) {
+	for _, arg := range args {
+		if name, ok := strings.CutPrefix(arg, "^"); ok {
+			hash, err := r.Commit(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, hash)
+			continue
+		}
+
+		if left, right, ok := strings.Cut(arg, "..."); ok {
+			a, b, err := r.rangeEnds(left, right)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			bases, err := history.New(r.Store).MergeBases(a, b)
+			if err != nil {
+				return nil, nil, err
+			}
+			include = append(include, a, b)
+			exclude = append(exclude, bases...)
+			continue
+		}
+
+		if left, right, ok := strings.Cut(arg, ".."); ok {
+			a, b, err := r.rangeEnds(left, right)
+			if err != nil {
+				return nil, nil, err
+			}
+			include = append(include, b)
+			exclude = append(exclude, a)
+			continue
+		}
+
+		hash, err := r.Commit(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		include = append(include, hash)
+	}
+
+	return include, exclude, nil
+}
+
+func (r *Resolver) rangeEnds(left, right string) (string, string, error) {
+	if left == "" {
+		left = "HEAD"
+	}
+	if right == "" {
+		right = "HEAD"
+	}
+
+	a, err := r.Commit(left)
+	if err != nil {
+		return "", "", err
+	}
+	b, err := r.Commit(right)
+	if err != nil {
+		return "", "", err
+	}
+
+	return a, b, nil
+}
@@ -0,0 +1,395 @@
+package revision
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/refs"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+	"github.com/SteliosSpanos/mygit/pkg/tree"
+)
+
+// minAbbrev is the shortest hash prefix accepted as an object name
+const minAbbrev = 4
+
+// ErrUnknown is returned for a name that resolves to no object
+var ErrUnknown = errors.New("unknown revision")
+
+// Resolver turns revision expressions into object hashes
+type Resolver struct {
+	GitDir string
+	Store  storage.ObjectStore
+}
+
+func New(gitDir string, store storage.ObjectStore) *Resolver {
+	return &Resolver{GitDir: gitDir, Store: store}
+}
+
+// Resolve understands the usual Git forms: full and abbreviated hashes,
+// HEAD and @, ref names, name@{n} reflog entries, and any chain of ~n, ^n,
+// ^{type} and ^{} suffixes, optionally followed by :path for an entry of
+// the resulting tree
+func (r *Resolver) Resolve(rev string) (string, error) {
+	if rev == "" {
+		return "", fmt.Errorf("empty revision")
+	}
+
+	base, entryPath, hasPath := strings.Cut(rev, ":")
+	if hasPath {
+		return r.resolvePath(base, entryPath)
+	}
+
+	name, suffixes := splitSuffixes(rev)
+
+	hash, err := r.resolveName(name)
+	if err != nil {
+		return "", err
+	}
+
+	for len(suffixes) > 0 {
+		if hash, suffixes, err = r.applySuffix(hash, suffixes); err != nil {
+			return "", fmt.Errorf("%s: %w", rev, err)
+		}
+	}
+
+	return hash, nil
+}
+
+// Commit resolves a revision and peels it to a commit
+func (r *Resolver) Commit(rev string) (string, error) {
+	hash, err := r.Resolve(rev)
+	if err != nil {
+		return "", err
+	}
+
+	return r.Peel(hash, objects.CommitObject)
+}
+
+// Tree resolves a revision and peels it to a tree
+func (r *Resolver) Tree(rev string) (string, error) {
+	hash, err := r.Resolve(rev)
+	if err != nil {
+		return "", err
+	}
+
+	return r.Peel(hash, objects.TreeObject)
+}
+
+// splitSuffixes separates the name from its first ~ or ^ suffix
+func splitSuffixes(rev string) (string, string) {
+	for i := 0; i < len(rev); i++ {
+		if rev[i] == '~' || rev[i] == '^' {
+			return rev[:i], rev[i:]
+		}
+		if rev[i] == '@' && i+1 < len(rev) && rev[i+1] == '{' {
+			end := strings.IndexByte(rev[i:], '}')
+			if end == -1 {
+				break
+			}
+			i += end
+		}
+	}
+
+	return rev, ""
+}
+
+func (r *Resolver) resolveName(name string) (string, error) {
+	if name == "" || name == "@" {
+		name = "HEAD"
+	}
+
+	if at := strings.Index(name, "@{"); at != -1 && strings.HasSuffix(name, "}") {
+		return r.resolveReflog(name[:at], name[at+2:len(name)-1])
+	}
+
+	format := r.Store.Algorithm()
+	if format.IsValid(name) {
+		return name, nil
+	}
+
+	if hash := r.readRef(name); hash != "" {
+		return hash, nil
+	}
+
+	if isHex(name) && len(name) >= minAbbrev && len(name) < format.HexSize() {
+		return r.expandAbbrev(name)
+	}
+
+	return "", fmt.Errorf("%w '%s'", ErrUnknown, name)
+}
+
+// refCandidates lists where Git looks for a short ref name, in order
+func refCandidates(name string) []string {
+	if name == "HEAD" || strings.HasSuffix(name, "_HEAD") {
+		return []string{name}
+	}
+
+	candidates := []string{
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+	}
+	if strings.HasPrefix(name, "refs/") {
+		candidates = append([]string{name}, candidates...)
+	}
+
+	return candidates
+}
+
+// FullRef returns the full name of the ref a short name refers to, or ""
+func (r *Resolver) FullRef(name string) string {
+	for _, candidate := range refCandidates(name) {
+		if hash, err := refs.ReadRef(r.GitDir, candidate); err == nil && hash != "" {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+func (r *Resolver) readRef(name string) string {
+	if full := r.FullRef(name); full != "" {
+		hash, _ := refs.ReadRef(r.GitDir, full)
+		return hash
+	}
+
+	return ""
+}
+
+// resolveReflog finds the value a ref had n moves ago
+func (r *Resolver) resolveReflog(ref, spec string) (string, error) {
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("unsupported reflog selector '@{%s}'", spec)
+	}
+
+	refName := "HEAD"
+	if ref != "" {
+		if refName = r.FullRef(ref); refName == "" {
+			return "", fmt.Errorf("%w '%s'", ErrUnknown, ref)
+		}
+	}
+
+	entries, err := refs.ReadReflog(r.GitDir, refName)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("log for '%s' only has %d entries", refName, len(entries))
+	}
+
+	return entries[len(entries)-1-n].New, nil
+}
+
+func (r *Resolver) expandAbbrev(prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	var found []string
+
+	err := r.Store.Iterate(func(hash string) error {
+		if strings.HasPrefix(hash, prefix) {
+			found = append(found, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%w '%s'", ErrUnknown, prefix)
+	case 1:
+		return found[0], nil
+	}
+
+	return "", fmt.Errorf("short object ID %s is ambiguous", prefix)
+}
+
+// Abbrev shortens a hash to at least length hex digits, more if needed to
+// keep it unambiguous among the objects in the store
+func (r *Resolver) Abbrev(hash string, length int) (string, error) {
+	length = min(max(length, minAbbrev), len(hash))
+
+	err := r.Store.Iterate(func(other string) error {
+		for length < len(hash) && other != hash && strings.HasPrefix(other, hash[:length]) {
+			length++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hash[:length], nil
+}
+
+// applySuffix applies the leading ~n, ^n or ^{type} of suffixes
+func (r *Resolver) applySuffix(hash, suffixes string) (string, string, error) {
+	op := suffixes[0]
+	rest := suffixes[1:]
+
+	if op == '^' && strings.HasPrefix(rest, "{") {
+		end := strings.IndexByte(rest, '}')
+		if end == -1 {
+			return "", "", fmt.Errorf("unterminated ^{")
+		}
+
+		peeled, err := r.peelSpec(hash, rest[1:end])
+		return peeled, rest[end+1:], err
+	}
+
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+
+	n := 1
+	if digits > 0 {
+		n, _ = strconv.Atoi(rest[:digits])
+	}
+	rest = rest[digits:]
+
+	commit, err := r.Peel(hash, objects.CommitObject)
+	if err != nil {
+		return "", "", err
+	}
+
+	if op == '^' {
+		if n == 0 {
+			return commit, rest, nil
+		}
+		parent, err := r.parent(commit, n)
+		return parent, rest, err
+	}
+
+	for i := 0; i < n; i++ {
+		if commit, err = r.parent(commit, 1); err != nil {
+			return "", "", err
+		}
+	}
+
+	return commit, rest, nil
+}
+
+func (r *Resolver) peelSpec(hash, spec string) (string, error) {
+	if spec == "" {
+		return r.peelTags(hash)
+	}
+
+	objType := objects.ObjectType(spec)
+	switch objType {
+	case objects.CommitObject, objects.TreeObject, objects.BlobObject, objects.TagObject:
+		return r.Peel(hash, objType)
+	}
+
+	return "", fmt.Errorf("unknown object type ^{%s}", spec)
+}
+
+func (r *Resolver) parent(commitHash string, n int) (string, error) {
+	commit, err := r.loadCommit(commitHash)
+	if err != nil {
+		return "", err
+	}
+	if n > len(commit.Parents) {
+		return "", fmt.Errorf("commit %s has no parent %d", commitHash[:7], n)
+	}
+
+	return commit.Parents[n-1], nil
+}
+
+// Peel follows tags, and from a commit its tree, until it reaches an object
+// of the wanted type
+func (r *Resolver) Peel(hash string, want objects.ObjectType) (string, error) {
+	for {
+		obj, err := storage.LoadObject(r.Store, hash)
+		if err != nil {
+			return "", err
+		}
+		if obj.Type() == want {
+			return hash, nil
+		}
+
+		switch o := obj.(type) {
+		case *objects.Tag:
+			hash = o.Object
+		case *objects.Commit:
+			if want != objects.TreeObject {
+				return "", fmt.Errorf("object %s is a commit, not a %s", hash, want)
+			}
+			hash = o.Tree
+		default:
+			return "", fmt.Errorf("object %s is a %s, not a %s", hash, obj.Type(), want)
+		}
+	}
+}
+
+func (r *Resolver) peelTags(hash string) (string, error) {
+	for {
+		obj, err := storage.LoadObject(r.Store, hash)
+		if err != nil {
+			return "", err
+		}
+
+		tag, ok := obj.(*objects.Tag)
+		if !ok {
+			return hash, nil
+		}
+		hash = tag.Object
+	}
+}
+
+func (r *Resolver) loadCommit(hash string) (*objects.Commit, error) {
+	obj, err := storage.LoadObject(r.Store, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", hash, obj.Type())
+	}
+
+	return commit, nil
+}
+
+// resolvePath finds the object at a path in a revision's tree
+func (r *Resolver) resolvePath(rev, entryPath string) (string, error) {
+	if rev == "" {
+		return "", fmt.Errorf("index paths are not supported: ':%s'", entryPath)
+	}
+
+	treeHash, err := r.Tree(rev)
+	if err != nil {
+		return "", err
+	}
+
+	entryPath = strings.Trim(entryPath, "/")
+	if entryPath == "" {
+		return treeHash, nil
+	}
+
+	entry, err := tree.Lookup(r.Store, treeHash, entryPath)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", fmt.Errorf("path '%s' does not exist in '%s'", entryPath, rev)
+	}
+
+	return entry.Hash, nil
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+
+	return s != ""
+}
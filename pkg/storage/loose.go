@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+// LooseStore keeps each object zlib-compressed in its own file under
+// <dir>/<first-2-chars>/<remaining-chars>
+type LooseStore struct {
+	dir  string
+	algo objects.HashAlgorithm
+}
+
+func NewLooseStore(dir string, algo objects.HashAlgorithm) *LooseStore {
+	return &LooseStore{dir: dir, algo: algo}
+}
+
+func (s *LooseStore) Algorithm() objects.HashAlgorithm {
+	return s.algo
+}
+
+// Path returns the file an object is or would be stored in
+func (s *LooseStore) Path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash[2:])
+}
+
+func (s *LooseStore) Has(hash string) bool {
+	if len(hash) < 4 {
+		return false
+	}
+
+	_, err := os.Stat(s.Path(hash))
+	return err == nil
+}
+
+func (s *LooseStore) Read(hash string) (objects.ObjectType, []byte, error) {
+	if len(hash) < 4 {
+		return "", nil, fmt.Errorf("invalid object hash: %s", hash)
+	}
+
+	compressed, err := os.ReadFile(s.Path(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, fmt.Errorf("object %s not found", hash)
+		}
+		return "", nil, fmt.Errorf("failed to read object file: %w", err)
+	}
+
+	decompressed, err := objects.Decompress(compressed)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decompress object: %w", err)
+	}
+
+	nullIdx := bytes.IndexByte(decompressed, 0)
+	if nullIdx == -1 {
+		return "", nil, fmt.Errorf("invalid object format: no null byte")
+	}
+
+	header := string(decompressed[:nullIdx])
+	content := decompressed[nullIdx+1:]
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("invalid object header: %s", header)
+	}
+
+	size, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid object size in header: %s", header)
+	}
+
+	if size != len(content) {
+		return "", nil, fmt.Errorf("object %s size mismatch: header says %d, content has %d", hash, size, len(content))
+	}
+
+	objectType := objects.ObjectType(parts[0])
+
+	return objectType, content, nil
+}
+
+func (s *LooseStore) Write(objType objects.ObjectType, data []byte) (string, error) {
+	hash := objects.HashData(s.algo, objType, data)
+
+	header := objects.Header(objType, int64(len(data)))
+	fullData := append([]byte(header), data...)
+
+	compressed, err := objects.Compress(fullData)
+	if err != nil {
+		return "", fmt.Errorf("failed to compress object: %w", err)
+	}
+
+	objectPath := s.Path(hash)
+	objectDir := filepath.Dir(objectPath)
+
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	if err := os.WriteFile(objectPath, compressed, 0644); err != nil {
+		return "", fmt.Errorf("failed to write object file: %w", err)
+	}
+
+	return hash, nil
+}
+
+func (s *LooseStore) Iterate(fn func(hash string) error) error {
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read objects directory: %w", err)
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(s.dir, dir.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read object directory: %w", err)
+		}
+
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), "tmp_") {
+				continue
+			}
+			if err := fn(dir.Name() + file.Name()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// List returns the hashes of all loose objects
+func (s *LooseStore) List() ([]string, error) {
+	var hashes []string
+	err := s.Iterate(func(hash string) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+
+	return hashes, err
+}
+
+func (s *LooseStore) Remove(hash string) error {
+	objectPath := s.Path(hash)
+
+	if err := os.Remove(objectPath); err != nil {
+		return fmt.Errorf("failed to remove object %s: %w", hash, err)
+	}
+
+	// Drop the fan-out directory once it is empty
+	os.Remove(filepath.Dir(objectPath))
+	return nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+type memoryObject struct {
+	objType objects.ObjectType
+	data    []byte
+}
+
+// MemoryStore keeps objects in a map, which is useful for building trees
+// and commits without touching the filesystem
+type MemoryStore struct {
+	algo    objects.HashAlgorithm
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+func NewMemoryStore(algo objects.HashAlgorithm) *MemoryStore {
+	return &MemoryStore{
+		algo:    algo,
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (s *MemoryStore) Algorithm() objects.HashAlgorithm {
+	return s.algo
+}
+
+func (s *MemoryStore) Has(hash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.objects[hash]
+	return ok
+}
+
+func (s *MemoryStore) Read(hash string) (objects.ObjectType, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[hash]
+	if !ok {
+		return "", nil, fmt.Errorf("object %s not found", hash)
+	}
+
+	data := make([]byte, len(obj.data))
+	copy(data, obj.data)
+
+	return obj.objType, data, nil
+}
+
+func (s *MemoryStore) Write(objType objects.ObjectType, data []byte) (string, error) {
+	hash := objects.HashData(s.algo, objType, data)
+
+	stored := make([]byte, len(data))
+	copy(stored, data)
+
+	s.mu.Lock()
+	s.objects[hash] = memoryObject{objType: objType, data: stored}
+	s.mu.Unlock()
+
+	return hash, nil
+}
+
+func (s *MemoryStore) Iterate(fn func(hash string) error) error {
+	s.mu.RLock()
+	hashes := make([]string, 0, len(s.objects))
+	for hash := range s.objects {
+		hashes = append(hashes, hash)
+	}
+	s.mu.RUnlock()
+
+	for _, hash := range hashes {
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
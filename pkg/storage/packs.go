@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/pack"
+)
+
+// PackStore reads objects from every pack in a directory. Packs are
+// immutable, so new objects cannot be written to it directly; use
+// pack.Write to create a new pack instead.
+type PackStore struct {
+	dir   string
+	algo  objects.HashAlgorithm
+	mu    sync.Mutex
+	packs map[string]*pack.Pack
+}
+
+func NewPackStore(dir string, algo objects.HashAlgorithm) *PackStore {
+	return &PackStore{
+		dir:   dir,
+		algo:  algo,
+		packs: make(map[string]*pack.Pack),
+	}
+}
+
+func (s *PackStore) Algorithm() objects.HashAlgorithm {
+	return s.algo
+}
+
+func (s *PackStore) Dir() string {
+	return s.dir
+}
+
+// Packs returns every pack in the directory, reusing packs that were
+// already opened by an earlier call
+func (s *PackStore) Packs() ([]*pack.Pack, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "pack-*.pack"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packs: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	packs := make([]*pack.Pack, 0, len(paths))
+	for _, path := range paths {
+		p, ok := s.packs[path]
+		if !ok {
+			p, err = pack.Open(path, s.algo)
+			if err != nil {
+				return nil, err
+			}
+			s.packs[path] = p
+		}
+		packs = append(packs, p)
+	}
+
+	return packs, nil
+}
+
+func (s *PackStore) Has(hash string) bool {
+	packs, err := s.Packs()
+	if err != nil {
+		return false
+	}
+
+	for _, p := range packs {
+		if p.Has(hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *PackStore) Read(hash string) (objects.ObjectType, []byte, error) {
+	packs, err := s.Packs()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, p := range packs {
+		if p.Has(hash) {
+			return p.Read(hash)
+		}
+	}
+
+	return "", nil, fmt.Errorf("object %s not found", hash)
+}
+
+func (s *PackStore) Write(objType objects.ObjectType, data []byte) (string, error) {
+	return "", fmt.Errorf("pack store is read-only")
+}
+
+func (s *PackStore) Iterate(fn func(hash string) error) error {
+	packs, err := s.Packs()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range packs {
+		for _, hash := range p.Index.Hashes {
+			if err := fn(hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Remove deletes a pack and its index from disk
+func (s *PackStore) Remove(packPath string) error {
+	s.mu.Lock()
+	if p, ok := s.packs[packPath]; ok {
+		p.Close()
+		delete(s.packs, packPath)
+	}
+	s.mu.Unlock()
+
+	base := strings.TrimSuffix(packPath, ".pack")
+	if err := os.Remove(base + ".idx"); err != nil {
+		return fmt.Errorf("failed to remove pack index: %w", err)
+	}
+	if err := os.Remove(packPath); err != nil {
+		return fmt.Errorf("failed to remove pack: %w", err)
+	}
+
+	return nil
+}
@@ -1,81 +1,123 @@
 package storage
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/SteliosSpanos/mygit/pkg/objects"
 )
 
-func WriteObject(gitDir string, obj objects.Object) (string, error) {
-	hash, err := objects.Hash(obj)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash object: %w", err)
-	}
+// ObjectStore is a content-addressed database of Git objects
+type ObjectStore interface {
+	Algorithm() objects.HashAlgorithm
+	Has(hash string) bool
+	Read(hash string) (objects.ObjectType, []byte, error)
+	Write(objType objects.ObjectType, data []byte) (string, error)
+	Iterate(fn func(hash string) error) error
+}
 
-	data, err := obj.Serialize()
-	if err != nil {
-		return "", fmt.Errorf("failed to serialize object: %w", err)
+// VerifyOnRead makes every read check that an object's content hashes to
+// the name it was requested by. It is off by default since it costs a hash
+// computation per read, and can be turned on with MYGIT_VERIFY_OBJECTS=1.
+var VerifyOnRead = os.Getenv("MYGIT_VERIFY_OBJECTS") == "1"
+
+// DiskStore is a repository's object database: loose objects with packs as
+// a fallback. New objects are always written loose.
+type DiskStore struct {
+	Loose *LooseStore
+	Packs *PackStore
+}
+
+// Open returns the object database of the repository at gitDir, naming
+// objects with algo
+func Open(gitDir string, algo objects.HashAlgorithm) *DiskStore {
+	objectsDir := filepath.Join(gitDir, "objects")
+
+	return &DiskStore{
+		Loose: NewLooseStore(objectsDir, algo),
+		Packs: NewPackStore(filepath.Join(objectsDir, "pack"), algo),
 	}
+}
 
-	header := fmt.Sprintf("%s %d\x00", obj.Type(), len(data))
-	fullData := append([]byte(header), data...)
+func (s *DiskStore) Algorithm() objects.HashAlgorithm {
+	return s.Loose.Algorithm()
+}
 
-	compressed, err := objects.Compress(fullData)
-	if err != nil {
-		return "", fmt.Errorf("failed to compress object: %w", err)
+func (s *DiskStore) Has(hash string) bool {
+	return s.Loose.Has(hash) || s.Packs.Has(hash)
+}
+
+func (s *DiskStore) Read(hash string) (objects.ObjectType, []byte, error) {
+	if s.Loose.Has(hash) {
+		return s.Loose.Read(hash)
 	}
 
-	objectDir := filepath.Join(gitDir, "objects", hash[:2])
-	objectPath := filepath.Join(objectDir, hash[2:])
+	return s.Packs.Read(hash)
+}
+
+func (s *DiskStore) Write(objType objects.ObjectType, data []byte) (string, error) {
+	return s.Loose.Write(objType, data)
+}
 
-	if err := os.MkdirAll(objectDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create object directory: %w", err)
+func (s *DiskStore) Iterate(fn func(hash string) error) error {
+	seen := make(map[string]bool)
+	visit := func(hash string) error {
+		if seen[hash] {
+			return nil
+		}
+		seen[hash] = true
+		return fn(hash)
 	}
 
-	if err := os.WriteFile(objectPath, compressed, 0644); err != nil {
-		return "", fmt.Errorf("failed to write object file: %w", err)
+	if err := s.Loose.Iterate(visit); err != nil {
+		return err
 	}
 
-	return hash, nil
+	return s.Packs.Iterate(visit)
 }
 
-func ReadObject(gitDir, hash string) (objects.ObjectType, []byte, error) {
-	objectPath := filepath.Join(gitDir, "objects", hash[:2], hash[2:])
-
-	compressed, err := os.ReadFile(objectPath)
+func WriteObject(store ObjectStore, obj objects.Object) (string, error) {
+	data, err := obj.Serialize()
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read object file: %w", err)
+		return "", fmt.Errorf("failed to serialize object: %w", err)
 	}
 
-	decompressed, err := objects.Decompress(compressed)
+	return store.Write(obj.Type(), data)
+}
+
+func ReadObject(store ObjectStore, hash string) (objects.ObjectType, []byte, error) {
+	objType, content, err := store.Read(hash)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to decompress object: %w", err)
+		return "", nil, err
 	}
 
-	nullIdx := bytes.IndexByte(decompressed, 0)
-	if nullIdx == -1 {
-		return "", nil, fmt.Errorf("invalid object format: no null byte")
+	if VerifyOnRead {
+		if err := checkHash(store.Algorithm(), hash, objType, content); err != nil {
+			return "", nil, err
+		}
 	}
 
-	header := string(decompressed[:nullIdx])
-	content := decompressed[nullIdx+1:]
+	return objType, content, nil
+}
 
-	parts := strings.SplitN(header, " ", 2)
-	if len(parts) != 2 {
-		return "", nil, fmt.Errorf("invalid object header: %s", header)
+// VerifyObject reads an object and checks its hash regardless of
+// VerifyOnRead
+func VerifyObject(store ObjectStore, hash string) (objects.ObjectType, []byte, error) {
+	objType, content, err := store.Read(hash)
+	if err != nil {
+		return "", nil, err
 	}
 
-	objectType := objects.ObjectType(parts[0])
+	if err := checkHash(store.Algorithm(), hash, objType, content); err != nil {
+		return objType, nil, err
+	}
 
-	return objectType, content, nil
+	return objType, content, nil
 }
 
-func LoadObject(gitDir, hash string) (objects.Object, error) {
-	objType, content, err := ReadObject(gitDir, hash)
+func LoadObject(store ObjectStore, hash string) (objects.Object, error) {
+	objType, content, err := ReadObject(store, hash)
 	if err != nil {
 		return nil, err
 	}
@@ -86,9 +128,13 @@ func LoadObject(gitDir, hash string) (objects.Object, error) {
 	case objects.BlobObject:
 		obj = objects.NewBlob(nil)
 	case objects.TreeObject:
-		obj = objects.NewTree()
+		tree := objects.NewTree()
+		tree.Algorithm = store.Algorithm()
+		obj = tree
 	case objects.CommitObject:
 		obj = objects.NewCommit("", "", "")
+	case objects.TagObject:
+		obj = &objects.Tag{}
 	default:
 		return nil, fmt.Errorf("unknown object type: %s", objType)
 	}
@@ -99,3 +145,11 @@ func LoadObject(gitDir, hash string) (objects.Object, error) {
 
 	return obj, nil
 }
+
+func checkHash(algo objects.HashAlgorithm, hash string, objType objects.ObjectType, content []byte) error {
+	if actual := objects.HashData(algo, objType, content); actual != hash {
+		return fmt.Errorf("object %s hash mismatch: content hashes to %s", hash, actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+)
+
+// StreamWriter is implemented by stores that can write an object of known
+// size without holding its content in memory
+type StreamWriter interface {
+	WriteStream(objType objects.ObjectType, size int64, r io.Reader) (string, error)
+}
+
+// StreamReader is implemented by stores that can hand out an object's
+// content as a reader instead of a byte slice
+type StreamReader interface {
+	OpenStream(hash string) (objects.ObjectType, int64, io.ReadCloser, error)
+}
+
+// WriteStream stores size bytes read from r as an object, streaming when the
+// store supports it
+func WriteStream(store ObjectStore, objType objects.ObjectType, size int64, r io.Reader) (string, error) {
+	if sw, ok := store.(StreamWriter); ok {
+		return sw.WriteStream(objType, size, r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read object content: %w", err)
+	}
+	if int64(len(data)) != size {
+		return "", fmt.Errorf("expected %d bytes of content, got %d", size, len(data))
+	}
+
+	return store.Write(objType, data)
+}
+
+// OpenStream returns an object's type, size and a reader over its content.
+// With VerifyOnRead the reader fails at EOF if the content does not match
+// the hash.
+func OpenStream(store ObjectStore, hash string) (objects.ObjectType, int64, io.ReadCloser, error) {
+	var (
+		objType objects.ObjectType
+		size    int64
+		r       io.ReadCloser
+		err     error
+	)
+
+	if sr, ok := store.(StreamReader); ok {
+		objType, size, r, err = sr.OpenStream(hash)
+	} else {
+		var data []byte
+		objType, data, err = store.Read(hash)
+		size = int64(len(data))
+		r = io.NopCloser(bytes.NewReader(data))
+	}
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	if VerifyOnRead {
+		r = &verifyingReader{
+			ReadCloser: r,
+			hash:       hash,
+			hasher:     objects.NewObjectHash(store.Algorithm(), objType, size),
+		}
+	}
+
+	return objType, size, r, nil
+}
+
+func (s *DiskStore) WriteStream(objType objects.ObjectType, size int64, r io.Reader) (string, error) {
+	return s.Loose.WriteStream(objType, size, r)
+}
+
+func (s *DiskStore) OpenStream(hash string) (objects.ObjectType, int64, io.ReadCloser, error) {
+	if s.Loose.Has(hash) {
+		return s.Loose.OpenStream(hash)
+	}
+
+	return s.Packs.OpenStream(hash)
+}
+
+// WriteStream hashes and compresses the content in a single pass into a
+// temporary file, which is then renamed to its final name
+func (s *LooseStore) WriteStream(objType objects.ObjectType, size int64, r io.Reader) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create objects directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "tmp_obj_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := objects.NewObjectHash(s.algo, objType, size)
+	zw := zlib.NewWriter(tmp)
+
+	if _, err := zw.Write([]byte(objects.Header(objType, size))); err != nil {
+		return "", fmt.Errorf("failed to compress object: %w", err)
+	}
+
+	n, err := io.Copy(io.MultiWriter(zw, hasher), io.LimitReader(r, size+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to compress object: %w", err)
+	}
+	if n != size {
+		return "", fmt.Errorf("expected %d bytes of content, got %d", size, n)
+	}
+
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write object file: %w", err)
+	}
+
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+	objectPath := s.Path(hash)
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), objectPath); err != nil {
+		return "", fmt.Errorf("failed to write object file: %w", err)
+	}
+
+	return hash, nil
+}
+
+func (s *LooseStore) OpenStream(hash string) (objects.ObjectType, int64, io.ReadCloser, error) {
+	if len(hash) < 4 {
+		return "", 0, nil, fmt.Errorf("invalid object hash: %s", hash)
+	}
+
+	file, err := os.Open(s.Path(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", 0, nil, fmt.Errorf("object %s not found", hash)
+		}
+		return "", 0, nil, fmt.Errorf("failed to open object file: %w", err)
+	}
+
+	zr, err := zlib.NewReader(file)
+	if err != nil {
+		file.Close()
+		return "", 0, nil, fmt.Errorf("failed to decompress object: %w", err)
+	}
+
+	br := bufio.NewReader(zr)
+	header, err := br.ReadString(0)
+	if err != nil {
+		zr.Close()
+		file.Close()
+		return "", 0, nil, fmt.Errorf("invalid object format: no null byte")
+	}
+
+	objType, sizeStr, ok := strings.Cut(strings.TrimSuffix(header, "\x00"), " ")
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if !ok || err != nil {
+		zr.Close()
+		file.Close()
+		return "", 0, nil, fmt.Errorf("invalid object header: %s", header)
+	}
+
+	r := &streamReader{
+		Reader:  io.LimitReader(br, size),
+		closers: []io.Closer{zr, file},
+		size:    size,
+		hash:    hash,
+	}
+
+	return objects.ObjectType(objType), size, r, nil
+}
+
+func (s *PackStore) OpenStream(hash string) (objects.ObjectType, int64, io.ReadCloser, error) {
+	packs, err := s.Packs()
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	for _, p := range packs {
+		if p.Has(hash) {
+			return p.OpenStream(hash)
+		}
+	}
+
+	return "", 0, nil, fmt.Errorf("object %s not found", hash)
+}
+
+// streamReader checks that exactly size bytes were available before
+// reporting EOF
+type streamReader struct {
+	io.Reader
+	closers []io.Closer
+	size    int64
+	read    int64
+	hash    string
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+
+	if err == io.EOF && r.read != r.size {
+		return n, fmt.Errorf("object %s size mismatch: header says %d, content has %d", r.hash, r.size, r.read)
+	}
+
+	return n, err
+}
+
+func (r *streamReader) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+type verifyingReader struct {
+	io.ReadCloser
+	hash   string
+	hasher hash.Hash
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hasher.Write(p[:n])
+
+	if err == io.EOF {
+		if actual := fmt.Sprintf("%x", r.hasher.Sum(nil)); actual != r.hash {
+			return n, fmt.Errorf("object %s hash mismatch: content hashes to %s", r.hash, actual)
+		}
+	}
+
+	return n, err
+}
@@ -9,49 +9,52 @@ import (
 	"github.com/SteliosSpanos/mygit/pkg/storage"
 )
 
-func BuildTreeFromIndex(gitDir string, idx *index.Index) (string, error) {
-	if len(idx.Entries) == 0 {
-		return "", fmt.Errorf("nothing to commit (index is empty)")
+// BuildTreeFromIndex writes one tree per directory in the index and returns
+// the root tree's hash. An empty index gives the empty tree.
+func BuildTreeFromIndex(store storage.ObjectStore, idx *index.Index) (string, error) {
+	if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+		return "", fmt.Errorf("index has unmerged entries for %s", unmerged[0])
 	}
 
-	rootTree := buildTree(idx.Entries, "")
-
-	hash, err := storeTree(gitDir, rootTree)
-	if err != nil {
-		return "", err
-	}
-
-	return hash, nil
+	return buildTree(store, idx.Entries)
 }
 
-func buildTree(entries []index.Entry, prefix string) *objects.Tree {
+// buildTree stores the tree for entries whose paths are relative to the
+// directory being built, writing subdirectories first
+func buildTree(store storage.ObjectStore, entries []index.Entry) (string, error) {
 	tree := objects.NewTree()
+	tree.Algorithm = store.Algorithm()
+
 	subDirs := make(map[string][]index.Entry)
+	var order []string
 
 	for _, entry := range entries {
-		relPath := entry.Path
-		if prefix != "" {
-			relPath = strings.TrimPrefix(relPath, prefix+"/")
+		dir, rest, nested := strings.Cut(entry.Path, "/")
+		if !nested {
+			tree.AddEntry(entry.Mode, entry.Path, entry.Hash)
+			continue
+		}
+
+		if _, ok := subDirs[dir]; !ok {
+			order = append(order, dir)
 		}
+		entry.Path = rest
+		subDirs[dir] = append(subDirs[dir], entry)
+	}
 
-		parts := strings.Split(relPath, "/")
-		if len(parts) == 1 {
-			tree.AddEntry(entry.Mode, parts[0], entry.Hash)
-		} else {
-			subDir := parts[0]
-			if subDirs[subDir] == nil {
-				subDirs[subDir] = make([]index.Entry, 0)
-			}
-			subDirs[subDir] = append(subDirs[subDir], entry)
+	for _, dir := range order {
+		hash, err := buildTree(store, subDirs[dir])
+		if err != nil {
+			return "", err
 		}
+		tree.AddEntry("040000", dir, hash)
 	}
 
-	//For now we only support all files in root
-	return tree
+	return storeTree(store, tree)
 }
 
-func storeTree(gitDir string, tree *objects.Tree) (string, error) {
-	hash, err := storage.WriteObject(gitDir, tree)
+func storeTree(store storage.ObjectStore, tree *objects.Tree) (string, error) {
+	hash, err := storage.WriteObject(store, tree)
 	if err != nil {
 		return "", fmt.Errorf("failed to store tree: %w", err)
 	}
@@ -0,0 +1,54 @@
+package tree
+
+import (
+	"sort"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+)
+
+// Change is a path whose entry differs between two snapshots. Old is nil
+// for an added path and New for a deleted one.
+type Change struct {
+	Path string
+	Old  *index.Entry
+	New  *index.Entry
+}
+
+// Diff compares two lists of entries, such as flattened trees or index
+// entries, and returns the changes sorted by path
+func Diff(old, new []index.Entry) []Change {
+	oldMap := make(map[string]index.Entry, len(old))
+	for _, entry := range old {
+		oldMap[entry.Path] = entry
+	}
+
+	var changes []Change
+	seen := make(map[string]bool, len(new))
+
+	for _, entry := range new {
+		entry := entry
+		seen[entry.Path] = true
+
+		before, ok := oldMap[entry.Path]
+		if !ok {
+			changes = append(changes, Change{Path: entry.Path, New: &entry})
+			continue
+		}
+		if before.Mode != entry.Mode || before.Hash != entry.Hash {
+			changes = append(changes, Change{Path: entry.Path, Old: &before, New: &entry})
+		}
+	}
+
+	for _, entry := range old {
+		entry := entry
+		if !seen[entry.Path] {
+			changes = append(changes, Change{Path: entry.Path, Old: &entry})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
@@ -0,0 +1,88 @@
+package tree
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// Flatten lists every blob below a tree as index entries with full paths,
+// the inverse of BuildTreeFromIndex
+func Flatten(store storage.ObjectStore, treeHash string) ([]index.Entry, error) {
+	var entries []index.Entry
+	if err := flatten(store, treeHash, "", &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func flatten(store storage.ObjectStore, treeHash, prefix string, entries *[]index.Entry) error {
+	obj, err := storage.LoadObject(store, treeHash)
+	if err != nil {
+		return fmt.Errorf("failed to load tree %s: %w", treeHash, err)
+	}
+
+	t, ok := obj.(*objects.Tree)
+	if !ok {
+		return fmt.Errorf("object %s is a %s, not a tree", treeHash, obj.Type())
+	}
+
+	for _, entry := range t.Entries {
+		name := path.Join(prefix, entry.Name)
+
+		if entry.IsDir() {
+			if err := flatten(store, entry.Hash, name, entries); err != nil {
+				return err
+			}
+			continue
+		}
+
+		*entries = append(*entries, index.Entry{Mode: entry.Mode, Hash: entry.Hash, Path: name})
+	}
+
+	return nil
+}
+
+// Lookup walks a slash-separated path down from a tree and returns the
+// entry it names, or nil if there is none
+func Lookup(store storage.ObjectStore, treeHash, name string) (*objects.TreeEntry, error) {
+	parts := strings.Split(name, "/")
+
+	for i, part := range parts {
+		obj, err := storage.LoadObject(store, treeHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tree %s: %w", treeHash, err)
+		}
+
+		t, ok := obj.(*objects.Tree)
+		if !ok {
+			return nil, nil
+		}
+
+		var found *objects.TreeEntry
+		for j := range t.Entries {
+			if t.Entries[j].Name == part {
+				found = &t.Entries[j]
+				break
+			}
+		}
+
+		if found == nil {
+			return nil, nil
+		}
+		if i == len(parts)-1 {
+			return found, nil
+		}
+		if !found.IsDir() {
+			return nil, nil
+		}
+		treeHash = found.Hash
+	}
+
+	return nil, nil
+}
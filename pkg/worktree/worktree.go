@@ -0,0 +1,269 @@
+package worktree
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SteliosSpanos/mygit/pkg/ignore"
+	"github.com/SteliosSpanos/mygit/pkg/index"
+	"github.com/SteliosSpanos/mygit/pkg/objects"
+	"github.com/SteliosSpanos/mygit/pkg/storage"
+)
+
+// WalkFunc is called with the slash-separated path of each file, symlink
+// and directory below the top of the work tree. Returning fs.SkipDir for a
+// directory skips its contents.
+type WalkFunc func(path string, entry fs.DirEntry) error
+
+// Walk visits the work tree rooted at root, starting at dir ("" for the
+// top). The .git directory and nested repositories are never entered.
+func Walk(root, dir string, fn WalkFunc) error {
+	start := filepath.Join(root, filepath.FromSlash(dir))
+
+	return filepath.WalkDir(start, func(full string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, full)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			if entry.Name() == ".git" || isRepository(full) {
+				return fs.SkipDir
+			}
+		}
+
+		return fn(rel, entry)
+	})
+}
+
+func isRepository(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// Mode maps a file to the index mode Git records for it
+func Mode(info fs.FileInfo) string {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		return "120000"
+	case info.Mode()&0111 != 0:
+		return "100755"
+	}
+
+	return "100644"
+}
+
+// Tracked reports whether Git would track info as a file
+func Tracked(info fs.FileInfo) bool {
+	return info.Mode().IsRegular() || info.Mode()&fs.ModeSymlink != 0
+}
+
+// HashFile computes the blob hash of a file or symlink without storing it
+func HashFile(algo objects.HashAlgorithm, path string, info fs.FileInfo) (string, error) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read link %s: %w", path, err)
+		}
+		return objects.HashData(algo, objects.BlobObject, []byte(target)), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	h := objects.NewObjectHash(algo, objects.BlobObject, info.Size())
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", path, err)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// WriteFile stores a file or symlink as a blob, streaming file content
+func WriteFile(store storage.ObjectStore, path string, info fs.FileInfo) (string, error) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read link %s: %w", path, err)
+		}
+		return storage.WriteObject(store, objects.NewBlob([]byte(target)))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash, err := storage.WriteStream(store, objects.BlobObject, info.Size(), file)
+	if err != nil {
+		return "", fmt.Errorf("failed to write object: %w", err)
+	}
+
+	return hash, nil
+}
+
+// Prefix returns the current directory's path within the work tree,
+// slash-separated and "" at the top
+func Prefix(root string) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	if resolved, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("current directory is outside the work tree")
+	}
+	if rel == "." {
+		return "", nil
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// Untracked lists the files that are not in the index, in walk order.
+// With a matcher, ignored files and directories are left out.
+func Untracked(root string, idx *index.Index, matcher *ignore.Matcher) ([]string, error) {
+	tracked := make(map[string]bool, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		tracked[entry.Path] = true
+	}
+
+	var untracked []string
+	err := Walk(root, "", func(path string, entry fs.DirEntry) error {
+		if matcher != nil && !tracked[path] {
+			p, err := matcher.Match(path, entry.IsDir())
+			if err != nil {
+				return err
+			}
+			if p != nil && !p.Negate {
+				if entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if !entry.IsDir() && !tracked[path] {
+			untracked = append(untracked, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk work tree: %w", err)
+	}
+
+	return untracked, nil
+}
+
+// Checkout writes an index entry's blob to the work tree, replacing
+// whatever is in the way and creating parent directories
+func Checkout(store storage.ObjectStore, root string, entry index.Entry) error {
+	full := filepath.Join(root, filepath.FromSlash(entry.Path))
+
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", entry.Path, err)
+	}
+	if err := os.RemoveAll(full); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", entry.Path, err)
+	}
+
+	objType, _, r, err := storage.OpenStream(store, entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to read blob for %s: %w", entry.Path, err)
+	}
+	defer r.Close()
+
+	if objType != objects.BlobObject {
+		return fmt.Errorf("object %s for %s is a %s, not a blob", entry.Hash, entry.Path, objType)
+	}
+
+	if entry.Mode == "120000" {
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read link target for %s: %w", entry.Path, err)
+		}
+		return os.Symlink(string(target), full)
+	}
+
+	perm := os.FileMode(0644)
+	if entry.Mode == "100755" {
+		perm = 0755
+	}
+
+	file, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", entry.Path, err)
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", entry.Path, err)
+	}
+
+	return file.Close()
+}
+
+// Remove deletes a file from the work tree along with any directories it
+// leaves empty. A file that is already gone is not an error.
+func Remove(root, path string) error {
+	full := filepath.Join(root, filepath.FromSlash(path))
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+
+	for dir := filepath.Dir(full); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Changed reports whether the work tree copy of an entry differs from it
+// in content or mode. A missing file counts as changed.
+func Changed(algo objects.HashAlgorithm, root string, entry index.Entry) (bool, error) {
+	full := filepath.Join(root, filepath.FromSlash(entry.Path))
+
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", entry.Path, err)
+	}
+	if !Tracked(info) {
+		return true, nil
+	}
+
+	hash, err := HashFile(algo, full, info)
+	if err != nil {
+		return false, err
+	}
+
+	return hash != entry.Hash || Mode(info) != entry.Mode, nil
+}